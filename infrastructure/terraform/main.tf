@@ -42,6 +42,24 @@ variable "project_name" {
   default     = "iot-network"
 }
 
+variable "owner" {
+  description = "Team that owns the deployment, recorded in the Owner tag"
+  type        = string
+  default     = "iot-platform"
+}
+
+variable "cost_center" {
+  description = "Cost center the deployment is billed to, recorded in the CostCenter tag"
+  type        = string
+  default     = "iot-network"
+}
+
+variable "dns_zone_id" {
+  description = "Route53 hosted zone the mqtt and api records are created in, none are when empty"
+  type        = string
+  default     = ""
+}
+
 variable "db_password" {
   description = "Database password"
   type        = string
@@ -55,105 +73,74 @@ module "vpc" {
   cidr_block           = "10.0.0.0/16"
   project_name         = var.project_name
   environment          = var.environment
-  public_subnet_count  = 2
-  private_subnet_count = 2
+  owner                = var.owner
+  cost_center          = var.cost_center
+  az_count             = 2
   public_subnet_cidrs  = ["10.0.1.0/24", "10.0.2.0/24"]
   private_subnet_cidrs = ["10.0.10.0/24", "10.0.11.0/24"]
   availability_zones   = data.aws_availability_zones.available.names
-}
 
-output "vpc_id" {
-  value = module.vpc.vpc_id
+  enable_flow_logs_encryption = true
 }
 
-output "public_subnet_ids" {
-  value = module.vpc.public_subnet_ids
+# The routing of the VPC used to be defined inline. The single private route
+# table becomes the one of the first AZ.
+moved {
+  from = aws_internet_gateway.main
+  to   = module.vpc.aws_internet_gateway.main
 }
 
-output "private_subnet_ids" {
-  value = module.vpc.private_subnet_ids
+moved {
+  from = aws_route_table.public
+  to   = module.vpc.aws_route_table.public
 }
 
-# Route Tables (moved to VPC module)
-resource "aws_internet_gateway" "main" {
-  vpc_id = module.vpc.vpc_id
-
-  tags = {
-    Name        = "${var.project_name}-igw"
-    Environment = var.environment
-  }
+moved {
+  from = aws_route_table_association.public
+  to   = module.vpc.aws_route_table_association.public
 }
 
-resource "aws_route_table" "public" {
-  vpc_id = module.vpc.vpc_id
-
-  route {
-    cidr_block = "0.0.0.0/0"
-    gateway_id = aws_internet_gateway.main.id
-  }
-
-  tags = {
-    Name        = "${var.project_name}-public-rt"
-    Environment = var.environment
-  }
+moved {
+  from = aws_eip.nat
+  to   = module.vpc.aws_eip.nat[0]
 }
 
-resource "aws_route_table_association" "public" {
-  count = length(module.vpc.public_subnet_ids)
-
-  subnet_id      = module.vpc.public_subnet_ids[count.index]
-  route_table_id = aws_route_table.public.id
+moved {
+  from = aws_nat_gateway.main
+  to   = module.vpc.aws_nat_gateway.main[0]
 }
 
-# NAT Gateway
-resource "aws_eip" "nat" {
-  domain = "vpc"
-
-  tags = {
-    Name        = "${var.project_name}-nat-eip"
-    Environment = var.environment
-  }
+moved {
+  from = aws_route_table.private
+  to   = module.vpc.aws_route_table.private[0]
 }
 
-resource "aws_nat_gateway" "main" {
-  allocation_id = aws_eip.nat.id
-  subnet_id     = module.vpc.public_subnet_ids[0]
-
-  tags = {
-    Name        = "${var.project_name}-nat"
-    Environment = var.environment
-  }
-
-  depends_on = [aws_internet_gateway.main]
+moved {
+  from = aws_route_table_association.private
+  to   = module.vpc.aws_route_table_association.private
 }
 
-resource "aws_route_table" "private" {
-  vpc_id = module.vpc.vpc_id
-
-  route {
-    cidr_block     = "0.0.0.0/0"
-    nat_gateway_id = aws_nat_gateway.main.id
-  }
-
-  tags = {
-    Name        = "${var.project_name}-private-rt"
-    Environment = var.environment
-  }
+output "vpc_id" {
+  value = module.vpc.vpc_id
 }
 
-resource "aws_route_table_association" "private" {
-  count = length(module.vpc.private_subnet_ids)
+output "public_subnet_ids" {
+  value = module.vpc.public_subnet_ids
+}
 
-  subnet_id      = module.vpc.private_subnet_ids[count.index]
-  route_table_id = aws_route_table.private.id
+output "private_subnet_ids" {
+  value = module.vpc.private_subnet_ids
 }
 
+# Internet gateway, NAT gateway and route tables live in the VPC module
+
 # Security Groups Module
 module "security" {
-  source       = "./modules/security"
-  vpc_id       = module.vpc.vpc_id
-  project_name = var.project_name
-  environment  = var.environment
+  source         = "./modules/security"
+  vpc_id         = module.vpc.vpc_id
+  vpc_cidr_block = module.vpc.vpc_cidr_block
+  project_name   = var.project_name
+  environment    = var.environment
 }
 
 output "web_sg_id" {
@@ -168,6 +155,33 @@ output "database_sg_id" {
   value = module.security.database_sg_id
 }
 
+output "mqtt_sg_id" {
+  value = module.security.mqtt_sg_id
+}
+
+# Private connectivity to IoT, S3 and DynamoDB from the private subnets
+module "vpc_endpoints" {
+  source         = "./modules/vpc-endpoints"
+  vpc_id         = module.vpc.vpc_id
+  vpc_cidr_block = module.vpc.vpc_cidr_block
+  subnet_ids     = module.vpc.private_subnet_ids
+  project_name   = var.project_name
+  environment    = var.environment
+  owner          = var.owner
+  cost_center    = var.cost_center
+}
+
+# IoT Core Module
+module "iot_core" {
+  source       = "./modules/iot-core"
+  project_name = var.project_name
+  environment  = var.environment
+}
+
+output "iot_provisioning_template_name" {
+  value = module.iot_core.provisioning_template_name
+}
+
 # RDS PostgreSQL
 resource "aws_db_subnet_group" "main" {
   name       = "${var.project_name}-db-subnet-group"
@@ -232,121 +246,85 @@ resource "aws_elasticache_cluster" "redis" {
   }
 }
 
-# EKS Cluster
-resource "aws_eks_cluster" "main" {
-  name     = "${var.project_name}-cluster"
-  role_arn = aws_iam_role.cluster.arn
-
-  vpc_config {
-    subnet_ids = concat(module.vpc.public_subnet_ids, module.vpc.private_subnet_ids)
-  }
-
-  depends_on = [
-    aws_iam_role_policy_attachment.cluster_AmazonEKSClusterPolicy,
-  ]
-
-  tags = {
-    Name        = "${var.project_name}-cluster"
-    Environment = var.environment
-  }
+# EKS Module
+module "eks" {
+  source             = "./modules/eks"
+  project_name       = var.project_name
+  environment        = var.environment
+  owner              = var.owner
+  cost_center        = var.cost_center
+  cluster_subnet_ids = concat(module.vpc.public_subnet_ids, module.vpc.private_subnet_ids)
+  node_subnet_ids    = module.vpc.private_subnet_ids
 }
 
-# EKS Node Group
-resource "aws_eks_node_group" "main" {
-  cluster_name    = aws_eks_cluster.main.name
-  node_group_name = "${var.project_name}-nodes"
-  node_role_arn   = aws_iam_role.node.arn
-  subnet_ids      = module.vpc.private_subnet_ids
-
-  scaling_config {
-    desired_size = 2
-    max_size     = 4
-    min_size     = 1
-  }
-
-  update_config {
-    max_unavailable = 1
-  }
-
-  instance_types = ["t3.medium"]
-
-  depends_on = [
-    aws_iam_role_policy_attachment.node_AmazonEKSWorkerNodePolicy,
-    aws_iam_role_policy_attachment.node_AmazonEKS_CNI_Policy,
-    aws_iam_role_policy_attachment.node_AmazonEC2ContainerRegistryReadOnly,
-  ]
-
-  tags = {
-    Name        = "${var.project_name}-nodes"
-    Environment = var.environment
-  }
+# The cluster used to be defined inline
+moved {
+  from = aws_eks_cluster.main
+  to   = module.eks.aws_eks_cluster.main
 }
 
-# IAM Roles
-resource "aws_iam_role" "cluster" {
-  name = "${var.project_name}-cluster-role"
-
-  assume_role_policy = jsonencode({
-    Statement = [{
-      Action = "sts:AssumeRole"
-      Effect = "Allow"
-      Principal = {
-        Service = "eks.amazonaws.com"
-      }
-    }]
-    Version = "2012-10-17"
-  })
+moved {
+  from = aws_eks_node_group.main
+  to   = module.eks.aws_eks_node_group.main
 }
 
-resource "aws_iam_role_policy_attachment" "cluster_AmazonEKSClusterPolicy" {
-  policy_arn = "arn:aws:iam::aws:policy/AmazonEKSClusterPolicy"
-  role       = aws_iam_role.cluster.name
+moved {
+  from = aws_iam_role.cluster
+  to   = module.eks.aws_iam_role.cluster
 }
 
-resource "aws_iam_role" "node" {
-  name = "${var.project_name}-node-role"
+moved {
+  from = aws_iam_role_policy_attachment.cluster_AmazonEKSClusterPolicy
+  to   = module.eks.aws_iam_role_policy_attachment.cluster_AmazonEKSClusterPolicy
+}
 
-  assume_role_policy = jsonencode({
-    Statement = [{
-      Action = "sts:AssumeRole"
-      Effect = "Allow"
-      Principal = {
-        Service = "ec2.amazonaws.com"
-      }
-    }]
-    Version = "2012-10-17"
-  })
+moved {
+  from = aws_iam_role.node
+  to   = module.eks.aws_iam_role.node
 }
 
-resource "aws_iam_role_policy_attachment" "node_AmazonEKSWorkerNodePolicy" {
-  policy_arn = "arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy"
-  role       = aws_iam_role.node.name
+moved {
+  from = aws_iam_role_policy_attachment.node_AmazonEKSWorkerNodePolicy
+  to   = module.eks.aws_iam_role_policy_attachment.node_AmazonEKSWorkerNodePolicy
 }
 
-resource "aws_iam_role_policy_attachment" "node_AmazonEKS_CNI_Policy" {
-  policy_arn = "arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy"
-  role       = aws_iam_role.node.name
+moved {
+  from = aws_iam_role_policy_attachment.node_AmazonEKS_CNI_Policy
+  to   = module.eks.aws_iam_role_policy_attachment.node_AmazonEKS_CNI_Policy
 }
 
-resource "aws_iam_role_policy_attachment" "node_AmazonEC2ContainerRegistryReadOnly" {
-  policy_arn = "arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"
-  role       = aws_iam_role.node.name
+moved {
+  from = aws_iam_role_policy_attachment.node_AmazonEC2ContainerRegistryReadOnly
+  to   = module.eks.aws_iam_role_policy_attachment.node_AmazonEC2ContainerRegistryReadOnly
 }
 
-# Load Balancer
-resource "aws_lb" "main" {
-  name               = "${var.project_name}-alb"
-  internal           = false
-  load_balancer_type = "application"
-  security_groups    = [aws_security_group.web.id]
-  subnets            = module.vpc.public_subnet_ids
+# Gateway Load Balancer
+module "gateway" {
+  source              = "./modules/gateway"
+  project_name        = var.project_name
+  environment         = var.environment
+  owner               = var.owner
+  cost_center         = var.cost_center
+  vpc_id              = module.vpc.vpc_id
+  public_subnet_ids   = module.vpc.public_subnet_ids
+  deletion_protection = true
+}
 
-  enable_deletion_protection = true
+# The load balancer used to be defined inline
+moved {
+  from = aws_lb.main
+  to   = module.gateway.aws_lb.main
+}
 
-  tags = {
-    Name        = "${var.project_name}-alb"
-    Environment = var.environment
-  }
+# mqtt.<environment> and api.<environment> records devices bootstrap from
+module "dns" {
+  source          = "./modules/dns"
+  count           = var.dns_zone_id == "" ? 0 : 1
+  environment     = var.environment
+  zone_id         = var.dns_zone_id
+  broker_endpoint = module.iot_core.broker_endpoint
+  alb_dns_name    = module.gateway.alb_dns_name
+  alb_zone_id     = module.gateway.alb_zone_id
 }
 
 # Data sources
@@ -357,12 +335,12 @@ data "aws_availability_zones" "available" {
 # Outputs
 output "cluster_endpoint" {
   description = "Endpoint for EKS control plane"
-  value       = aws_eks_cluster.main.endpoint
+  value       = module.eks.cluster_endpoint
 }
 
 output "cluster_name" {
   description = "EKS cluster name"
-  value       = aws_eks_cluster.main.name
+  value       = module.eks.cluster_name
 }
 
 output "database_endpoint" {
@@ -379,5 +357,5 @@ output "redis_endpoint" {
 
 output "load_balancer_hostname" {
   description = "Load balancer hostname"
-  value       = aws_lb.main.dns_name
+  value       = module.gateway.alb_dns_name
 }
@@ -0,0 +1,150 @@
+# ASG Module
+#
+# Auto scaling group running the gateway workers in the private subnets. The
+# instances come from a launch template requiring IMDSv2, mix on-demand and
+# spot capacity as spot_percentage says, and register with the given target
+# groups, whose health checks then decide whether an instance is replaced.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_ssm_parameter" "al2023" {
+  name = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"
+}
+
+resource "aws_iam_role" "workers" {
+  name = "${local.name}-workers"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "ec2.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+# Workers have no ssh access, Session Manager is how operators get on them
+resource "aws_iam_role_policy_attachment" "workers_ssm" {
+  policy_arn = "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"
+  role       = aws_iam_role.workers.name
+}
+
+resource "aws_iam_instance_profile" "workers" {
+  name = "${local.name}-workers"
+  role = aws_iam_role.workers.name
+
+  tags = local.tags
+}
+
+resource "aws_launch_template" "workers" {
+  name_prefix            = "${local.name}-workers-"
+  image_id               = data.aws_ssm_parameter.al2023.insecure_value
+  instance_type          = var.instance_types[0]
+  vpc_security_group_ids = var.security_group_ids
+  user_data              = var.user_data == "" ? null : base64encode(var.user_data)
+
+  iam_instance_profile {
+    arn = aws_iam_instance_profile.workers.arn
+  }
+
+  metadata_options {
+    http_endpoint               = "enabled"
+    http_tokens                 = "required"
+    http_put_response_hop_limit = 1
+  }
+
+  block_device_mappings {
+    device_name = "/dev/xvda"
+
+    ebs {
+      encrypted   = true
+      kms_key_id  = var.kms_key_arn == "" ? null : var.kms_key_arn
+      volume_type = "gp3"
+    }
+  }
+
+  tag_specifications {
+    resource_type = "instance"
+    tags = merge(local.tags, {
+      Name = "${local.name}-worker"
+    })
+  }
+
+  tag_specifications {
+    resource_type = "volume"
+    tags = merge(local.tags, {
+      Name = "${local.name}-worker"
+    })
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-workers-lt"
+  })
+}
+
+resource "aws_autoscaling_group" "workers" {
+  name                      = "${local.name}-workers"
+  vpc_zone_identifier       = var.subnet_ids
+  min_size                  = var.min_size
+  max_size                  = var.max_size
+  desired_capacity          = var.desired_capacity
+  target_group_arns         = var.target_group_arns
+  health_check_type         = length(var.target_group_arns) > 0 ? "ELB" : "EC2"
+  health_check_grace_period = var.health_check_grace_period
+
+  mixed_instances_policy {
+    instances_distribution {
+      on_demand_base_capacity                  = 0
+      on_demand_percentage_above_base_capacity = 100 - var.spot_percentage
+      spot_allocation_strategy                 = "price-capacity-optimized"
+    }
+
+    launch_template {
+      launch_template_specification {
+        launch_template_id = aws_launch_template.workers.id
+        version            = aws_launch_template.workers.latest_version
+      }
+
+      dynamic "override" {
+        for_each = var.instance_types
+        content {
+          instance_type = override.value
+        }
+      }
+    }
+  }
+
+  dynamic "tag" {
+    for_each = merge(local.tags, { Name = "${local.name}-workers" })
+    content {
+      key                 = tag.key
+      value               = tag.value
+      propagate_at_launch = false
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = var.min_size <= var.desired_capacity && var.desired_capacity <= var.max_size
+      error_message = "desired_capacity must lie between min_size and max_size."
+    }
+
+    # Scaling policies and operators own the desired capacity once the group
+    # exists
+    ignore_changes = [desired_capacity]
+  }
+}
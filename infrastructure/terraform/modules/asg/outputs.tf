@@ -0,0 +1,19 @@
+output "autoscaling_group_name" {
+  description = "Name of the auto scaling group"
+  value       = aws_autoscaling_group.workers.name
+}
+
+output "autoscaling_group_arn" {
+  description = "ARN of the auto scaling group"
+  value       = aws_autoscaling_group.workers.arn
+}
+
+output "launch_template_id" {
+  description = "ID of the launch template the workers are launched from"
+  value       = aws_launch_template.workers.id
+}
+
+output "role_name" {
+  description = "Name of the IAM role of the workers, for attaching the policies the gateway services need"
+  value       = aws_iam_role.workers.name
+}
@@ -0,0 +1,105 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "subnet_ids" {
+  description = "Private subnets the workers are launched into, one per AZ"
+  type        = list(string)
+
+  validation {
+    condition     = length(var.subnet_ids) > 0
+    error_message = "subnet_ids must list at least one subnet."
+  }
+}
+
+variable "security_group_ids" {
+  description = "Security groups the workers attach, e.g. the gateway module's targets group"
+  type        = list(string)
+  default     = []
+}
+
+variable "target_group_arns" {
+  description = "Target groups the workers register with; their health checks replace the EC2 status checks when set"
+  type        = list(string)
+  default     = []
+}
+
+variable "instance_types" {
+  description = "Instance types the workers may run on, in order of preference; more types make spot capacity easier to find"
+  type        = list(string)
+  default     = ["t3.micro"]
+
+  validation {
+    condition     = length(var.instance_types) > 0 && alltrue([for type in var.instance_types : can(regex("^[a-z][a-z0-9-]*\\.[a-z0-9]+$", type))])
+    error_message = "instance_types must list EC2 instance types such as t3.micro."
+  }
+}
+
+variable "spot_percentage" {
+  description = "Percentage of the workers run on spot capacity, the rest are on-demand"
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.spot_percentage >= 0 && var.spot_percentage <= 100 && floor(var.spot_percentage) == var.spot_percentage
+    error_message = "spot_percentage must be a whole number between 0 and 100."
+  }
+}
+
+variable "min_size" {
+  description = "Fewest workers the group runs"
+  type        = number
+  default     = 1
+}
+
+variable "max_size" {
+  description = "Most workers the group runs"
+  type        = number
+  default     = 3
+}
+
+variable "desired_capacity" {
+  description = "Workers the group starts with; later changes are left to scaling policies and operators"
+  type        = number
+  default     = 1
+}
+
+variable "health_check_grace_period" {
+  description = "Seconds a new worker gets to start its services before failing health checks gets it replaced"
+  type        = number
+  default     = 300
+}
+
+variable "user_data" {
+  description = "Script the workers run at boot, unencoded"
+  type        = string
+  default     = ""
+}
+
+variable "kms_key_arn" {
+  description = "ARN of the KMS key the workers' volumes are encrypted with, the account's default EBS key when empty"
+  type        = string
+  default     = ""
+}
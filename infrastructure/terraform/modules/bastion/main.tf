@@ -0,0 +1,139 @@
+# Bastion Module
+#
+# Jump host operators use to reach instances in the private subnets. With ssh
+# access it sits in a public subnet and accepts ssh from allowed_ssh_cidr_blocks;
+# with ssm access it sits in a private subnet, has no ingress at all and is only
+# reachable through Session Manager. Instances that should accept ssh from the
+# bastion attach the access security group.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+  ssh  = var.access_mode == "ssh"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_ssm_parameter" "al2023" {
+  name = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"
+}
+
+resource "aws_security_group" "bastion" {
+  name_prefix = "${local.name}-bastion"
+  description = "Bastion host"
+  vpc_id      = var.vpc_id
+
+  dynamic "ingress" {
+    for_each = local.ssh && length(var.allowed_ssh_cidr_blocks) > 0 ? [1] : []
+    content {
+      from_port   = 22
+      to_port     = 22
+      protocol    = "tcp"
+      cidr_blocks = var.allowed_ssh_cidr_blocks
+    }
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-bastion-sg"
+  })
+}
+
+resource "aws_security_group" "access" {
+  name_prefix = "${local.name}-bastion-access"
+  description = "Lets the bastion reach instances over ssh"
+  vpc_id      = var.vpc_id
+
+  ingress {
+    from_port       = 22
+    to_port         = 22
+    protocol        = "tcp"
+    security_groups = [aws_security_group.bastion.id]
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-bastion-access-sg"
+  })
+}
+
+resource "aws_key_pair" "bastion" {
+  count = local.ssh ? 1 : 0
+
+  key_name   = "${local.name}-bastion"
+  public_key = var.public_key
+
+  tags = local.tags
+}
+
+# Session Manager works in both modes, so the ssh bastion can still be reached
+# when its key is lost
+resource "aws_iam_role" "bastion" {
+  name = "${local.name}-bastion"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "ec2.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy_attachment" "bastion_ssm" {
+  policy_arn = "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"
+  role       = aws_iam_role.bastion.name
+}
+
+resource "aws_iam_instance_profile" "bastion" {
+  name = "${local.name}-bastion"
+  role = aws_iam_role.bastion.name
+
+  tags = local.tags
+}
+
+resource "aws_instance" "bastion" {
+  ami                         = data.aws_ssm_parameter.al2023.insecure_value
+  instance_type               = var.instance_type
+  subnet_id                   = var.subnet_id
+  vpc_security_group_ids      = [aws_security_group.bastion.id]
+  key_name                    = local.ssh ? aws_key_pair.bastion[0].key_name : null
+  associate_public_ip_address = local.ssh
+  iam_instance_profile        = aws_iam_instance_profile.bastion.name
+
+  metadata_options {
+    http_endpoint = "enabled"
+    http_tokens   = "required"
+  }
+
+  root_block_device {
+    encrypted = true
+  }
+
+  lifecycle {
+    precondition {
+      condition     = !local.ssh || var.public_key != ""
+      error_message = "public_key is required when access_mode is ssh."
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-bastion"
+  })
+
+  depends_on = [aws_iam_role_policy_attachment.bastion_ssm]
+}
@@ -0,0 +1,34 @@
+output "instance_id" {
+  description = "ID of the bastion instance"
+  value       = aws_instance.bastion.id
+}
+
+output "public_ip" {
+  description = "Public address of the bastion, empty with ssm access"
+  value       = aws_instance.bastion.public_ip
+}
+
+output "private_ip" {
+  description = "Private address of the bastion"
+  value       = aws_instance.bastion.private_ip
+}
+
+output "ssh_user" {
+  description = "User to log in to the bastion as"
+  value       = "ec2-user"
+}
+
+output "security_group_id" {
+  description = "ID of the bastion's own security group"
+  value       = aws_security_group.bastion.id
+}
+
+output "access_security_group_id" {
+  description = "ID of the security group that lets the bastion reach an instance over ssh"
+  value       = aws_security_group.access.id
+}
+
+output "key_name" {
+  description = "Name of the EC2 key pair holding public_key, empty with ssm access"
+  value       = local.ssh ? aws_key_pair.bastion[0].key_name : ""
+}
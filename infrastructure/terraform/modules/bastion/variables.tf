@@ -0,0 +1,74 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "vpc_id" {
+  description = "ID of the VPC the bastion runs in"
+  type        = string
+}
+
+variable "subnet_id" {
+  description = "Subnet the bastion runs in: a public subnet for ssh access, a private one for ssm access"
+  type        = string
+}
+
+variable "access_mode" {
+  description = "How operators reach the bastion: ssh to its public address, or ssm through Session Manager only"
+  type        = string
+  default     = "ssh"
+
+  validation {
+    condition     = contains(["ssh", "ssm"], var.access_mode)
+    error_message = "access_mode must be ssh or ssm."
+  }
+}
+
+variable "public_key" {
+  description = "OpenSSH public key allowed to log in to the bastion, required for ssh access"
+  type        = string
+  default     = ""
+}
+
+variable "allowed_ssh_cidr_blocks" {
+  description = "CIDR blocks allowed to ssh to the bastion"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = !contains(var.allowed_ssh_cidr_blocks, "0.0.0.0/0")
+    error_message = "allowed_ssh_cidr_blocks must not open the bastion to 0.0.0.0/0."
+  }
+}
+
+variable "instance_type" {
+  description = "Instance type of the bastion"
+  type        = string
+  default     = "t3.micro"
+
+  validation {
+    condition     = can(regex("^[a-z][a-z0-9-]*\\.[a-z0-9]+$", var.instance_type))
+    error_message = "instance_type must be an EC2 instance type such as t3.micro."
+  }
+}
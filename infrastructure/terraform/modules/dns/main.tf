@@ -0,0 +1,38 @@
+# DNS Module
+#
+# The records device bootstrap resolves in the environment's subdomain of an
+# existing hosted zone: mqtt.<environment>.<zone> is a CNAME to the MQTT broker
+# endpoint and api.<environment>.<zone> an alias to the gateway load balancer.
+# With a name_prefix the subdomain becomes <name_prefix>-<environment>, so
+# parallel deployments sharing a zone never touch each other's records. The
+# zone itself is not managed here.
+
+locals {
+  subdomain = var.name_prefix == "" ? var.environment : "${var.name_prefix}-${var.environment}"
+  domain    = "${local.subdomain}.${trimsuffix(data.aws_route53_zone.main.name, ".")}"
+}
+
+data "aws_route53_zone" "main" {
+  zone_id = var.zone_id
+}
+
+resource "aws_route53_record" "mqtt" {
+  zone_id = var.zone_id
+  name    = "mqtt.${local.domain}"
+  type    = "CNAME"
+  ttl     = var.mqtt_record_ttl
+  records = [var.broker_endpoint]
+}
+
+# Alias records take the TTL of their target, 60 seconds for load balancers
+resource "aws_route53_record" "api" {
+  zone_id = var.zone_id
+  name    = "api.${local.domain}"
+  type    = "A"
+
+  alias {
+    name                   = var.alb_dns_name
+    zone_id                = var.alb_zone_id
+    evaluate_target_health = true
+  }
+}
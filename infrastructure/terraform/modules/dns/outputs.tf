@@ -0,0 +1,9 @@
+output "mqtt_fqdn" {
+  description = "Name devices resolve to reach the MQTT broker"
+  value       = aws_route53_record.mqtt.fqdn
+}
+
+output "api_fqdn" {
+  description = "Name devices resolve to reach the gateway API"
+  value       = aws_route53_record.api.fqdn
+}
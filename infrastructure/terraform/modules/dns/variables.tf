@@ -0,0 +1,46 @@
+variable "name_prefix" {
+  description = "Prefix prepended to the environment's subdomain, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "environment" {
+  description = "Environment name, the subdomain the records are created in"
+  type        = string
+}
+
+variable "zone_id" {
+  description = "ID of the existing Route53 hosted zone to create the records in"
+  type        = string
+
+  validation {
+    condition     = can(regex("^Z[0-9A-Z]+$", var.zone_id))
+    error_message = "zone_id must be a Route53 hosted zone ID such as Z0123456789ABCDEFGHIJ."
+  }
+}
+
+variable "broker_endpoint" {
+  description = "Hostname of the MQTT broker the mqtt record points at"
+  type        = string
+}
+
+variable "mqtt_record_ttl" {
+  description = "TTL in seconds of the mqtt record"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.mqtt_record_ttl >= 30 && var.mqtt_record_ttl <= 86400
+    error_message = "mqtt_record_ttl must be between 30 and 86400 seconds."
+  }
+}
+
+variable "alb_dns_name" {
+  description = "DNS name of the gateway load balancer the api record is an alias to"
+  type        = string
+}
+
+variable "alb_zone_id" {
+  description = "Route53 zone ID of the gateway load balancer"
+  type        = string
+}
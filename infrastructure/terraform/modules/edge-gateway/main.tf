@@ -0,0 +1,165 @@
+# Edge Gateway Module
+#
+# Greengrass v2 core devices bridging field devices to the network. The module
+# registers one core as an IoT thing in a thing group, gives it the IoT policy
+# and token exchange role a Greengrass nucleus needs, and deploys the
+# components in var.components to the group. A nucleus installed with the
+# thing's certificate picks the deployment up on its first connection; the
+# certificate itself is issued at install time and never stored in state.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  iot_arn_prefix = "arn:aws:iot:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_region" "current" {}
+
+data "aws_caller_identity" "current" {}
+
+data "aws_iot_endpoint" "data" {
+  endpoint_type = "iot:Data-ATS"
+}
+
+# The nucleus trades its certificate for the token exchange role's credentials
+# here
+data "aws_iot_endpoint" "credentials" {
+  endpoint_type = "iot:CredentialProvider"
+}
+
+resource "aws_iot_thing_group" "cores" {
+  name = "${local.name}-edge-cores"
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-edge-cores"
+  })
+}
+
+resource "aws_iot_thing" "core" {
+  name = "${local.name}-edge-core"
+}
+
+resource "aws_iot_thing_group_membership" "core" {
+  thing_name       = aws_iot_thing.core.name
+  thing_group_name = aws_iot_thing_group.cores.name
+}
+
+# Components get the token exchange role's credentials from the nucleus, so the
+# role only carries what they need: their own log groups
+resource "aws_iam_role" "token_exchange" {
+  name = "${local.name}-edge-token-exchange"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "credentials.iot.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "token_exchange" {
+  name = "${local.name}-edge-token-exchange"
+  role = aws_iam_role.token_exchange.id
+
+  policy = jsonencode({
+    Statement = [{
+      Sid = "ComponentLogs"
+      Action = [
+        "logs:CreateLogGroup",
+        "logs:CreateLogStream",
+        "logs:PutLogEvents",
+        "logs:DescribeLogStreams",
+      ]
+      Effect = "Allow"
+      Resource = [
+        "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:log-group:/aws/greengrass/*",
+        "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:log-group:/aws/greengrass/*:log-stream:*",
+      ]
+    }]
+    Version = "2012-10-17"
+  })
+}
+
+resource "aws_iot_role_alias" "token_exchange" {
+  alias    = "${local.name}-edge-token-exchange"
+  role_arn = aws_iam_role.token_exchange.arn
+
+  tags = local.tags
+}
+
+# The minimal policy of a Greengrass core: connecting as the core thing, its own
+# shadow and jobs topics, fetching deployments and artifacts, and assuming the
+# token exchange role. The nucleus connects with the thing name as client ID,
+# suffixed for additional connections.
+resource "aws_iot_policy" "core" {
+  name = "${local.name}-edge-core-policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "iot:Connect"
+        Resource = "${local.iot_arn_prefix}:client/${aws_iot_thing.core.name}*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["iot:Publish", "iot:Receive"]
+        Resource = "${local.iot_arn_prefix}:topic/$aws/things/${aws_iot_thing.core.name}*/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = "iot:Subscribe"
+        Resource = "${local.iot_arn_prefix}:topicfilter/$aws/things/${aws_iot_thing.core.name}*/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["iot:GetThingShadow", "iot:UpdateThingShadow", "iot:DeleteThingShadow"]
+        Resource = "${local.iot_arn_prefix}:thing/${aws_iot_thing.core.name}*"
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "greengrass:GetComponentVersionArtifact",
+          "greengrass:ResolveComponentCandidates",
+          "greengrass:GetDeploymentConfiguration",
+          "greengrass:ListThingGroupsForCoreDevice",
+        ]
+        Resource = "*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = "iot:AssumeRoleWithCertificate"
+        Resource = aws_iot_role_alias.token_exchange.arn
+      },
+    ]
+  })
+}
+
+# Deployments to a thing group reach every core in it, including cores that
+# join later
+resource "awscc_greengrassv2_deployment" "components" {
+  target_arn      = aws_iot_thing_group.cores.arn
+  deployment_name = "${local.name}-edge-components"
+
+  components = {
+    for name, version in var.components : name => {
+      component_version = version
+    }
+  }
+
+  tags = local.tags
+}
@@ -0,0 +1,44 @@
+output "thing_name" {
+  description = "Name of the core device's IoT thing, which the nucleus is installed as"
+  value       = aws_iot_thing.core.name
+}
+
+output "thing_group_name" {
+  description = "Name of the thing group the components are deployed to"
+  value       = aws_iot_thing_group.cores.name
+}
+
+output "thing_group_arn" {
+  description = "ARN of the thing group the components are deployed to"
+  value       = aws_iot_thing_group.cores.arn
+}
+
+output "core_policy_name" {
+  description = "Name of the IoT policy to attach to the core device's certificate"
+  value       = aws_iot_policy.core.name
+}
+
+output "role_alias_name" {
+  description = "Role alias of the token exchange role, the nucleus's iotRoleAlias"
+  value       = aws_iot_role_alias.token_exchange.alias
+}
+
+output "token_exchange_role_arn" {
+  description = "ARN of the role components run AWS calls under"
+  value       = aws_iam_role.token_exchange.arn
+}
+
+output "data_endpoint" {
+  description = "ATS data endpoint, the nucleus's iotDataEndpoint"
+  value       = data.aws_iot_endpoint.data.endpoint_address
+}
+
+output "credential_endpoint" {
+  description = "Credential provider endpoint, the nucleus's iotCredEndpoint"
+  value       = data.aws_iot_endpoint.credentials.endpoint_address
+}
+
+output "deployment_id" {
+  description = "ID of the Greengrass deployment of the components"
+  value       = awscc_greengrassv2_deployment.components.deployment_id
+}
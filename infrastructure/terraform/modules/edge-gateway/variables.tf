@@ -0,0 +1,40 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "components" {
+  description = "Greengrass components deployed to the core devices, by name to version, e.g. aws.greengrass.Cli = 2.12.6"
+  type        = map(string)
+
+  validation {
+    condition     = length(var.components) > 0
+    error_message = "components must list at least one component."
+  }
+
+  validation {
+    condition     = alltrue([for version in values(var.components) : can(regex("^[0-9]+\\.[0-9]+\\.[0-9]+$", version))])
+    error_message = "components must map component names to semantic versions such as 2.12.6."
+  }
+}
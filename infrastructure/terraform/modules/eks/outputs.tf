@@ -0,0 +1,19 @@
+output "cluster_name" {
+  description = "EKS cluster name"
+  value       = aws_eks_cluster.main.name
+}
+
+output "cluster_endpoint" {
+  description = "Endpoint for EKS control plane"
+  value       = aws_eks_cluster.main.endpoint
+}
+
+output "cluster_certificate_authority_data" {
+  description = "Base64 encoded CA certificate of the cluster"
+  value       = aws_eks_cluster.main.certificate_authority[0].data
+}
+
+output "node_group_name" {
+  description = "Name of the worker node group"
+  value       = aws_eks_node_group.main.node_group_name
+}
@@ -0,0 +1,65 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "cluster_subnet_ids" {
+  description = "Subnets the control plane places its network interfaces in"
+  type        = list(string)
+}
+
+variable "node_subnet_ids" {
+  description = "Subnets the worker nodes run in, normally the private subnets"
+  type        = list(string)
+}
+
+variable "private_endpoint" {
+  description = "Whether the Kubernetes API is only reachable from inside the VPC"
+  type        = bool
+  default     = false
+}
+
+variable "node_instance_types" {
+  description = "Instance types of the worker node group"
+  type        = list(string)
+  default     = ["t3.medium"]
+}
+
+variable "node_desired_size" {
+  description = "Number of worker nodes to run"
+  type        = number
+  default     = 2
+}
+
+variable "node_min_size" {
+  description = "Minimum number of worker nodes"
+  type        = number
+  default     = 1
+}
+
+variable "node_max_size" {
+  description = "Maximum number of worker nodes"
+  type        = number
+  default     = 4
+}
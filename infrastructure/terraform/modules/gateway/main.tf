@@ -0,0 +1,156 @@
+# Gateway Module
+#
+# Application load balancer in front of the gateway services. Targets are
+# registered by IP, which is what the EKS load balancer controller does for
+# pods, or by instance when target_type is instance, which is what the asg
+# module's worker groups need; anything registered has to attach the targets
+# security group.
+
+locals {
+  name  = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+  https = var.certificate_arn != ""
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+resource "aws_security_group" "alb" {
+  name_prefix = "${local.name}-alb"
+  description = "Gateway load balancer"
+  vpc_id      = var.vpc_id
+
+  ingress {
+    from_port   = 80
+    to_port     = 80
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  ingress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-alb-sg"
+  })
+}
+
+resource "aws_security_group" "targets" {
+  name_prefix = "${local.name}-gateway-targets"
+  description = "Lets the gateway load balancer reach its targets"
+  vpc_id      = var.vpc_id
+
+  ingress {
+    from_port       = var.target_port
+    to_port         = var.target_port
+    protocol        = "tcp"
+    security_groups = [aws_security_group.alb.id]
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-gateway-targets-sg"
+  })
+}
+
+# Load balancer names are limited to 32 characters
+resource "aws_lb" "main" {
+  name               = "${local.name}-alb"
+  internal           = false
+  load_balancer_type = "application"
+  security_groups    = [aws_security_group.alb.id]
+  subnets            = var.public_subnet_ids
+
+  enable_deletion_protection = var.deletion_protection
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-alb"
+  })
+}
+
+resource "aws_lb_target_group" "gateway" {
+  name        = "${local.name}-tg"
+  port        = var.target_port
+  protocol    = "HTTP"
+  target_type = var.target_type
+  vpc_id      = var.vpc_id
+
+  health_check {
+    path                = var.health_check_path
+    matcher             = "200"
+    interval            = 15
+    timeout             = 5
+    healthy_threshold   = 2
+    unhealthy_threshold = 3
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-tg"
+  })
+}
+
+resource "aws_lb_listener" "http" {
+  load_balancer_arn = aws_lb.main.arn
+  port              = 80
+  protocol          = "HTTP"
+
+  default_action {
+    type             = var.force_https ? "redirect" : "forward"
+    target_group_arn = var.force_https ? null : aws_lb_target_group.gateway.arn
+
+    dynamic "redirect" {
+      for_each = var.force_https ? [1] : []
+      content {
+        port        = "443"
+        protocol    = "HTTPS"
+        status_code = "HTTP_301"
+      }
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = !var.force_https || local.https
+      error_message = "force_https requires certificate_arn."
+    }
+  }
+
+  tags = local.tags
+}
+
+resource "aws_lb_listener" "https" {
+  count = local.https ? 1 : 0
+
+  load_balancer_arn = aws_lb.main.arn
+  port              = 443
+  protocol          = "HTTPS"
+  ssl_policy        = "ELBSecurityPolicy-TLS13-1-2-2021-06"
+  certificate_arn   = var.certificate_arn
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.gateway.arn
+  }
+
+  tags = local.tags
+}
@@ -0,0 +1,29 @@
+output "alb_dns_name" {
+  description = "DNS name of the load balancer"
+  value       = aws_lb.main.dns_name
+}
+
+output "alb_arn" {
+  description = "ARN of the load balancer"
+  value       = aws_lb.main.arn
+}
+
+output "target_group_arn" {
+  description = "ARN of the target group gateway services register with"
+  value       = aws_lb_target_group.gateway.arn
+}
+
+output "alb_security_group_id" {
+  description = "ID of the load balancer's security group"
+  value       = aws_security_group.alb.id
+}
+
+output "target_security_group_id" {
+  description = "ID of the security group targets attach so the load balancer can reach them"
+  value       = aws_security_group.targets.id
+}
+
+output "alb_zone_id" {
+  description = "Route53 zone ID of the load balancer, for alias records"
+  value       = aws_lb.main.zone_id
+}
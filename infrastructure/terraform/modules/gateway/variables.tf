@@ -0,0 +1,82 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+
+  # Load balancer and target group names are limited to 32 characters
+  validation {
+    condition     = length(var.name_prefix) <= 16
+    error_message = "name_prefix must be at most 16 characters, load balancer and target group names are limited to 32."
+  }
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "vpc_id" {
+  description = "ID of the VPC"
+  type        = string
+}
+
+variable "public_subnet_ids" {
+  description = "Public subnets the load balancer is placed in, at least two in different AZs"
+  type        = list(string)
+}
+
+variable "target_port" {
+  description = "Port the gateway services listen on"
+  type        = number
+  default     = 8000
+}
+
+variable "target_type" {
+  description = "How targets register with the target group: ip for services registering by address, instance for auto scaling groups"
+  type        = string
+  default     = "ip"
+
+  validation {
+    condition     = contains(["ip", "instance"], var.target_type)
+    error_message = "target_type must be ip or instance."
+  }
+}
+
+variable "health_check_path" {
+  description = "Path the target group health checks request"
+  type        = string
+  default     = "/healthz"
+}
+
+variable "certificate_arn" {
+  description = "ACM certificate of the HTTPS listener, no HTTPS listener is created when empty"
+  type        = string
+  default     = ""
+}
+
+variable "force_https" {
+  description = "Whether the HTTP listener redirects to HTTPS instead of forwarding, requires certificate_arn"
+  type        = bool
+  default     = false
+}
+
+variable "deletion_protection" {
+  description = "Whether deletion protection is enabled on the load balancer"
+  type        = bool
+  default     = false
+}
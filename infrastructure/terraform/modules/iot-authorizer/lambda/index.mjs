@@ -0,0 +1,128 @@
+// Custom authorizer for devices connecting with a signed token instead of an
+// X.509 certificate.
+//
+// A token is "<claims>.<signature>", both base64url encoded, where claims is a
+// JSON object with the client ID the device connects as in "sub" and an expiry
+// in unix seconds in "exp". The signature is an Ed25519 signature of the
+// encoded claims made with the key whose public half is in TOKEN_PUBLIC_KEY.
+// Devices send the token as the MQTT password, or in the token parameter when
+// connecting over HTTP or WebSockets.
+
+import { createPublicKey, verify } from "node:crypto";
+
+const publicKey = createPublicKey(process.env.TOKEN_PUBLIC_KEY);
+const arnPrefix = `arn:aws:iot:${process.env.AWS_REGION}:${process.env.ACCOUNT_ID}`;
+
+// Connections are re-authorized at least this often
+const refreshAfterSeconds = 300;
+const disconnectAfterSeconds = 86400;
+
+export const handler = async (event) => {
+  const clientId = event.protocolData?.mqtt?.clientId;
+  const claims = verifiedClaims(tokenOf(event));
+
+  if (!claims || (clientId && claims.sub !== clientId)) {
+    console.log(`denying connection of client ${clientId}`);
+    return deny(clientId);
+  }
+  return allow(claims.sub);
+};
+
+function tokenOf(event) {
+  if (event.token) {
+    return event.token;
+  }
+  const password = event.protocolData?.mqtt?.password;
+  return password ? Buffer.from(password, "base64").toString("utf8") : "";
+}
+
+// verifiedClaims returns the claims of a token that is well formed, correctly
+// signed and not expired, and null for anything else.
+function verifiedClaims(token) {
+  const [encodedClaims, encodedSignature, ...rest] = (token || "").split(".");
+  if (!encodedClaims || !encodedSignature || rest.length > 0) {
+    return null;
+  }
+
+  let signed;
+  try {
+    signed = verify(null, Buffer.from(encodedClaims), publicKey, Buffer.from(encodedSignature, "base64url"));
+  } catch {
+    signed = false;
+  }
+  if (!signed) {
+    return null;
+  }
+
+  let claims;
+  try {
+    claims = JSON.parse(Buffer.from(encodedClaims, "base64url").toString("utf8"));
+  } catch {
+    return null;
+  }
+  if (typeof claims.sub !== "string" || claims.sub === "" || typeof claims.exp !== "number") {
+    return null;
+  }
+  if (claims.exp <= Math.floor(Date.now() / 1000)) {
+    return null;
+  }
+  return claims;
+}
+
+// Same permissions as the device certificate policy of the iot-core module
+function allow(clientId) {
+  return {
+    isAuthenticated: true,
+    principalId: principalIdOf(clientId),
+    refreshAfterInSeconds: refreshAfterSeconds,
+    disconnectAfterInSeconds: disconnectAfterSeconds,
+    policyDocuments: [
+      JSON.stringify({
+        Version: "2012-10-17",
+        Statement: [
+          {
+            Effect: "Allow",
+            Action: "iot:Connect",
+            Resource: `${arnPrefix}:client/${clientId}`,
+          },
+          {
+            Effect: "Allow",
+            Action: ["iot:Publish", "iot:Receive"],
+            Resource: `${arnPrefix}:topic/devices/${process.env.ENVIRONMENT}/${clientId}/*`,
+          },
+          {
+            Effect: "Allow",
+            Action: "iot:Subscribe",
+            Resource: `${arnPrefix}:topicfilter/devices/${process.env.ENVIRONMENT}/${clientId}/*`,
+          },
+        ],
+      }),
+    ],
+  };
+}
+
+function deny(clientId) {
+  return {
+    isAuthenticated: false,
+    principalId: principalIdOf(clientId || "unknown"),
+    refreshAfterInSeconds: refreshAfterSeconds,
+    disconnectAfterInSeconds: disconnectAfterSeconds,
+    policyDocuments: [
+      JSON.stringify({
+        Version: "2012-10-17",
+        Statement: [
+          {
+            Effect: "Deny",
+            Action: "iot:Connect",
+            Resource: `${arnPrefix}:client/*`,
+          },
+        ],
+      }),
+    ],
+  };
+}
+
+// Principal IDs may only contain letters and digits
+function principalIdOf(clientId) {
+  return clientId.replace(/[^a-zA-Z0-9]/g, "").slice(0, 128) || "unknown";
+}
@@ -0,0 +1,135 @@
+# IoT Authorizer Module
+#
+# Custom authorizer letting devices connect with a signed token instead of an
+# X.509 certificate. The Lambda in lambda/ verifies the token and returns the
+# policy of the device; see there for the token format.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_region" "current" {}
+
+data "aws_caller_identity" "current" {}
+
+data "archive_file" "authorizer" {
+  type        = "zip"
+  source_dir  = "${path.module}/lambda"
+  output_path = "${path.module}/.build/authorizer.zip"
+}
+
+resource "aws_iam_role" "authorizer" {
+  name = "${local.name}-iot-authorizer"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "lambda.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy_attachment" "authorizer_logs" {
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+  role       = aws_iam_role.authorizer.name
+}
+
+resource "aws_lambda_function" "authorizer" {
+  function_name    = "${local.name}-iot-authorizer"
+  role             = aws_iam_role.authorizer.arn
+  runtime          = "nodejs20.x"
+  handler          = "index.handler"
+  filename         = data.archive_file.authorizer.output_path
+  source_code_hash = data.archive_file.authorizer.output_base64sha256
+  timeout          = 5
+
+  environment {
+    variables = {
+      TOKEN_PUBLIC_KEY = var.token_signing_public_key
+      ACCOUNT_ID       = data.aws_caller_identity.current.account_id
+      ENVIRONMENT      = var.environment
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-iot-authorizer"
+  })
+
+  depends_on = [aws_iam_role_policy_attachment.authorizer_logs]
+}
+
+# The Lambda checks the token signature itself, so IoT's own token signing
+# check is disabled
+resource "aws_iot_authorizer" "device" {
+  name                    = "${local.name}-device-authorizer"
+  authorizer_function_arn = aws_lambda_function.authorizer.arn
+  signing_disabled        = true
+  status                  = "ACTIVE"
+  enable_caching_for_http = true
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-device-authorizer"
+  })
+}
+
+resource "aws_lambda_permission" "iot_invoke" {
+  statement_id  = "AllowIotInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.authorizer.function_name
+  principal     = "iot.amazonaws.com"
+  source_arn    = aws_iot_authorizer.device.arn
+}
+
+# The provider has no resource for the account's default authorizer, so it is
+# set through the AWS CLI. The default it replaces is read before the first
+# apply and kept in the triggers; on destroy it is restored, or the default
+# cleared when there was none or it no longer exists. Either only happens
+# while the default is still this authorizer.
+data "external" "previous_default_authorizer" {
+  count = var.make_default ? 1 : 0
+
+  program = ["sh", "-c", <<-EOT
+    aws iot describe-default-authorizer --region ${data.aws_region.current.name} --query '{name: authorizerDescription.authorizerName || `""`}' --output json 2>/dev/null || echo '{"name": ""}'
+  EOT
+  ]
+}
+
+resource "null_resource" "default_authorizer" {
+  count = var.make_default ? 1 : 0
+
+  triggers = {
+    authorizer_name  = aws_iot_authorizer.device.name
+    region           = data.aws_region.current.name
+    previous_default = data.external.previous_default_authorizer[0].result.name
+  }
+
+  provisioner "local-exec" {
+    command = "aws iot set-default-authorizer --authorizer-name ${self.triggers.authorizer_name} --region ${self.triggers.region}"
+  }
+
+  provisioner "local-exec" {
+    when    = destroy
+    command = "[ \"$(aws iot describe-default-authorizer --region ${self.triggers.region} --query authorizerDescription.authorizerName --output text 2>/dev/null)\" != \"${self.triggers.authorizer_name}\" ] || { [ -n \"${self.triggers.previous_default}\" ] && aws iot set-default-authorizer --authorizer-name \"${self.triggers.previous_default}\" --region ${self.triggers.region}; } || aws iot clear-default-authorizer --region ${self.triggers.region}"
+  }
+
+  # Once this authorizer is the default, reading the default again returns
+  # it, which must not replace what was recorded before the first apply
+  lifecycle {
+    ignore_changes = [triggers["previous_default"]]
+  }
+
+  depends_on = [aws_lambda_permission.iot_invoke]
+}
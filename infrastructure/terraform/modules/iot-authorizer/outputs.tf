@@ -0,0 +1,9 @@
+output "authorizer_name" {
+  description = "Name of the IoT custom authorizer"
+  value       = aws_iot_authorizer.device.name
+}
+
+output "function_name" {
+  description = "Name of the Lambda function behind the authorizer"
+  value       = aws_lambda_function.authorizer.function_name
+}
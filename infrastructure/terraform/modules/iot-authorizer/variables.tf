@@ -0,0 +1,36 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "token_signing_public_key" {
+  description = "PEM encoded Ed25519 public key device tokens are verified against"
+  type        = string
+}
+
+variable "make_default" {
+  description = "Whether to make this the account's default authorizer for custom authentication"
+  type        = bool
+  default     = false
+}
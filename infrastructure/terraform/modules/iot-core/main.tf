@@ -0,0 +1,144 @@
+# IoT Core Module
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  iot_arn_prefix = "arn:aws:iot:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}"
+}
+
+data "aws_region" "current" {}
+
+data "aws_caller_identity" "current" {}
+
+# The ATS endpoint serves certificates signed by Amazon Root CA 1, which is in
+# the default trust store of most MQTT clients
+data "aws_iot_endpoint" "data" {
+  endpoint_type = "iot:Data-ATS"
+}
+
+resource "aws_iot_thing_type" "device" {
+  name = "${local.name}-device"
+
+  properties {
+    description           = "IoT network device"
+    searchable_attributes = ["firmware_version"]
+  }
+
+  tags = {
+    Name        = "${local.name}-device"
+    Environment = var.environment
+  }
+}
+
+resource "aws_iot_thing_group" "devices" {
+  name = "${local.name}-devices"
+
+  tags = {
+    Name        = "${local.name}-devices"
+    Environment = var.environment
+  }
+}
+
+# Devices may only connect as their own thing and only use their own topics,
+# devices/<environment>/<thing name>/...
+resource "aws_iot_policy" "device" {
+  name = "${local.name}-device-policy"
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "iot:Connect"
+        Resource = "${local.iot_arn_prefix}:client/$${iot:Connection.Thing.ThingName}"
+      },
+      {
+        Effect   = "Allow"
+        Action   = ["iot:Publish", "iot:Receive"]
+        Resource = "${local.iot_arn_prefix}:topic/devices/${var.environment}/$${iot:Connection.Thing.ThingName}/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = "iot:Subscribe"
+        Resource = "${local.iot_arn_prefix}:topicfilter/devices/${var.environment}/$${iot:Connection.Thing.ThingName}/*"
+      },
+    ]
+  })
+}
+
+# Provisioning
+resource "aws_iam_role" "provisioning" {
+  name = "${local.name}-iot-provisioning"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "iot.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "provisioning" {
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSIoTThingsRegistration"
+  role       = aws_iam_role.provisioning.name
+}
+
+resource "aws_iot_provisioning_template" "fleet" {
+  name                  = "${local.name}-fleet"
+  description           = "Registers a device thing and activates its certificate"
+  provisioning_role_arn = aws_iam_role.provisioning.arn
+  enabled               = true
+
+  template_body = jsonencode({
+    Parameters = {
+      ThingName = {
+        Type = "String"
+      }
+      "AWS::IoT::Certificate::Id" = {
+        Type = "String"
+      }
+    }
+
+    Resources = {
+      certificate = {
+        Type = "AWS::IoT::Certificate"
+        Properties = {
+          CertificateId = { Ref = "AWS::IoT::Certificate::Id" }
+          Status        = "Active"
+        }
+      }
+
+      policy = {
+        Type = "AWS::IoT::Policy"
+        Properties = {
+          PolicyName = aws_iot_policy.device.name
+        }
+      }
+
+      thing = {
+        Type = "AWS::IoT::Thing"
+        OverrideSettings = {
+          AttributePayload = "MERGE"
+          ThingGroups      = "DO_NOTHING"
+          ThingTypeName    = "REPLACE"
+        }
+        Properties = {
+          ThingName     = { Ref = "ThingName" }
+          ThingTypeName = aws_iot_thing_type.device.name
+          ThingGroups   = [aws_iot_thing_group.devices.name]
+        }
+      }
+    }
+  })
+
+  tags = {
+    Name        = "${local.name}-fleet"
+    Environment = var.environment
+  }
+
+  depends_on = [aws_iam_role_policy_attachment.provisioning]
+}
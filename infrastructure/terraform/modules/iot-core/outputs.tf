@@ -0,0 +1,24 @@
+output "thing_type_name" {
+  description = "Name of the device thing type"
+  value       = aws_iot_thing_type.device.name
+}
+
+output "thing_group_name" {
+  description = "Name of the thing group provisioned devices join"
+  value       = aws_iot_thing_group.devices.name
+}
+
+output "device_policy_name" {
+  description = "Name of the IoT policy attached to device certificates"
+  value       = aws_iot_policy.device.name
+}
+
+output "provisioning_template_name" {
+  description = "Name of the fleet provisioning template"
+  value       = aws_iot_provisioning_template.fleet.name
+}
+
+output "broker_endpoint" {
+  description = "Hostname devices connect to over MQTT/TLS on port 8883"
+  value       = data.aws_iot_endpoint.data.endpoint_address
+}
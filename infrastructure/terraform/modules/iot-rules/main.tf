@@ -0,0 +1,95 @@
+# IoT Rules Module
+#
+# Routes device telemetry published on devices/<environment>/<thing>/telemetry
+# into a DynamoDB table holding the latest reading of every device.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+resource "aws_dynamodb_table" "telemetry" {
+  name                        = "${local.name}-telemetry"
+  billing_mode                = "PAY_PER_REQUEST"
+  hash_key                    = "device_id"
+  deletion_protection_enabled = var.deletion_protection
+
+  attribute {
+    name = "device_id"
+    type = "S"
+  }
+
+  dynamic "server_side_encryption" {
+    for_each = var.kms_key_arn == "" ? [] : [var.kms_key_arn]
+    content {
+      enabled     = true
+      kms_key_arn = server_side_encryption.value
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-telemetry"
+  })
+}
+
+resource "aws_iam_role" "telemetry_rule" {
+  name = "${local.name}-telemetry-rule"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "iot.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "telemetry_rule" {
+  name = "${local.name}-telemetry-rule"
+  role = aws_iam_role.telemetry_rule.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+      Action   = "dynamodb:PutItem"
+      Resource = aws_dynamodb_table.telemetry.arn
+    }]
+  })
+}
+
+# Readings without a numeric temperature are dropped by the WHERE clause
+# rather than written to the table. Rule names only allow letters, digits and
+# underscores.
+resource "aws_iot_topic_rule" "telemetry" {
+  name        = replace("${local.name}_telemetry", "-", "_")
+  description = "Stores the latest telemetry reading of every device"
+  enabled     = true
+  sql         = "SELECT *, topic(3) AS device_id, timestamp() AS received_at FROM 'devices/${var.environment}/+/telemetry' WHERE isNumber(temperature)"
+  sql_version = "2016-03-23"
+
+  dynamodbv2 {
+    role_arn = aws_iam_role.telemetry_rule.arn
+
+    put_item {
+      table_name = aws_dynamodb_table.telemetry.name
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-telemetry"
+  })
+
+  depends_on = [aws_iam_role_policy.telemetry_rule]
+}
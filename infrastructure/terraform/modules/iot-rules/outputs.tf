@@ -0,0 +1,19 @@
+output "telemetry_table_name" {
+  description = "Name of the DynamoDB table holding the latest reading of every device"
+  value       = aws_dynamodb_table.telemetry.name
+}
+
+output "telemetry_rule_name" {
+  description = "Name of the IoT topic rule routing telemetry into the table"
+  value       = aws_iot_topic_rule.telemetry.name
+}
+
+output "telemetry_topic_filter" {
+  description = "MQTT topic filter the telemetry rule selects from"
+  value       = "devices/${var.environment}/+/telemetry"
+}
+
+output "telemetry_table_arn" {
+  description = "ARN of the DynamoDB table holding the latest reading of every device"
+  value       = aws_dynamodb_table.telemetry.arn
+}
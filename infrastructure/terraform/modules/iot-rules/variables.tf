@@ -0,0 +1,37 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "kms_key_arn" {
+  description = "ARN of the KMS key the telemetry table is encrypted with; AWS managed encryption is used when empty"
+  type        = string
+  default     = ""
+}
+
+variable "deletion_protection" {
+  description = "Whether the telemetry table is protected from being deleted, which also blocks destroying the module"
+  type        = bool
+  default     = false
+}
@@ -0,0 +1,183 @@
+# Ledger Module
+#
+# The append-only audit trail backing the decentralized device registry. Every
+# device attestation is written to the attestation bucket as an object, which
+# S3 Object Lock keeps from being overwritten or deleted for retention_days,
+# and indexed in the attestation table by device with the object's version ID
+# and SHA-256 checksum. Anyone with read access can prove an attestation is
+# unaltered by comparing the checksum S3 computed on upload with the one in the
+# index. Readers and writers go through the access role, which can add
+# attestations but never delete or shorten the retention of one.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_caller_identity" "current" {}
+
+resource "aws_s3_bucket" "attestations" {
+  bucket              = "${local.name}-attestations-${data.aws_caller_identity.current.account_id}"
+  object_lock_enabled = true
+  force_destroy       = var.force_destroy
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-attestations"
+  })
+}
+
+resource "aws_s3_bucket_ownership_controls" "attestations" {
+  bucket = aws_s3_bucket.attestations.id
+
+  rule {
+    object_ownership = "BucketOwnerEnforced"
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "attestations" {
+  bucket = aws_s3_bucket.attestations.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+# Object Lock works on versions, so versioning can never be suspended
+resource "aws_s3_bucket_versioning" "attestations" {
+  bucket = aws_s3_bucket.attestations.id
+
+  versioning_configuration {
+    status = "Enabled"
+  }
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "attestations" {
+  bucket = aws_s3_bucket.attestations.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm     = var.kms_key_arn == "" ? "AES256" : "aws:kms"
+      kms_master_key_id = var.kms_key_arn == "" ? null : var.kms_key_arn
+    }
+    bucket_key_enabled = var.kms_key_arn != ""
+  }
+}
+
+# Every new version is locked on upload. COMPLIANCE retention cannot be
+# shortened or bypassed by anyone, GOVERNANCE retention only with
+# s3:BypassGovernanceRetention.
+resource "aws_s3_bucket_object_lock_configuration" "attestations" {
+  bucket = aws_s3_bucket.attestations.id
+
+  rule {
+    default_retention {
+      mode = var.retention_mode
+      days = var.retention_days
+    }
+  }
+
+  depends_on = [aws_s3_bucket_versioning.attestations]
+}
+
+resource "aws_dynamodb_table" "attestations" {
+  name                        = "${local.name}-attestations"
+  billing_mode                = "PAY_PER_REQUEST"
+  hash_key                    = "device_id"
+  range_key                   = "recorded_at"
+  deletion_protection_enabled = var.deletion_protection
+
+  attribute {
+    name = "device_id"
+    type = "S"
+  }
+
+  attribute {
+    name = "recorded_at"
+    type = "N"
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  server_side_encryption {
+    enabled     = var.kms_key_arn != ""
+    kms_key_arn = var.kms_key_arn == "" ? null : var.kms_key_arn
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-attestations"
+  })
+}
+
+resource "aws_iam_role" "access" {
+  name = "${local.name}-ledger-access"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        AWS = var.access_principal_arns
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+# Attestations can be added and read, never deleted, overwritten in the index
+# or unlocked: the role has no s3:Delete*, s3:PutObjectRetention or
+# dynamodb:UpdateItem, and writers add index items with a condition that the
+# item does not exist yet
+resource "aws_iam_role_policy" "access" {
+  name = "${local.name}-ledger-access"
+  role = aws_iam_role.access.id
+
+  policy = jsonencode({
+    Statement = concat(
+      [
+        {
+          Sid = "Attestations"
+          Action = [
+            "s3:PutObject",
+            "s3:GetObject",
+            "s3:GetObjectVersion",
+            "s3:GetObjectAttributes",
+            "s3:GetObjectVersionAttributes",
+            "s3:GetObjectRetention",
+          ]
+          Effect   = "Allow"
+          Resource = "${aws_s3_bucket.attestations.arn}/*"
+        },
+        {
+          Sid      = "AttestationVersions"
+          Action   = ["s3:ListBucket", "s3:ListBucketVersions"]
+          Effect   = "Allow"
+          Resource = aws_s3_bucket.attestations.arn
+        },
+        {
+          Sid      = "Index"
+          Action   = ["dynamodb:PutItem", "dynamodb:GetItem", "dynamodb:Query"]
+          Effect   = "Allow"
+          Resource = aws_dynamodb_table.attestations.arn
+        },
+      ],
+      var.kms_key_arn == "" ? [] : [{
+        Sid      = "Encryption"
+        Action   = ["kms:GenerateDataKey", "kms:Decrypt"]
+        Effect   = "Allow"
+        Resource = var.kms_key_arn
+      }],
+    )
+    Version = "2012-10-17"
+  })
+}
@@ -0,0 +1,24 @@
+output "bucket_name" {
+  description = "Name of the S3 bucket holding the attestations"
+  value       = aws_s3_bucket.attestations.bucket
+}
+
+output "bucket_arn" {
+  description = "ARN of the S3 bucket holding the attestations"
+  value       = aws_s3_bucket.attestations.arn
+}
+
+output "table_name" {
+  description = "Name of the DynamoDB table indexing the attestations by device"
+  value       = aws_dynamodb_table.attestations.name
+}
+
+output "table_arn" {
+  description = "ARN of the DynamoDB table indexing the attestations by device"
+  value       = aws_dynamodb_table.attestations.arn
+}
+
+output "access_role_arn" {
+  description = "ARN of the role that adds and reads attestations"
+  value       = aws_iam_role.access.arn
+}
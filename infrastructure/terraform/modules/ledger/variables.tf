@@ -0,0 +1,75 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "deletion_protection" {
+  description = "Whether the attestation table is protected from deletion; it has to be turned off, and applied, before the table can be destroyed"
+  type        = bool
+  default     = true
+}
+
+variable "retention_mode" {
+  description = "Object Lock mode of the attestations: COMPLIANCE retention cannot be shortened by anyone, GOVERNANCE retention by principals allowed s3:BypassGovernanceRetention"
+  type        = string
+  default     = "COMPLIANCE"
+
+  validation {
+    condition     = contains(["COMPLIANCE", "GOVERNANCE"], var.retention_mode)
+    error_message = "retention_mode must be COMPLIANCE or GOVERNANCE."
+  }
+}
+
+variable "retention_days" {
+  description = "Days every attestation is locked against being overwritten or deleted"
+  type        = number
+  default     = 365
+
+  validation {
+    condition     = var.retention_days >= 1 && floor(var.retention_days) == var.retention_days
+    error_message = "retention_days must be a whole number of days, at least 1."
+  }
+}
+
+variable "force_destroy" {
+  description = "Whether destroying the module deletes the attestation bucket along with the attestations in it; locked versions can only be deleted in GOVERNANCE mode"
+  type        = bool
+  default     = false
+}
+
+variable "kms_key_arn" {
+  description = "ARN of the KMS key the attestations and their index are encrypted with, AWS owned keys when empty"
+  type        = string
+  default     = ""
+}
+
+variable "access_principal_arns" {
+  description = "ARNs of the principals allowed to assume the access role, e.g. the registry service's role"
+  type        = list(string)
+
+  validation {
+    condition     = length(var.access_principal_arns) > 0 && alltrue([for arn in var.access_principal_arns : can(regex("^arn:aws[a-z-]*:iam::[0-9]{12}:", arn))])
+    error_message = "access_principal_arns must list at least one IAM principal ARN."
+  }
+}
@@ -0,0 +1,256 @@
+# Logging Module
+#
+# Ships device and broker logs to central storage. Devices publish their logs
+# to devices/<environment>/<thing name>/logs, which a topic rule writes to the
+# device log group; the broker and the MQTT bridge write to the broker log
+# group. A subscription filter on each group forwards the matching events
+# through a Firehose delivery stream into the sink bucket under logs/, where
+# each object holds the gzipped CloudWatch Logs payloads of one buffer.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  # Log groups by source, each with the pattern of the events it forwards
+  filter_patterns = {
+    devices = var.device_filter_pattern
+    broker  = var.broker_filter_pattern
+  }
+
+  sink_prefix = "logs/"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_region" "current" {}
+
+data "aws_caller_identity" "current" {}
+
+resource "aws_cloudwatch_log_group" "sources" {
+  for_each = local.filter_patterns
+
+  name              = "/iot/${local.name}/${each.key}"
+  retention_in_days = var.retention_in_days
+  kms_key_id        = var.kms_key_arn == "" ? null : var.kms_key_arn
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-${each.key}-logs"
+  })
+}
+
+# Device logs
+resource "aws_iam_role" "device_logs_rule" {
+  name = "${local.name}-device-logs-rule"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "iot.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "device_logs_rule" {
+  name = "${local.name}-device-logs-rule"
+  role = aws_iam_role.device_logs_rule.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+      Action   = ["logs:CreateLogStream", "logs:PutLogEvents", "logs:DescribeLogStreams"]
+      Resource = "${aws_cloudwatch_log_group.sources["devices"].arn}:*"
+    }]
+  })
+}
+
+resource "aws_iot_topic_rule" "device_logs" {
+  name        = replace("${local.name}_device_logs", "-", "_")
+  description = "Writes the logs devices publish to the device log group"
+  enabled     = true
+  sql         = "SELECT *, topic(3) AS device_id FROM 'devices/${var.environment}/+/logs'"
+  sql_version = "2016-03-23"
+
+  cloudwatch_logs {
+    log_group_name = aws_cloudwatch_log_group.sources["devices"].name
+    role_arn       = aws_iam_role.device_logs_rule.arn
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-device-logs"
+  })
+
+  depends_on = [aws_iam_role_policy.device_logs_rule]
+}
+
+# Sink
+resource "aws_s3_bucket" "sink" {
+  bucket        = "${local.name}-log-sink-${data.aws_caller_identity.current.account_id}"
+  force_destroy = var.force_destroy
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-log-sink"
+  })
+}
+
+resource "aws_s3_bucket_ownership_controls" "sink" {
+  bucket = aws_s3_bucket.sink.id
+
+  rule {
+    object_ownership = "BucketOwnerEnforced"
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "sink" {
+  bucket = aws_s3_bucket.sink.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "sink" {
+  bucket = aws_s3_bucket.sink.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm     = var.kms_key_arn == "" ? "AES256" : "aws:kms"
+      kms_master_key_id = var.kms_key_arn == "" ? null : var.kms_key_arn
+    }
+    bucket_key_enabled = var.kms_key_arn != ""
+  }
+}
+
+resource "aws_iam_role" "delivery" {
+  name = "${local.name}-log-delivery"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "firehose.amazonaws.com"
+      }
+      Condition = {
+        StringEquals = {
+          "sts:ExternalId" = data.aws_caller_identity.current.account_id
+        }
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "delivery" {
+  name = "${local.name}-log-delivery"
+  role = aws_iam_role.delivery.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = concat(
+      [{
+        Sid = "Sink"
+        Action = [
+          "s3:AbortMultipartUpload",
+          "s3:GetBucketLocation",
+          "s3:GetObject",
+          "s3:ListBucket",
+          "s3:ListBucketMultipartUploads",
+          "s3:PutObject",
+        ]
+        Effect   = "Allow"
+        Resource = [aws_s3_bucket.sink.arn, "${aws_s3_bucket.sink.arn}/*"]
+      }],
+      var.kms_key_arn == "" ? [] : [{
+        Sid      = "SinkEncryption"
+        Action   = ["kms:GenerateDataKey", "kms:Decrypt"]
+        Effect   = "Allow"
+        Resource = var.kms_key_arn
+      }],
+    )
+  })
+}
+
+# CloudWatch Logs payloads arrive gzipped and are stored as they are
+resource "aws_kinesis_firehose_delivery_stream" "sink" {
+  name        = "${local.name}-log-sink"
+  destination = "extended_s3"
+
+  extended_s3_configuration {
+    role_arn            = aws_iam_role.delivery.arn
+    bucket_arn          = aws_s3_bucket.sink.arn
+    prefix              = local.sink_prefix
+    error_output_prefix = "errors/!{firehose:error-output-type}/"
+    buffering_size      = 5
+    buffering_interval  = var.buffering_interval
+    compression_format  = "UNCOMPRESSED"
+    kms_key_arn         = var.kms_key_arn == "" ? null : var.kms_key_arn
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-log-sink"
+  })
+
+  depends_on = [aws_iam_role_policy.delivery]
+}
+
+resource "aws_iam_role" "subscription" {
+  name = "${local.name}-log-subscription"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "logs.amazonaws.com"
+      }
+      Condition = {
+        StringLike = {
+          "aws:SourceArn" = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:log-group:/iot/${local.name}/*"
+        }
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "subscription" {
+  name = "${local.name}-log-subscription"
+  role = aws_iam_role.subscription.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Action   = ["firehose:PutRecord", "firehose:PutRecordBatch"]
+      Effect   = "Allow"
+      Resource = aws_kinesis_firehose_delivery_stream.sink.arn
+    }]
+  })
+}
+
+resource "aws_cloudwatch_log_subscription_filter" "sink" {
+  for_each = local.filter_patterns
+
+  name            = "${local.name}-${each.key}-to-sink"
+  log_group_name  = aws_cloudwatch_log_group.sources[each.key].name
+  filter_pattern  = each.value
+  destination_arn = aws_kinesis_firehose_delivery_stream.sink.arn
+  role_arn        = aws_iam_role.subscription.arn
+
+  depends_on = [aws_iam_role_policy.subscription]
+}
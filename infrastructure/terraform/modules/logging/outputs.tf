@@ -0,0 +1,34 @@
+output "device_log_group_name" {
+  description = "Name of the log group device logs are written to"
+  value       = aws_cloudwatch_log_group.sources["devices"].name
+}
+
+output "broker_log_group_name" {
+  description = "Name of the log group the broker and the MQTT bridge write to"
+  value       = aws_cloudwatch_log_group.sources["broker"].name
+}
+
+output "log_group_names" {
+  description = "Names of every log group the module creates"
+  value       = [for group in aws_cloudwatch_log_group.sources : group.name]
+}
+
+output "device_logs_rule_name" {
+  description = "Name of the topic rule writing device logs to the device log group"
+  value       = aws_iot_topic_rule.device_logs.name
+}
+
+output "delivery_stream_arn" {
+  description = "ARN of the Firehose delivery stream the subscription filters forward to"
+  value       = aws_kinesis_firehose_delivery_stream.sink.arn
+}
+
+output "sink_bucket_name" {
+  description = "Name of the S3 bucket the forwarded logs are stored in"
+  value       = aws_s3_bucket.sink.bucket
+}
+
+output "sink_prefix" {
+  description = "Key prefix of the forwarded logs in the sink bucket"
+  value       = local.sink_prefix
+}
@@ -0,0 +1,71 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "retention_in_days" {
+  description = "Days the device and broker log groups keep their events"
+  type        = number
+  default     = 30
+
+  validation {
+    condition     = contains([1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653], var.retention_in_days)
+    error_message = "retention_in_days must be a retention CloudWatch Logs supports, such as 7, 30 or 365."
+  }
+}
+
+variable "kms_key_arn" {
+  description = "ARN of the KMS key the log groups and the sink bucket are encrypted with, AWS owned keys when empty. Its key policy has to let CloudWatch Logs use it for the log groups"
+  type        = string
+  default     = ""
+}
+
+variable "device_filter_pattern" {
+  description = "Filter pattern selecting the device log events forwarded to the sink; the default forwards structured events that name their device"
+  type        = string
+  default     = "{ $.device_id = * }"
+}
+
+variable "broker_filter_pattern" {
+  description = "Filter pattern selecting the broker log events forwarded to the sink, all of them when empty"
+  type        = string
+  default     = ""
+}
+
+variable "buffering_interval" {
+  description = "Seconds the delivery stream buffers log events before writing them to the sink bucket"
+  type        = number
+  default     = 300
+
+  validation {
+    condition     = var.buffering_interval >= 60 && var.buffering_interval <= 900
+    error_message = "buffering_interval must be between 60 and 900 seconds."
+  }
+}
+
+variable "force_destroy" {
+  description = "Whether destroying the module deletes the sink bucket along with the logs still in it"
+  type        = bool
+  default     = false
+}
@@ -0,0 +1,152 @@
+# Monitoring Module
+#
+# CloudWatch alarms, and a dashboard of the same metrics, for the three things
+# that have taken devices offline before: the broker losing its connected
+# clients, the telemetry rule failing to write to DynamoDB, and NAT gateways
+# dropping packets. Every alarm notifies the alarms SNS topic. Metrics are
+# pointed at the resources the other modules created, never at fixed names, so
+# an alarm cannot end up watching a metric nothing publishes.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+
+  # The broker publishes its connected client count per deployment
+  broker_dimensions = {
+    Deployment = local.name
+  }
+}
+
+data "aws_region" "current" {}
+
+# Not encrypted with the AWS managed key: CloudWatch alarms cannot publish to
+# topics encrypted with it
+resource "aws_sns_topic" "alarms" {
+  name = "${local.name}-alarms"
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-alarms"
+  })
+}
+
+resource "aws_cloudwatch_metric_alarm" "broker_connections" {
+  alarm_name          = "${local.name}-broker-connections"
+  alarm_description   = "Fewer than ${var.min_broker_connections} clients are connected to the MQTT broker"
+  namespace           = var.broker_metric_namespace
+  metric_name         = "ConnectedClients"
+  dimensions          = local.broker_dimensions
+  statistic           = "Minimum"
+  period              = 60
+  evaluation_periods  = 1
+  comparison_operator = "LessThanThreshold"
+  threshold           = var.min_broker_connections
+  treat_missing_data  = "missing"
+  alarm_actions       = [aws_sns_topic.alarms.arn]
+  ok_actions          = [aws_sns_topic.alarms.arn]
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-broker-connections"
+  })
+}
+
+resource "aws_cloudwatch_metric_alarm" "telemetry_rule_errors" {
+  alarm_name          = "${local.name}-telemetry-rule-errors"
+  alarm_description   = "IoT rule ${var.telemetry_rule_name} fails to write telemetry to DynamoDB"
+  namespace           = "AWS/IoT"
+  metric_name         = "Failure"
+
+  dimensions = {
+    RuleName   = var.telemetry_rule_name
+    ActionType = "DynamoDBv2"
+  }
+
+  statistic           = "Sum"
+  period              = 300
+  evaluation_periods  = 1
+  comparison_operator = "GreaterThanOrEqualToThreshold"
+  threshold           = var.rule_failure_threshold
+  treat_missing_data  = "notBreaching"
+  alarm_actions       = [aws_sns_topic.alarms.arn]
+  ok_actions          = [aws_sns_topic.alarms.arn]
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-telemetry-rule-errors"
+  })
+}
+
+resource "aws_cloudwatch_metric_alarm" "nat_packet_drops" {
+  count = length(var.nat_gateway_ids)
+
+  alarm_name          = "${local.name}-nat-packet-drops-${count.index + 1}"
+  alarm_description   = "NAT gateway ${var.nat_gateway_ids[count.index]} drops packets"
+  namespace           = "AWS/NATGateway"
+  metric_name         = "PacketsDropCount"
+
+  dimensions = {
+    NatGatewayId = var.nat_gateway_ids[count.index]
+  }
+
+  statistic           = "Sum"
+  period              = 300
+  evaluation_periods  = 1
+  comparison_operator = "GreaterThanThreshold"
+  threshold           = var.nat_packet_drop_threshold
+  treat_missing_data  = "notBreaching"
+  alarm_actions       = [aws_sns_topic.alarms.arn]
+  ok_actions          = [aws_sns_topic.alarms.arn]
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-nat-packet-drops-${count.index + 1}"
+  })
+}
+
+resource "aws_cloudwatch_dashboard" "main" {
+  dashboard_name = "${local.name}-overview"
+
+  dashboard_body = jsonencode({
+    widgets = [
+      {
+        type   = "metric"
+        width  = 8
+        height = 6
+        properties = {
+          title   = "Broker connected clients"
+          region  = data.aws_region.current.name
+          stat    = "Minimum"
+          period  = 60
+          metrics = [[var.broker_metric_namespace, "ConnectedClients", "Deployment", local.name]]
+        }
+      },
+      {
+        type   = "metric"
+        width  = 8
+        height = 6
+        properties = {
+          title   = "Telemetry rule failures"
+          region  = data.aws_region.current.name
+          stat    = "Sum"
+          period  = 300
+          metrics = [["AWS/IoT", "Failure", "RuleName", var.telemetry_rule_name, "ActionType", "DynamoDBv2"]]
+        }
+      },
+      {
+        type   = "metric"
+        width  = 8
+        height = 6
+        properties = {
+          title   = "NAT gateway packet drops"
+          region  = data.aws_region.current.name
+          stat    = "Sum"
+          period  = 300
+          metrics = [for id in var.nat_gateway_ids : ["AWS/NATGateway", "PacketsDropCount", "NatGatewayId", id]]
+        }
+      },
+    ]
+  })
+}
@@ -0,0 +1,22 @@
+output "alarm_topic_arn" {
+  description = "ARN of the SNS topic every alarm notifies"
+  value       = aws_sns_topic.alarms.arn
+}
+
+output "alarm_names" {
+  description = "Names of the CloudWatch alarms"
+  value = concat(
+    [aws_cloudwatch_metric_alarm.broker_connections.alarm_name, aws_cloudwatch_metric_alarm.telemetry_rule_errors.alarm_name],
+    aws_cloudwatch_metric_alarm.nat_packet_drops[*].alarm_name,
+  )
+}
+
+output "broker_connections_alarm_name" {
+  description = "Name of the alarm on the broker's connected clients"
+  value       = aws_cloudwatch_metric_alarm.broker_connections.alarm_name
+}
+
+output "dashboard_name" {
+  description = "Name of the CloudWatch dashboard"
+  value       = aws_cloudwatch_dashboard.main.dashboard_name
+}
@@ -0,0 +1,65 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "telemetry_rule_name" {
+  description = "Name of the IoT topic rule routing telemetry into DynamoDB, from the iot-rules module"
+  type        = string
+}
+
+variable "nat_gateway_ids" {
+  description = "IDs of the NAT gateways to alarm on packet drops for, from the vpc module"
+  type        = list(string)
+  default     = []
+}
+
+variable "broker_metric_namespace" {
+  description = "CloudWatch namespace the MQTT broker publishes its ConnectedClients metric to"
+  type        = string
+  default     = "IoTNetwork/Broker"
+
+  validation {
+    condition     = !startswith(var.broker_metric_namespace, "AWS/")
+    error_message = "broker_metric_namespace must not start with AWS/, which is reserved for AWS services."
+  }
+}
+
+variable "min_broker_connections" {
+  description = "Alarm when fewer clients than this are connected to the broker"
+  type        = number
+  default     = 1
+}
+
+variable "rule_failure_threshold" {
+  description = "Alarm when the telemetry rule fails at least this many times in five minutes"
+  type        = number
+  default     = 1
+}
+
+variable "nat_packet_drop_threshold" {
+  description = "Alarm when a NAT gateway drops more packets than this in five minutes"
+  type        = number
+  default     = 100
+}
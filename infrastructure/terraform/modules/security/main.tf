@@ -1,7 +1,11 @@
 # Security Groups Module
 
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+}
+
 resource "aws_security_group" "web" {
-  name_prefix = "${var.project_name}-web"
+  name_prefix = "${local.name}-web"
   vpc_id      = var.vpc_id
 
   ingress {
@@ -26,13 +30,13 @@ resource "aws_security_group" "web" {
   }
 
   tags = {
-    Name        = "${var.project_name}-web-sg"
+    Name        = "${local.name}-web-sg"
     Environment = var.environment
   }
 }
 
 resource "aws_security_group" "app" {
-  name_prefix = "${var.project_name}-app"
+  name_prefix = "${local.name}-app"
   vpc_id      = var.vpc_id
 
   ingress {
@@ -57,13 +61,13 @@ resource "aws_security_group" "app" {
   }
 
   tags = {
-    Name        = "${var.project_name}-app-sg"
+    Name        = "${local.name}-app-sg"
     Environment = var.environment
   }
 }
 
 resource "aws_security_group" "database" {
-  name_prefix = "${var.project_name}-db"
+  name_prefix = "${local.name}-db"
   vpc_id      = var.vpc_id
 
   ingress {
@@ -81,7 +85,47 @@ resource "aws_security_group" "database" {
   }
 
   tags = {
-    Name        = "${var.project_name}-db-sg"
+    Name        = "${local.name}-db-sg"
+    Environment = var.environment
+  }
+}
+
+# MQTT broker: TLS from the device networks, plaintext only from inside the VPC
+resource "aws_security_group" "mqtt" {
+  name_prefix = "${local.name}-mqtt"
+  vpc_id      = var.vpc_id
+
+  dynamic "ingress" {
+    for_each = length(var.device_cidr_blocks) > 0 ? [1] : []
+    content {
+      description = "MQTT over TLS from devices"
+      from_port   = 8883
+      to_port     = 8883
+      protocol    = "tcp"
+      cidr_blocks = var.device_cidr_blocks
+    }
+  }
+
+  dynamic "ingress" {
+    for_each = var.allow_plaintext_mqtt ? [1] : []
+    content {
+      description = "Plaintext MQTT from inside the VPC"
+      from_port   = 1883
+      to_port     = 1883
+      protocol    = "tcp"
+      cidr_blocks = [var.vpc_cidr_block]
+    }
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = "-1"
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  tags = {
+    Name        = "${local.name}-mqtt-sg"
     Environment = var.environment
   }
 }
@@ -97,3 +141,7 @@ output "app_sg_id" {
 output "database_sg_id" {
   value = aws_security_group.database.id
 }
+
+output "mqtt_sg_id" {
+  value = aws_security_group.mqtt.id
+}
@@ -12,3 +12,31 @@ variable "environment" {
   description = "Environment name"
   type        = string
 }
+
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "vpc_cidr_block" {
+  description = "CIDR block of the VPC, allowed to reach the broker over plaintext MQTT"
+  type        = string
+}
+
+variable "device_cidr_blocks" {
+  description = "CIDR blocks devices connect from over MQTT/TLS"
+  type        = list(string)
+  default     = []
+
+  validation {
+    condition     = !contains(var.device_cidr_blocks, "0.0.0.0/0")
+    error_message = "device_cidr_blocks must not open the broker to 0.0.0.0/0."
+  }
+}
+
+variable "allow_plaintext_mqtt" {
+  description = "Whether to allow plaintext MQTT on 1883 from inside the VPC"
+  type        = bool
+  default     = true
+}
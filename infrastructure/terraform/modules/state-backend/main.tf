@@ -0,0 +1,84 @@
+# State Backend Module
+#
+# S3 bucket and DynamoDB lock table for the s3 backend. State is encrypted at
+# rest and every version is kept, so a corrupted state can be rolled back.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_caller_identity" "current" {}
+
+# Bucket names are global, so the account ID keeps them unique
+resource "aws_s3_bucket" "state" {
+  bucket        = "${local.name}-tfstate-${data.aws_caller_identity.current.account_id}"
+  force_destroy = var.force_destroy
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-tfstate"
+  })
+}
+
+resource "aws_s3_bucket_versioning" "state" {
+  bucket = aws_s3_bucket.state.id
+
+  versioning_configuration {
+    status = "Enabled"
+  }
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "state" {
+  bucket = aws_s3_bucket.state.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm     = "aws:kms"
+      kms_master_key_id = var.kms_key_arn == "" ? null : var.kms_key_arn
+    }
+    bucket_key_enabled = true
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "state" {
+  bucket = aws_s3_bucket.state.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+# The s3 backend expects the hash key to be called LockID
+resource "aws_dynamodb_table" "lock" {
+  name         = "${local.name}-tflock"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "LockID"
+
+  attribute {
+    name = "LockID"
+    type = "S"
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  dynamic "server_side_encryption" {
+    for_each = var.kms_key_arn == "" ? [] : [var.kms_key_arn]
+    content {
+      enabled     = true
+      kms_key_arn = server_side_encryption.value
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-tflock"
+  })
+}
@@ -0,0 +1,19 @@
+output "bucket_name" {
+  description = "Name of the S3 bucket holding the state"
+  value       = aws_s3_bucket.state.bucket
+}
+
+output "lock_table_name" {
+  description = "Name of the DynamoDB table holding the state locks"
+  value       = aws_dynamodb_table.lock.name
+}
+
+output "bucket_arn" {
+  description = "ARN of the S3 bucket holding the state"
+  value       = aws_s3_bucket.state.arn
+}
+
+output "lock_table_arn" {
+  description = "ARN of the DynamoDB table holding the state locks"
+  value       = aws_dynamodb_table.lock.arn
+}
@@ -0,0 +1,245 @@
+# Storage Module
+#
+# Telemetry history of the devices and its long-term archive. The contract the
+# ingestion and dashboard code rely on:
+#
+#   - items are keyed by message_id, so a reading delivered twice is stored once
+#   - the device-time index is keyed by device_id and recorded_at, epoch
+#     milliseconds, and projects every attribute; dashboards query it for a
+#     device over a time range
+#   - expires_at, epoch seconds, is the TTL attribute; the writer sets it, items
+#     without it are kept
+#
+# Readings older than the table keeps are archived to the bucket, which moves
+# them to archive_storage_class after archive_transition_days and, unless
+# archive_expiration_days is 0, deletes them after that many days. The writer
+# role is for the ingestion service, the reader role for the dashboards.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_caller_identity" "current" {}
+
+resource "aws_dynamodb_table" "telemetry" {
+  name                        = "${local.name}-telemetry-history"
+  billing_mode                = "PAY_PER_REQUEST"
+  hash_key                    = "message_id"
+  deletion_protection_enabled = var.deletion_protection
+
+  attribute {
+    name = "message_id"
+    type = "S"
+  }
+
+  attribute {
+    name = "device_id"
+    type = "S"
+  }
+
+  attribute {
+    name = "recorded_at"
+    type = "N"
+  }
+
+  global_secondary_index {
+    name            = "device-time"
+    hash_key        = "device_id"
+    range_key       = "recorded_at"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  dynamic "server_side_encryption" {
+    for_each = var.kms_key_arn == "" ? [] : [var.kms_key_arn]
+    content {
+      enabled     = true
+      kms_key_arn = server_side_encryption.value
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-telemetry-history"
+  })
+}
+
+resource "aws_s3_bucket" "archive" {
+  bucket        = "${local.name}-telemetry-archive-${data.aws_caller_identity.current.account_id}"
+  force_destroy = var.force_destroy
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-telemetry-archive"
+  })
+}
+
+resource "aws_s3_bucket_ownership_controls" "archive" {
+  bucket = aws_s3_bucket.archive.id
+
+  rule {
+    object_ownership = "BucketOwnerEnforced"
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "archive" {
+  bucket = aws_s3_bucket.archive.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "archive" {
+  bucket = aws_s3_bucket.archive.id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm     = var.kms_key_arn == "" ? "AES256" : "aws:kms"
+      kms_master_key_id = var.kms_key_arn == "" ? null : var.kms_key_arn
+    }
+    bucket_key_enabled = var.kms_key_arn != ""
+  }
+}
+
+resource "aws_s3_bucket_lifecycle_configuration" "archive" {
+  bucket = aws_s3_bucket.archive.id
+
+  rule {
+    id     = "archive"
+    status = "Enabled"
+
+    filter {}
+
+    transition {
+      days          = var.archive_transition_days
+      storage_class = var.archive_storage_class
+    }
+
+    dynamic "expiration" {
+      for_each = var.archive_expiration_days == 0 ? [] : [var.archive_expiration_days]
+      content {
+        days = expiration.value
+      }
+    }
+
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 7
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = var.archive_expiration_days == 0 || var.archive_expiration_days > var.archive_transition_days
+      error_message = "archive_expiration_days must be 0 or later than archive_transition_days."
+    }
+  }
+}
+
+resource "aws_iam_role" "writer" {
+  name = "${local.name}-telemetry-writer"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        AWS = var.writer_principal_arns
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "writer" {
+  name = "${local.name}-telemetry-writer"
+  role = aws_iam_role.writer.id
+
+  policy = jsonencode({
+    Statement = concat([
+      {
+        Sid      = "Readings"
+        Action   = ["dynamodb:PutItem", "dynamodb:BatchWriteItem"]
+        Effect   = "Allow"
+        Resource = aws_dynamodb_table.telemetry.arn
+      },
+      {
+        Sid      = "Archive"
+        Action   = ["s3:PutObject"]
+        Effect   = "Allow"
+        Resource = "${aws_s3_bucket.archive.arn}/*"
+      },
+      ], var.kms_key_arn == "" ? [] : [
+      {
+        Sid      = "Encryption"
+        Action   = ["kms:Encrypt", "kms:Decrypt", "kms:GenerateDataKey"]
+        Effect   = "Allow"
+        Resource = var.kms_key_arn
+      },
+    ])
+    Version = "2012-10-17"
+  })
+}
+
+resource "aws_iam_role" "reader" {
+  name = "${local.name}-telemetry-reader"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        AWS = var.reader_principal_arns
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "reader" {
+  name = "${local.name}-telemetry-reader"
+  role = aws_iam_role.reader.id
+
+  policy = jsonencode({
+    Statement = concat([
+      {
+        Sid      = "Readings"
+        Action   = ["dynamodb:Query"]
+        Effect   = "Allow"
+        Resource = "${aws_dynamodb_table.telemetry.arn}/index/device-time"
+      },
+      {
+        Sid      = "Archive"
+        Action   = ["s3:GetObject"]
+        Effect   = "Allow"
+        Resource = "${aws_s3_bucket.archive.arn}/*"
+      },
+      ], var.kms_key_arn == "" ? [] : [
+      {
+        Sid      = "Encryption"
+        Action   = ["kms:Decrypt"]
+        Effect   = "Allow"
+        Resource = var.kms_key_arn
+      },
+    ])
+    Version = "2012-10-17"
+  })
+}
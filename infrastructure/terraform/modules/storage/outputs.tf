@@ -0,0 +1,34 @@
+output "table_name" {
+  description = "Name of the telemetry history table"
+  value       = aws_dynamodb_table.telemetry.name
+}
+
+output "table_arn" {
+  description = "ARN of the telemetry history table"
+  value       = aws_dynamodb_table.telemetry.arn
+}
+
+output "device_time_index_name" {
+  description = "Name of the index dashboards query a device's readings over a time range with"
+  value       = "device-time"
+}
+
+output "archive_bucket_name" {
+  description = "Name of the telemetry archive bucket"
+  value       = aws_s3_bucket.archive.id
+}
+
+output "archive_bucket_arn" {
+  description = "ARN of the telemetry archive bucket"
+  value       = aws_s3_bucket.archive.arn
+}
+
+output "writer_role_arn" {
+  description = "ARN of the role the ingestion service writes readings and archives with"
+  value       = aws_iam_role.writer.arn
+}
+
+output "reader_role_arn" {
+  description = "ARN of the role the dashboards read readings and archives with"
+  value       = aws_iam_role.reader.arn
+}
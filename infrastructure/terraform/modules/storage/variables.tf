@@ -0,0 +1,96 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "deletion_protection" {
+  description = "Whether the telemetry table is protected from deletion"
+  type        = bool
+  default     = true
+}
+
+variable "force_destroy" {
+  description = "Whether destroying the module deletes the archive bucket along with the objects still in it"
+  type        = bool
+  default     = false
+}
+
+variable "kms_key_arn" {
+  description = "ARN of the KMS key the table and archive are encrypted with, AWS owned keys when empty"
+  type        = string
+  default     = ""
+}
+
+variable "archive_transition_days" {
+  description = "Days after which archived readings move to archive_storage_class"
+  type        = number
+  default     = 30
+
+  validation {
+    condition     = var.archive_transition_days >= 1 && floor(var.archive_transition_days) == var.archive_transition_days
+    error_message = "archive_transition_days must be a whole number of days, at least 1."
+  }
+}
+
+variable "archive_storage_class" {
+  description = "Storage class archived readings move to after archive_transition_days"
+  type        = string
+  default     = "GLACIER_IR"
+
+  validation {
+    condition     = contains(["STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"], var.archive_storage_class)
+    error_message = "archive_storage_class must be an S3 storage class objects can transition to, such as GLACIER_IR."
+  }
+}
+
+variable "archive_expiration_days" {
+  description = "Days after which archived readings are deleted, 0 to keep them"
+  type        = number
+  default     = 365
+
+  validation {
+    condition     = var.archive_expiration_days >= 0 && floor(var.archive_expiration_days) == var.archive_expiration_days
+    error_message = "archive_expiration_days must be a whole number of days, or 0."
+  }
+}
+
+variable "writer_principal_arns" {
+  description = "ARNs of the principals allowed to assume the writer role, e.g. the ingestion service's role"
+  type        = list(string)
+
+  validation {
+    condition     = length(var.writer_principal_arns) > 0 && alltrue([for arn in var.writer_principal_arns : can(regex("^arn:aws[a-z-]*:iam::[0-9]{12}:", arn))])
+    error_message = "writer_principal_arns must list at least one IAM principal ARN."
+  }
+}
+
+variable "reader_principal_arns" {
+  description = "ARNs of the principals allowed to assume the reader role, e.g. the dashboard service's role"
+  type        = list(string)
+
+  validation {
+    condition     = length(var.reader_principal_arns) > 0 && alltrue([for arn in var.reader_principal_arns : can(regex("^arn:aws[a-z-]*:iam::[0-9]{12}:", arn))])
+    error_message = "reader_principal_arns must list at least one IAM principal ARN."
+  }
+}
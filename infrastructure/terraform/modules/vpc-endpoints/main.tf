@@ -0,0 +1,67 @@
+# VPC Endpoints Module
+#
+# Interface endpoints that let devices and workloads in the private subnets
+# reach AWS IoT, S3 and DynamoDB without going through the NAT. Private DNS is
+# enabled on every endpoint, so the regional service hostnames resolve to the
+# endpoints' private addresses inside the VPC; without it traffic still works
+# but silently leaves through the NAT. The endpoints only accept HTTPS from
+# the VPC CIDR.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_region" "current" {}
+
+resource "aws_security_group" "endpoints" {
+  name_prefix = "${local.name}-endpoints"
+  description = "VPC interface endpoints"
+  vpc_id      = var.vpc_id
+
+  # No egress rules: endpoints only answer requests
+  ingress {
+    from_port   = 443
+    to_port     = 443
+    protocol    = "tcp"
+    cidr_blocks = [var.vpc_cidr_block]
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-endpoints-sg"
+  })
+
+  lifecycle {
+    create_before_destroy = true
+  }
+}
+
+resource "aws_vpc_endpoint" "interface" {
+  for_each = toset(var.services)
+
+  vpc_id              = var.vpc_id
+  service_name        = "com.amazonaws.${data.aws_region.current.name}.${each.key}"
+  vpc_endpoint_type   = "Interface"
+  subnet_ids          = var.subnet_ids
+  security_group_ids  = [aws_security_group.endpoints.id]
+  private_dns_enabled = true
+
+  # S3 and DynamoDB otherwise only enable private DNS alongside a gateway
+  # endpoint for the same service
+  dynamic "dns_options" {
+    for_each = contains(["s3", "dynamodb"], each.key) ? [1] : []
+    content {
+      private_dns_only_for_inbound_resolver_endpoint = false
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-${replace(each.key, ".", "-")}-endpoint"
+  })
+}
@@ -0,0 +1,9 @@
+output "endpoint_ids" {
+  description = "IDs of the interface endpoints, keyed by service"
+  value       = { for service, endpoint in aws_vpc_endpoint.interface : service => endpoint.id }
+}
+
+output "security_group_id" {
+  description = "ID of the security group of the endpoints"
+  value       = aws_security_group.endpoints.id
+}
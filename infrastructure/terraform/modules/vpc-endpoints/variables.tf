@@ -0,0 +1,51 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "vpc_id" {
+  description = "ID of the VPC to create the endpoints in"
+  type        = string
+}
+
+variable "vpc_cidr_block" {
+  description = "CIDR block of the VPC, the only source the endpoints accept traffic from"
+  type        = string
+}
+
+variable "subnet_ids" {
+  description = "IDs of the private subnets to place an endpoint network interface in, at most one per AZ"
+  type        = list(string)
+
+  validation {
+    condition     = length(var.subnet_ids) > 0
+    error_message = "subnet_ids must not be empty."
+  }
+}
+
+variable "services" {
+  description = "Services to create interface endpoints for, as the last part of their endpoint service name"
+  type        = list(string)
+  default     = ["iot.data", "s3", "dynamodb"]
+}
@@ -0,0 +1,34 @@
+# VPC Peering Accepter Module
+#
+# The spoke side of a peering the vpc-peering module requested from the hub
+# account: accepts the request and routes the spoke's route tables to the hub.
+# Applied under credentials of the spoke account. Destroying it leaves the
+# peering in place; it is deleted with the vpc-peering module.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+resource "aws_vpc_peering_connection_accepter" "hub_spoke" {
+  vpc_peering_connection_id = var.peering_connection_id
+  auto_accept               = true
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-hub-spoke-pcx"
+  })
+}
+
+resource "aws_route" "spoke_to_hub" {
+  count = length(var.spoke_route_table_ids)
+
+  route_table_id            = var.spoke_route_table_ids[count.index]
+  destination_cidr_block    = var.hub_cidr_block
+  vpc_peering_connection_id = aws_vpc_peering_connection_accepter.hub_spoke.id
+}
@@ -0,0 +1,9 @@
+output "peering_connection_id" {
+  description = "ID of the accepted peering connection"
+  value       = aws_vpc_peering_connection_accepter.hub_spoke.id
+}
+
+output "accept_status" {
+  description = "Status of the peering connection once accepted, active when it went through"
+  value       = aws_vpc_peering_connection_accepter.hub_spoke.accept_status
+}
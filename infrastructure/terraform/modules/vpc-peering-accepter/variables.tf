@@ -0,0 +1,45 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "peering_connection_id" {
+  description = "ID of the peering connection the vpc-peering module requested"
+  type        = string
+
+  validation {
+    condition     = can(regex("^pcx-[0-9a-f]+$", var.peering_connection_id))
+    error_message = "peering_connection_id must be a VPC peering connection ID such as pcx-0123456789abcdef0."
+  }
+}
+
+variable "hub_cidr_block" {
+  description = "CIDR block of the hub VPC"
+  type        = string
+}
+
+variable "spoke_route_table_ids" {
+  description = "Route tables of the spoke VPC that get a route to the hub"
+  type        = list(string)
+}
@@ -0,0 +1,69 @@
+# VPC Peering Module
+#
+# Peers a regional device VPC (spoke) back to the hub VPC in the same region.
+# Peering is not transitive, so spokes peered to the same hub still cannot
+# reach each other.
+#
+# A spoke in the same account is accepted and routed here. A spoke in another
+# account, set in spoke_account_id, has to accept the request itself: the
+# vpc-peering-accepter module does that, and adds the spoke's routes, applied
+# under credentials of the spoke account.
+
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+
+  # Two CIDRs overlap when their network addresses match at the shorter of
+  # the two prefix lengths
+  common_prefix = min(tonumber(split("/", var.hub_cidr_block)[1]), tonumber(split("/", var.spoke_cidr_block)[1]))
+  cidrs_overlap = (
+    cidrhost("${split("/", var.hub_cidr_block)[0]}/${local.common_prefix}", 0) ==
+    cidrhost("${split("/", var.spoke_cidr_block)[0]}/${local.common_prefix}", 0)
+  )
+
+  cross_account = var.spoke_account_id != ""
+}
+
+resource "aws_vpc_peering_connection" "hub_spoke" {
+  vpc_id        = var.hub_vpc_id
+  peer_vpc_id   = var.spoke_vpc_id
+  peer_owner_id = local.cross_account ? var.spoke_account_id : null
+  # Only the owner of the spoke can accept a request across accounts
+  auto_accept = !local.cross_account
+
+  # Routes to an overlapping CIDR would shadow the VPC's own local route
+  lifecycle {
+    precondition {
+      condition     = !local.cidrs_overlap
+      error_message = "hub_cidr_block and spoke_cidr_block must not overlap."
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-hub-spoke-pcx"
+  })
+}
+
+# Routes are added on both sides; a route missing on either one breaks
+# connectivity in only one direction.
+resource "aws_route" "hub_to_spoke" {
+  count = length(var.hub_route_table_ids)
+
+  route_table_id            = var.hub_route_table_ids[count.index]
+  destination_cidr_block    = var.spoke_cidr_block
+  vpc_peering_connection_id = aws_vpc_peering_connection.hub_spoke.id
+}
+
+resource "aws_route" "spoke_to_hub" {
+  count = local.cross_account ? 0 : length(var.spoke_route_table_ids)
+
+  route_table_id            = var.spoke_route_table_ids[count.index]
+  destination_cidr_block    = var.hub_cidr_block
+  vpc_peering_connection_id = aws_vpc_peering_connection.hub_spoke.id
+}
@@ -0,0 +1,4 @@
+output "peering_connection_id" {
+  description = "ID of the hub to spoke peering connection"
+  value       = aws_vpc_peering_connection.hub_spoke.id
+}
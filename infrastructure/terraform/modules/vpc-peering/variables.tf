@@ -0,0 +1,67 @@
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
+}
+
+variable "project_name" {
+  description = "Project name"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment name"
+  type        = string
+}
+
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+}
+
+variable "hub_vpc_id" {
+  description = "ID of the hub VPC, which requests the peering"
+  type        = string
+}
+
+variable "hub_cidr_block" {
+  description = "CIDR block of the hub VPC"
+  type        = string
+}
+
+variable "hub_route_table_ids" {
+  description = "Route tables of the hub VPC that get a route to the spoke"
+  type        = list(string)
+}
+
+variable "spoke_vpc_id" {
+  description = "ID of the spoke (device) VPC, which accepts the peering"
+  type        = string
+}
+
+variable "spoke_cidr_block" {
+  description = "CIDR block of the spoke VPC"
+  type        = string
+}
+
+variable "spoke_route_table_ids" {
+  description = "Route tables of the spoke VPC that get a route to the hub, unless the spoke is in another account"
+  type        = list(string)
+  default     = []
+}
+
+variable "spoke_account_id" {
+  description = "ID of the account the spoke VPC is in, when it is not the hub's; the spoke then accepts the peering with the vpc-peering-accepter module"
+  type        = string
+  default     = ""
+
+  validation {
+    condition     = var.spoke_account_id == "" || can(regex("^[0-9]{12}$", var.spoke_account_id))
+    error_message = "spoke_account_id must be a 12 digit AWS account ID."
+  }
+}
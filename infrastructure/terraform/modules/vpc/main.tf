@@ -1,62 +1,308 @@
 # VPC Module
 
+locals {
+  name = var.name_prefix == "" ? var.project_name : "${var.name_prefix}-${var.project_name}"
+
+  # Regions with fewer AZs than az_count get a subnet pair in every AZ they
+  # have instead of failing, so everything below counts local.az_count.
+  candidate_zones    = length(var.availability_zones) > 0 ? var.availability_zones : data.aws_availability_zones.available.names
+  az_count           = min(var.az_count, length(local.candidate_zones))
+  availability_zones = slice(local.candidate_zones, 0, local.az_count)
+
+  # Subnets are carved out of the VPC CIDR with 4 extra bits: public subnets
+  # take the first slots and private subnets start halfway through the range.
+  public_subnet_cidrs = length(var.public_subnet_cidrs) > 0 ? var.public_subnet_cidrs : [
+    for i in range(local.az_count) : cidrsubnet(var.cidr_block, 4, i)
+  ]
+  private_subnet_cidrs = length(var.private_subnet_cidrs) > 0 ? var.private_subnet_cidrs : [
+    for i in range(local.az_count) : cidrsubnet(var.cidr_block, 4, i + 8)
+  ]
+
+  nat_gateway_count = var.enable_nat ? (var.nat_strategy == "per_az" ? local.az_count : 1) : 0
+
+  flow_logs_to_cloudwatch = var.enable_flow_logs && var.flow_logs_destination == "cloud-watch-logs"
+  flow_logs_to_s3         = var.enable_flow_logs && var.flow_logs_destination == "s3"
+  flow_logs_encrypted     = local.flow_logs_to_cloudwatch && var.enable_flow_logs_encryption
+
+  # Tags finance and ops require on every resource
+  tags = {
+    Project     = var.project_name
+    Environment = var.environment
+    Owner       = var.owner
+    CostCenter  = var.cost_center
+  }
+}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+
+data "aws_caller_identity" "current" {}
+
+data "aws_region" "current" {}
+
 resource "aws_vpc" "main" {
   cidr_block           = var.cidr_block
   enable_dns_hostnames = true
   enable_dns_support   = true
 
-  tags = {
-    Name        = "${var.project_name}-vpc"
-    Environment = var.environment
-  }
+  tags = merge(local.tags, {
+    Name = "${local.name}-vpc"
+  })
+}
+
+# Adopt the default security group and strip all of its rules so nothing can
+# accidentally rely on it.
+resource "aws_default_security_group" "default" {
+  vpc_id = aws_vpc.main.id
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-default-sg"
+  })
 }
 
 resource "aws_internet_gateway" "main" {
   vpc_id = aws_vpc.main.id
 
-  tags = {
-    Name        = "${var.project_name}-igw"
-    Environment = var.environment
-  }
+  tags = merge(local.tags, {
+    Name = "${local.name}-igw"
+  })
 }
 
 resource "aws_subnet" "public" {
-  count = var.public_subnet_count
+  count = local.az_count
 
   vpc_id                  = aws_vpc.main.id
-  cidr_block              = var.public_subnet_cidrs[count.index]
-  availability_zone       = var.availability_zones[count.index]
+  cidr_block              = local.public_subnet_cidrs[count.index]
+  availability_zone       = local.availability_zones[count.index]
   map_public_ip_on_launch = true
 
-  tags = {
-    Name        = "${var.project_name}-public-${count.index + 1}"
-    Environment = var.environment
-    Type        = "public"
-  }
+  tags = merge(local.tags, {
+    Name = "${local.name}-public-${count.index + 1}"
+    Type = "public"
+  })
 }
 
 resource "aws_subnet" "private" {
-  count = var.private_subnet_count
+  count = local.az_count
 
   vpc_id            = aws_vpc.main.id
-  cidr_block        = var.private_subnet_cidrs[count.index]
-  availability_zone = var.availability_zones[count.index]
+  cidr_block        = local.private_subnet_cidrs[count.index]
+  availability_zone = local.availability_zones[count.index]
 
-  tags = {
-    Name        = "${var.project_name}-private-${count.index + 1}"
-    Environment = var.environment
-    Type        = "private"
+  tags = merge(local.tags, {
+    Name = "${local.name}-private-${count.index + 1}"
+    Type = "private"
+  })
+}
+
+# Route Tables
+resource "aws_route_table" "public" {
+  vpc_id = aws_vpc.main.id
+
+  route {
+    cidr_block = "0.0.0.0/0"
+    gateway_id = aws_internet_gateway.main.id
   }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-public-rt"
+  })
 }
 
-output "vpc_id" {
-  value = aws_vpc.main.id
+resource "aws_route_table_association" "public" {
+  count = local.az_count
+
+  subnet_id      = aws_subnet.public[count.index].id
+  route_table_id = aws_route_table.public.id
+}
+
+# NAT Gateways
+resource "aws_eip" "nat" {
+  count = local.nat_gateway_count
+
+  domain = "vpc"
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-nat-eip-${count.index + 1}"
+  })
 }
 
-output "public_subnet_ids" {
-  value = aws_subnet.public[*].id
+resource "aws_nat_gateway" "main" {
+  count = local.nat_gateway_count
+
+  allocation_id = aws_eip.nat[count.index].id
+  subnet_id     = aws_subnet.public[count.index].id
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-nat-${count.index + 1}"
+  })
+
+  depends_on = [aws_internet_gateway.main]
 }
 
-output "private_subnet_ids" {
-  value = aws_subnet.private[*].id
+# One private route table per AZ so a per-AZ NAT strategy keeps traffic
+# inside its own zone; with a single NAT every table points at the same one.
+resource "aws_route_table" "private" {
+  count = local.az_count
+
+  vpc_id = aws_vpc.main.id
+
+  dynamic "route" {
+    for_each = local.nat_gateway_count > 0 ? [aws_nat_gateway.main[min(count.index, local.nat_gateway_count - 1)].id] : []
+    content {
+      cidr_block     = "0.0.0.0/0"
+      nat_gateway_id = route.value
+    }
+  }
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-private-rt-${count.index + 1}"
+  })
+}
+
+resource "aws_route_table_association" "private" {
+  count = local.az_count
+
+  subnet_id      = aws_subnet.private[count.index].id
+  route_table_id = aws_route_table.private[count.index].id
+}
+
+# Flow logs capture rejected as well as accepted traffic. Security requires
+# them on every VPC, so they are on unless explicitly disabled.
+resource "aws_flow_log" "main" {
+  count = var.enable_flow_logs ? 1 : 0
+
+  vpc_id               = aws_vpc.main.id
+  traffic_type         = "ALL"
+  log_destination_type = var.flow_logs_destination
+  log_destination      = local.flow_logs_to_cloudwatch ? aws_cloudwatch_log_group.flow_logs[0].arn : aws_s3_bucket.flow_logs[0].arn
+  iam_role_arn         = local.flow_logs_to_cloudwatch ? aws_iam_role.flow_logs[0].arn : null
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-flow-log"
+  })
+}
+
+# CloudWatch Logs only uses a customer managed key whose policy lets the
+# regional logs service principal use it for this log group. The key is
+# opt-in: short-lived VPCs would otherwise each leave one pending deletion.
+resource "aws_kms_key" "flow_logs" {
+  count = local.flow_logs_encrypted ? 1 : 0
+
+  description             = "Encrypts the flow logs of ${local.name}"
+  enable_key_rotation     = true
+  deletion_window_in_days = 7
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Sid       = "AccountAdministration"
+        Effect    = "Allow"
+        Principal = { AWS = "arn:aws:iam::${data.aws_caller_identity.current.account_id}:root" }
+        Action    = "kms:*"
+        Resource  = "*"
+      },
+      {
+        Sid       = "CloudWatchLogs"
+        Effect    = "Allow"
+        Principal = { Service = "logs.${data.aws_region.current.name}.amazonaws.com" }
+        Action    = ["kms:Encrypt*", "kms:Decrypt*", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:Describe*"]
+        Resource  = "*"
+        Condition = {
+          ArnLike = {
+            "kms:EncryptionContext:aws:logs:arn" = "arn:aws:logs:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:log-group:/vpc/${local.name}/flow-logs"
+          }
+        }
+      },
+    ]
+  })
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-flow-logs"
+  })
+}
+
+resource "aws_cloudwatch_log_group" "flow_logs" {
+  count = local.flow_logs_to_cloudwatch ? 1 : 0
+
+  name              = "/vpc/${local.name}/flow-logs"
+  retention_in_days = var.flow_logs_retention_in_days
+  kms_key_id        = local.flow_logs_encrypted ? aws_kms_key.flow_logs[0].arn : null
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-flow-logs"
+  })
+}
+
+resource "aws_iam_role" "flow_logs" {
+  count = local.flow_logs_to_cloudwatch ? 1 : 0
+
+  name = "${local.name}-flow-logs"
+
+  assume_role_policy = jsonencode({
+    Statement = [{
+      Action = "sts:AssumeRole"
+      Effect = "Allow"
+      Principal = {
+        Service = "vpc-flow-logs.amazonaws.com"
+      }
+    }]
+    Version = "2012-10-17"
+  })
+
+  tags = local.tags
+}
+
+resource "aws_iam_role_policy" "flow_logs" {
+  count = local.flow_logs_to_cloudwatch ? 1 : 0
+
+  name = "${local.name}-flow-logs"
+  role = aws_iam_role.flow_logs[0].id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [{
+      Effect   = "Allow"
+      Action   = ["logs:CreateLogStream", "logs:PutLogEvents", "logs:DescribeLogStreams"]
+      Resource = "${aws_cloudwatch_log_group.flow_logs[0].arn}:*"
+    }]
+  })
+}
+
+# The log delivery service adds the bucket policy it needs when the flow log
+# is created.
+resource "aws_s3_bucket" "flow_logs" {
+  count = local.flow_logs_to_s3 ? 1 : 0
+
+  bucket        = "${local.name}-flow-logs-${data.aws_caller_identity.current.account_id}"
+  force_destroy = var.flow_logs_force_destroy
+
+  tags = merge(local.tags, {
+    Name = "${local.name}-flow-logs"
+  })
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "flow_logs" {
+  count = local.flow_logs_to_s3 ? 1 : 0
+
+  bucket = aws_s3_bucket.flow_logs[0].id
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
+
+resource "aws_s3_bucket_public_access_block" "flow_logs" {
+  count = local.flow_logs_to_s3 ? 1 : 0
+
+  bucket = aws_s3_bucket.flow_logs[0].id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
 }
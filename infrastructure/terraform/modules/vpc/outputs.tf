@@ -12,3 +12,38 @@ output "private_subnet_ids" {
   description = "IDs of private subnets"
   value       = aws_subnet.private[*].id
 }
+
+output "nat_gateway_ids" {
+  description = "IDs of NAT gateways"
+  value       = aws_nat_gateway.main[*].id
+}
+
+output "public_route_table_id" {
+  description = "ID of the public route table"
+  value       = aws_route_table.public.id
+}
+
+output "private_route_table_ids" {
+  description = "IDs of private route tables, one per AZ"
+  value       = aws_route_table.private[*].id
+}
+
+output "vpc_cidr_block" {
+  description = "CIDR block of the VPC"
+  value       = aws_vpc.main.cidr_block
+}
+
+output "flow_log_id" {
+  description = "ID of the VPC flow log, null when flow logs are disabled"
+  value       = one(aws_flow_log.main[*].id)
+}
+
+output "flow_log_group_name" {
+  description = "CloudWatch log group the flow logs are delivered to, null unless the destination is cloud-watch-logs"
+  value       = one(aws_cloudwatch_log_group.flow_logs[*].name)
+}
+
+output "flow_log_bucket_arn" {
+  description = "ARN of the S3 bucket the flow logs are delivered to, null unless the destination is s3"
+  value       = one(aws_s3_bucket.flow_logs[*].arn)
+}
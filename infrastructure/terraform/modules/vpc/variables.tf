@@ -1,6 +1,17 @@
 variable "cidr_block" {
   description = "CIDR block for the VPC"
   type        = string
+
+  validation {
+    condition     = can(cidrhost(var.cidr_block, 0)) && tonumber(split("/", var.cidr_block)[1]) >= 16 && tonumber(split("/", var.cidr_block)[1]) <= 24
+    error_message = "cidr_block must be a valid IPv4 CIDR between /16 and /24."
+  }
+}
+
+variable "name_prefix" {
+  description = "Prefix prepended to resource names, used to keep parallel deployments apart"
+  type        = string
+  default     = ""
 }
 
 variable "project_name" {
@@ -13,27 +24,103 @@ variable "environment" {
   type        = string
 }
 
-variable "public_subnet_count" {
-  description = "Number of public subnets"
-  type        = number
+variable "owner" {
+  description = "Team that owns the resources, recorded in the Owner tag"
+  type        = string
+
+  validation {
+    condition     = length(var.owner) > 0
+    error_message = "owner must not be empty."
+  }
+}
+
+variable "cost_center" {
+  description = "Cost center the resources are billed to, recorded in the CostCenter tag"
+  type        = string
+
+  validation {
+    condition     = length(var.cost_center) > 0
+    error_message = "cost_center must not be empty."
+  }
 }
 
-variable "private_subnet_count" {
-  description = "Number of private subnets"
+variable "az_count" {
+  description = "Number of availability zones to spread public and private subnets across, capped at the number the region has"
   type        = number
+  default     = 2
+
+  validation {
+    condition     = var.az_count >= 1 && var.az_count <= 3
+    error_message = "az_count must be between 1 and 3."
+  }
+}
+
+variable "enable_nat" {
+  description = "Whether to create a NAT gateway for the private subnets"
+  type        = bool
+  default     = true
+}
+
+variable "nat_strategy" {
+  description = "NAT gateway layout when enable_nat is true: \"single\" shares one NAT, \"per_az\" creates one per AZ"
+  type        = string
+  default     = "single"
+
+  validation {
+    condition     = contains(["single", "per_az"], var.nat_strategy)
+    error_message = "nat_strategy must be either \"single\" or \"per_az\"."
+  }
 }
 
 variable "public_subnet_cidrs" {
-  description = "CIDR blocks for public subnets"
+  description = "CIDR blocks for public subnets; derived from cidr_block when empty"
   type        = list(string)
+  default     = []
 }
 
 variable "private_subnet_cidrs" {
-  description = "CIDR blocks for private subnets"
+  description = "CIDR blocks for private subnets; derived from cidr_block when empty"
   type        = list(string)
+  default     = []
 }
 
 variable "availability_zones" {
-  description = "List of availability zones"
+  description = "List of availability zones; the region's available zones are used when empty"
   type        = list(string)
+  default     = []
+}
+
+variable "enable_flow_logs" {
+  description = "Whether to capture flow logs of all traffic in the VPC"
+  type        = bool
+  default     = true
+}
+
+variable "flow_logs_destination" {
+  description = "Where flow logs are delivered: \"cloud-watch-logs\" or \"s3\""
+  type        = string
+  default     = "cloud-watch-logs"
+
+  validation {
+    condition     = contains(["cloud-watch-logs", "s3"], var.flow_logs_destination)
+    error_message = "flow_logs_destination must be either \"cloud-watch-logs\" or \"s3\"."
+  }
+}
+
+variable "flow_logs_retention_in_days" {
+  description = "Days flow logs are kept in CloudWatch Logs"
+  type        = number
+  default     = 90
+}
+
+variable "enable_flow_logs_encryption" {
+  description = "Whether flow logs delivered to CloudWatch Logs are encrypted with a KMS key the module creates instead of the service's own encryption"
+  type        = bool
+  default     = false
+}
+
+variable "flow_logs_force_destroy" {
+  description = "Whether destroying the module deletes the flow log bucket even when it still holds logs"
+  type        = bool
+  default     = false
 }
@@ -0,0 +1,316 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// Workers boot, start the stand-in service and then need two passing
+	// health checks fifteen seconds apart, so capacity gets ten minutes to
+	// become healthy.
+	asgHealthyRetries     = 60
+	asgHealthyTimeBetween = 10 * time.Second
+
+	asgScaledOutCapacity = 3
+)
+
+// TestAsgModule runs the stand-in gateway service on workers of the asg
+// module behind the gateway load balancer. The group starts with one worker,
+// is scaled out to three through the Auto Scaling API, and every worker has
+// to be InService and pass the load balancer's health checks before the
+// deadline. Each worker has to run in a private subnet without a public
+// address and require IMDSv2, and its volumes have to be encrypted with the
+// test's key. The group is scaled back in before it is destroyed.
+func TestAsgModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	// Load balancers need subnets in two AZs, and the workers only talk to
+	// the load balancer, so there is no NAT
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.22.0.0/16",
+		"az_count":     2,
+		"enable_nat":   false,
+	})
+
+	vpcId := "vpc-00000000000000000"
+	publicSubnetIds := []string{"subnet-00000000000000000", "subnet-00000000000000001"}
+	privateSubnetIds := []string{"subnet-00000000000000002", "subnet-00000000000000003"}
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(false))
+		testhelpers.ApplyModule(t, vpcOptions)
+		vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+		publicSubnetIds = terraform.OutputList(t, vpcOptions, "public_subnet_ids")
+		privateSubnetIds = terraform.OutputList(t, vpcOptions, "private_subnet_ids")
+	}
+
+	gatewayOptions := testhelpers.NewModuleOptionsInRegion(t, "gateway", region, map[string]interface{}{
+		"project_name":      "iot-network",
+		"environment":       "test",
+		"owner":             "terratest",
+		"cost_center":       "ci",
+		"vpc_id":            vpcId,
+		"public_subnet_ids": publicSubnetIds,
+		"target_port":       gatewayTargetPort,
+		"target_type":       "instance",
+	})
+
+	targetGroupArn := "arn:aws:elasticloadbalancing:us-west-2:000000000000:targetgroup/placeholder/0000000000000000"
+	targetSecurityGroupId := "sg-00000000000000000"
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.ApplyModule(t, gatewayOptions)
+		targetGroupArn = terraform.Output(t, gatewayOptions, "target_group_arn")
+		targetSecurityGroupId = terraform.Output(t, gatewayOptions, "target_security_group_id")
+	}
+
+	// Auto Scaling launches the workers through its service-linked role,
+	// which may only use the key when the key policy allows it
+	keyArn, keyAlias := testhelpers.CreateDataKey(t, testhelpers.Region(vpcOptions), autoScalingKeyGrants)
+
+	userData, err := os.ReadFile(filepath.Join("testdata", "healthz-server.sh"))
+	require.NoError(t, err)
+
+	asgOptions := testhelpers.NewModuleOptionsInRegion(t, "asg", region, map[string]interface{}{
+		"project_name":       "iot-network",
+		"environment":        "test",
+		"owner":              "terratest",
+		"cost_center":        "ci",
+		"subnet_ids":         privateSubnetIds,
+		"security_group_ids": []string{targetSecurityGroupId},
+		"target_group_arns":  []string{targetGroupArn},
+		"min_size":           1,
+		"max_size":           asgScaledOutCapacity,
+		"desired_capacity":   1,
+		"user_data":          string(userData),
+		"kms_key_arn":        keyArn,
+	})
+
+	testhelpers.RunModuleChecks(t, asgOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			testhelpers.AssertPlannedResources(t, plan, map[string]int{
+				"aws_autoscaling_group": 1,
+				"aws_launch_template":   1,
+			})
+			testhelpers.AssertResourceAttr(t, plan, "aws_launch_template.workers", "metadata_options[0].http_tokens", "required")
+			testhelpers.AssertResourceAttr(t, plan, "aws_launch_template.workers", "block_device_mappings[0].ebs[0].kms_key_id", keyArn)
+			testhelpers.AssertResourceAttr(t, plan, "aws_autoscaling_group.workers", "health_check_type", "ELB")
+			testhelpers.AssertResourceAttr(t, plan, "aws_autoscaling_group.workers", "target_group_arns", []string{targetGroupArn})
+
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_autoscaling_group.workers")
+			subnets := plan.ResourcePlannedValuesMap["aws_autoscaling_group.workers"].AttributeValues["vpc_zone_identifier"]
+			assert.ElementsMatch(t, privateSubnetIds, subnets, "workers are not launched into the private subnets")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			sess := testhelpers.SessionFor(t, terraformOptions)
+			workers := asgWorkers{
+				name:           terraform.Output(t, terraformOptions, "autoscaling_group_name"),
+				targetGroupArn: targetGroupArn,
+				autoscaling:    autoscaling.New(sess),
+				elbv2:          elbv2.New(sess),
+			}
+			ec2Client := ec2.New(sess)
+
+			instanceIds := workers.waitUntilHealthy(t, 1)
+			assertWorkerInstances(t, ec2Client, instanceIds, privateSubnetIds)
+			testhelpers.AssertEncryptedAtRest(t, testhelpers.Region(terraformOptions), workerVolumes(t, ec2Client, instanceIds), keyAlias)
+
+			started := time.Now()
+			workers.setDesiredCapacity(t, asgScaledOutCapacity)
+			instanceIds = workers.waitUntilHealthy(t, asgScaledOutCapacity)
+			report.RecordDuration(t, "", "scale-out", report.Pass,
+				fmt.Sprintf("%d healthy workers", asgScaledOutCapacity), time.Since(started))
+			assertWorkerInstances(t, ec2Client, instanceIds, privateSubnetIds)
+
+			// Scaling in first leaves the destroy only one worker to drain
+			workers.setDesiredCapacity(t, 1)
+			retry.DoWithRetry(t, "scale in "+workers.name, asgHealthyRetries, asgHealthyTimeBetween, func() (string, error) {
+				group, err := workers.describe()
+				if err != nil {
+					return "", err
+				}
+				if len(group.Instances) != 1 {
+					return "", fmt.Errorf("%s still has %d instances", workers.name, len(group.Instances))
+				}
+				return "", nil
+			})
+		},
+	})
+}
+
+// asgWorkers is an auto scaling group of the asg module and the target group
+// its workers register with.
+type asgWorkers struct {
+	name           string
+	targetGroupArn string
+	autoscaling    *autoscaling.AutoScaling
+	elbv2          *elbv2.ELBV2
+}
+
+func (w asgWorkers) describe() (*autoscaling.Group, error) {
+	groups, err := w.autoscaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{awsSDK.String(w.name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups.AutoScalingGroups) != 1 {
+		return nil, fmt.Errorf("auto scaling group %s not found", w.name)
+	}
+	return groups.AutoScalingGroups[0], nil
+}
+
+func (w asgWorkers) setDesiredCapacity(t *testing.T, capacity int) {
+	_, err := w.autoscaling.SetDesiredCapacity(&autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: awsSDK.String(w.name),
+		DesiredCapacity:      awsSDK.Int64(int64(capacity)),
+		HonorCooldown:        awsSDK.Bool(false),
+	})
+	require.NoError(t, err, "setting the desired capacity of %s to %d", w.name, capacity)
+}
+
+// waitUntilHealthy waits for the group to have exactly the given number of
+// instances, all InService, healthy to Auto Scaling and passing the target
+// group's health checks, and returns their IDs. It fails with the last health
+// state of every instance if that does not happen in time.
+func (w asgWorkers) waitUntilHealthy(t *testing.T, capacity int) []string {
+	var instanceIds []string
+	var lastState []string
+	description := fmt.Sprintf("wait for %d healthy workers in %s", capacity, w.name)
+	_, err := retry.DoWithRetryE(t, description, asgHealthyRetries, asgHealthyTimeBetween, func() (string, error) {
+		group, err := w.describe()
+		if err != nil {
+			return "", err
+		}
+		targets, err := w.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: awsSDK.String(w.targetGroupArn)})
+		if err != nil {
+			return "", err
+		}
+		targetHealth := map[string]*elbv2.TargetHealth{}
+		for _, target := range targets.TargetHealthDescriptions {
+			targetHealth[awsSDK.StringValue(target.Target.Id)] = target.TargetHealth
+		}
+
+		instanceIds, lastState = nil, nil
+		healthy := 0
+		for _, instance := range group.Instances {
+			instanceId := awsSDK.StringValue(instance.InstanceId)
+			instanceIds = append(instanceIds, instanceId)
+
+			target := "not registered"
+			targetHealthy := false
+			if health, ok := targetHealth[instanceId]; ok {
+				target = awsSDK.StringValue(health.State)
+				if reason := awsSDK.StringValue(health.Reason); reason != "" {
+					target += " (" + reason + ": " + awsSDK.StringValue(health.Description) + ")"
+				}
+				targetHealthy = awsSDK.StringValue(health.State) == elbv2.TargetHealthStateEnumHealthy
+			}
+			lastState = append(lastState, fmt.Sprintf("%s: %s, %s to Auto Scaling, target %s",
+				instanceId, awsSDK.StringValue(instance.LifecycleState), awsSDK.StringValue(instance.HealthStatus), target))
+
+			if awsSDK.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService &&
+				awsSDK.StringValue(instance.HealthStatus) == "Healthy" && targetHealthy {
+				healthy++
+			}
+		}
+		sort.Strings(lastState)
+
+		if len(group.Instances) != capacity || healthy != capacity {
+			return "", fmt.Errorf("%d of %d workers healthy, %d instances in the group", healthy, capacity, len(group.Instances))
+		}
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("%s never had %d healthy workers, last health state:\n%s", w.name, capacity, strings.Join(lastState, "\n"))
+	}
+	sort.Strings(instanceIds)
+	return instanceIds
+}
+
+// assertWorkerInstances checks that every worker runs in one of the private
+// subnets without a public address and only answers IMDSv2 requests.
+func assertWorkerInstances(t *testing.T, ec2Client *ec2.EC2, instanceIds []string, privateSubnetIds []string) {
+	described, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: awsSDK.StringSlice(instanceIds)})
+	require.NoError(t, err)
+
+	var seen int
+	for _, reservation := range described.Reservations {
+		for _, instance := range reservation.Instances {
+			seen++
+			instanceId := awsSDK.StringValue(instance.InstanceId)
+			assert.Contains(t, privateSubnetIds, awsSDK.StringValue(instance.SubnetId), "worker %s is outside the private subnets", instanceId)
+			assert.Empty(t, awsSDK.StringValue(instance.PublicIpAddress), "worker %s has a public address", instanceId)
+			if assert.NotNil(t, instance.MetadataOptions, "worker %s has no metadata options", instanceId) {
+				assert.Equal(t, ec2.HttpTokensStateRequired, awsSDK.StringValue(instance.MetadataOptions.HttpTokens), "worker %s does not require IMDSv2", instanceId)
+			}
+		}
+	}
+	assert.Equal(t, len(instanceIds), seen, "DescribeInstances did not return every worker")
+}
+
+// autoScalingKeyGrants lets the service-linked role of Auto Scaling encrypt
+// the volumes of the workers it launches with the test's key.
+func autoScalingKeyGrants(accountId string) []testhelpers.KeyPolicyStatement {
+	principal := map[string]string{"AWS": "arn:aws:iam::" + accountId + ":role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"}
+	return []testhelpers.KeyPolicyStatement{
+		{
+			"Sid":       "AutoScalingVolumes",
+			"Effect":    "Allow",
+			"Principal": principal,
+			"Action":    []string{"kms:Encrypt", "kms:Decrypt", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:DescribeKey"},
+			"Resource":  "*",
+		},
+		{
+			"Sid":       "AutoScalingGrants",
+			"Effect":    "Allow",
+			"Principal": principal,
+			"Action":    "kms:CreateGrant",
+			"Resource":  "*",
+			"Condition": map[string]interface{}{
+				"Bool": map[string]bool{"kms:GrantIsForAWSResource": true},
+			},
+		},
+	}
+}
+
+// workerVolumes returns the IDs of the EBS volumes attached to the workers.
+func workerVolumes(t *testing.T, ec2Client *ec2.EC2, instanceIds []string) []string {
+	described, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: awsSDK.StringSlice(instanceIds)})
+	require.NoError(t, err)
+
+	var volumeIds []string
+	for _, reservation := range described.Reservations {
+		for _, instance := range reservation.Instances {
+			for _, mapping := range instance.BlockDeviceMappings {
+				if mapping.Ebs != nil {
+					volumeIds = append(volumeIds, awsSDK.StringValue(mapping.Ebs.VolumeId))
+				}
+			}
+		}
+	}
+	require.NotEmpty(t, volumeIds, "workers %v have no EBS volumes", instanceIds)
+	return volumeIds
+}
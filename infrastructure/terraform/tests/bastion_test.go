@@ -0,0 +1,259 @@
+package tests
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// runnerIPServiceURL answers with the public address requests come from.
+const runnerIPServiceURL = "https://checkip.amazonaws.com"
+
+// TestBastionModule applies the bastion module in both access modes. With ssh
+// access the test logs in to the bastion and, through it, to an instance in a
+// private subnet that the test runner cannot reach directly. With ssm access
+// the bastion has no public address and a command is run on it through
+// Session Manager. The ssh key pair is generated per run and only held in
+// memory.
+func TestBastionModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name       string
+		accessMode string
+	}{
+		{name: "Ssh", accessMode: "ssh"},
+		{name: "Ssm", accessMode: "ssm"},
+	}
+
+	for i, tc := range testCases {
+		i, tc := i, tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Without VPC endpoints the SSM agent can only reach Session
+			// Manager through the NAT
+			vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   fmt.Sprintf("10.%d.0.0/16", 50+i),
+				"az_count":     1,
+				"enable_nat":   tc.accessMode == "ssm",
+			})
+
+			vpcId := "vpc-00000000000000000"
+			publicSubnetId := "subnet-00000000000000000"
+			privateSubnetId := "subnet-00000000000000001"
+			if !testhelpers.IsPlanOnly() {
+				testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(tc.accessMode == "ssm"))
+				testhelpers.ApplyModule(t, vpcOptions)
+				vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+				publicSubnetId = terraform.OutputList(t, vpcOptions, "public_subnet_ids")[0]
+				privateSubnetId = terraform.OutputList(t, vpcOptions, "private_subnet_ids")[0]
+			}
+
+			vars := map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"vpc_id":       vpcId,
+				"access_mode":  tc.accessMode,
+				"subnet_id":    privateSubnetId,
+			}
+			var keyPair *ssh.KeyPair
+			if tc.accessMode == "ssh" {
+				keyPair = ssh.GenerateRSAKeyPair(t, 4096)
+				vars["subnet_id"] = publicSubnetId
+				vars["public_key"] = keyPair.PublicKey
+				vars["allowed_ssh_cidr_blocks"] = []string{runnerPublicIP(t) + "/32"}
+			}
+			bastionOptions := testhelpers.NewModuleOptionsInRegion(t, "bastion", region, vars)
+
+			testhelpers.RunModuleChecks(t, bastionOptions, testhelpers.ModuleChecks{
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_instance.bastion")
+					instance := plan.ResourcePlannedValuesMap["aws_instance.bastion"].AttributeValues
+					assert.Equal(t, tc.accessMode == "ssh", instance["associate_public_ip_address"], "planned associate_public_ip_address")
+
+					ingress, _ := plan.ResourcePlannedValuesMap["aws_security_group.bastion"].AttributeValues["ingress"].([]interface{})
+					if tc.accessMode == "ssm" {
+						assert.Empty(t, ingress, "bastion with ssm access accepts inbound traffic")
+					} else {
+						assert.Len(t, ingress, 1, "bastion ingress rules")
+					}
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					if tc.accessMode == "ssh" {
+						checkSshBastion(t, terraformOptions, keyPair, privateSubnetId)
+					} else {
+						checkSsmBastion(t, terraformOptions)
+					}
+				},
+			})
+		})
+	}
+}
+
+// checkSshBastion logs in to the bastion and, jumping through it, to a target
+// instance launched in the private subnet with the bastion access group.
+func checkSshBastion(t *testing.T, terraformOptions *terraform.Options, keyPair *ssh.KeyPair, privateSubnetId string) {
+	region := testhelpers.Region(terraformOptions)
+	user := terraform.Output(t, terraformOptions, "ssh_user")
+	bastion := ssh.Host{
+		Hostname:    terraform.Output(t, terraformOptions, "public_ip"),
+		SshUserName: user,
+		SshKeyPair:  keyPair,
+	}
+	require.NotEmpty(t, bastion.Hostname, "bastion with ssh access has no public address")
+
+	targetIP := launchPrivateInstance(t, region, privateInstance{
+		name:             testhelpers.NamePrefix(terraformOptions) + "-bastion-target",
+		subnetId:         privateSubnetId,
+		securityGroupIds: []string{terraform.Output(t, terraformOptions, "access_security_group_id")},
+		keyName:          terraform.Output(t, terraformOptions, "key_name"),
+	})
+	target := ssh.Host{Hostname: targetIP, SshUserName: user, SshKeyPair: keyPair}
+
+	output := ssh.CheckSshCommandWithRetry(t, bastion, "echo bastion", 30, 10*time.Second)
+	assert.Equal(t, "bastion", strings.TrimSpace(output))
+
+	output = retry.DoWithRetry(t, "ssh to "+targetIP+" through the bastion", 30, 10*time.Second, func() (string, error) {
+		return ssh.CheckPrivateSshConnectionE(t, bastion, target, "echo target")
+	})
+	assert.Equal(t, "target", strings.TrimSpace(output))
+
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort(targetIP, "22"), 5*time.Second); err == nil {
+		conn.Close()
+		t.Errorf("private instance %s is reachable from the test runner without the bastion", targetIP)
+	}
+}
+
+// checkSsmBastion waits for the bastion to register with Systems Manager,
+// opens a session to it and runs a command on it.
+func checkSsmBastion(t *testing.T, terraformOptions *terraform.Options) {
+	region := testhelpers.Region(terraformOptions)
+	instanceId := terraform.Output(t, terraformOptions, "instance_id")
+	assert.Empty(t, terraform.Output(t, terraformOptions, "public_ip"), "bastion with ssm access has a public address")
+
+	ssmClient := ssm.New(testhelpers.NewSession(t, region))
+	require.NoError(t, aws.WaitForSsmInstanceWithClientE(t, ssmClient, instanceId, 10*time.Minute))
+
+	// Nothing is sent over the session's stream, which needs the Session
+	// Manager plugin; opening it proves the agent accepts sessions
+	session, err := ssmClient.StartSession(&ssm.StartSessionInput{Target: awsSDK.String(instanceId)})
+	require.NoError(t, err, "could not start a session on %s", instanceId)
+	assert.NotEmpty(t, awsSDK.StringValue(session.SessionId))
+	if _, err := ssmClient.TerminateSession(&ssm.TerminateSessionInput{SessionId: session.SessionId}); err != nil {
+		t.Logf("Failed to terminate session %s: %v", awsSDK.StringValue(session.SessionId), err)
+	}
+
+	result, err := aws.CheckSSMCommandWithClientE(t, ssmClient, instanceId, "echo ssm", 2*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm", strings.TrimSpace(result.Stdout))
+}
+
+// privateInstance describes an instance launchPrivateInstance starts. KeyName
+// and UserData are optional.
+type privateInstance struct {
+	name             string
+	subnetId         string
+	securityGroupIds []string
+	keyName          string
+	userData         string
+}
+
+// launchPrivateInstance launches an Amazon Linux instance without a public
+// address and returns its private address once it is running. The instance
+// is terminated when the test finishes, before the modules it lives in are
+// destroyed.
+func launchPrivateInstance(t *testing.T, region string, spec privateInstance) string {
+	sess := testhelpers.NewSession(t, region)
+	ec2Client := ec2.New(sess)
+	imageId, err := aws.GetParameterWithClientE(t, ssm.New(sess), "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64")
+	require.NoError(t, err)
+
+	input := &ec2.RunInstancesInput{
+		ImageId:          awsSDK.String(imageId),
+		InstanceType:     awsSDK.String("t3.micro"),
+		SubnetId:         awsSDK.String(spec.subnetId),
+		SecurityGroupIds: awsSDK.StringSlice(spec.securityGroupIds),
+		MinCount:         awsSDK.Int64(1),
+		MaxCount:         awsSDK.Int64(1),
+		MetadataOptions:  &ec2.InstanceMetadataOptionsRequest{HttpTokens: awsSDK.String(ec2.HttpTokensStateRequired)},
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: awsSDK.String(ec2.ResourceTypeInstance),
+			Tags: []*ec2.Tag{
+				{Key: awsSDK.String("Name"), Value: awsSDK.String(spec.name)},
+				{Key: awsSDK.String("Project"), Value: awsSDK.String("iot-network")},
+				{Key: awsSDK.String("Environment"), Value: awsSDK.String("test")},
+				{Key: awsSDK.String("Owner"), Value: awsSDK.String("terratest")},
+				{Key: awsSDK.String("CostCenter"), Value: awsSDK.String("ci")},
+			},
+		}},
+	}
+	if spec.keyName != "" {
+		input.KeyName = awsSDK.String(spec.keyName)
+	}
+	if spec.userData != "" {
+		input.UserData = awsSDK.String(base64.StdEncoding.EncodeToString([]byte(spec.userData)))
+	}
+
+	reservation, err := ec2Client.RunInstances(input)
+	require.NoError(t, err)
+	instance := reservation.Instances[0]
+	instanceIds := []*string{instance.InstanceId}
+
+	t.Cleanup(func() {
+		if _, err := ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: instanceIds}); err != nil {
+			t.Logf("Failed to terminate %s: %v", awsSDK.StringValue(instance.InstanceId), err)
+			return
+		}
+		if err := ec2Client.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{InstanceIds: instanceIds}); err != nil {
+			t.Logf("Failed waiting for %s to terminate: %v", awsSDK.StringValue(instance.InstanceId), err)
+		}
+	})
+
+	require.NoError(t, ec2Client.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{InstanceIds: instanceIds}))
+	described, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: instanceIds})
+	require.NoError(t, err)
+	instance = described.Reservations[0].Instances[0]
+	assert.Empty(t, awsSDK.StringValue(instance.PublicIpAddress), "private instance %s has a public address", awsSDK.StringValue(instance.InstanceId))
+	return awsSDK.StringValue(instance.PrivateIpAddress)
+}
+
+// runnerPublicIP returns the address the test runner reaches AWS from, which
+// is the only one the bastion lets in.
+func runnerPublicIP(t *testing.T) string {
+	response, err := http.Get(runnerIPServiceURL)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode, "%s failed", runnerIPServiceURL)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	ip := strings.TrimSpace(string(body))
+	require.NotNil(t, net.ParseIP(ip), "%s returned %q", runnerIPServiceURL, ip)
+	return ip
+}
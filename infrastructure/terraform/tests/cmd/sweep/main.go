@@ -0,0 +1,62 @@
+// Command sweep deletes the AWS resources leaked by module tests that were
+// killed before they could destroy what they applied. Every resource the tests
+// create is named after a prefix that records when it was generated, see
+// internal/nameprefix, so anything older than -older-than can be assumed
+// abandoned:
+//
+//	go run ./cmd/sweep -region us-west-2 -older-than 6h -dry-run
+//	go run ./cmd/sweep -region us-west-2 -older-than 6h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+
+	"terraform-tests/internal/nameprefix"
+)
+
+func main() {
+	region := flag.String("region", os.Getenv("AWS_DEFAULT_REGION"), "region to sweep, defaults to AWS_DEFAULT_REGION")
+	prefix := flag.String("prefix", nameprefix.Start, "only sweep resources whose name starts with this prefix")
+	olderThan := flag.Duration("older-than", 6*time.Hour, "only sweep resources created by test runs older than this")
+	dryRun := flag.Bool("dry-run", false, "print what would be deleted without deleting anything")
+	flag.Parse()
+
+	if *region == "" {
+		fmt.Fprintln(os.Stderr, "sweep: -region or AWS_DEFAULT_REGION must be set")
+		os.Exit(2)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsSDK.Config{Region: region},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sweep: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	s := &sweeper{
+		tagging: resourcegroupstaggingapi.New(sess),
+		ec2:     ec2.New(sess),
+		iot:     iot.New(sess),
+		prefix:  *prefix,
+		cutoff:  now.Add(-*olderThan),
+		now:     now,
+		dryRun:  *dryRun,
+		out:     os.Stdout,
+	}
+	if err := s.sweep(); err != nil {
+		fmt.Fprintf(os.Stderr, "sweep: %v\n", err)
+		os.Exit(1)
+	}
+}
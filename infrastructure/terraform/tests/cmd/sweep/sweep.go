@@ -0,0 +1,508 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/iot/iotiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+
+	"terraform-tests/internal/nameprefix"
+)
+
+// thingTypeDeprecationDelay is how long AWS IoT insists a thing type is
+// deprecated before it can be deleted.
+const thingTypeDeprecationDelay = 5 * time.Minute
+
+// sweeper deletes the resources left behind by module tests that never got to
+// destroy them. A resource is swept when its name starts with prefix and the
+// name prefix it was created with is older than cutoff.
+type sweeper struct {
+	tagging resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	ec2     ec2iface.EC2API
+	iot     iotiface.IoTAPI
+
+	prefix string
+	cutoff time.Time
+	now    time.Time
+	dryRun bool
+	out    io.Writer
+}
+
+// expired reports whether the named resource was created by a test run older
+// than the cutoff. Names without a parsable prefix are never swept.
+func (s *sweeper) expired(name string) bool {
+	if !strings.HasPrefix(name, s.prefix) {
+		return false
+	}
+	_, created, ok := nameprefix.Parse(name)
+	return ok && created.Before(s.cutoff)
+}
+
+// do runs a deleting call, or only prints it in dry-run mode.
+func (s *sweeper) do(description string, call func() error) error {
+	if s.dryRun {
+		fmt.Fprintf(s.out, "would %s\n", description)
+		return nil
+	}
+	fmt.Fprintf(s.out, "%s\n", description)
+	if err := call(); err != nil {
+		return fmt.Errorf("%s: %w", description, err)
+	}
+	return nil
+}
+
+// sweep deletes every expired resource. IoT resources go first, then each VPC
+// with everything inside it, then the Elastic IPs the NAT gateways released.
+// A failure is reported but does not stop the sweep, so one stuck resource
+// does not keep the rest around.
+func (s *sweeper) sweep() error {
+	var errs []error
+
+	errs = append(errs, s.sweepIot()...)
+
+	vpcIds, allocationIds, err := s.expiredEc2Resources()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, vpcId := range vpcIds {
+		errs = append(errs, s.sweepVpc(vpcId)...)
+	}
+	errs = append(errs, s.sweepAddresses(allocationIds)...)
+
+	return errors.Join(errs...)
+}
+
+// expiredEc2Resources finds the expired VPCs and Elastic IPs through the
+// tagging API by their Name tag. Everything else in EC2 is found through the
+// VPC it belongs to, which also catches resources that were never tagged.
+func (s *sweeper) expiredEc2Resources() ([]string, []string, error) {
+	var vpcIds, allocationIds []string
+	err := s.tagging.GetResourcesPages(&resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: awsSDK.StringSlice([]string{"ec2:vpc", "ec2:elastic-ip"}),
+		TagFilters:          []*resourcegroupstaggingapi.TagFilter{{Key: awsSDK.String("Name")}},
+	}, func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+		for _, mapping := range page.ResourceTagMappingList {
+			var name string
+			for _, tag := range mapping.Tags {
+				if awsSDK.StringValue(tag.Key) == "Name" {
+					name = awsSDK.StringValue(tag.Value)
+				}
+			}
+			if !s.expired(name) {
+				continue
+			}
+
+			arn := awsSDK.StringValue(mapping.ResourceARN)
+			id := arn[strings.LastIndex(arn, "/")+1:]
+			switch {
+			case strings.HasPrefix(id, "vpc-"):
+				vpcIds = append(vpcIds, id)
+			case strings.HasPrefix(id, "eipalloc-"):
+				allocationIds = append(allocationIds, id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing tagged EC2 resources: %w", err)
+	}
+	return vpcIds, allocationIds, nil
+}
+
+// sweepVpc deletes a VPC after everything that keeps it from being deleted:
+// NAT gateways, endpoints and ENIs first, then the internet gateway, subnets,
+// route tables and security groups.
+func (s *sweeper) sweepVpc(vpcId string) []error {
+	var errs []error
+	inVpc := []*ec2.Filter{{Name: awsSDK.String("vpc-id"), Values: awsSDK.StringSlice([]string{vpcId})}}
+
+	natGateways, err := s.ec2.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+		Filter: append(inVpc, &ec2.Filter{Name: awsSDK.String("state"), Values: awsSDK.StringSlice([]string{"pending", "available"})}),
+	})
+	if err != nil {
+		return []error{fmt.Errorf("listing NAT gateways of %s: %w", vpcId, err)}
+	}
+	var deletedNatGatewayIds []*string
+	for _, natGateway := range natGateways.NatGateways {
+		natGatewayId := natGateway.NatGatewayId
+		err := s.do("delete NAT gateway "+awsSDK.StringValue(natGatewayId), func() error {
+			_, err := s.ec2.DeleteNatGateway(&ec2.DeleteNatGatewayInput{NatGatewayId: natGatewayId})
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deletedNatGatewayIds = append(deletedNatGatewayIds, natGatewayId)
+	}
+	// NAT gateways take a minute or two to go away and hold on to their
+	// subnet's ENI until they do
+	if len(deletedNatGatewayIds) > 0 && !s.dryRun {
+		if err := s.ec2.WaitUntilNatGatewayDeleted(&ec2.DescribeNatGatewaysInput{NatGatewayIds: deletedNatGatewayIds}); err != nil {
+			return append(errs, fmt.Errorf("waiting for the NAT gateways of %s to be deleted: %w", vpcId, err))
+		}
+	}
+
+	endpoints, err := s.ec2.DescribeVpcEndpoints(&ec2.DescribeVpcEndpointsInput{Filters: inVpc})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing VPC endpoints of %s: %w", vpcId, err))
+	}
+	if len(endpoints.VpcEndpoints) > 0 {
+		var endpointIds []*string
+		for _, endpoint := range endpoints.VpcEndpoints {
+			endpointIds = append(endpointIds, endpoint.VpcEndpointId)
+		}
+		errs = appendErr(errs, s.do("delete VPC endpoints "+strings.Join(awsSDK.StringValueSlice(endpointIds), ", "), func() error {
+			_, err := s.ec2.DeleteVpcEndpoints(&ec2.DeleteVpcEndpointsInput{VpcEndpointIds: endpointIds})
+			return err
+		}))
+	}
+
+	interfaces, err := s.ec2.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{Filters: inVpc})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing network interfaces of %s: %w", vpcId, err))
+	}
+	for _, networkInterface := range interfaces.NetworkInterfaces {
+		interfaceId := networkInterface.NetworkInterfaceId
+		// Interfaces still in use belong to something else in the VPC, e.g. a
+		// Lambda function, and go away with it
+		if awsSDK.StringValue(networkInterface.Status) != ec2.NetworkInterfaceStatusAvailable {
+			fmt.Fprintf(s.out, "skipping network interface %s, it is %s\n", awsSDK.StringValue(interfaceId), awsSDK.StringValue(networkInterface.Status))
+			continue
+		}
+		errs = appendErr(errs, s.do("delete network interface "+awsSDK.StringValue(interfaceId), func() error {
+			_, err := s.ec2.DeleteNetworkInterface(&ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: interfaceId})
+			return err
+		}))
+	}
+
+	gateways, err := s.ec2.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{{Name: awsSDK.String("attachment.vpc-id"), Values: awsSDK.StringSlice([]string{vpcId})}},
+	})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing internet gateways of %s: %w", vpcId, err))
+	}
+	for _, gateway := range gateways.InternetGateways {
+		gatewayId := gateway.InternetGatewayId
+		errs = appendErr(errs, s.do("detach and delete internet gateway "+awsSDK.StringValue(gatewayId), func() error {
+			if _, err := s.ec2.DetachInternetGateway(&ec2.DetachInternetGatewayInput{InternetGatewayId: gatewayId, VpcId: awsSDK.String(vpcId)}); err != nil {
+				return err
+			}
+			_, err := s.ec2.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{InternetGatewayId: gatewayId})
+			return err
+		}))
+	}
+
+	subnets, err := s.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: inVpc})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing subnets of %s: %w", vpcId, err))
+	}
+	for _, subnet := range subnets.Subnets {
+		subnetId := subnet.SubnetId
+		errs = appendErr(errs, s.do("delete subnet "+awsSDK.StringValue(subnetId), func() error {
+			_, err := s.ec2.DeleteSubnet(&ec2.DeleteSubnetInput{SubnetId: subnetId})
+			return err
+		}))
+	}
+
+	routeTables, err := s.ec2.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: inVpc})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing route tables of %s: %w", vpcId, err))
+	}
+	for _, routeTable := range routeTables.RouteTables {
+		if isMainRouteTable(routeTable) {
+			continue
+		}
+		routeTableId := routeTable.RouteTableId
+		errs = appendErr(errs, s.do("delete route table "+awsSDK.StringValue(routeTableId), func() error {
+			_, err := s.ec2.DeleteRouteTable(&ec2.DeleteRouteTableInput{RouteTableId: routeTableId})
+			return err
+		}))
+	}
+
+	groups, err := s.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{Filters: inVpc})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing security groups of %s: %w", vpcId, err))
+	}
+	// Groups can reference each other, so every rule is revoked before any
+	// group is deleted
+	var deletableGroups []*ec2.SecurityGroup
+	for _, group := range groups.SecurityGroups {
+		if awsSDK.StringValue(group.GroupName) == "default" {
+			continue
+		}
+		deletableGroups = append(deletableGroups, group)
+		if len(group.IpPermissions) == 0 {
+			continue
+		}
+		group := group
+		errs = appendErr(errs, s.do("revoke ingress rules of security group "+awsSDK.StringValue(group.GroupId), func() error {
+			_, err := s.ec2.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{GroupId: group.GroupId, IpPermissions: group.IpPermissions})
+			return err
+		}))
+	}
+	for _, group := range deletableGroups {
+		groupId := group.GroupId
+		errs = appendErr(errs, s.do("delete security group "+awsSDK.StringValue(groupId), func() error {
+			_, err := s.ec2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: groupId})
+			return err
+		}))
+	}
+
+	return appendErr(errs, s.do("delete VPC "+vpcId, func() error {
+		_, err := s.ec2.DeleteVpc(&ec2.DeleteVpcInput{VpcId: awsSDK.String(vpcId)})
+		return err
+	}))
+}
+
+// sweepAddresses releases the expired Elastic IPs that are no longer
+// associated with anything.
+func (s *sweeper) sweepAddresses(allocationIds []string) []error {
+	if len(allocationIds) == 0 {
+		return nil
+	}
+
+	addresses, err := s.ec2.DescribeAddresses(&ec2.DescribeAddressesInput{AllocationIds: awsSDK.StringSlice(allocationIds)})
+	if err != nil {
+		return []error{fmt.Errorf("listing Elastic IPs: %w", err)}
+	}
+
+	var errs []error
+	for _, address := range addresses.Addresses {
+		allocationId := address.AllocationId
+		// In dry-run mode the NAT gateways holding the addresses are still
+		// there, but they would have been deleted by now
+		if address.AssociationId != nil && !s.dryRun {
+			fmt.Fprintf(s.out, "skipping Elastic IP %s, it is still associated\n", awsSDK.StringValue(allocationId))
+			continue
+		}
+		errs = appendErr(errs, s.do("release Elastic IP "+awsSDK.StringValue(allocationId), func() error {
+			_, err := s.ec2.ReleaseAddress(&ec2.ReleaseAddressInput{AllocationId: allocationId})
+			return err
+		}))
+	}
+	return errs
+}
+
+// sweepIot deletes the expired IoT resources: provisioning templates first as
+// they reference the policies, then things with their certificates, then
+// thing groups, policies and thing types.
+func (s *sweeper) sweepIot() []error {
+	var errs []error
+
+	err := s.iot.ListProvisioningTemplatesPages(&iot.ListProvisioningTemplatesInput{}, func(page *iot.ListProvisioningTemplatesOutput, lastPage bool) bool {
+		for _, template := range page.Templates {
+			if !s.expired(awsSDK.StringValue(template.TemplateName)) {
+				continue
+			}
+			templateName := template.TemplateName
+			errs = appendErr(errs, s.do("delete provisioning template "+awsSDK.StringValue(templateName), func() error {
+				_, err := s.iot.DeleteProvisioningTemplate(&iot.DeleteProvisioningTemplateInput{TemplateName: templateName})
+				return err
+			}))
+		}
+		return true
+	})
+	errs = appendErr(errs, wrap("listing provisioning templates", err))
+
+	var thingNames []string
+	err = s.iot.ListThingsPages(&iot.ListThingsInput{}, func(page *iot.ListThingsOutput, lastPage bool) bool {
+		for _, thing := range page.Things {
+			if s.expired(awsSDK.StringValue(thing.ThingName)) {
+				thingNames = append(thingNames, awsSDK.StringValue(thing.ThingName))
+			}
+		}
+		return true
+	})
+	errs = appendErr(errs, wrap("listing things", err))
+	for _, thingName := range thingNames {
+		errs = append(errs, s.sweepThing(thingName)...)
+	}
+
+	err = s.iot.ListThingGroupsPages(&iot.ListThingGroupsInput{}, func(page *iot.ListThingGroupsOutput, lastPage bool) bool {
+		for _, group := range page.ThingGroups {
+			if !s.expired(awsSDK.StringValue(group.GroupName)) {
+				continue
+			}
+			groupName := group.GroupName
+			errs = appendErr(errs, s.do("delete thing group "+awsSDK.StringValue(groupName), func() error {
+				_, err := s.iot.DeleteThingGroup(&iot.DeleteThingGroupInput{ThingGroupName: groupName})
+				return err
+			}))
+		}
+		return true
+	})
+	errs = appendErr(errs, wrap("listing thing groups", err))
+
+	var policyNames []string
+	err = s.iot.ListPoliciesPages(&iot.ListPoliciesInput{}, func(page *iot.ListPoliciesOutput, lastPage bool) bool {
+		for _, policy := range page.Policies {
+			if s.expired(awsSDK.StringValue(policy.PolicyName)) {
+				policyNames = append(policyNames, awsSDK.StringValue(policy.PolicyName))
+			}
+		}
+		return true
+	})
+	errs = appendErr(errs, wrap("listing policies", err))
+	for _, policyName := range policyNames {
+		errs = append(errs, s.sweepPolicy(policyName)...)
+	}
+
+	var thingTypeNames []string
+	err = s.iot.ListThingTypesPages(&iot.ListThingTypesInput{}, func(page *iot.ListThingTypesOutput, lastPage bool) bool {
+		for _, thingType := range page.ThingTypes {
+			if s.expired(awsSDK.StringValue(thingType.ThingTypeName)) {
+				thingTypeNames = append(thingTypeNames, awsSDK.StringValue(thingType.ThingTypeName))
+			}
+		}
+		return true
+	})
+	errs = appendErr(errs, wrap("listing thing types", err))
+	for _, thingTypeName := range thingTypeNames {
+		errs = appendErr(errs, s.sweepThingType(thingTypeName))
+	}
+
+	return errs
+}
+
+// sweepThing deletes a thing and the certificates attached to it. Each
+// certificate loses its policies and its thing and is deactivated before it
+// is deleted, and the thing goes last.
+func (s *sweeper) sweepThing(thingName string) []error {
+	principals, err := s.iot.ListThingPrincipals(&iot.ListThingPrincipalsInput{ThingName: awsSDK.String(thingName)})
+	if err != nil {
+		return []error{fmt.Errorf("listing principals of thing %s: %w", thingName, err)}
+	}
+
+	var errs []error
+	for _, principal := range principals.Principals {
+		certificateArn := awsSDK.StringValue(principal)
+		certificateId := certificateArn[strings.LastIndex(certificateArn, "/")+1:]
+
+		attached, err := s.iot.ListAttachedPolicies(&iot.ListAttachedPoliciesInput{Target: principal})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listing policies attached to %s: %w", certificateArn, err))
+			continue
+		}
+		for _, policy := range attached.Policies {
+			policyName := policy.PolicyName
+			errs = appendErr(errs, s.do(fmt.Sprintf("detach policy %s from certificate %s", awsSDK.StringValue(policyName), certificateId), func() error {
+				_, err := s.iot.DetachPolicy(&iot.DetachPolicyInput{PolicyName: policyName, Target: principal})
+				return err
+			}))
+		}
+
+		errs = appendErr(errs, s.do(fmt.Sprintf("detach certificate %s from thing %s", certificateId, thingName), func() error {
+			_, err := s.iot.DetachThingPrincipal(&iot.DetachThingPrincipalInput{ThingName: awsSDK.String(thingName), Principal: principal})
+			return err
+		}))
+		errs = appendErr(errs, s.do("deactivate and delete certificate "+certificateId, func() error {
+			if _, err := s.iot.UpdateCertificate(&iot.UpdateCertificateInput{CertificateId: awsSDK.String(certificateId), NewStatus: awsSDK.String(iot.CertificateStatusInactive)}); err != nil {
+				return err
+			}
+			_, err := s.iot.DeleteCertificate(&iot.DeleteCertificateInput{CertificateId: awsSDK.String(certificateId)})
+			return err
+		}))
+	}
+
+	return appendErr(errs, s.do("delete thing "+thingName, func() error {
+		_, err := s.iot.DeleteThing(&iot.DeleteThingInput{ThingName: awsSDK.String(thingName)})
+		return err
+	}))
+}
+
+// sweepPolicy detaches a policy from every target and drops its non-default
+// versions, which both have to happen before it can be deleted.
+func (s *sweeper) sweepPolicy(policyName string) []error {
+	var errs []error
+
+	targets, err := s.iot.ListTargetsForPolicy(&iot.ListTargetsForPolicyInput{PolicyName: awsSDK.String(policyName)})
+	if err != nil {
+		return []error{fmt.Errorf("listing targets of policy %s: %w", policyName, err)}
+	}
+	for _, target := range targets.Targets {
+		target := target
+		errs = appendErr(errs, s.do(fmt.Sprintf("detach policy %s from %s", policyName, awsSDK.StringValue(target)), func() error {
+			_, err := s.iot.DetachPolicy(&iot.DetachPolicyInput{PolicyName: awsSDK.String(policyName), Target: target})
+			return err
+		}))
+	}
+
+	versions, err := s.iot.ListPolicyVersions(&iot.ListPolicyVersionsInput{PolicyName: awsSDK.String(policyName)})
+	if err != nil {
+		return append(errs, fmt.Errorf("listing versions of policy %s: %w", policyName, err))
+	}
+	for _, version := range versions.PolicyVersions {
+		if awsSDK.BoolValue(version.IsDefaultVersion) {
+			continue
+		}
+		versionId := version.VersionId
+		errs = appendErr(errs, s.do(fmt.Sprintf("delete version %s of policy %s", awsSDK.StringValue(versionId), policyName), func() error {
+			_, err := s.iot.DeletePolicyVersion(&iot.DeletePolicyVersionInput{PolicyName: awsSDK.String(policyName), PolicyVersionId: versionId})
+			return err
+		}))
+	}
+
+	return appendErr(errs, s.do("delete policy "+policyName, func() error {
+		_, err := s.iot.DeletePolicy(&iot.DeletePolicyInput{PolicyName: awsSDK.String(policyName)})
+		return err
+	}))
+}
+
+// sweepThingType deprecates a thing type, or deletes it if it has been
+// deprecated long enough. A thing type therefore takes two sweeps at least
+// five minutes apart to go away.
+func (s *sweeper) sweepThingType(thingTypeName string) error {
+	thingType, err := s.iot.DescribeThingType(&iot.DescribeThingTypeInput{ThingTypeName: awsSDK.String(thingTypeName)})
+	if err != nil {
+		return fmt.Errorf("describing thing type %s: %w", thingTypeName, err)
+	}
+
+	metadata := thingType.ThingTypeMetadata
+	if metadata == nil || !awsSDK.BoolValue(metadata.Deprecated) {
+		return s.do("deprecate thing type "+thingTypeName, func() error {
+			_, err := s.iot.DeprecateThingType(&iot.DeprecateThingTypeInput{ThingTypeName: awsSDK.String(thingTypeName)})
+			return err
+		})
+	}
+	if s.now.Sub(awsSDK.TimeValue(metadata.DeprecationDate)) < thingTypeDeprecationDelay {
+		fmt.Fprintf(s.out, "skipping thing type %s, it was deprecated less than %s ago\n", thingTypeName, thingTypeDeprecationDelay)
+		return nil
+	}
+	return s.do("delete thing type "+thingTypeName, func() error {
+		_, err := s.iot.DeleteThingType(&iot.DeleteThingTypeInput{ThingTypeName: awsSDK.String(thingTypeName)})
+		return err
+	})
+}
+
+func isMainRouteTable(routeTable *ec2.RouteTable) bool {
+	for _, association := range routeTable.Associations {
+		if awsSDK.BoolValue(association.Main) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+func wrap(action string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	return nil
+}
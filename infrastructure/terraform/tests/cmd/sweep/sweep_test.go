@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/iot/iotiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/nameprefix"
+)
+
+var (
+	sweepTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// staleName was created a day before the sweep and freshName a minute
+	// before it
+	staleName = nameprefix.New(sweepTime.Add(-24*time.Hour)) + "-iot-network"
+	freshName = nameprefix.New(sweepTime.Add(-time.Minute)) + "-iot-network"
+)
+
+// fakeTagging answers GetResources with a fixed set of tagged resources.
+type fakeTagging struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	resources map[string]string // ARN to Name tag
+}
+
+func (f *fakeTagging) GetResourcesPages(input *resourcegroupstaggingapi.GetResourcesInput, fn func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool) error {
+	page := &resourcegroupstaggingapi.GetResourcesOutput{}
+	for arn, name := range f.resources {
+		page.ResourceTagMappingList = append(page.ResourceTagMappingList, &resourcegroupstaggingapi.ResourceTagMapping{
+			ResourceARN: awsSDK.String(arn),
+			Tags:        []*resourcegroupstaggingapi.Tag{{Key: awsSDK.String("Name"), Value: awsSDK.String(name)}},
+		})
+	}
+	fn(page, true)
+	return nil
+}
+
+// fakeEc2 describes the contents of a single VPC and records every call that
+// changes something.
+type fakeEc2 struct {
+	ec2iface.EC2API
+	calls *[]string
+
+	natGateways []*ec2.NatGateway
+	endpoints   []*ec2.VpcEndpoint
+	interfaces  []*ec2.NetworkInterface
+	gateways    []*ec2.InternetGateway
+	subnets     []*ec2.Subnet
+	routeTables []*ec2.RouteTable
+	groups      []*ec2.SecurityGroup
+	addresses   []*ec2.Address
+}
+
+func (f *fakeEc2) record(call string) { *f.calls = append(*f.calls, call) }
+
+func (f *fakeEc2) DescribeNatGateways(*ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	return &ec2.DescribeNatGatewaysOutput{NatGateways: f.natGateways}, nil
+}
+
+func (f *fakeEc2) DeleteNatGateway(input *ec2.DeleteNatGatewayInput) (*ec2.DeleteNatGatewayOutput, error) {
+	f.record("DeleteNatGateway " + awsSDK.StringValue(input.NatGatewayId))
+	return &ec2.DeleteNatGatewayOutput{}, nil
+}
+
+func (f *fakeEc2) WaitUntilNatGatewayDeleted(*ec2.DescribeNatGatewaysInput) error {
+	f.record("WaitUntilNatGatewayDeleted")
+	return nil
+}
+
+func (f *fakeEc2) DescribeVpcEndpoints(*ec2.DescribeVpcEndpointsInput) (*ec2.DescribeVpcEndpointsOutput, error) {
+	return &ec2.DescribeVpcEndpointsOutput{VpcEndpoints: f.endpoints}, nil
+}
+
+func (f *fakeEc2) DeleteVpcEndpoints(input *ec2.DeleteVpcEndpointsInput) (*ec2.DeleteVpcEndpointsOutput, error) {
+	for _, id := range input.VpcEndpointIds {
+		f.record("DeleteVpcEndpoints " + awsSDK.StringValue(id))
+	}
+	return &ec2.DeleteVpcEndpointsOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeNetworkInterfaces(*ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	return &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: f.interfaces}, nil
+}
+
+func (f *fakeEc2) DeleteNetworkInterface(input *ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	f.record("DeleteNetworkInterface " + awsSDK.StringValue(input.NetworkInterfaceId))
+	return &ec2.DeleteNetworkInterfaceOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeInternetGateways(*ec2.DescribeInternetGatewaysInput) (*ec2.DescribeInternetGatewaysOutput, error) {
+	return &ec2.DescribeInternetGatewaysOutput{InternetGateways: f.gateways}, nil
+}
+
+func (f *fakeEc2) DetachInternetGateway(input *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	f.record("DetachInternetGateway " + awsSDK.StringValue(input.InternetGatewayId))
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEc2) DeleteInternetGateway(input *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	f.record("DeleteInternetGateway " + awsSDK.StringValue(input.InternetGatewayId))
+	return &ec2.DeleteInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{Subnets: f.subnets}, nil
+}
+
+func (f *fakeEc2) DeleteSubnet(input *ec2.DeleteSubnetInput) (*ec2.DeleteSubnetOutput, error) {
+	f.record("DeleteSubnet " + awsSDK.StringValue(input.SubnetId))
+	return &ec2.DeleteSubnetOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeRouteTables(*ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	return &ec2.DescribeRouteTablesOutput{RouteTables: f.routeTables}, nil
+}
+
+func (f *fakeEc2) DeleteRouteTable(input *ec2.DeleteRouteTableInput) (*ec2.DeleteRouteTableOutput, error) {
+	f.record("DeleteRouteTable " + awsSDK.StringValue(input.RouteTableId))
+	return &ec2.DeleteRouteTableOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeSecurityGroups(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: f.groups}, nil
+}
+
+func (f *fakeEc2) RevokeSecurityGroupIngress(input *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	f.record("RevokeSecurityGroupIngress " + awsSDK.StringValue(input.GroupId))
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeEc2) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.record("DeleteSecurityGroup " + awsSDK.StringValue(input.GroupId))
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (f *fakeEc2) DeleteVpc(input *ec2.DeleteVpcInput) (*ec2.DeleteVpcOutput, error) {
+	f.record("DeleteVpc " + awsSDK.StringValue(input.VpcId))
+	return &ec2.DeleteVpcOutput{}, nil
+}
+
+func (f *fakeEc2) DescribeAddresses(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	return &ec2.DescribeAddressesOutput{Addresses: f.addresses}, nil
+}
+
+func (f *fakeEc2) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.record("ReleaseAddress " + awsSDK.StringValue(input.AllocationId))
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+// fakeIot lists a fixed set of IoT resources and records every call that
+// changes something.
+type fakeIot struct {
+	iotiface.IoTAPI
+	calls *[]string
+
+	things     map[string][]string // thing name to certificate ARNs
+	policies   map[string][]string // policy name to targets
+	thingTypes map[string]*iot.ThingTypeMetadata
+}
+
+func (f *fakeIot) record(call string) { *f.calls = append(*f.calls, call) }
+
+func (f *fakeIot) ListProvisioningTemplatesPages(_ *iot.ListProvisioningTemplatesInput, fn func(*iot.ListProvisioningTemplatesOutput, bool) bool) error {
+	fn(&iot.ListProvisioningTemplatesOutput{}, true)
+	return nil
+}
+
+func (f *fakeIot) ListThingsPages(_ *iot.ListThingsInput, fn func(*iot.ListThingsOutput, bool) bool) error {
+	page := &iot.ListThingsOutput{}
+	for name := range f.things {
+		page.Things = append(page.Things, &iot.ThingAttribute{ThingName: awsSDK.String(name)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeIot) ListThingPrincipals(input *iot.ListThingPrincipalsInput) (*iot.ListThingPrincipalsOutput, error) {
+	return &iot.ListThingPrincipalsOutput{Principals: awsSDK.StringSlice(f.things[awsSDK.StringValue(input.ThingName)])}, nil
+}
+
+func (f *fakeIot) ListAttachedPolicies(*iot.ListAttachedPoliciesInput) (*iot.ListAttachedPoliciesOutput, error) {
+	var policies []*iot.Policy
+	for name := range f.policies {
+		policies = append(policies, &iot.Policy{PolicyName: awsSDK.String(name)})
+	}
+	return &iot.ListAttachedPoliciesOutput{Policies: policies}, nil
+}
+
+func (f *fakeIot) DetachPolicy(input *iot.DetachPolicyInput) (*iot.DetachPolicyOutput, error) {
+	f.record("DetachPolicy " + awsSDK.StringValue(input.PolicyName) + " " + awsSDK.StringValue(input.Target))
+	return &iot.DetachPolicyOutput{}, nil
+}
+
+func (f *fakeIot) DetachThingPrincipal(input *iot.DetachThingPrincipalInput) (*iot.DetachThingPrincipalOutput, error) {
+	f.record("DetachThingPrincipal " + awsSDK.StringValue(input.Principal))
+	return &iot.DetachThingPrincipalOutput{}, nil
+}
+
+func (f *fakeIot) UpdateCertificate(input *iot.UpdateCertificateInput) (*iot.UpdateCertificateOutput, error) {
+	f.record("UpdateCertificate " + awsSDK.StringValue(input.CertificateId) + " " + awsSDK.StringValue(input.NewStatus))
+	return &iot.UpdateCertificateOutput{}, nil
+}
+
+func (f *fakeIot) DeleteCertificate(input *iot.DeleteCertificateInput) (*iot.DeleteCertificateOutput, error) {
+	f.record("DeleteCertificate " + awsSDK.StringValue(input.CertificateId))
+	return &iot.DeleteCertificateOutput{}, nil
+}
+
+func (f *fakeIot) DeleteThing(input *iot.DeleteThingInput) (*iot.DeleteThingOutput, error) {
+	f.record("DeleteThing " + awsSDK.StringValue(input.ThingName))
+	return &iot.DeleteThingOutput{}, nil
+}
+
+func (f *fakeIot) ListThingGroupsPages(_ *iot.ListThingGroupsInput, fn func(*iot.ListThingGroupsOutput, bool) bool) error {
+	fn(&iot.ListThingGroupsOutput{}, true)
+	return nil
+}
+
+func (f *fakeIot) ListPoliciesPages(_ *iot.ListPoliciesInput, fn func(*iot.ListPoliciesOutput, bool) bool) error {
+	page := &iot.ListPoliciesOutput{}
+	for name := range f.policies {
+		page.Policies = append(page.Policies, &iot.Policy{PolicyName: awsSDK.String(name)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeIot) ListTargetsForPolicy(input *iot.ListTargetsForPolicyInput) (*iot.ListTargetsForPolicyOutput, error) {
+	return &iot.ListTargetsForPolicyOutput{Targets: awsSDK.StringSlice(f.policies[awsSDK.StringValue(input.PolicyName)])}, nil
+}
+
+func (f *fakeIot) ListPolicyVersions(*iot.ListPolicyVersionsInput) (*iot.ListPolicyVersionsOutput, error) {
+	return &iot.ListPolicyVersionsOutput{PolicyVersions: []*iot.PolicyVersion{
+		{VersionId: awsSDK.String("1"), IsDefaultVersion: awsSDK.Bool(false)},
+		{VersionId: awsSDK.String("2"), IsDefaultVersion: awsSDK.Bool(true)},
+	}}, nil
+}
+
+func (f *fakeIot) DeletePolicyVersion(input *iot.DeletePolicyVersionInput) (*iot.DeletePolicyVersionOutput, error) {
+	f.record("DeletePolicyVersion " + awsSDK.StringValue(input.PolicyName) + " " + awsSDK.StringValue(input.PolicyVersionId))
+	return &iot.DeletePolicyVersionOutput{}, nil
+}
+
+func (f *fakeIot) DeletePolicy(input *iot.DeletePolicyInput) (*iot.DeletePolicyOutput, error) {
+	f.record("DeletePolicy " + awsSDK.StringValue(input.PolicyName))
+	return &iot.DeletePolicyOutput{}, nil
+}
+
+func (f *fakeIot) ListThingTypesPages(_ *iot.ListThingTypesInput, fn func(*iot.ListThingTypesOutput, bool) bool) error {
+	page := &iot.ListThingTypesOutput{}
+	for name := range f.thingTypes {
+		page.ThingTypes = append(page.ThingTypes, &iot.ThingTypeDefinition{ThingTypeName: awsSDK.String(name)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeIot) DescribeThingType(input *iot.DescribeThingTypeInput) (*iot.DescribeThingTypeOutput, error) {
+	return &iot.DescribeThingTypeOutput{ThingTypeMetadata: f.thingTypes[awsSDK.StringValue(input.ThingTypeName)]}, nil
+}
+
+func (f *fakeIot) DeprecateThingType(input *iot.DeprecateThingTypeInput) (*iot.DeprecateThingTypeOutput, error) {
+	f.record("DeprecateThingType " + awsSDK.StringValue(input.ThingTypeName))
+	return &iot.DeprecateThingTypeOutput{}, nil
+}
+
+func (f *fakeIot) DeleteThingType(input *iot.DeleteThingTypeInput) (*iot.DeleteThingTypeOutput, error) {
+	f.record("DeleteThingType " + awsSDK.StringValue(input.ThingTypeName))
+	return &iot.DeleteThingTypeOutput{}, nil
+}
+
+// leakedVpc is a VPC the vpc and security modules left behind, with a NAT
+// gateway holding an Elastic IP.
+func leakedVpc(calls *[]string) *fakeEc2 {
+	return &fakeEc2{
+		calls:       calls,
+		natGateways: []*ec2.NatGateway{{NatGatewayId: awsSDK.String("nat-1")}},
+		interfaces: []*ec2.NetworkInterface{
+			{NetworkInterfaceId: awsSDK.String("eni-available"), Status: awsSDK.String(ec2.NetworkInterfaceStatusAvailable)},
+			{NetworkInterfaceId: awsSDK.String("eni-in-use"), Status: awsSDK.String(ec2.NetworkInterfaceStatusInUse)},
+		},
+		gateways: []*ec2.InternetGateway{{InternetGatewayId: awsSDK.String("igw-1")}},
+		subnets:  []*ec2.Subnet{{SubnetId: awsSDK.String("subnet-public")}, {SubnetId: awsSDK.String("subnet-private")}},
+		routeTables: []*ec2.RouteTable{
+			{RouteTableId: awsSDK.String("rtb-main"), Associations: []*ec2.RouteTableAssociation{{Main: awsSDK.Bool(true)}}},
+			{RouteTableId: awsSDK.String("rtb-public")},
+		},
+		groups: []*ec2.SecurityGroup{
+			{GroupId: awsSDK.String("sg-default"), GroupName: awsSDK.String("default")},
+			{GroupId: awsSDK.String("sg-web"), GroupName: awsSDK.String(staleName + "-web-sg"), IpPermissions: []*ec2.IpPermission{{IpProtocol: awsSDK.String("tcp")}}},
+			{GroupId: awsSDK.String("sg-app"), GroupName: awsSDK.String(staleName + "-app-sg")},
+		},
+		addresses: []*ec2.Address{{AllocationId: awsSDK.String("eipalloc-1")}},
+	}
+}
+
+func newTestSweeper(tagging *fakeTagging, ec2Client *fakeEc2, iotClient *fakeIot, out *bytes.Buffer) *sweeper {
+	return &sweeper{
+		tagging: tagging,
+		ec2:     ec2Client,
+		iot:     iotClient,
+		prefix:  nameprefix.Start,
+		cutoff:  sweepTime.Add(-6 * time.Hour),
+		now:     sweepTime,
+		out:     out,
+	}
+}
+
+func TestSweepVpcDependencyOrder(t *testing.T) {
+	var calls []string
+	tagging := &fakeTagging{resources: map[string]string{
+		"arn:aws:ec2:us-west-2:123456789012:vpc/vpc-stale":              staleName + "-vpc",
+		"arn:aws:ec2:us-west-2:123456789012:elastic-ip/eipalloc-1":      staleName + "-nat-eip-1",
+		"arn:aws:ec2:us-west-2:123456789012:vpc/vpc-fresh":              freshName + "-vpc",
+		"arn:aws:ec2:us-west-2:123456789012:vpc/vpc-production":         "iot-network-vpc",
+		"arn:aws:ec2:us-west-2:123456789012:vpc/vpc-old-prefix":         "tt-abc123-iot-network-vpc",
+		"arn:aws:ec2:us-west-2:123456789012:elastic-ip/eipalloc-fresh":  freshName + "-nat-eip-1",
+		"arn:aws:ec2:us-west-2:123456789012:security-group/sg-orphaned": staleName + "-web-sg",
+	}}
+	var out bytes.Buffer
+	s := newTestSweeper(tagging, leakedVpc(&calls), &fakeIot{calls: &calls}, &out)
+
+	require.NoError(t, s.sweep())
+
+	assert.Equal(t, []string{
+		"DeleteNatGateway nat-1",
+		"WaitUntilNatGatewayDeleted",
+		"DeleteNetworkInterface eni-available",
+		"DetachInternetGateway igw-1",
+		"DeleteInternetGateway igw-1",
+		"DeleteSubnet subnet-public",
+		"DeleteSubnet subnet-private",
+		"DeleteRouteTable rtb-public",
+		"RevokeSecurityGroupIngress sg-web",
+		"DeleteSecurityGroup sg-web",
+		"DeleteSecurityGroup sg-app",
+		"DeleteVpc vpc-stale",
+		"ReleaseAddress eipalloc-1",
+	}, calls)
+	assert.Contains(t, out.String(), "skipping network interface eni-in-use")
+}
+
+func TestSweepIotDependencyOrder(t *testing.T) {
+	var calls []string
+	certificateArn := "arn:aws:iot:us-west-2:123456789012:cert/abc123"
+	iotClient := &fakeIot{
+		calls: &calls,
+		things: map[string][]string{
+			staleName + "-device": {certificateArn},
+			freshName + "-device": {"arn:aws:iot:us-west-2:123456789012:cert/fresh"},
+		},
+		policies: map[string][]string{staleName + "-device-policy": nil},
+		thingTypes: map[string]*iot.ThingTypeMetadata{
+			staleName + "-device":  {Deprecated: awsSDK.Bool(false)},
+			staleName + "-gateway": {Deprecated: awsSDK.Bool(true), DeprecationDate: awsSDK.Time(sweepTime.Add(-time.Hour))},
+			staleName + "-sensor":  {Deprecated: awsSDK.Bool(true), DeprecationDate: awsSDK.Time(sweepTime.Add(-time.Minute))},
+		},
+	}
+	var out bytes.Buffer
+	s := newTestSweeper(&fakeTagging{}, &fakeEc2{calls: &calls}, iotClient, &out)
+
+	require.NoError(t, s.sweep())
+
+	policyName := staleName + "-device-policy"
+	thingTypeCalls := calls[len(calls)-2:]
+	assert.Equal(t, []string{
+		"DetachPolicy " + policyName + " " + certificateArn,
+		"DetachThingPrincipal " + certificateArn,
+		"UpdateCertificate abc123 INACTIVE",
+		"DeleteCertificate abc123",
+		"DeleteThing " + staleName + "-device",
+		"DeletePolicyVersion " + policyName + " 1",
+		"DeletePolicy " + policyName,
+	}, calls[:len(calls)-2])
+	assert.ElementsMatch(t, []string{
+		"DeprecateThingType " + staleName + "-device",
+		"DeleteThingType " + staleName + "-gateway",
+	}, thingTypeCalls)
+	assert.Contains(t, out.String(), "skipping thing type "+staleName+"-sensor")
+}
+
+func TestSweepDryRun(t *testing.T) {
+	var calls []string
+	tagging := &fakeTagging{resources: map[string]string{
+		"arn:aws:ec2:us-west-2:123456789012:vpc/vpc-stale":         staleName + "-vpc",
+		"arn:aws:ec2:us-west-2:123456789012:elastic-ip/eipalloc-1": staleName + "-nat-eip-1",
+	}}
+	iotClient := &fakeIot{
+		calls:  &calls,
+		things: map[string][]string{staleName + "-device": {"arn:aws:iot:us-west-2:123456789012:cert/abc123"}},
+	}
+	var out bytes.Buffer
+	s := newTestSweeper(tagging, leakedVpc(&calls), iotClient, &out)
+	s.dryRun = true
+
+	require.NoError(t, s.sweep())
+
+	assert.Empty(t, calls, "a dry run must not change anything")
+	for _, line := range []string{
+		"would delete NAT gateway nat-1",
+		"would delete subnet subnet-public",
+		"would delete VPC vpc-stale",
+		"would release Elastic IP eipalloc-1",
+		"would deactivate and delete certificate abc123",
+		"would delete thing " + staleName + "-device",
+	} {
+		assert.Contains(t, out.String(), line)
+	}
+}
@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/nameprefix"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// hostedZoneIdEnvVar is the hosted zone TestDnsModule creates its records
+	// in. The zone has to be delegated so that its name servers answer for it.
+	hostedZoneIdEnvVar = "TEST_HOSTED_ZONE_ID"
+
+	// dnsTestTtl differs from the module default so a TTL the module ignores
+	// is caught
+	dnsTestTtl = 120
+
+	dnsQueryTimeout = 5 * time.Second
+)
+
+// TestDnsModule creates the mqtt and api records in the hosted zone in
+// TEST_HOSTED_ZONE_ID, under a subdomain carrying the unique name prefix, and
+// resolves them against the zone's own name servers until mqtt is a CNAME to
+// the broker endpoint and api resolves to the gateway load balancer's
+// addresses, for up to TEST_POLL_DEADLINE. It then destroys the module and
+// resolves them again until they are gone. Records outside the subdomain are
+// compared before and after to prove the test left them alone.
+//
+//	TEST_HOSTED_ZONE_ID=Z0123456789ABCDEFGHIJ TEST_POLL_DEADLINE=5m go test -run TestDnsModule ./...
+func TestDnsModule(t *testing.T) {
+	t.Parallel()
+
+	zoneId := os.Getenv(hostedZoneIdEnvVar)
+	if zoneId == "" {
+		t.Skipf("set %s to a hosted zone the test may create records in", hostedZoneIdEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+
+	// Load balancers need subnets in two AZs
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.19.0.0/16",
+		"az_count":     2,
+		"enable_nat":   false,
+	})
+
+	vpcId := "vpc-00000000000000000"
+	publicSubnetIds := []string{"subnet-00000000000000000", "subnet-00000000000000001"}
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.SkipWithoutQuotaHeadroom(t, region, vpcQuotaRequirements(false))
+		testhelpers.ApplyModule(t, vpcOptions)
+		vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+		publicSubnetIds = terraform.OutputList(t, vpcOptions, "public_subnet_ids")
+	}
+
+	gatewayOptions := testhelpers.NewModuleOptionsInRegion(t, "gateway", region, map[string]interface{}{
+		"project_name":      "iot-network",
+		"environment":       "test",
+		"owner":             "terratest",
+		"cost_center":       "ci",
+		"vpc_id":            vpcId,
+		"public_subnet_ids": publicSubnetIds,
+	})
+
+	albDnsName := "iot-network-alb-0000000000.us-west-2.elb.amazonaws.com"
+	albZoneId := "Z1H1FL5HABSF5"
+	brokerEndpoint := "a0000000000000-ats.iot.us-west-2.amazonaws.com"
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.ApplyModule(t, gatewayOptions)
+		albDnsName = terraform.Output(t, gatewayOptions, "alb_dns_name")
+		albZoneId = terraform.Output(t, gatewayOptions, "alb_zone_id")
+
+		endpoint, err := testhelpers.NewIotClient(t, region).DescribeEndpoint(&iot.DescribeEndpointInput{EndpointType: awsSDK.String("iot:Data-ATS")})
+		require.NoError(t, err)
+		brokerEndpoint = awsSDK.StringValue(endpoint.EndpointAddress)
+	}
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "dns", region, map[string]interface{}{
+		"environment":     "test",
+		"zone_id":         zoneId,
+		"broker_endpoint": brokerEndpoint,
+		"mqtt_record_ttl": dnsTestTtl,
+		"alb_dns_name":    albDnsName,
+		"alb_zone_id":     albZoneId,
+	})
+
+	route53Client := testhelpers.NewRoute53Client(t, region)
+	zone, err := route53Client.GetHostedZone(&route53.GetHostedZoneInput{Id: awsSDK.String(zoneId)})
+	require.NoError(t, err)
+	// The subdomain the module creates its records in, see modules/dns
+	domain := fmt.Sprintf("%s-test.%s", testhelpers.NamePrefix(terraformOptions), strings.TrimSuffix(awsSDK.StringValue(zone.HostedZone.Name), "."))
+	mqttName := "mqtt." + domain
+	apiName := "api." + domain
+
+	var othersBefore []string
+	if !testhelpers.IsPlanOnly() {
+		othersBefore = recordsOutside(t, route53Client, zoneId, domain)
+	}
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_route53_record.mqtt")
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_route53_record.api")
+
+			mqtt := plan.ResourcePlannedValuesMap["aws_route53_record.mqtt"].AttributeValues
+			assert.Equal(t, mqttName, mqtt["name"], "planned mqtt record name")
+			assert.Equal(t, "CNAME", mqtt["type"], "planned mqtt record type")
+			assert.EqualValues(t, dnsTestTtl, mqtt["ttl"], "planned mqtt record TTL")
+			assert.Equal(t, apiName, plan.ResourcePlannedValuesMap["aws_route53_record.api"].AttributeValues["name"], "planned api record name")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			assert.Equal(t, mqttName, terraform.Output(t, terraformOptions, "mqtt_fqdn"))
+			assert.Equal(t, apiName, terraform.Output(t, terraformOptions, "api_fqdn"))
+
+			resolver := authoritativeResolver(t, awsSDK.StringValueSlice(zone.DelegationSet.NameServers))
+
+			resolvedCname := ""
+			mqttResolved := testhelpers.PollUntil(t, "resolving "+mqttName, func() (bool, error) {
+				cname, err := lookupCname(resolver, mqttName)
+				resolvedCname = cname
+				return err == nil && cname == brokerEndpoint, err
+			})
+			assert.True(t, mqttResolved, "%s never became a CNAME to %s, last resolved to %q", mqttName, brokerEndpoint, resolvedCname)
+
+			var resolvedAddresses, albAddresses []string
+			apiResolved := testhelpers.PollUntil(t, "resolving "+apiName, func() (bool, error) {
+				var err error
+				if albAddresses, err = net.DefaultResolver.LookupHost(context.Background(), albDnsName); err != nil {
+					return false, err
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), dnsQueryTimeout)
+				defer cancel()
+				if resolvedAddresses, err = resolver.LookupHost(ctx, apiName); err != nil {
+					return false, err
+				}
+				// Load balancer addresses rotate, one in common is enough
+				return overlaps(resolvedAddresses, albAddresses), nil
+			})
+			assert.True(t, apiResolved, "%s never resolved to the addresses of %s %v, last resolved to %v", apiName, albDnsName, albAddresses, resolvedAddresses)
+
+			// The Go resolver does not expose TTLs, so they are read from the zone
+			records := recordSets(t, route53Client, zoneId, mqttName)
+			if assert.Len(t, records, 1, "record sets named %s", mqttName) {
+				assert.Equal(t, int64(dnsTestTtl), awsSDK.Int64Value(records[0].TTL), "TTL of %s", mqttName)
+			}
+
+			testhelpers.DestroyModule(t, terraformOptions)
+
+			for _, name := range []string{mqttName, apiName} {
+				name := name
+				gone := testhelpers.PollUntil(t, "waiting for "+name+" to be removed", func() (bool, error) {
+					_, err := lookupCname(resolver, name)
+					var dnsError *net.DNSError
+					if errors.As(err, &dnsError) && dnsError.IsNotFound {
+						return true, nil
+					}
+					return false, err
+				})
+				assert.True(t, gone, "%s still resolves after destroying the module", name)
+			}
+
+			othersAfter := recordsOutside(t, route53Client, zoneId, domain)
+			assert.Equal(t, othersBefore, othersAfter, "records outside %s changed while testing", domain)
+		},
+	})
+}
+
+// authoritativeResolver returns a resolver that only asks the name servers of
+// the hosted zone, so results do not depend on the CI host's resolver or its
+// cache.
+func authoritativeResolver(t *testing.T, nameServers []string) *net.Resolver {
+	require.NotEmpty(t, nameServers, "hosted zone has no name servers")
+
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// Rotate through the name servers, the resolver retries on
+			// failure
+			nameServer := nameServers[int(next.Add(1))%len(nameServers)]
+			dialer := net.Dialer{Timeout: dnsQueryTimeout}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(nameServer, "53"))
+		},
+	}
+}
+
+// lookupCname returns the target of the CNAME record of name, without the
+// trailing dot.
+func lookupCname(resolver *net.Resolver, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsQueryTimeout)
+	defer cancel()
+
+	cname, err := resolver.LookupCNAME(ctx, name)
+	return strings.TrimSuffix(cname, "."), err
+}
+
+// recordSets returns the record sets of the zone named name.
+func recordSets(t *testing.T, route53Client *route53.Route53, zoneId string, name string) []*route53.ResourceRecordSet {
+	output, err := route53Client.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    awsSDK.String(zoneId),
+		StartRecordName: awsSDK.String(name),
+	})
+	require.NoError(t, err)
+
+	var records []*route53.ResourceRecordSet
+	for _, record := range output.ResourceRecordSets {
+		if strings.TrimSuffix(awsSDK.StringValue(record.Name), ".") == name {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// recordsOutside returns every record set of the zone that is not in domain,
+// as sorted "<name> <type> <values>" lines to compare before and after. The
+// records of other test runs, which come and go concurrently, are left out.
+func recordsOutside(t *testing.T, route53Client *route53.Route53, zoneId string, domain string) []string {
+	var records []string
+	err := route53Client.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{HostedZoneId: awsSDK.String(zoneId)},
+		func(page *route53.ListResourceRecordSetsOutput, _ bool) bool {
+			for _, record := range page.ResourceRecordSets {
+				name := strings.TrimSuffix(awsSDK.StringValue(record.Name), ".")
+				if name == domain || strings.HasSuffix(name, "."+domain) || inTestRunSubdomain(name) {
+					continue
+				}
+				values := make([]string, 0, len(record.ResourceRecords))
+				for _, value := range record.ResourceRecords {
+					values = append(values, awsSDK.StringValue(value.Value))
+				}
+				if record.AliasTarget != nil {
+					values = append(values, "alias "+awsSDK.StringValue(record.AliasTarget.DNSName))
+				}
+				records = append(records, fmt.Sprintf("%s %s %s", name, awsSDK.StringValue(record.Type), strings.Join(values, ",")))
+			}
+			return true
+		})
+	require.NoError(t, err)
+	sort.Strings(records)
+	return records
+}
+
+// inTestRunSubdomain reports whether the record name has a label starting with
+// a test name prefix.
+func inTestRunSubdomain(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if _, _, ok := nameprefix.Parse(label); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether the two lists have an element in common.
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
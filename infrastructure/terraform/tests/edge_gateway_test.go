@@ -0,0 +1,354 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/greengrassv2"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// greengrassSimulatedCoreEnvVar makes TestEdgeGatewayModule apply the
+	// module and install a nucleus on an instance as its core device. Without
+	// it only the plan assertions run, as there is no core to deploy to.
+	greengrassSimulatedCoreEnvVar = "TEST_GREENGRASS_SIMULATED_CORE"
+
+	// greengrassNucleusVersion is the nucleus the simulated core installs
+	greengrassNucleusVersion = "2.12.6"
+
+	// Installing Java and the nucleus takes about five minutes, the first
+	// deployment a few more, so the core gets fifteen minutes for both.
+	greengrassCoreRetries     = 90
+	greengrassCoreTimeBetween = 10 * time.Second
+)
+
+// edgeGatewayComponents are the components TestEdgeGatewayModule deploys, the
+// CLI being the smallest public component that has to be installed and run.
+var edgeGatewayComponents = map[string]string{
+	"aws.greengrass.Cli": greengrassNucleusVersion,
+}
+
+// TestEdgeGatewayModule deploys components to the edge gateway module's thing
+// group. With TEST_GREENGRASS_SIMULATED_CORE set, an instance in a private
+// subnet installs the nucleus as the module's core thing, and the test polls
+// Greengrass until the core reports HEALTHY and the module's deployment
+// reached COMPLETED on it. A deployment that fails instead fails the test with
+// the state of every component on the core. The root components installed
+// have to be the components var exactly, no more and no less. The core device,
+// its certificate and the instance are removed before the module is destroyed.
+//
+//	TEST_GREENGRASS_SIMULATED_CORE=1 go test -run TestEdgeGatewayModule -timeout 45m ./...
+func TestEdgeGatewayModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	simulatedCore, _ := strconv.ParseBool(os.Getenv(greengrassSimulatedCoreEnvVar))
+	applies := simulatedCore && !testhelpers.IsPlanOnly()
+
+	// The nucleus downloads itself, its components and the root CA, so the
+	// core's subnet needs a NAT
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.23.0.0/16",
+		"az_count":     1,
+		"enable_nat":   true,
+	})
+
+	vpcId := "vpc-00000000000000000"
+	privateSubnetId := "subnet-00000000000000000"
+	if applies {
+		testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(true))
+		testhelpers.ApplyModule(t, vpcOptions)
+		vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+		privateSubnetId = terraform.OutputList(t, vpcOptions, "private_subnet_ids")[0]
+	}
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "edge-gateway", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"components":   edgeGatewayComponents,
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		PlanOnly: !simulatedCore,
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			testhelpers.AssertPlannedResources(t, plan, map[string]int{
+				"aws_iot_thing":                  1,
+				"aws_iot_thing_group":            1,
+				"aws_iot_thing_group_membership": 1,
+				"aws_iot_role_alias":             1,
+				"awscc_greengrassv2_deployment":  1,
+			})
+
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "awscc_greengrassv2_deployment.components")
+			planned, _ := plan.ResourcePlannedValuesMap["awscc_greengrassv2_deployment.components"].AttributeValues["components"].(map[string]interface{})
+			plannedNames := make([]string, 0, len(planned))
+			for name := range planned {
+				plannedNames = append(plannedNames, name)
+			}
+			var names []string
+			for name, version := range edgeGatewayComponents {
+				names = append(names, name)
+				testhelpers.AssertResourceAttr(t, plan, "awscc_greengrassv2_deployment.components",
+					fmt.Sprintf("components[%q].component_version", name), version)
+			}
+			assert.ElementsMatch(t, names, plannedNames, "components of the planned deployment")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			core := startSimulatedCore(t, terraformOptions, vpcId, privateSubnetId)
+
+			started := time.Now()
+			core.waitUntilHealthy(t)
+			deploymentId := terraform.Output(t, terraformOptions, "deployment_id")
+			core.waitForDeployment(t, deploymentId, terraform.Output(t, terraformOptions, "thing_group_arn"))
+			report.RecordDuration(t, "", "greengrass-deployment", report.Pass,
+				fmt.Sprintf("%s completed on %s", deploymentId, core.thingName), time.Since(started))
+
+			deployment, err := core.greengrass.GetDeployment(&greengrassv2.GetDeploymentInput{DeploymentId: awsSDK.String(deploymentId)})
+			require.NoError(t, err)
+			deployed := map[string]string{}
+			for name, component := range deployment.Components {
+				deployed[name] = awsSDK.StringValue(component.ComponentVersion)
+			}
+			assert.Equal(t, edgeGatewayComponents, deployed, "components of deployment %s", deploymentId)
+
+			installed, err := core.installedComponents(greengrassv2.InstalledComponentTopologyFilterRoot)
+			require.NoError(t, err)
+			root := map[string]string{}
+			for _, component := range installed {
+				root[awsSDK.StringValue(component.ComponentName)] = awsSDK.StringValue(component.ComponentVersion)
+			}
+			assert.Equal(t, edgeGatewayComponents, root, "root components installed on %s", core.thingName)
+		},
+	})
+}
+
+// greengrassCoreUserData is what testdata/greengrass-core.sh.tmpl is rendered
+// with.
+type greengrassCoreUserData struct {
+	NucleusVersion     string
+	ThingName          string
+	Region             string
+	RoleAlias          string
+	DataEndpoint       string
+	CredentialEndpoint string
+	CertificatePem     string
+	PrivateKey         string
+}
+
+// simulatedCore is an instance running the nucleus as the edge gateway
+// module's core thing.
+type simulatedCore struct {
+	thingName  string
+	greengrass *greengrassv2.GreengrassV2
+}
+
+// startSimulatedCore issues a certificate for the module's core thing and
+// launches an instance in the private subnet that installs the nucleus with
+// it. When the test finishes the instance is terminated first, then the core
+// device is deleted from Greengrass and the certificate from IoT, leaving the
+// thing to the module's destroy.
+func startSimulatedCore(t *testing.T, terraformOptions *terraform.Options, vpcId string, privateSubnetId string) simulatedCore {
+	region := testhelpers.Region(terraformOptions)
+	sess := testhelpers.SessionFor(t, terraformOptions)
+	iotClient := iot.New(sess)
+	ec2Client := ec2.New(sess)
+	core := simulatedCore{
+		thingName:  terraform.Output(t, terraformOptions, "thing_name"),
+		greengrass: greengrassv2.New(sess),
+	}
+
+	certificate, err := iotClient.CreateKeysAndCertificate(&iot.CreateKeysAndCertificateInput{SetAsActive: awsSDK.Bool(true)})
+	require.NoError(t, err)
+	certificateArn := certificate.CertificateArn
+	certificateId := certificate.CertificateId
+	policyName := awsSDK.String(terraform.Output(t, terraformOptions, "core_policy_name"))
+	t.Cleanup(func() {
+		deleteTestCertificate(t, iotClient, core.thingName, awsSDK.StringValue(certificateId), awsSDK.StringValue(certificateArn))
+	})
+
+	_, err = iotClient.AttachPolicy(&iot.AttachPolicyInput{PolicyName: policyName, Target: certificateArn})
+	require.NoError(t, err)
+	_, err = iotClient.AttachThingPrincipal(&iot.AttachThingPrincipalInput{ThingName: awsSDK.String(core.thingName), Principal: certificateArn})
+	require.NoError(t, err)
+
+	// The nucleus registers the core device on its first connection
+	t.Cleanup(func() {
+		if _, err := core.greengrass.DeleteCoreDevice(&greengrassv2.DeleteCoreDeviceInput{CoreDeviceThingName: awsSDK.String(core.thingName)}); err != nil {
+			t.Logf("Failed to delete core device %s: %v", core.thingName, err)
+		}
+	})
+
+	userDataTemplate, err := template.ParseFiles(filepath.Join("testdata", "greengrass-core.sh.tmpl"))
+	require.NoError(t, err)
+	var userData bytes.Buffer
+	require.NoError(t, userDataTemplate.Execute(&userData, greengrassCoreUserData{
+		NucleusVersion:     greengrassNucleusVersion,
+		ThingName:          core.thingName,
+		Region:             region,
+		RoleAlias:          terraform.Output(t, terraformOptions, "role_alias_name"),
+		DataEndpoint:       terraform.Output(t, terraformOptions, "data_endpoint"),
+		CredentialEndpoint: terraform.Output(t, terraformOptions, "credential_endpoint"),
+		CertificatePem:     strings.TrimSpace(awsSDK.StringValue(certificate.CertificatePem)),
+		PrivateKey:         strings.TrimSpace(awsSDK.StringValue(certificate.KeyPair.PrivateKey)),
+	}))
+
+	// A new group only allows egress, all the core needs
+	group, err := ec2Client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   awsSDK.String(core.thingName),
+		Description: awsSDK.String("Simulated Greengrass core for the edge gateway test"),
+		VpcId:       awsSDK.String(vpcId),
+	})
+	require.NoError(t, err)
+	groupId := group.GroupId
+	t.Cleanup(func() {
+		if _, err := ec2Client.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: groupId}); err != nil {
+			t.Logf("Failed to delete security group %s: %v", awsSDK.StringValue(groupId), err)
+		}
+	})
+
+	launchPrivateInstance(t, region, privateInstance{
+		name:             core.thingName,
+		subnetId:         privateSubnetId,
+		securityGroupIds: []string{awsSDK.StringValue(groupId)},
+		userData:         userData.String(),
+	})
+	return core
+}
+
+// waitUntilHealthy waits for the core device to be registered and report
+// HEALTHY.
+func (c simulatedCore) waitUntilHealthy(t *testing.T) {
+	description := "wait for core device " + c.thingName + " to be healthy"
+	retry.DoWithRetry(t, description, greengrassCoreRetries, greengrassCoreTimeBetween, func() (string, error) {
+		device, err := c.greengrass.GetCoreDevice(&greengrassv2.GetCoreDeviceInput{CoreDeviceThingName: awsSDK.String(c.thingName)})
+		if err != nil {
+			return "", err
+		}
+		if status := awsSDK.StringValue(device.Status); status != greengrassv2.CoreDeviceStatusHealthy {
+			return "", fmt.Errorf("core device %s is %s", c.thingName, status)
+		}
+		return "", nil
+	})
+}
+
+// waitForDeployment waits for the deployment to the thing group to complete on
+// the core. It fails as soon as the deployment ends any other way, with the
+// deployment's errors and the state of every component on the core.
+func (c simulatedCore) waitForDeployment(t *testing.T, deploymentId string, thingGroupArn string) {
+	var failure string
+	description := fmt.Sprintf("wait for deployment %s on %s", deploymentId, c.thingName)
+	_, err := retry.DoWithRetryE(t, description, greengrassCoreRetries, greengrassCoreTimeBetween, func() (string, error) {
+		effective, err := c.effectiveDeployment(deploymentId)
+		if err != nil {
+			return "", err
+		}
+		if effective == nil {
+			return "", fmt.Errorf("deployment %s has not reached %s yet", deploymentId, c.thingName)
+		}
+		if targetArn := awsSDK.StringValue(effective.TargetArn); targetArn != thingGroupArn {
+			return "", retry.FatalError{Underlying: fmt.Errorf("deployment %s targets %s, not the thing group %s", deploymentId, targetArn, thingGroupArn)}
+		}
+
+		switch status := awsSDK.StringValue(effective.CoreDeviceExecutionStatus); status {
+		case greengrassv2.EffectiveDeploymentExecutionStatusCompleted, greengrassv2.EffectiveDeploymentExecutionStatusSucceeded:
+			return "", nil
+		case greengrassv2.EffectiveDeploymentExecutionStatusQueued, greengrassv2.EffectiveDeploymentExecutionStatusInProgress:
+			return "", fmt.Errorf("deployment %s is %s on %s", deploymentId, status, c.thingName)
+		default:
+			failure = c.deploymentFailure(effective)
+			return "", retry.FatalError{Underlying: fmt.Errorf("deployment %s is %s on %s", deploymentId, status, c.thingName)}
+		}
+	})
+	if err != nil && failure != "" {
+		t.Fatalf("%v:\n%s", err, failure)
+	}
+	require.NoError(t, err)
+}
+
+// effectiveDeployment returns the deployment as the core reports it, or nil if
+// the core has not received it yet.
+func (c simulatedCore) effectiveDeployment(deploymentId string) (*greengrassv2.EffectiveDeployment, error) {
+	var found *greengrassv2.EffectiveDeployment
+	err := c.greengrass.ListEffectiveDeploymentsPages(&greengrassv2.ListEffectiveDeploymentsInput{
+		CoreDeviceThingName: awsSDK.String(c.thingName),
+	}, func(page *greengrassv2.ListEffectiveDeploymentsOutput, lastPage bool) bool {
+		for _, deployment := range page.EffectiveDeployments {
+			if awsSDK.StringValue(deployment.DeploymentId) == deploymentId {
+				found = deployment
+				return false
+			}
+		}
+		return true
+	})
+	return found, err
+}
+
+// installedComponents lists the components on the core, either only the root
+// components deployed to it or all of them including their dependencies.
+func (c simulatedCore) installedComponents(topologyFilter string) ([]*greengrassv2.InstalledComponent, error) {
+	var components []*greengrassv2.InstalledComponent
+	err := c.greengrass.ListInstalledComponentsPages(&greengrassv2.ListInstalledComponentsInput{
+		CoreDeviceThingName: awsSDK.String(c.thingName),
+		TopologyFilter:      awsSDK.String(topologyFilter),
+	}, func(page *greengrassv2.ListInstalledComponentsOutput, lastPage bool) bool {
+		components = append(components, page.InstalledComponents...)
+		return true
+	})
+	return components, err
+}
+
+// deploymentFailure describes why a deployment failed on the core: the reason
+// and errors the core reported for it, then the lifecycle state of every
+// component installed on the core, one per line.
+func (c simulatedCore) deploymentFailure(effective *greengrassv2.EffectiveDeployment) string {
+	lines := []string{"reason: " + awsSDK.StringValue(effective.Reason)}
+	if details := effective.StatusDetails; details != nil {
+		lines = append(lines,
+			"error types: "+strings.Join(awsSDK.StringValueSlice(details.ErrorTypes), ", "),
+			"error stack: "+strings.Join(awsSDK.StringValueSlice(details.ErrorStack), " > "))
+	}
+
+	components, err := c.installedComponents(greengrassv2.InstalledComponentTopologyFilterAll)
+	if err != nil {
+		return strings.Join(append(lines, fmt.Sprintf("listing the installed components failed: %v", err)), "\n")
+	}
+	var states []string
+	for _, component := range components {
+		state := fmt.Sprintf("%s %s: %s", awsSDK.StringValue(component.ComponentName),
+			awsSDK.StringValue(component.ComponentVersion), awsSDK.StringValue(component.LifecycleState))
+		if details := awsSDK.StringValue(component.LifecycleStateDetails); details != "" {
+			state += " (" + details + ")"
+		}
+		if codes := awsSDK.StringValueSlice(component.LifecycleStatusCodes); len(codes) > 0 {
+			state += " [" + strings.Join(codes, ", ") + "]"
+		}
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return strings.Join(append(lines, states...), "\n")
+}
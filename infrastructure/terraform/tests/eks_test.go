@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/gruntwork-io/terratest/modules/k8s"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// eksTestEnvVar enables TestEksModule. Creating a cluster and its node group
+// takes around twenty minutes, so it is left out of regular runs; give go test
+// a -timeout of at least 90m when enabling it.
+const eksTestEnvVar = "RUN_EKS_TEST"
+
+const eksTestNodeCount = 2
+
+// TestEksModule applies the EKS module on a fresh VPC. With a public endpoint
+// the test talks to the cluster: the nodes have to join and become Ready, and
+// a sample MQTT bridge deployment has to become available on them. With a
+// private endpoint the API cannot be reached from outside the VPC, so only the
+// endpoint access settings are checked.
+func TestEksModule(t *testing.T) {
+	t.Parallel()
+	testhelpers.SkipUnlessEnabled(t, eksTestEnvVar)
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name            string
+		privateEndpoint bool
+	}{
+		{name: "PublicEndpoint", privateEndpoint: false},
+		{name: "PrivateEndpoint", privateEndpoint: true},
+	}
+
+	for i, tc := range testCases {
+		i, tc := i, tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Nodes in the private subnets need the NAT to pull images
+			vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   fmt.Sprintf("10.%d.0.0/16", 40+i),
+				"az_count":     2,
+				"enable_nat":   true,
+			})
+
+			publicSubnetIds := []string{"subnet-00000000000000000", "subnet-00000000000000001"}
+			privateSubnetIds := []string{"subnet-00000000000000002", "subnet-00000000000000003"}
+			if !testhelpers.IsPlanOnly() {
+				testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(true))
+				testhelpers.ApplyModule(t, vpcOptions)
+				publicSubnetIds = terraform.OutputList(t, vpcOptions, "public_subnet_ids")
+				privateSubnetIds = terraform.OutputList(t, vpcOptions, "private_subnet_ids")
+			}
+
+			eksOptions := testhelpers.NewModuleOptionsInRegion(t, "eks", region, map[string]interface{}{
+				"project_name":       "iot-network",
+				"environment":        "test",
+				"owner":              "terratest",
+				"cost_center":        "ci",
+				"cluster_subnet_ids": append(publicSubnetIds, privateSubnetIds...),
+				"node_subnet_ids":    privateSubnetIds,
+				"private_endpoint":   tc.privateEndpoint,
+				"node_desired_size":  eksTestNodeCount,
+			})
+
+			testhelpers.RunModuleChecks(t, eksOptions, testhelpers.ModuleChecks{
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_eks_cluster.main")
+					vpcConfig, _ := plan.ResourcePlannedValuesMap["aws_eks_cluster.main"].AttributeValues["vpc_config"].([]interface{})
+					require.Len(t, vpcConfig, 1)
+					access := vpcConfig[0].(map[string]interface{})
+					assert.Equal(t, true, access["endpoint_private_access"], "planned endpoint_private_access")
+					assert.Equal(t, !tc.privateEndpoint, access["endpoint_public_access"], "planned endpoint_public_access")
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					clusterName := terraform.Output(t, terraformOptions, "cluster_name")
+
+					cluster, err := testhelpers.NewEksClient(t, region).DescribeCluster(&eks.DescribeClusterInput{Name: awsSDK.String(clusterName)})
+					require.NoError(t, err)
+					vpcConfig := cluster.Cluster.ResourcesVpcConfig
+					assert.True(t, awsSDK.BoolValue(vpcConfig.EndpointPrivateAccess), "cluster %s has no private endpoint", clusterName)
+					assert.Equal(t, !tc.privateEndpoint, awsSDK.BoolValue(vpcConfig.EndpointPublicAccess), "cluster %s endpoint_public_access", clusterName)
+					if tc.privateEndpoint {
+						return
+					}
+
+					kubectlOptions := k8s.NewKubectlOptions("", writeKubeconfig(t, terraformOptions, region), "")
+					waitForReadyNodes(t, kubectlOptions, eksTestNodeCount)
+
+					namespace := testhelpers.NamePrefix(terraformOptions)
+					k8s.CreateNamespace(t, kubectlOptions, namespace)
+					t.Cleanup(func() {
+						k8s.DeleteNamespace(t, kubectlOptions, namespace)
+					})
+					kubectlOptions.Namespace = namespace
+
+					k8s.KubectlApply(t, kubectlOptions, filepath.Join("testdata", "mqtt-bridge.yaml"))
+					k8s.WaitUntilDeploymentAvailable(t, kubectlOptions, "mqtt-bridge", 30, 10*time.Second)
+				},
+			})
+		})
+	}
+}
+
+// writeKubeconfig writes a kubeconfig for the cluster built from the module
+// outputs. Tokens come from `aws eks get-token`, so the AWS CLI has to be on
+// the PATH.
+func writeKubeconfig(t *testing.T, terraformOptions *terraform.Options, region string) string {
+	clusterName := terraform.Output(t, terraformOptions, "cluster_name")
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+  - name: %[1]s
+    cluster:
+      server: %[2]s
+      certificate-authority-data: %[3]s
+contexts:
+  - name: %[1]s
+    context:
+      cluster: %[1]s
+      user: %[1]s
+current-context: %[1]s
+users:
+  - name: %[1]s
+    user:
+      exec:
+        apiVersion: client.authentication.k8s.io/v1beta1
+        command: aws
+        args: ["eks", "get-token", "--cluster-name", "%[1]s", "--region", "%[4]s"]
+`,
+		clusterName,
+		terraform.Output(t, terraformOptions, "cluster_endpoint"),
+		terraform.Output(t, terraformOptions, "cluster_certificate_authority_data"),
+		region,
+	)
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+	return path
+}
+
+// waitForReadyNodes waits until the expected number of nodes has joined the
+// cluster and is Ready. k8s.WaitUntilAllNodesReady is not enough on its own as
+// it passes as soon as every node that has joined so far is Ready.
+func waitForReadyNodes(t *testing.T, kubectlOptions *k8s.KubectlOptions, expected int) {
+	retry.DoWithRetry(t, fmt.Sprintf("wait for %d Ready nodes", expected), 40, 15*time.Second, func() (string, error) {
+		nodes, err := k8s.GetReadyNodesE(t, kubectlOptions)
+		if err != nil {
+			return "", err
+		}
+		if len(nodes) < expected {
+			return "", fmt.Errorf("%d of %d nodes are Ready", len(nodes), expected)
+		}
+		return "", nil
+	})
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// environmentExpectation is what the tfvars of an environment have to add up
+// to once planned.
+type environmentExpectation struct {
+	// cidrBlock is the VPC CIDR the environment is tested with
+	cidrBlock          string
+	perAzNat           bool
+	deletionProtection bool
+}
+
+// environmentExpectations covers every file in environments/. A tfvars file
+// without an entry fails the test, so adding an environment means deciding
+// what it must look like.
+var environmentExpectations = map[string]environmentExpectation{
+	"dev":     {cidrBlock: "10.14.0.0/16", perAzNat: false, deletionProtection: false},
+	"staging": {cidrBlock: "10.15.0.0/16", perAzNat: false, deletionProtection: true},
+	"prod":    {cidrBlock: "10.16.0.0/16", perAzNat: true, deletionProtection: true},
+}
+
+// TestEnvironments plans the vpc and iot-rules modules with the tfvars of
+// every environment in environments/ and checks the environment's
+// expectations: prod must have a NAT gateway per AZ and a deletion protected
+// telemetry table, dev neither. Only dev is applied, set
+// TEST_APPLY_ENVIRONMENTS to apply others:
+//
+//	TEST_APPLY_ENVIRONMENTS=dev,staging go test -run TestEnvironments ./...
+func TestEnvironments(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	for _, environment := range testhelpers.Environments(t) {
+		environment := environment
+		t.Run(environment.Name, func(t *testing.T) {
+			t.Parallel()
+
+			expected, ok := environmentExpectations[environment.Name]
+			require.True(t, ok, "no expectations for environment %s in environmentExpectations", environment.Name)
+			planOnly := !testhelpers.ShouldApplyEnvironment(environment.Name)
+			varFiles := []string{environment.VarFile}
+
+			t.Run("vpc", func(t *testing.T) {
+				t.Parallel()
+
+				terraformOptions := testhelpers.NewModuleOptionsWithVarFiles(t, "vpc", region, varFiles, map[string]interface{}{
+					"project_name": "iot-network",
+					"owner":        "terratest",
+					"cost_center":  "ci",
+					"cidr_block":   expected.cidrBlock,
+				})
+
+				azCount, _ := environment.Vars["az_count"].(float64)
+				quotas := vpcQuotaRequirements(true)
+				if expected.perAzNat {
+					quotas.ElasticIPs = int(azCount)
+				}
+
+				testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+					PlanOnly: planOnly,
+					Quotas:   quotas,
+					Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+						terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_vpc.main")
+						tags, _ := plan.ResourcePlannedValuesMap["aws_vpc.main"].AttributeValues["tags"].(map[string]interface{})
+						assert.Equal(t, environment.Name, tags["Environment"], "planned VPC Environment tag")
+
+						zones := len(testhelpers.PlannedResourcesOfType(plan, "aws_subnet")) / 2
+						natGateways := len(testhelpers.PlannedResourcesOfType(plan, "aws_nat_gateway"))
+						if expected.perAzNat {
+							assert.Greater(t, zones, 1, "%s has to span several AZs", environment.Name)
+							assert.Equal(t, zones, natGateways, "%s has to have a NAT gateway per AZ", environment.Name)
+						} else {
+							assert.LessOrEqual(t, natGateways, 1, "%s must not have a NAT gateway per AZ", environment.Name)
+						}
+					},
+					Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+						natGatewayIds := terraform.OutputList(t, terraformOptions, "nat_gateway_ids")
+						privateSubnetIds := terraform.OutputList(t, terraformOptions, "private_subnet_ids")
+						if expected.perAzNat {
+							assert.Len(t, natGatewayIds, len(privateSubnetIds), "NAT gateways of %s", environment.Name)
+						} else {
+							assert.LessOrEqual(t, len(natGatewayIds), 1, "NAT gateways of %s", environment.Name)
+						}
+					},
+				})
+			})
+
+			t.Run("iot-rules", func(t *testing.T) {
+				t.Parallel()
+
+				terraformOptions := testhelpers.NewModuleOptionsWithVarFiles(t, "iot-rules", region, varFiles, map[string]interface{}{
+					"project_name": "iot-network",
+					"owner":        "terratest",
+					"cost_center":  "ci",
+				})
+
+				testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+					PlanOnly: planOnly,
+					Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+						terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_dynamodb_table.telemetry")
+						table := plan.ResourcePlannedValuesMap["aws_dynamodb_table.telemetry"].AttributeValues
+						assert.Equal(t, expected.deletionProtection, table["deletion_protection_enabled"], "planned deletion protection of the telemetry table in %s", environment.Name)
+					},
+					Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+						region := testhelpers.Region(terraformOptions)
+						tableName := terraform.Output(t, terraformOptions, "telemetry_table_name")
+						dynamoClient := dynamodb.New(testhelpers.NewSession(t, region))
+
+						// Runs before the teardown, which could not delete the
+						// table otherwise
+						t.Cleanup(func() {
+							_, err := dynamoClient.UpdateTable(&dynamodb.UpdateTableInput{
+								TableName:                 awsSDK.String(tableName),
+								DeletionProtectionEnabled: awsSDK.Bool(false),
+							})
+							if err != nil {
+								t.Logf("Failed to disable deletion protection of %s: %v", tableName, err)
+							}
+						})
+
+						output, err := dynamoClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: awsSDK.String(tableName)})
+						require.NoError(t, err)
+						assert.Equal(t, expected.deletionProtection, awsSDK.BoolValue(output.Table.DeletionProtectionEnabled), "deletion protection of %s in %s", tableName, environment.Name)
+					},
+				})
+			})
+		})
+	}
+}
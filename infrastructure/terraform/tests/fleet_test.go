@@ -0,0 +1,263 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// fleetTestEnvVar enables TestFleetProvisioning, which registers a whole
+	// fleet of devices and is only run nightly.
+	fleetTestEnvVar = "RUN_FLEET_TEST"
+
+	// fleetSizeEnvVar is how many devices TestFleetProvisioning registers.
+	fleetSizeEnvVar = "FLEET_SIZE"
+
+	defaultFleetSize = 50
+
+	// RegisterThing is throttled at 10 requests per second per account, more
+	// workers only turn registrations into retries
+	fleetWorkers = 10
+
+	// fleetMaxRegistrationLatency is how long a single device may take from
+	// creating its certificate to being registered, throttling retries
+	// included.
+	fleetMaxRegistrationLatency = 30 * time.Second
+)
+
+// fleetDevice is one device of the fleet TestFleetProvisioning registers. The
+// certificate fields are set as soon as the certificate exists, so cleanup
+// finds it even when registering the thing failed.
+type fleetDevice struct {
+	thingName      string
+	certificateId  string
+	certificateArn string
+	latency        time.Duration
+	err            error
+}
+
+// TestFleetProvisioning simulates the burst of devices coming online after a
+// firmware rollout: FLEET_SIZE devices, 50 by default, each create their own
+// certificate and register through the fleet provisioning template
+// concurrently. Every thing has to end up in the device thing group with an
+// active certificate carrying the device policy, and no registration may take
+// longer than fleetMaxRegistrationLatency. The latency of every device is
+// recorded in the test report.
+//
+//	RUN_FLEET_TEST=1 FLEET_SIZE=200 go test -run TestFleetProvisioning -timeout 60m ./...
+func TestFleetProvisioning(t *testing.T) {
+	t.Parallel()
+	testhelpers.SkipUnlessEnabled(t, fleetTestEnvVar)
+
+	region := testhelpers.SelectRegion(t)
+
+	fleetSize := defaultFleetSize
+	if value := os.Getenv(fleetSizeEnvVar); value != "" {
+		size, err := strconv.Atoi(value)
+		require.NoError(t, err, "%s must be a number", fleetSizeEnvVar)
+		require.Positive(t, size, "%s must be positive", fleetSizeEnvVar)
+		fleetSize = size
+	}
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-core", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Quotas: testhelpers.QuotaRequirements{IoTThings: fleetSize},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			iotClient := testhelpers.NewIotClient(t, testhelpers.Region(terraformOptions))
+
+			templateName := terraform.Output(t, terraformOptions, "provisioning_template_name")
+			template, err := iotClient.DescribeProvisioningTemplate(&iot.DescribeProvisioningTemplateInput{TemplateName: awsSDK.String(templateName)})
+			require.NoError(t, err, "provisioning template %s does not exist", templateName)
+			thingGroupName := terraform.Output(t, terraformOptions, "thing_group_name")
+			policyName := terraform.Output(t, terraformOptions, "device_policy_name")
+
+			devices := make([]*fleetDevice, fleetSize)
+			for i := range devices {
+				devices[i] = &fleetDevice{thingName: fmt.Sprintf("%s-fleet-%03d", testhelpers.NamePrefix(terraformOptions), i)}
+			}
+			t.Cleanup(func() {
+				deleteFleet(t, iotClient, devices)
+			})
+
+			registerFleet(iotClient, awsSDK.StringValue(template.TemplateBody), devices)
+
+			var failures, slow []string
+			for _, device := range devices {
+				status, details := report.Pass, device.thingName
+				switch {
+				case device.err != nil:
+					status, details = report.Fail, fmt.Sprintf("%s: %v", device.thingName, device.err)
+					failures = append(failures, details)
+				case device.latency > fleetMaxRegistrationLatency:
+					status = report.Fail
+					slow = append(slow, fmt.Sprintf("%s: %s", device.thingName, device.latency.Round(time.Millisecond)))
+				}
+				report.RecordDuration(t, "", "fleet-provisioning", status, details, device.latency)
+			}
+			logFleetLatencies(t, devices)
+			require.Empty(t, failures, "%d of %d devices failed to register:\n%s", len(failures), fleetSize, strings.Join(failures, "\n"))
+			assert.Empty(t, slow, "registrations took longer than %s:\n%s", fleetMaxRegistrationLatency, strings.Join(slow, "\n"))
+
+			members := thingGroupMembers(t, iotClient, thingGroupName)
+			for _, device := range devices {
+				assert.True(t, members[device.thingName], "thing %s is not in thing group %s", device.thingName, thingGroupName)
+				assertFleetCertificate(t, iotClient, device, policyName)
+			}
+		},
+	})
+}
+
+// registerFleet registers the devices concurrently on fleetWorkers workers,
+// recording on each device how long it took and why it failed.
+func registerFleet(iotClient *iot.IoT, templateBody string, devices []*fleetDevice) {
+	queue := make(chan *fleetDevice)
+	var wg sync.WaitGroup
+	for i := 0; i < fleetWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for device := range queue {
+				start := time.Now()
+				device.err = registerFleetDevice(iotClient, templateBody, device)
+				device.latency = time.Since(start)
+			}
+		}()
+	}
+	for _, device := range devices {
+		queue <- device
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// registerFleetDevice creates the device's certificate and registers its thing
+// through the provisioning template, the way a device does on first boot. It
+// returns errors rather than failing the test, as it runs on a worker.
+func registerFleetDevice(iotClient *iot.IoT, templateBody string, device *fleetDevice) error {
+	certificate, err := iotClient.CreateKeysAndCertificate(&iot.CreateKeysAndCertificateInput{SetAsActive: awsSDK.Bool(false)})
+	if err != nil {
+		return fmt.Errorf("creating certificate: %w", err)
+	}
+	device.certificateId = awsSDK.StringValue(certificate.CertificateId)
+	device.certificateArn = awsSDK.StringValue(certificate.CertificateArn)
+
+	_, err = iotClient.RegisterThing(&iot.RegisterThingInput{
+		TemplateBody: awsSDK.String(templateBody),
+		Parameters: map[string]*string{
+			"ThingName":                 awsSDK.String(device.thingName),
+			"AWS::IoT::Certificate::Id": awsSDK.String(device.certificateId),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("registering thing: %w", err)
+	}
+	return nil
+}
+
+// deleteFleet removes the certificates and things of every device, whether or
+// not it registered, on as many workers as registering them took.
+func deleteFleet(t *testing.T, iotClient *iot.IoT, devices []*fleetDevice) {
+	queue := make(chan *fleetDevice)
+	var wg sync.WaitGroup
+	for i := 0; i < fleetWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for device := range queue {
+				if device.certificateId == "" {
+					// RegisterThing never ran without a certificate; deleting
+					// a thing that does not exist succeeds
+					if _, err := iotClient.DeleteThing(&iot.DeleteThingInput{ThingName: awsSDK.String(device.thingName)}); err != nil {
+						t.Logf("Failed to delete thing %s: %v", device.thingName, err)
+					}
+					continue
+				}
+				deleteTestDevice(t, iotClient, device.thingName, device.certificateId, device.certificateArn)
+			}
+		}()
+	}
+	for _, device := range devices {
+		queue <- device
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// thingGroupMembers returns the names of the things in the thing group.
+func thingGroupMembers(t *testing.T, iotClient *iot.IoT, thingGroupName string) map[string]bool {
+	members := map[string]bool{}
+	input := &iot.ListThingsInThingGroupInput{ThingGroupName: awsSDK.String(thingGroupName)}
+	for {
+		output, err := iotClient.ListThingsInThingGroup(input)
+		require.NoError(t, err)
+		for _, name := range output.Things {
+			members[awsSDK.StringValue(name)] = true
+		}
+		if output.NextToken == nil {
+			return members
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// assertFleetCertificate checks that the template activated the device's
+// certificate, attached it to the device's thing and attached the device policy
+// to it.
+func assertFleetCertificate(t *testing.T, iotClient *iot.IoT, device *fleetDevice, policyName string) {
+	certificate, err := iotClient.DescribeCertificate(&iot.DescribeCertificateInput{CertificateId: awsSDK.String(device.certificateId)})
+	if assert.NoError(t, err, "describing the certificate of %s", device.thingName) {
+		assert.Equal(t, iot.CertificateStatusActive, awsSDK.StringValue(certificate.CertificateDescription.Status), "certificate of %s", device.thingName)
+	}
+
+	principals, err := iotClient.ListThingPrincipals(&iot.ListThingPrincipalsInput{ThingName: awsSDK.String(device.thingName)})
+	if assert.NoError(t, err, "listing the principals of %s", device.thingName) {
+		assert.Contains(t, awsSDK.StringValueSlice(principals.Principals), device.certificateArn, "certificate is not attached to thing %s", device.thingName)
+	}
+
+	attached, err := iotClient.ListAttachedPolicies(&iot.ListAttachedPoliciesInput{Target: awsSDK.String(device.certificateArn)})
+	if assert.NoError(t, err, "listing the policies of the certificate of %s", device.thingName) {
+		var names []string
+		for _, policy := range attached.Policies {
+			names = append(names, awsSDK.StringValue(policy.PolicyName))
+		}
+		assert.Contains(t, names, policyName, "device policy is not attached to the certificate of %s", device.thingName)
+	}
+}
+
+// logFleetLatencies logs the median, 95th percentile and slowest registration
+// of the devices that registered.
+func logFleetLatencies(t *testing.T, devices []*fleetDevice) {
+	var latencies []time.Duration
+	for _, device := range devices {
+		if device.err == nil {
+			latencies = append(latencies, device.latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	t.Logf("Registered %d devices: p50 %s, p95 %s, max %s", len(latencies),
+		latencies[len(latencies)/2].Round(time.Millisecond),
+		latencies[len(latencies)*95/100].Round(time.Millisecond),
+		latencies[len(latencies)-1].Round(time.Millisecond))
+}
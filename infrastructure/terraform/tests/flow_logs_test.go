@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// flowLogsRetentionInDays differs from the module default so the test can tell
+// the var is applied.
+const flowLogsRetentionInDays = 14
+
+// TestVpcFlowLogs checks that the vpc module captures all traffic of the VPC to
+// the destination in its outputs, that flow logs going to CloudWatch Logs are
+// retained as configured and encrypted with KMS, and that disabling flow logs
+// really leaves the VPC without one.
+func TestVpcFlowLogs(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name        string
+		cidrBlock   string
+		enabled     bool
+		destination string
+	}{
+		{name: "CloudWatch", cidrBlock: "10.8.0.0/16", enabled: true, destination: "cloud-watch-logs"},
+		{name: "S3", cidrBlock: "10.9.0.0/16", enabled: true, destination: "s3"},
+		{name: "Disabled", cidrBlock: "10.11.0.0/16", enabled: false, destination: "cloud-watch-logs"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name":                "iot-network",
+				"environment":                 "test",
+				"owner":                       "terratest",
+				"cost_center":                 "ci",
+				"cidr_block":                  tc.cidrBlock,
+				"az_count":                    1,
+				"enable_nat":                  false,
+				"enable_flow_logs":            tc.enabled,
+				"flow_logs_destination":       tc.destination,
+				"flow_logs_retention_in_days": flowLogsRetentionInDays,
+				"flow_logs_force_destroy":     true,
+				// Only used by the CloudWatch destination
+				"enable_flow_logs_encryption": true,
+			})
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Quotas: vpcQuotaRequirements(false),
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					flowLogs := testhelpers.PlannedResourcesOfType(plan, "aws_flow_log")
+					if !tc.enabled {
+						assert.Empty(t, flowLogs, "flow log planned with enable_flow_logs = false")
+						return
+					}
+					require.Len(t, flowLogs, 1)
+					for _, flowLog := range flowLogs {
+						assert.Equal(t, "ALL", flowLog["traffic_type"], "flow log does not capture all traffic")
+						assert.Equal(t, tc.destination, flowLog["log_destination_type"])
+					}
+					if tc.destination == "cloud-watch-logs" {
+						testhelpers.AssertResourceAttr(t, plan, "aws_kms_key.flow_logs[0]", "deletion_window_in_days", 7)
+					}
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					vpcId := terraform.Output(t, terraformOptions, "vpc_id")
+					ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+					describeFlowLogs := func() ([]*ec2.FlowLog, error) {
+						output, err := ec2Client.DescribeFlowLogs(&ec2.DescribeFlowLogsInput{
+							Filter: []*ec2.Filter{{Name: awsSDK.String("resource-id"), Values: []*string{awsSDK.String(vpcId)}}},
+						})
+						if err != nil {
+							return nil, err
+						}
+						return output.FlowLogs, nil
+					}
+
+					if !tc.enabled {
+						flowLogs, err := describeFlowLogs()
+						require.NoError(t, err)
+						assert.Empty(t, flowLogs, "VPC %s has flow logs with enable_flow_logs = false", vpcId)
+						return
+					}
+
+					var flowLog *ec2.FlowLog
+					active := testhelpers.PollUntil(t, "flow log of "+vpcId+" active", func() (bool, error) {
+						flowLogs, err := describeFlowLogs()
+						if err != nil || len(flowLogs) == 0 {
+							return false, err
+						}
+						flowLog = flowLogs[0]
+						return awsSDK.StringValue(flowLog.FlowLogStatus) == "ACTIVE", nil
+					})
+					require.NotNil(t, flowLog, "VPC %s has no flow log", vpcId)
+					assert.True(t, active, "flow log %s is %s", awsSDK.StringValue(flowLog.FlowLogId), awsSDK.StringValue(flowLog.FlowLogStatus))
+					assert.Equal(t, terraform.Output(t, terraformOptions, "flow_log_id"), awsSDK.StringValue(flowLog.FlowLogId))
+					assert.Equal(t, "ALL", awsSDK.StringValue(flowLog.TrafficType), "flow log does not capture all traffic")
+					assert.Equal(t, tc.destination, awsSDK.StringValue(flowLog.LogDestinationType))
+
+					if tc.destination == "s3" {
+						bucketArn := terraform.Output(t, terraformOptions, "flow_log_bucket_arn")
+						assert.Equal(t, bucketArn, strings.TrimSuffix(awsSDK.StringValue(flowLog.LogDestination), "/"), "flow log destination")
+						return
+					}
+
+					logGroupName := terraform.Output(t, terraformOptions, "flow_log_group_name")
+					assert.Equal(t, logGroupName, awsSDK.StringValue(flowLog.LogGroupName), "flow log destination")
+					logGroup := describeLogGroup(t, region, logGroupName)
+					assert.Equal(t, int64(flowLogsRetentionInDays), awsSDK.Int64Value(logGroup.RetentionInDays), "retention of log group %s", logGroupName)
+					assert.NotEmpty(t, awsSDK.StringValue(logGroup.KmsKeyId), "log group %s is not encrypted with KMS", logGroupName)
+				},
+			})
+		})
+	}
+}
+
+// describeLogGroup returns the CloudWatch log group with the given name.
+func describeLogGroup(t *testing.T, region string, name string) *cloudwatchlogs.LogGroup {
+	output, err := cloudwatchlogs.New(testhelpers.NewSession(t, region)).DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: awsSDK.String(name),
+	})
+	require.NoError(t, err)
+	for _, logGroup := range output.LogGroups {
+		if awsSDK.StringValue(logGroup.LogGroupName) == name {
+			return logGroup
+		}
+	}
+	require.FailNow(t, "log group not found", "no log group named %s", name)
+	return nil
+}
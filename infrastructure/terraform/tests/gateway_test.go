@@ -0,0 +1,291 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// Targets take a few health check intervals to become healthy after
+	// registering, so /healthz is retried for up to ten minutes.
+	gatewayHealthRetries       = 60
+	gatewayHealthTimeBetween   = 10 * time.Second
+	gatewayTargetPort          = 8000
+	gatewayResponseSnippetSize = 200
+
+	// gatewayCommonName is the common name of the certificate the test
+	// issues for the HTTPS listener
+	gatewayCommonName = "gateway.test.invalid"
+
+	// The test's certificates are valid for a week
+	gatewayCertificateMinDaysValid = 1
+)
+
+// TestGatewayModule registers a stand-in service with the gateway load
+// balancer and checks the listener, target group and health check wiring:
+// /healthz has to answer {"status":"ok"} through the load balancer, unknown
+// paths have to fail fast with a 404 or 503, and with force_https the HTTP
+// listener has to redirect to HTTPS. The HTTPS listener uses a certificate
+// issued by a CA the test generates and imported into ACM, and its chain is
+// verified against that CA.
+func TestGatewayModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name       string
+		forceHttps bool
+	}{
+		{name: "Http", forceHttps: false},
+		{name: "ForceHttps", forceHttps: true},
+	}
+
+	for i, tc := range testCases {
+		i, tc := i, tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Load balancers need subnets in two AZs
+			vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   fmt.Sprintf("10.%d.0.0/16", 60+i),
+				"az_count":     2,
+				"enable_nat":   false,
+			})
+
+			vpcId := "vpc-00000000000000000"
+			publicSubnetIds := []string{"subnet-00000000000000000", "subnet-00000000000000001"}
+			privateSubnetIds := []string{"subnet-00000000000000002", "subnet-00000000000000003"}
+			certificateArn := ""
+			caBundle := ""
+			if tc.forceHttps {
+				certificateArn = "arn:aws:acm:us-west-2:000000000000:certificate/00000000-0000-0000-0000-000000000000"
+			}
+			if !testhelpers.IsPlanOnly() {
+				testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(false))
+				testhelpers.ApplyModule(t, vpcOptions)
+				vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+				publicSubnetIds = terraform.OutputList(t, vpcOptions, "public_subnet_ids")
+				privateSubnetIds = terraform.OutputList(t, vpcOptions, "private_subnet_ids")
+				if tc.forceHttps {
+					certificateArn, caBundle = importTestCertificate(t, testhelpers.Region(vpcOptions), gatewayCommonName)
+				}
+			}
+
+			gatewayOptions := testhelpers.NewModuleOptionsInRegion(t, "gateway", region, map[string]interface{}{
+				"project_name":      "iot-network",
+				"environment":       "test",
+				"owner":             "terratest",
+				"cost_center":       "ci",
+				"vpc_id":            vpcId,
+				"public_subnet_ids": publicSubnetIds,
+				"target_port":       gatewayTargetPort,
+				"certificate_arn":   certificateArn,
+				"force_https":       tc.forceHttps,
+			})
+
+			testhelpers.RunModuleChecks(t, gatewayOptions, testhelpers.ModuleChecks{
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_lb_listener.http")
+					actions, _ := plan.ResourcePlannedValuesMap["aws_lb_listener.http"].AttributeValues["default_action"].([]interface{})
+					require.Len(t, actions, 1)
+					expected := "forward"
+					if tc.forceHttps {
+						expected = "redirect"
+					}
+					assert.Equal(t, expected, actions[0].(map[string]interface{})["type"], "planned HTTP listener action")
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					dnsName := terraform.Output(t, terraformOptions, "alb_dns_name")
+					registerGatewayTarget(t, region, terraformOptions, privateSubnetIds[0])
+
+					baseURL := "http://" + dnsName
+					var tlsConfig *tls.Config
+					if tc.forceHttps {
+						baseURL = "https://" + dnsName
+						testhelpers.AssertTLSEndpointWithCABundle(t, dnsName+":443", caBundle, gatewayCommonName, gatewayCertificateMinDaysValid)
+						roots := x509.NewCertPool()
+						bundle, err := os.ReadFile(caBundle)
+						require.NoError(t, err)
+						require.True(t, roots.AppendCertsFromPEM(bundle))
+						tlsConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+					}
+
+					assertHealthz(t, baseURL+"/healthz", tlsConfig)
+
+					// http_helper gives up after ten seconds, so a hanging
+					// listener shows up as an error rather than a stuck test
+					unknownURL := baseURL + "/" + testhelpers.NamePrefix(terraformOptions) + "-unknown"
+					status, body, err := http_helper.HttpGetE(t, unknownURL, tlsConfig)
+					require.NoError(t, err, "GET %s did not complete", unknownURL)
+					assert.Contains(t, []int{http.StatusNotFound, http.StatusServiceUnavailable}, status,
+						"GET %s returned %d: %s", unknownURL, status, responseSnippet(body))
+
+					if tc.forceHttps {
+						assertHttpsRedirect(t, "http://"+dnsName+"/healthz", "https://"+dnsName)
+					}
+				},
+			})
+		})
+	}
+}
+
+// registerGatewayTarget launches an instance serving /healthz on the target
+// port and registers it with the gateway's target group by IP.
+func registerGatewayTarget(t *testing.T, region string, terraformOptions *terraform.Options, subnetId string) {
+	userData, err := os.ReadFile(filepath.Join("testdata", "healthz-server.sh"))
+	require.NoError(t, err)
+
+	targetIP := launchPrivateInstance(t, region, privateInstance{
+		name:             testhelpers.NamePrefix(terraformOptions) + "-gateway-target",
+		subnetId:         subnetId,
+		securityGroupIds: []string{terraform.Output(t, terraformOptions, "target_security_group_id")},
+		userData:         string(userData),
+	})
+
+	_, err = testhelpers.NewElbv2Client(t, region).RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: awsSDK.String(terraform.Output(t, terraformOptions, "target_group_arn")),
+		Targets:        []*elbv2.TargetDescription{{Id: awsSDK.String(targetIP), Port: awsSDK.Int64(gatewayTargetPort)}},
+	})
+	require.NoError(t, err)
+}
+
+// assertHealthz waits for url to answer 200 with a body reporting status ok,
+// failing with the last response seen if it never does.
+func assertHealthz(t *testing.T, url string, tlsConfig *tls.Config) {
+	var lastStatus int
+	var lastBody string
+	err := http_helper.HttpGetWithRetryWithCustomValidationE(t, url, tlsConfig, gatewayHealthRetries, gatewayHealthTimeBetween, func(status int, body string) bool {
+		lastStatus, lastBody = status, body
+		return status == http.StatusOK && strings.Contains(body, `"status":"ok"`)
+	})
+	if err != nil {
+		t.Fatalf("GET %s never returned 200 with \"status\":\"ok\", last response was %d: %s", url, lastStatus, responseSnippet(lastBody))
+	}
+}
+
+// assertHttpsRedirect checks that url answers with a permanent redirect to a
+// location starting with httpsPrefix.
+func assertHttpsRedirect(t *testing.T, url string, httpsPrefix string) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Get(url)
+	require.NoError(t, err, "GET %s did not complete", url)
+	defer response.Body.Close()
+
+	assert.Equal(t, http.StatusMovedPermanently, response.StatusCode, "GET %s status", url)
+	location := response.Header.Get("Location")
+	assert.True(t, strings.HasPrefix(location, httpsPrefix), "GET %s redirected to %q, expected %s...", url, location, httpsPrefix)
+}
+
+// importTestCertificate generates a CA and a certificate it issues for the
+// common name and for every load balancer in the region, imports the
+// certificate with its chain into ACM and returns its ARN together with the
+// path of a PEM bundle holding the CA, in the format of TLS_CA_BUNDLE. The
+// private keys never leave memory. The certificate is deleted when the test
+// finishes, after the listeners using it have been destroyed.
+func importTestCertificate(t *testing.T, region string, commonName string) (string, string) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName + " test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(0, 0, 7),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	require.NoError(t, err)
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer})
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, caPem, 0o600))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano() + 1),
+		Subject:      pkix.Name{CommonName: commonName},
+		// The load balancer's DNS name is only known once it exists
+		DNSNames:    []string{commonName, "*." + region + ".elb.amazonaws.com"},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().AddDate(0, 0, 7),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	acmClient := acm.New(testhelpers.NewSession(t, region))
+	imported, err := acmClient.ImportCertificate(&acm.ImportCertificateInput{
+		Certificate:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		CertificateChain: caPem,
+		PrivateKey:       pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		Tags: []*acm.Tag{
+			{Key: awsSDK.String("Project"), Value: awsSDK.String("iot-network")},
+			{Key: awsSDK.String("Environment"), Value: awsSDK.String("test")},
+			{Key: awsSDK.String("Owner"), Value: awsSDK.String("terratest")},
+			{Key: awsSDK.String("CostCenter"), Value: awsSDK.String("ci")},
+		},
+	})
+	require.NoError(t, err)
+	certificateArn := awsSDK.StringValue(imported.CertificateArn)
+
+	t.Cleanup(func() {
+		// The certificate stays in use for a little while after the
+		// listener is deleted
+		_, err := retry.DoWithRetryE(t, "delete certificate "+certificateArn, 10, 15*time.Second, func() (string, error) {
+			_, err := acmClient.DeleteCertificate(&acm.DeleteCertificateInput{CertificateArn: awsSDK.String(certificateArn)})
+			return "", err
+		})
+		if err != nil {
+			t.Logf("Failed to delete certificate %s: %v", certificateArn, err)
+		}
+	})
+
+	return certificateArn, caBundle
+}
+
+// responseSnippet shortens a response body for failure messages.
+func responseSnippet(body string) string {
+	body = strings.TrimSpace(body)
+	if len(body) > gatewayResponseSnippetSize {
+		return body[:gatewayResponseSnippetSize] + "..."
+	}
+	return body
+}
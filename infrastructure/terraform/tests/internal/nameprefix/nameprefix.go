@@ -0,0 +1,53 @@
+// Package nameprefix generates and parses the name prefixes the module tests
+// put on every resource they create. A prefix is tt- followed by the creation
+// time in base 36 seconds and a random suffix, e.g. tt-t1a2b3x9k2m7, so the
+// sweeper can tell how old a leaked resource is from its name alone.
+package nameprefix
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+const (
+	// Start begins every generated prefix, which makes resources created by
+	// the tests easy to spot.
+	Start = "tt-"
+
+	// timestampLength is the width of the creation time, which is six base 36
+	// digits until 2038.
+	timestampLength = 6
+
+	// bodyLength is the length of a prefix after Start: the creation time
+	// followed by a random id of the same width.
+	bodyLength = 2 * timestampLength
+)
+
+// New returns a fresh prefix created at the given time.
+func New(now time.Time) string {
+	return Start + strconv.FormatInt(now.Unix(), 36) + strings.ToLower(random.UniqueId())
+}
+
+// Parse extracts the prefix from a name that starts with one, e.g. the Name
+// tag of a resource, and returns it with its creation time.
+func Parse(name string) (string, time.Time, bool) {
+	if !strings.HasPrefix(name, Start) || len(name) < len(Start)+bodyLength {
+		return "", time.Time{}, false
+	}
+	prefix := name[:len(Start)+bodyLength]
+	if strings.Trim(prefix[len(Start):], "0123456789abcdefghijklmnopqrstuvwxyz") != "" {
+		return "", time.Time{}, false
+	}
+	if len(name) > len(prefix) && name[len(prefix)] != '-' {
+		return "", time.Time{}, false
+	}
+
+	seconds, err := strconv.ParseInt(prefix[len(Start):len(Start)+timestampLength], 36, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return prefix, time.Unix(seconds, 0), true
+}
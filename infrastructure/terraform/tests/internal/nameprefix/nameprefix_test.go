@@ -0,0 +1,32 @@
+package nameprefix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 34, 56, 0, time.UTC)
+	prefix := New(created)
+
+	parsed, parsedCreated, ok := Parse(prefix + "-iot-network-vpc")
+	assert.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+	assert.True(t, created.Equal(parsedCreated), "expected %s, got %s", created, parsedCreated)
+
+	_, _, ok = Parse(prefix)
+	assert.True(t, ok, "a bare prefix should parse")
+
+	for _, name := range []string{
+		"iot-network-vpc",
+		"tt-abc123-iot-network-vpc",
+		"tt-abc1-23defgh-iot-network-vpc",
+		prefix + "x-iot-network-vpc",
+		"tt-!!!!!!abcdef-iot-network-vpc",
+	} {
+		_, _, ok := Parse(name)
+		assert.False(t, ok, "%s should not parse", name)
+	}
+}
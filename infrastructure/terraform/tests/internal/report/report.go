@@ -0,0 +1,276 @@
+// Package report records which checks the module tests ran and how each one
+// ended, so that CI dashboards can show more than pass or fail per run. The
+// report is written as JSON to TEST_REPORT_PATH and, optionally, as JUnit XML
+// to TEST_REPORT_JUNIT_PATH; nothing is written when neither is set.
+//
+// The files are rewritten on every Record, not only by Flush at the end of the
+// run, so they are complete up to the last check even when a test panics or
+// go test times out and the process exits without returning from m.Run.
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// PathEnvVar is where the JSON report is written.
+	PathEnvVar = "TEST_REPORT_PATH"
+
+	// JUnitPathEnvVar is where the JUnit XML report is written.
+	JUnitPathEnvVar = "TEST_REPORT_JUNIT_PATH"
+)
+
+// Status is how a check ended.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+// Result is one check of one module run by one test in one region.
+// DurationSeconds is only set for checks that measure how long something took,
+// see RecordDuration.
+type Result struct {
+	Test            string    `json:"test"`
+	Module          string    `json:"module"`
+	Region          string    `json:"region,omitempty"`
+	Check           string    `json:"check"`
+	Status          Status    `json:"status"`
+	Details         string    `json:"details,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// Report is the document written to TEST_REPORT_PATH.
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Results     []Result  `json:"results"`
+}
+
+var (
+	mu      sync.Mutex
+	results []Result
+	// modules maps test names to the module they check, see SetModule
+	modules = map[string]string{}
+	// regions maps test names to the region they deploy to, see SetRegion
+	regions = map[string]string{}
+)
+
+// SetModule records that the test checks the module, so that checks recorded
+// by it or its subtests without a module of their own are attributed to it.
+func SetModule(t *testing.T, module string) {
+	mu.Lock()
+	defer mu.Unlock()
+	modules[t.Name()] = module
+}
+
+// SetRegion records that the test deploys its modules to the region, so that
+// the checks recorded by it or its subtests are attributed to the region.
+func SetRegion(t *testing.T, region string) {
+	mu.Lock()
+	defer mu.Unlock()
+	regions[t.Name()] = region
+}
+
+// Record adds a check to the report and rewrites the report files. An empty
+// module is taken from the closest of the test and its parents that has one
+// set with SetModule. Failing to write the report is logged, not failed on.
+func Record(t *testing.T, module string, check string, status Status, details string) {
+	record(t, Result{Module: module, Check: check, Status: status, Details: details})
+}
+
+// RecordDuration is Record for a check that measured how long something took,
+// such as registering one device of a fleet.
+func RecordDuration(t *testing.T, module string, check string, status Status, details string, duration time.Duration) {
+	record(t, Result{Module: module, Check: check, Status: status, Details: details, DurationSeconds: duration.Seconds()})
+}
+
+func record(t *testing.T, result Result) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	result.Test = t.Name()
+	if result.Module == "" {
+		result.Module = closestOf(t.Name(), modules)
+	}
+	result.Region = closestOf(t.Name(), regions)
+	result.Time = time.Now().UTC()
+	results = append(results, result)
+	if err := write(); err != nil {
+		t.Logf("Failed to write test report: %v", err)
+	}
+}
+
+// StatusOf returns how the test has ended so far. Recorded from a t.Cleanup,
+// it is how the test ended.
+func StatusOf(t *testing.T) Status {
+	switch {
+	case t.Skipped():
+		return Skip
+	case t.Failed():
+		return Fail
+	}
+	return Pass
+}
+
+// Flush writes the report files one last time. TestMain calls it after the
+// tests have run, so that the report exists even when no check was recorded.
+func Flush() error {
+	mu.Lock()
+	defer mu.Unlock()
+	return write()
+}
+
+// closestOf returns the value set for the test or its closest parent.
+func closestOf(testName string, values map[string]string) string {
+	for name := testName; name != ""; {
+		if value, ok := values[name]; ok {
+			return value
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return ""
+}
+
+// write writes the report files. The caller holds mu.
+func write() error {
+	current := newReport(results, time.Now().UTC())
+
+	if path := os.Getenv(PathEnvVar); path != "" {
+		encoded, err := json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, append(encoded, '\n')); err != nil {
+			return err
+		}
+	}
+	if path := os.Getenv(JUnitPathEnvVar); path != "" {
+		encoded, err := encodeJUnit(current)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newReport builds the report of the results, sorted by module, check and
+// test so that reports of two runs can be diffed.
+func newReport(results []Result, now time.Time) Report {
+	sorted := append([]Result{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Test < b.Test
+	})
+	return Report{GeneratedAt: now, Results: sorted}
+}
+
+// writeFile replaces the file in one step, so a dashboard never reads a half
+// written report.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr,omitempty"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// encodeJUnit renders the report as JUnit XML with a test suite per module and
+// a test case per check.
+func encodeJUnit(current Report) ([]byte, error) {
+	var suites junitTestSuites
+	bySuite := map[string]int{}
+	for _, result := range current.Results {
+		module := result.Module
+		if module == "" {
+			module = "unknown"
+		}
+		i, ok := bySuite[module]
+		if !ok {
+			i = len(suites.Suites)
+			bySuite[module] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: module, Timestamp: current.GeneratedAt.Format(time.RFC3339)})
+		}
+
+		testCase := junitTestCase{ClassName: module, Name: fmt.Sprintf("%s (%s)", result.Check, result.Test), Time: result.DurationSeconds}
+		switch result.Status {
+		case Fail:
+			testCase.Failure = &junitMessage{Message: result.Check + " failed", Text: result.Details}
+			suites.Suites[i].Failures++
+		case Skip:
+			testCase.Skipped = &junitMessage{Message: result.Details}
+			suites.Suites[i].Skipped++
+		}
+		suites.Suites[i].Tests++
+		suites.Suites[i].Cases = append(suites.Suites[i].Cases, testCase)
+	}
+
+	encoded, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(encoded, '\n')...), nil
+}
@@ -0,0 +1,222 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetReport empties the report for a test and restores it afterwards, so
+// the tests of this package do not see each other's results.
+func resetReport(t *testing.T) {
+	mu.Lock()
+	savedResults, savedModules, savedRegions := results, modules, regions
+	results, modules, regions = nil, map[string]string{}, map[string]string{}
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		results, modules, regions = savedResults, savedModules, savedRegions
+		mu.Unlock()
+	})
+}
+
+func TestReportMatchesSchema(t *testing.T) {
+	resetReport(t)
+	reportPath := filepath.Join(t.TempDir(), "reports", "report.json")
+	t.Setenv(PathEnvVar, reportPath)
+
+	SetModule(t, "vpc")
+	SetRegion(t, "eu-west-1")
+	t.Run("Plan", func(t *testing.T) {
+		Record(t, "", "plan", Pass, "")
+	})
+	t.Run("Apply", func(t *testing.T) {
+		Record(t, "", "encryption", Fail, "dynamodb table telemetry is encrypted with the AWS owned key")
+		Record(t, "iot-core", "mqtt-e2e", Skip, "set RUN_MQTT_TEST=true")
+		RecordDuration(t, "iot-core", "fleet-provisioning", Pass, "tt-t1a2b3x9k2m7-fleet-000", 1500*time.Millisecond)
+	})
+
+	schema := readJSON(t, filepath.Join("testdata", "report.schema.json"))
+	document := readJSON(t, reportPath)
+	assert.Empty(t, validateSchema(schema, document, "$"), "report does not match the schema")
+
+	var written Report
+	encoded, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(encoded, &written))
+	require.Len(t, written.Results, 4)
+	// Sorted by module, then check
+	assert.Equal(t, []string{"iot-core", "iot-core", "vpc", "vpc"}, []string{written.Results[0].Module, written.Results[1].Module, written.Results[2].Module, written.Results[3].Module})
+	assert.Equal(t, "fleet-provisioning", written.Results[0].Check)
+	assert.Equal(t, 1.5, written.Results[0].DurationSeconds)
+	assert.Zero(t, written.Results[1].DurationSeconds, "duration of a check that measured nothing")
+	assert.Equal(t, Result{
+		Test:    "TestReportMatchesSchema/Apply",
+		Module:  "vpc",
+		Region:  "eu-west-1",
+		Check:   "encryption",
+		Status:  Fail,
+		Details: "dynamodb table telemetry is encrypted with the AWS owned key",
+		Time:    written.Results[2].Time,
+	}, written.Results[2])
+}
+
+func TestEmptyReportMatchesSchema(t *testing.T) {
+	resetReport(t)
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	t.Setenv(PathEnvVar, reportPath)
+
+	require.NoError(t, Flush())
+
+	document := readJSON(t, reportPath)
+	assert.Empty(t, validateSchema(readJSON(t, filepath.Join("testdata", "report.schema.json")), document, "$"))
+	assert.Equal(t, []interface{}{}, document.(map[string]interface{})["results"], "no results must still be a list")
+}
+
+func TestRecordFromParallelTests(t *testing.T) {
+	resetReport(t)
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	t.Setenv(PathEnvVar, reportPath)
+
+	const tests = 20
+	t.Run("Group", func(t *testing.T) {
+		for i := 0; i < tests; i++ {
+			i := i
+			t.Run(fmt.Sprintf("Test%d", i), func(t *testing.T) {
+				t.Parallel()
+				Record(t, fmt.Sprintf("module-%02d", i), "tags", Pass, "")
+			})
+		}
+	})
+
+	var written Report
+	encoded, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(encoded, &written))
+	require.Len(t, written.Results, tests, "results lost under parallel tests")
+	modules := make([]string, 0, tests)
+	for _, result := range written.Results {
+		modules = append(modules, result.Module)
+	}
+	assert.True(t, sort.StringsAreSorted(modules), "results are not sorted by module")
+	assert.Len(t, slices.Compact(modules), tests)
+}
+
+func TestEncodeJUnit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	encoded, err := encodeJUnit(newReport([]Result{
+		{Test: "TestVpcModule/Apply", Module: "vpc", Check: "tags", Status: Pass, DurationSeconds: 2.5},
+		{Test: "TestVpcModule/Apply", Module: "vpc", Check: "encryption", Status: Fail, Details: "bucket is not encrypted"},
+		{Test: "TestMqttPublishSubscribe", Module: "iot-core", Check: "mqtt-e2e", Status: Skip, Details: "plan-only"},
+	}, now))
+	require.NoError(t, err)
+
+	var suites junitTestSuites
+	require.NoError(t, xml.Unmarshal(encoded, &suites), "JUnit report is not valid XML:\n%s", encoded)
+	require.Len(t, suites.Suites, 2)
+	assert.Equal(t, junitTestSuite{
+		Name: "iot-core", Tests: 1, Skipped: 1, Timestamp: "2024-05-01T12:00:00Z",
+		Cases: []junitTestCase{{ClassName: "iot-core", Name: "mqtt-e2e (TestMqttPublishSubscribe)", Skipped: &junitMessage{Message: "plan-only"}}},
+	}, suites.Suites[0])
+	assert.Equal(t, "vpc", suites.Suites[1].Name)
+	assert.Equal(t, 2, suites.Suites[1].Tests)
+	assert.Equal(t, 1, suites.Suites[1].Failures)
+	require.NotNil(t, suites.Suites[1].Cases[0].Failure)
+	assert.Equal(t, "bucket is not encrypted", suites.Suites[1].Cases[0].Failure.Text)
+	assert.Nil(t, suites.Suites[1].Cases[1].Failure)
+	assert.Equal(t, 2.5, suites.Suites[1].Cases[1].Time, "JUnit time of a check with a duration")
+}
+
+func TestModuleOf(t *testing.T) {
+	t.Parallel()
+
+	modules := map[string]string{"TestVpcPeering": "vpc-peering", "TestVpcPeering/Hub": "vpc"}
+	assert.Equal(t, "vpc", closestOf("TestVpcPeering/Hub/Apply", modules))
+	assert.Equal(t, "vpc-peering", closestOf("TestVpcPeering/Apply", modules))
+	assert.Equal(t, "", closestOf("TestVpcModule/Plan", modules))
+}
+
+func readJSON(t *testing.T, path string) interface{} {
+	encoded, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var document interface{}
+	require.NoError(t, json.Unmarshal(encoded, &document), "%s is not valid JSON", path)
+	return document
+}
+
+// validateSchema checks the document against the subset of JSON Schema that
+// report.schema.json uses and returns a line per violation.
+func validateSchema(schema interface{}, document interface{}, path string) []string {
+	rules, _ := schema.(map[string]interface{})
+	var violations []string
+
+	switch rules["type"] {
+	case "object":
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return []string{path + ": not an object"}
+		}
+		properties, _ := rules["properties"].(map[string]interface{})
+		required, _ := rules["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				violations = append(violations, fmt.Sprintf("%s: missing %s", path, name))
+			}
+		}
+		for name, value := range object {
+			property, ok := properties[name]
+			if !ok {
+				if rules["additionalProperties"] == false {
+					violations = append(violations, fmt.Sprintf("%s: unexpected property %s", path, name))
+				}
+				continue
+			}
+			violations = append(violations, validateSchema(property, value, path+"."+name)...)
+		}
+	case "array":
+		array, ok := document.([]interface{})
+		if !ok {
+			return []string{path + ": not an array"}
+		}
+		for i, item := range array {
+			violations = append(violations, validateSchema(rules["items"], item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "number":
+		value, ok := document.(float64)
+		if !ok {
+			return []string{path + ": not a number"}
+		}
+		if minimum, ok := rules["exclusiveMinimum"].(float64); ok && value <= minimum {
+			violations = append(violations, fmt.Sprintf("%s: %v is not above %v", path, value, minimum))
+		}
+	case "string":
+		value, ok := document.(string)
+		if !ok {
+			return []string{path + ": not a string"}
+		}
+		if minLength, ok := rules["minLength"].(float64); ok && len(value) < int(minLength) {
+			violations = append(violations, fmt.Sprintf("%s: shorter than %d", path, int(minLength)))
+		}
+		if enum, ok := rules["enum"].([]interface{}); ok && !slices.Contains(enum, interface{}(value)) {
+			violations = append(violations, fmt.Sprintf("%s: %q is not one of %v", path, value, enum))
+		}
+		if rules["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %q is not a date-time", path, value))
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,119 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// defaultRegion matches the aws_region default of the root configuration.
+	defaultRegion = "us-west-2"
+
+	// regionEnvVar selects the region for both the tests and the AWS provider.
+	regionEnvVar = "AWS_DEFAULT_REGION"
+)
+
+// TestRegionsEnvVar lists the regions, comma separated, that region matrix
+// tests run in, e.g. TEST_REGIONS=us-east-1,eu-west-1,ap-south-1.
+const TestRegionsEnvVar = "TEST_REGIONS"
+
+// AwsRegion returns the region the tests run against, taken from
+// AWS_DEFAULT_REGION and falling back to defaultRegion. It does not check the
+// region against FORBIDDEN_REGIONS or the services the modules need; tests
+// pick their region with SelectRegion instead.
+func AwsRegion() string {
+	if region := os.Getenv(regionEnvVar); region != "" {
+		return region
+	}
+	return defaultRegion
+}
+
+// TestRegions returns the regions listed in TEST_REGIONS, or just AwsRegion
+// when it is not set.
+func TestRegions() []string {
+	regions := envList(TestRegionsEnvVar)
+	if len(regions) == 0 {
+		return []string{AwsRegion()}
+	}
+	return regions
+}
+
+// NewIotClient creates an AWS IoT control plane client for the given region.
+// terratest's aws module has no IoT support of its own.
+func NewIotClient(t *testing.T, region string) *iot.IoT {
+	return iot.New(NewSession(t, region))
+}
+
+// NewIotDataClient creates an AWS IoT data plane client for the given region.
+// The data plane is served from the account's own ATS endpoint, so it is looked
+// up first.
+func NewIotDataClient(t *testing.T, region string) *iotdataplane.IoTDataPlane {
+	sess := NewSession(t, region)
+
+	endpoint, err := iot.New(sess).DescribeEndpoint(&iot.DescribeEndpointInput{EndpointType: awsSDK.String("iot:Data-ATS")})
+	require.NoError(t, err)
+	return iotdataplane.New(sess, awsSDK.NewConfig().WithEndpoint("https://"+awsSDK.StringValue(endpoint.EndpointAddress)))
+}
+
+// NewCloudWatchClient creates a CloudWatch metrics and alarms client for the
+// given region. terratest's aws module only has one for CloudWatch Logs.
+func NewCloudWatchClient(t *testing.T, region string) *cloudwatch.CloudWatch {
+	return cloudwatch.New(NewSession(t, region))
+}
+
+// NewEksClient creates an EKS client for the given region.
+func NewEksClient(t *testing.T, region string) *eks.EKS {
+	return eks.New(NewSession(t, region))
+}
+
+// NewElbv2Client creates an Elastic Load Balancing v2 client for the given
+// region.
+func NewElbv2Client(t *testing.T, region string) *elbv2.ELBV2 {
+	return elbv2.New(NewSession(t, region))
+}
+
+// NewKinesisClient creates a Kinesis Data Streams client for the given region.
+func NewKinesisClient(t *testing.T, region string) *kinesis.Kinesis {
+	return kinesis.New(NewSession(t, region))
+}
+
+// NewServiceQuotasClient creates a Service Quotas client for the given region.
+func NewServiceQuotasClient(t *testing.T, region string) *servicequotas.ServiceQuotas {
+	return servicequotas.New(NewSession(t, region))
+}
+
+// NewRoute53Client creates a Route53 client. Route53 is a global service, the
+// region only decides where the requests are signed.
+func NewRoute53Client(t *testing.T, region string) *route53.Route53 {
+	return route53.New(NewSession(t, region))
+}
+
+// InvokeFunction invokes the Lambda function with the payload encoded as JSON
+// and returns its response, failing the test when the function errors. Unlike
+// terratest's, it runs with the credentials of NewSession.
+func InvokeFunction(t *testing.T, region string, functionName string, payload interface{}) []byte {
+	t.Helper()
+
+	encoded, err := json.Marshal(payload)
+	require.NoError(t, err)
+	output, err := lambda.New(NewSession(t, region)).Invoke(&lambda.InvokeInput{
+		FunctionName: awsSDK.String(functionName),
+		Payload:      encoded,
+	})
+	require.NoError(t, err, "invoking function %s", functionName)
+	require.Nil(t, output.FunctionError, "function %s failed: %s", functionName, output.Payload)
+	return output.Payload
+}
@@ -0,0 +1,200 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+)
+
+// Test stages run through test_structure.RunTestStage. Each one is skipped when
+// SKIP_<stage> is set, e.g. SKIP_teardown=true.
+const (
+	StageSetup    = "setup"
+	StageValidate = "validate"
+	StageTeardown = "teardown"
+)
+
+// PlanOnlyEnvVar switches every module test into plan-only mode: modules are
+// planned but never applied, and only the plan assertions run.
+const PlanOnlyEnvVar = "TERRATEST_PLAN_ONLY"
+
+// IsPlanOnly reports whether plan-only mode is enabled.
+func IsPlanOnly() bool {
+	planOnly, _ := strconv.ParseBool(os.Getenv(PlanOnlyEnvVar))
+	return planOnly
+}
+
+// recordViolations adds a check to the test report, failed with the
+// violations as details when there are any.
+func recordViolations(t *testing.T, module string, check string, violations []string) {
+	if len(violations) > 0 {
+		report.Record(t, module, check, report.Fail, strings.Join(violations, "\n"))
+		return
+	}
+	report.Record(t, module, check, report.Pass, "")
+}
+
+// SkipUnlessEnabled skips the test unless envVar is set to a true value. Slow
+// suites that only run nightly are gated this way.
+func SkipUnlessEnabled(t *testing.T, envVar string) {
+	t.Helper()
+
+	if enabled, _ := strconv.ParseBool(os.Getenv(envVar)); !enabled {
+		t.Skipf("set %s=true to run this test", envVar)
+	}
+}
+
+// ModuleChecks holds the assertions a module test runs against the planned
+// resource graph and against the applied infrastructure, and the quotas the
+// apply needs. PlanOnly skips the apply of this module alone, the way
+// plan-only mode does for every module.
+type ModuleChecks struct {
+	Plan     func(t *testing.T, plan *terraform.PlanStruct)
+	Apply    func(t *testing.T, terraformOptions *terraform.Options)
+	Quotas   QuotaRequirements
+	PlanOnly bool
+}
+
+// RunModuleChecks plans the module and runs the plan assertions, then applies
+// it and runs the apply assertions unless plan-only mode is enabled. The apply
+// is skipped when the plan assertions fail, including when the plan costs more
+// than MAX_MONTHLY_COST_USD or its IAM policies are not least-privilege, and
+// skipped when the region has too little quota left for checks.Quotas.
+// Applying belongs to the setup stage and both sets of assertions to the
+// validate stage; destroying the module is left to the teardown registered by
+// NewModuleOptionsInRegion.
+func RunModuleChecks(t *testing.T, terraformOptions *terraform.Options, checks ModuleChecks) {
+	t.Helper()
+
+	module := ModuleName(terraformOptions)
+	report.SetModule(t, module)
+
+	planned := t.Run("Plan", func(t *testing.T) {
+		t.Cleanup(func() { report.Record(t, module, "plan", report.StatusOf(t), "") })
+		if os.Getenv(test_structure.SKIP_STAGE_ENV_VAR_PREFIX+StageValidate) != "" {
+			t.Skipf("%s%s is set, skipping plan assertions", test_structure.SKIP_STAGE_ENV_VAR_PREFIX, StageValidate)
+		}
+
+		plan := PlanModule(t, terraformOptions)
+		AssertMonthlyCostWithinBudget(t, plan)
+		AssertLeastPrivilegePolicies(t, terraformOptions, PlannedPolicyDocuments(plan))
+		if checks.Plan != nil {
+			checks.Plan(t, plan)
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		t.Cleanup(func() { report.Record(t, module, "apply", report.StatusOf(t), "") })
+		if IsPlanOnly() {
+			t.Skipf("%s is set, skipping apply-based assertions", PlanOnlyEnvVar)
+		}
+		if checks.PlanOnly {
+			t.Skip("module is only planned, skipping apply-based assertions")
+		}
+		if !planned {
+			t.Skip("plan assertions failed, skipping apply")
+		}
+
+		SkipWithoutQuotaHeadroom(t, Region(terraformOptions), checks.Quotas)
+		ApplyModule(t, terraformOptions)
+
+		test_structure.RunTestStage(t, StageValidate, func() {
+			AssertLeastPrivilegePolicies(t, terraformOptions, PolicyDocumentsInState(t, terraformOptions))
+			if checks.Apply != nil {
+				checks.Apply(t, terraformOptions)
+			}
+		})
+	})
+}
+
+// PlanModule plans the module into a plan file private to the test, waiting for
+// a free terraform slot first, and returns the parsed plan.
+func PlanModule(t *testing.T, terraformOptions *terraform.Options) *terraform.PlanStruct {
+	t.Helper()
+
+	require.NoError(t, refreshCredentials(terraformOptions))
+	planOptions, err := terraformOptions.Clone()
+	require.NoError(t, err)
+	planOptions.PlanFilePath = filepath.Join(t.TempDir(), "terraform.tfplan")
+
+	var plan *terraform.PlanStruct
+	withTerraformSlot(func() {
+		plan, err = terraform.InitAndPlanAndShowWithStructE(t, planOptions)
+	})
+	require.NoError(t, err)
+	return plan
+}
+
+// ApplyModule applies the module as part of the setup stage, waiting for a free
+// terraform slot first, and registers it to be destroyed in the teardown
+// stage, also when the setup stage is skipped. Tests use it directly for
+// modules that other modules under test depend on. The test fails instead
+// when the test deadline leaves less than DestroyBudget to destroy the module
+// again.
+func ApplyModule(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	RegisterTeardown(terraformOptions)
+	test_structure.RunTestStage(t, StageSetup, func() {
+		armDeadlineTeardown(t, terraformOptions)
+		withTerraformSlot(func() {
+			require.NoError(t, refreshCredentials(terraformOptions))
+			terraform.InitAndApply(t, terraformOptions)
+		})
+	})
+}
+
+// ApplyModuleE applies the module like ApplyModule, but right away instead of
+// in the setup stage, and returns the terraform output and error instead of
+// failing the test, for tests that expect the apply to fail, such as the
+// applies contending for a state lock. It only touches the test through
+// terratest's logging, so it can run on goroutines of its own.
+func ApplyModuleE(t *testing.T, terraformOptions *terraform.Options) (string, error) {
+	t.Helper()
+
+	RegisterTeardown(terraformOptions)
+	if err := armDeadlineTeardownE(t, terraformOptions); err != nil {
+		return "", err
+	}
+	var output string
+	var err error
+	withTerraformSlot(func() {
+		if err = refreshCredentials(terraformOptions); err != nil {
+			return
+		}
+		output, err = terraform.InitAndApplyE(t, terraformOptions)
+	})
+	return output, err
+}
+
+// InitModule runs terraform init on the module, waiting for a free terraform
+// slot first.
+func InitModule(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	withTerraformSlot(func() {
+		require.NoError(t, refreshCredentials(terraformOptions))
+		terraform.Init(t, terraformOptions)
+	})
+}
+
+// DestroyModule destroys the module, waiting for a free terraform slot first,
+// and returns the terraform output.
+func DestroyModule(t *testing.T, terraformOptions *terraform.Options) string {
+	t.Helper()
+
+	var output string
+	withTerraformSlot(func() {
+		require.NoError(t, refreshCredentials(terraformOptions))
+		output = terraform.Destroy(t, terraformOptions)
+	})
+	return output
+}
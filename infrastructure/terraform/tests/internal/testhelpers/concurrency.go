@@ -0,0 +1,42 @@
+package testhelpers
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// MaxParallelEnvVar caps how many terraform plans, applies and destroys run at
+// once across all parallel tests, so that a full `go test ./... -timeout 60m`
+// does not trip AWS API rate limits. go test's own -parallel flag still decides
+// how many tests are in flight; the rest wait here for a slot.
+const MaxParallelEnvVar = "TERRATEST_MAX_PARALLEL"
+
+const defaultMaxParallel = 4
+
+var terraformSlots = make(chan struct{}, maxParallel())
+
+func maxParallel() int {
+	if value, err := strconv.Atoi(os.Getenv(MaxParallelEnvVar)); err == nil && value > 0 {
+		return value
+	}
+	return defaultMaxParallel
+}
+
+// SkipWithoutTerraformSlots skips the test when MaxParallelEnvVar lets fewer
+// than count terraform runs happen at once, as the applies of a test that
+// contend for a state lock have to.
+func SkipWithoutTerraformSlots(t *testing.T, count int) {
+	t.Helper()
+
+	if slots := cap(terraformSlots); slots < count {
+		t.Skipf("%s allows %d terraform runs at once, the test needs %d", MaxParallelEnvVar, slots, count)
+	}
+}
+
+// withTerraformSlot runs fn while holding one of the terraform slots.
+func withTerraformSlot(fn func()) {
+	terraformSlots <- struct{}{}
+	defer func() { <-terraformSlots }()
+	fn()
+}
@@ -0,0 +1,120 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+)
+
+// MaxMonthlyCostEnvVar sets the most a planned module may cost per month, in
+// USD, before RunModuleChecks refuses to apply it. The guardrail is off when it
+// is not set.
+const MaxMonthlyCostEnvVar = "MAX_MONTHLY_COST_USD"
+
+// hoursPerMonth is the month AWS uses for its monthly prices.
+const hoursPerMonth = 730
+
+// monthlyPrices estimates the monthly cost of the expensive resource types the
+// modules use from their planned attributes. Prices are us-east-1 on-demand
+// list prices; the estimate only has to be good enough to catch a test that
+// provisions far more than intended. Types not listed are counted as free.
+var monthlyPrices = map[string]func(attributes map[string]interface{}) float64{
+	"aws_nat_gateway": hourly(0.045),
+	// Public IPv4 addresses are charged whether or not they are attached
+	"aws_eip": hourly(0.005),
+	// Interface endpoints are charged per AZ, i.e. per subnet; gateway
+	// endpoints are free
+	"aws_vpc_endpoint": func(attributes map[string]interface{}) float64 {
+		if attributes["vpc_endpoint_type"] != "Interface" {
+			return 0
+		}
+		subnets, _ := attributes["subnet_ids"].([]interface{})
+		return hourly(0.01)(attributes) * float64(max(len(subnets), 1))
+	},
+	"aws_mq_broker": func(attributes map[string]interface{}) float64 {
+		instanceType, _ := attributes["host_instance_type"].(string)
+		price := mqBrokerMonthlyPrices[instanceType]
+		if attributes["deployment_mode"] == "ACTIVE_STANDBY_MULTI_AZ" {
+			price *= 2
+		}
+		return price
+	},
+}
+
+// mqBrokerMonthlyPrices are the per-instance prices of the Amazon MQ broker
+// instance types, in USD per month.
+var mqBrokerMonthlyPrices = map[string]float64{
+	"mq.t3.micro":  0.027 * hoursPerMonth,
+	"mq.m5.large":  0.288 * hoursPerMonth,
+	"mq.m5.xlarge": 0.576 * hoursPerMonth,
+}
+
+func hourly(price float64) func(map[string]interface{}) float64 {
+	return func(map[string]interface{}) float64 {
+		return price * hoursPerMonth
+	}
+}
+
+// ResourceCost is the estimated monthly cost of a single planned resource.
+type ResourceCost struct {
+	Address    string
+	MonthlyUSD float64
+}
+
+// EstimateMonthlyCost returns the estimated cost of every planned resource
+// that has a price, most expensive first, and their total.
+func EstimateMonthlyCost(plan *terraform.PlanStruct) ([]ResourceCost, float64) {
+	var costs []ResourceCost
+	var total float64
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		price, ok := monthlyPrices[resource.Type]
+		if !ok || resource.Mode != "managed" {
+			continue
+		}
+		cost := price(resource.AttributeValues)
+		costs = append(costs, ResourceCost{Address: address, MonthlyUSD: cost})
+		total += cost
+	}
+
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].MonthlyUSD != costs[j].MonthlyUSD {
+			return costs[i].MonthlyUSD > costs[j].MonthlyUSD
+		}
+		return costs[i].Address < costs[j].Address
+	})
+	return costs, total
+}
+
+// AssertMonthlyCostWithinBudget fails with a per-resource breakdown when the
+// estimated monthly cost of the plan is above MAX_MONTHLY_COST_USD.
+func AssertMonthlyCostWithinBudget(t *testing.T, plan *terraform.PlanStruct) {
+	t.Helper()
+
+	limit := os.Getenv(MaxMonthlyCostEnvVar)
+	if limit == "" {
+		return
+	}
+	maxCost, err := strconv.ParseFloat(limit, 64)
+	require.NoError(t, err, "%s must be a number of USD, got %q", MaxMonthlyCostEnvVar, limit)
+
+	costs, total := EstimateMonthlyCost(plan)
+	if total <= maxCost {
+		report.Record(t, "", "cost", report.Pass, fmt.Sprintf("$%.2f per month", total))
+		return
+	}
+
+	var breakdown strings.Builder
+	for _, cost := range costs {
+		fmt.Fprintf(&breakdown, "  %-50s $%8.2f\n", cost.Address, cost.MonthlyUSD)
+	}
+	report.Record(t, "", "cost", report.Fail, fmt.Sprintf("$%.2f per month is above $%.2f", total, maxCost))
+	t.Errorf("estimated monthly cost $%.2f is above %s=$%.2f:\n%s", total, MaxMonthlyCostEnvVar, maxCost, breakdown.String())
+}
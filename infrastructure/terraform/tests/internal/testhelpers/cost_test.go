@@ -0,0 +1,38 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateMonthlyCost(t *testing.T) {
+	planJSON, err := os.ReadFile(filepath.Join("testdata", "cost_plan.json"))
+	require.NoError(t, err)
+	plan, err := terraform.ParsePlanJSON(string(planJSON))
+	require.NoError(t, err)
+
+	costs, total := EstimateMonthlyCost(plan)
+
+	expected := []ResourceCost{
+		{Address: "aws_mq_broker.mqtt", MonthlyUSD: 2 * 0.288 * 730},
+		{Address: "aws_nat_gateway.main[0]", MonthlyUSD: 0.045 * 730},
+		{Address: "aws_nat_gateway.main[1]", MonthlyUSD: 0.045 * 730},
+		{Address: "aws_vpc_endpoint.iot_data", MonthlyUSD: 2 * 0.01 * 730},
+		{Address: "aws_eip.nat[0]", MonthlyUSD: 0.005 * 730},
+		{Address: "aws_eip.nat[1]", MonthlyUSD: 0.005 * 730},
+		{Address: "aws_vpc_endpoint.s3", MonthlyUSD: 0},
+	}
+	require.Len(t, costs, len(expected))
+	var expectedTotal float64
+	for i, cost := range expected {
+		assert.Equal(t, cost.Address, costs[i].Address)
+		assert.InDelta(t, cost.MonthlyUSD, costs[i].MonthlyUSD, 0.001, cost.Address)
+		expectedTotal += cost.MonthlyUSD
+	}
+	assert.InDelta(t, expectedTotal, total, 0.001)
+}
@@ -0,0 +1,177 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// AssumeRoleArnEnvVar is the role modules are applied under and the
+	// assertions run as, e.g. a deployment role in the production networking
+	// account. The CI credentials are used directly when it is unset.
+	AssumeRoleArnEnvVar = "TEST_ASSUME_ROLE_ARN"
+
+	// AssumeRoleExternalIdEnvVar is the external ID the trust policy of the
+	// assumed roles requires.
+	AssumeRoleExternalIdEnvVar = "TEST_ASSUME_ROLE_EXTERNAL_ID"
+
+	// The longest a role assumed by another role may be assumed for
+	assumeRoleDuration = time.Hour
+
+	// Credentials expiring within the window are refreshed before the next
+	// terraform command or AWS request. An EKS cluster, the longest apply,
+	// takes about fifteen minutes.
+	credentialsExpiryWindow = 20 * time.Minute
+
+	roleSessionName = "terratest"
+)
+
+// The environment variables the AWS provider reads static credentials from.
+const (
+	accessKeyIdEnvVar     = "AWS_ACCESS_KEY_ID"
+	secretAccessKeyEnvVar = "AWS_SECRET_ACCESS_KEY"
+	sessionTokenEnvVar    = "AWS_SESSION_TOKEN"
+)
+
+var (
+	// envVarsMu guards the EnvVars of options against refreshCredentials
+	// writing them while a destroy started ahead of the deadline copies them
+	envVarsMu sync.Mutex
+
+	roleCredentialsMu sync.Mutex
+	// roleCredentials holds the credentials of every role assumed so far by
+	// ARN, shared by all tests so a role is assumed once per expiry rather
+	// than once per client.
+	roleCredentials = map[string]*credentials.Credentials{}
+)
+
+// credentialsOfRole returns the credentials of the role. The SDK assumes the
+// role on first use, and again whenever the credentials are about to expire.
+func credentialsOfRole(roleArn string) (*credentials.Credentials, error) {
+	roleCredentialsMu.Lock()
+	defer roleCredentialsMu.Unlock()
+
+	if existing, ok := roleCredentials[roleArn]; ok {
+		return existing, nil
+	}
+
+	// STS is called with the CI credentials, in the region of the tests
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsSDK.NewConfig().WithRegion(AwsRegion()),
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	assumed := stscreds.NewCredentials(sess, roleArn, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = roleSessionName
+		provider.Duration = assumeRoleDuration
+		provider.ExpiryWindow = credentialsExpiryWindow
+		if externalId := os.Getenv(AssumeRoleExternalIdEnvVar); externalId != "" {
+			provider.ExternalID = awsSDK.String(externalId)
+		}
+	})
+	roleCredentials[roleArn] = assumed
+	return assumed, nil
+}
+
+// UseRole makes the module of the options apply under the role instead of the
+// one in TEST_ASSUME_ROLE_ARN, and SessionFor return sessions of the role, for
+// tests that span accounts.
+func UseRole(t *testing.T, terraformOptions *terraform.Options, roleArn string) {
+	t.Helper()
+
+	envVarsMu.Lock()
+	terraformOptions.EnvVars[AssumeRoleArnEnvVar] = roleArn
+	envVarsMu.Unlock()
+	require.NoError(t, refreshCredentials(terraformOptions))
+}
+
+// refreshCredentials sets the environment of terraform to the current
+// credentials of the role the module is applied under, assuming the role again
+// if they are about to expire. Every terraform command run through the helpers
+// is preceded by it. Options without a role are left alone.
+//
+// The credentials only ever live in memory: the options were persisted before
+// they were added, and neither terratest nor the helpers log the environment.
+func refreshCredentials(terraformOptions *terraform.Options) error {
+	roleArn := terraformOptions.EnvVars[AssumeRoleArnEnvVar]
+	if roleArn == "" {
+		return nil
+	}
+
+	assumed, err := credentialsOfRole(roleArn)
+	if err != nil {
+		return fmt.Errorf("assuming role %s: %w", roleArn, err)
+	}
+	value, err := assumed.Get()
+	if err != nil {
+		return fmt.Errorf("assuming role %s: %w", roleArn, err)
+	}
+
+	envVarsMu.Lock()
+	defer envVarsMu.Unlock()
+	terraformOptions.EnvVars[accessKeyIdEnvVar] = value.AccessKeyID
+	terraformOptions.EnvVars[secretAccessKeyEnvVar] = value.SecretAccessKey
+	terraformOptions.EnvVars[sessionTokenEnvVar] = value.SessionToken
+	return nil
+}
+
+// NewSession creates an AWS session for the given region with the credentials
+// the tests run under: those of the role in TEST_ASSUME_ROLE_ARN, or the CI
+// credentials when it is unset. Every client the helpers create comes from one,
+// and so should those the tests create.
+func NewSession(t *testing.T, region string) *session.Session {
+	t.Helper()
+	return NewSessionOfRole(t, region, os.Getenv(AssumeRoleArnEnvVar))
+}
+
+// SessionFor creates an AWS session for the region of the module of the
+// options, with the credentials the module is applied under.
+func SessionFor(t *testing.T, terraformOptions *terraform.Options) *session.Session {
+	t.Helper()
+	return NewSessionOfRole(t, Region(terraformOptions), terraformOptions.EnvVars[AssumeRoleArnEnvVar])
+}
+
+// NewSessionOfRole creates an AWS session for the given region with the
+// credentials of the role, refreshed before they expire, or the CI credentials
+// when roleArn is empty.
+func NewSessionOfRole(t *testing.T, region string, roleArn string) *session.Session {
+	t.Helper()
+
+	if roleArn == "" {
+		sess, err := aws.NewAuthenticatedSession(region)
+		require.NoError(t, err)
+		return sess
+	}
+
+	assumed, err := credentialsOfRole(roleArn)
+	require.NoError(t, err, "assuming role %s", roleArn)
+	sess, err := session.NewSession(awsSDK.NewConfig().WithRegion(region).WithCredentials(assumed))
+	require.NoError(t, err)
+	_, err = sess.Config.Credentials.Get()
+	require.NoError(t, err, "assuming role %s", roleArn)
+	return sess
+}
+
+// AccountId returns the ID of the account the credentials of the session
+// belong to.
+func AccountId(t *testing.T, sess *session.Session) string {
+	t.Helper()
+
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	require.NoError(t, err)
+	return awsSDK.StringValue(identity.Account)
+}
@@ -0,0 +1,40 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshCredentials(t *testing.T) {
+	t.Run("NoRole", func(t *testing.T) {
+		terraformOptions := &terraform.Options{EnvVars: map[string]string{regionEnvVar: "eu-west-1"}}
+		require.NoError(t, refreshCredentials(terraformOptions))
+		assert.Equal(t, map[string]string{regionEnvVar: "eu-west-1"}, terraformOptions.EnvVars)
+	})
+
+	t.Run("Role", func(t *testing.T) {
+		roleArn := "arn:aws:iam::123456789012:role/" + t.Name()
+		roleCredentialsMu.Lock()
+		roleCredentials[roleArn] = credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", "token")
+		roleCredentialsMu.Unlock()
+		t.Cleanup(func() {
+			roleCredentialsMu.Lock()
+			delete(roleCredentials, roleArn)
+			roleCredentialsMu.Unlock()
+		})
+
+		terraformOptions := &terraform.Options{EnvVars: map[string]string{regionEnvVar: "eu-west-1"}}
+		UseRole(t, terraformOptions, roleArn)
+		assert.Equal(t, map[string]string{
+			regionEnvVar:          "eu-west-1",
+			AssumeRoleArnEnvVar:   roleArn,
+			accessKeyIdEnvVar:     "AKIAEXAMPLE",
+			secretAccessKeyEnvVar: "secret",
+			sessionTokenEnvVar:    "token",
+		}, terraformOptions.EnvVars)
+	})
+}
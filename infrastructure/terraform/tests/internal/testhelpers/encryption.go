@@ -0,0 +1,315 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/nameprefix"
+)
+
+// placeholderKeyArn stands in for the ARN of a test's key in plan-only mode.
+const placeholderKeyArn = "arn:aws:kms:%s:000000000000:key/00000000-0000-0000-0000-000000000000"
+
+// keyDeletionWindowDays is how long the keys of the tests stay pending
+// deletion, the shortest window KMS allows.
+const keyDeletionWindowDays = 7
+
+// KeyPolicyStatement is a statement of a KMS key policy as it appears in the
+// policy JSON.
+type KeyPolicyStatement map[string]interface{}
+
+// Kinds of resources AssertEncryptedAtRest can check.
+const (
+	kindS3Bucket      = "s3 bucket"
+	kindDynamoDBTable = "dynamodb table"
+	kindKinesisStream = "kinesis stream"
+	kindEbsVolume     = "ebs volume"
+)
+
+// encryptedResource is a resource output by a module: its kind and the name or
+// ID its API takes.
+type encryptedResource struct {
+	kind string
+	name string
+}
+
+// encryptionCheckers return the KMS key a resource of their kind is encrypted
+// with, or an error when it is not encrypted with a KMS key at all.
+var encryptionCheckers = map[string]func(t *testing.T, region string, name string) (string, error){
+	kindS3Bucket:      s3BucketEncryptionKey,
+	kindDynamoDBTable: dynamoDBTableEncryptionKey,
+	kindKinesisStream: kinesisStreamEncryptionKey,
+	kindEbsVolume:     ebsVolumeEncryptionKey,
+}
+
+// AssertEncryptedAtRest checks that every resource, given as the ARNs and IDs
+// the module outputs, is encrypted with the KMS key the alias points to, e.g.
+// alias/iot-network-data. S3 buckets, DynamoDB tables, Kinesis streams and EBS
+// volumes are supported; anything else fails rather than passing unchecked.
+// Every resource is checked and all failures are reported.
+func AssertEncryptedAtRest(t *testing.T, region string, resources []string, keyAlias string) {
+	t.Helper()
+
+	violations := encryptionViolations(t, region, resources, keyAlias)
+	recordViolations(t, "", "encryption", violations)
+	for _, violation := range violations {
+		t.Error(violation)
+	}
+}
+
+func encryptionViolations(t *testing.T, region string, resources []string, keyAlias string) []string {
+	kmsClient := kms.New(NewSession(t, region))
+	expectedKey, err := kmsKeyArn(kmsClient, keyAlias)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot look up KMS key %s: %v", keyAlias, err)}
+	}
+
+	var violations []string
+	for _, resource := range resources {
+		parsed, resourceType, ok := parseEncryptedResource(resource)
+		if !ok {
+			violations = append(violations, fmt.Sprintf("%s: no encryption checker for type %s", resource, resourceType))
+			continue
+		}
+
+		keyId, err := encryptionCheckers[parsed.kind](t, region, parsed.name)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s %s is not encrypted at rest with KMS: %v", parsed.kind, parsed.name, err))
+			continue
+		}
+		key, err := kmsKeyArn(kmsClient, keyId)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s %s is encrypted with KMS key %s, which cannot be looked up: %v", parsed.kind, parsed.name, keyId, err))
+			continue
+		}
+		if key != expectedKey {
+			violations = append(violations, fmt.Sprintf("%s %s is encrypted with KMS key %s instead of %s (%s)", parsed.kind, parsed.name, key, keyAlias, expectedKey))
+		}
+	}
+	return violations
+}
+
+// parseEncryptedResource works out what kind of resource an ARN or ID output by
+// a module is. When there is no checker for it, the second result names its
+// type for the failure message.
+func parseEncryptedResource(resource string) (encryptedResource, string, bool) {
+	if !arn.IsARN(resource) {
+		if strings.HasPrefix(resource, "vol-") {
+			return encryptedResource{kind: kindEbsVolume, name: resource}, "", true
+		}
+		if prefix, _, found := strings.Cut(resource, "-"); found {
+			return encryptedResource{}, fmt.Sprintf("%q (ID prefix)", prefix), false
+		}
+		return encryptedResource{}, "unknown (neither an ARN nor an EC2 ID)", false
+	}
+
+	parsed, err := arn.Parse(resource)
+	if err != nil {
+		return encryptedResource{}, "unknown (malformed ARN)", false
+	}
+	resourceType, name, _ := strings.Cut(parsed.Resource, "/")
+	switch {
+	case parsed.Service == "s3" && !strings.ContainsAny(parsed.Resource, "/:"):
+		return encryptedResource{kind: kindS3Bucket, name: parsed.Resource}, "", true
+	case parsed.Service == "dynamodb" && resourceType == "table" && !strings.Contains(name, "/"):
+		return encryptedResource{kind: kindDynamoDBTable, name: name}, "", true
+	case parsed.Service == "kinesis" && resourceType == "stream" && !strings.Contains(name, "/"):
+		return encryptedResource{kind: kindKinesisStream, name: name}, "", true
+	case parsed.Service == "ec2" && resourceType == "volume":
+		return encryptedResource{kind: kindEbsVolume, name: name}, "", true
+	}
+	if parsed.Service == "s3" {
+		return encryptedResource{}, "s3:object", false
+	}
+	resourceType, _, _ = strings.Cut(resourceType, ":")
+	return encryptedResource{}, parsed.Service + ":" + resourceType, false
+}
+
+// kmsKeyArn resolves a key ID, key ARN, alias name or alias ARN to the ARN of
+// the key.
+func kmsKeyArn(kmsClient *kms.KMS, keyId string) (string, error) {
+	output, err := kmsClient.DescribeKey(&kms.DescribeKeyInput{KeyId: awsSDK.String(keyId)})
+	if err != nil {
+		return "", err
+	}
+	return awsSDK.StringValue(output.KeyMetadata.Arn), nil
+}
+
+func s3BucketEncryptionKey(t *testing.T, region string, bucket string) (string, error) {
+	output, err := s3.New(NewSession(t, region)).GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: awsSDK.String(bucket)})
+	if err != nil {
+		return "", err
+	}
+	for _, rule := range output.ServerSideEncryptionConfiguration.Rules {
+		byDefault := rule.ApplyServerSideEncryptionByDefault
+		if byDefault == nil || !strings.HasPrefix(awsSDK.StringValue(byDefault.SSEAlgorithm), s3.ServerSideEncryptionAwsKms) {
+			continue
+		}
+		if awsSDK.StringValue(byDefault.KMSMasterKeyID) == "" {
+			return "", fmt.Errorf("default encryption uses the AWS managed key aws/s3")
+		}
+		return awsSDK.StringValue(byDefault.KMSMasterKeyID), nil
+	}
+	return "", fmt.Errorf("default encryption is not aws:kms")
+}
+
+func dynamoDBTableEncryptionKey(t *testing.T, region string, table string) (string, error) {
+	output, err := dynamodb.New(NewSession(t, region)).DescribeTable(&dynamodb.DescribeTableInput{TableName: awsSDK.String(table)})
+	if err != nil {
+		return "", err
+	}
+	// Tables encrypted with the AWS owned key have no SSE description
+	sse := output.Table.SSEDescription
+	if sse == nil || awsSDK.StringValue(sse.SSEType) != dynamodb.SSETypeKms {
+		return "", fmt.Errorf("table is encrypted with the AWS owned key")
+	}
+	if status := awsSDK.StringValue(sse.Status); status != dynamodb.SSEStatusEnabled {
+		return "", fmt.Errorf("encryption is %s", status)
+	}
+	return awsSDK.StringValue(sse.KMSMasterKeyArn), nil
+}
+
+func kinesisStreamEncryptionKey(t *testing.T, region string, stream string) (string, error) {
+	output, err := NewKinesisClient(t, region).DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{StreamName: awsSDK.String(stream)})
+	if err != nil {
+		return "", err
+	}
+	if encryption := awsSDK.StringValue(output.StreamDescriptionSummary.EncryptionType); encryption != kinesis.EncryptionTypeKms {
+		return "", fmt.Errorf("encryption type is %s", encryption)
+	}
+	return awsSDK.StringValue(output.StreamDescriptionSummary.KeyId), nil
+}
+
+func ebsVolumeEncryptionKey(t *testing.T, region string, volumeId string) (string, error) {
+	output, err := ec2.New(NewSession(t, region)).DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: []*string{awsSDK.String(volumeId)}})
+	if err != nil {
+		return "", err
+	}
+	if len(output.Volumes) == 0 {
+		return "", fmt.Errorf("volume not found")
+	}
+	if !awsSDK.BoolValue(output.Volumes[0].Encrypted) {
+		return "", fmt.Errorf("volume is not encrypted")
+	}
+	return awsSDK.StringValue(output.Volumes[0].KmsKeyId), nil
+}
+
+// CreateDataKey creates the KMS key a test encrypts the data of its modules
+// with, standing in for the shared data key of a real environment, and returns
+// its ARN and alias. The key has the default key policy, which leaves access
+// to IAM policies of the account, plus the statements grants returns for the
+// account, for AWS services that are only allowed to use a key through its
+// policy; grants may be nil. In plan-only mode nothing is created and a
+// placeholder ARN is returned.
+//
+// Like the modules, the key is created in the setup stage, saved for the later
+// stages of a staged run, which is why a test gets only one, and scheduled for
+// deletion in the teardown stage, once the test and the destroy of its modules
+// are done.
+func CreateDataKey(t *testing.T, region string, grants func(accountId string) []KeyPolicyStatement) (string, string) {
+	t.Helper()
+
+	if IsPlanOnly() {
+		return fmt.Sprintf(placeholderKeyArn, region), "alias/" + nameprefix.New(time.Now()) + "-iot-network-data"
+	}
+
+	kmsClient := kms.New(NewSession(t, region))
+	key := &dataKey{}
+	// Registered ahead of creating the key, so a setup failing halfway does
+	// not leave it behind
+	if teardownEnabled() {
+		t.Cleanup(func() {
+			key.delete(t, kmsClient)
+		})
+	}
+
+	dir := t.TempDir()
+	if test_structure.SkipStageEnvVarSet() {
+		dir = stagedTestDir(t)
+	}
+	test_structure.RunTestStage(t, StageSetup, func() {
+		key.create(t, kmsClient, region, grants)
+		test_structure.SaveString(t, dir, dataKeyArnKey, key.arn)
+		test_structure.SaveString(t, dir, dataKeyAliasKey, key.alias)
+	})
+	key.arn = test_structure.LoadString(t, dir, dataKeyArnKey)
+	key.alias = test_structure.LoadString(t, dir, dataKeyAliasKey)
+	return key.arn, key.alias
+}
+
+// The names the ARN and alias of a test's data key are saved under.
+const (
+	dataKeyArnKey   = "data_key_arn"
+	dataKeyAliasKey = "data_key_alias"
+)
+
+// dataKey is the data key of a test, as far as it has been created.
+type dataKey struct {
+	arn   string
+	alias string
+}
+
+func (k *dataKey) create(t *testing.T, kmsClient *kms.KMS, region string, grants func(accountId string) []KeyPolicyStatement) {
+	alias := "alias/" + nameprefix.New(time.Now()) + "-iot-network-data"
+	input := &kms.CreateKeyInput{
+		Description: awsSDK.String("Data key of " + t.Name()),
+		Tags:        []*kms.Tag{{TagKey: awsSDK.String("Name"), TagValue: awsSDK.String(strings.TrimPrefix(alias, "alias/"))}},
+	}
+	if grants != nil {
+		accountId := AccountId(t, NewSession(t, region))
+		policy, err := dataKeyPolicy(accountId, grants(accountId))
+		require.NoError(t, err)
+		input.Policy = awsSDK.String(policy)
+	}
+
+	key, err := kmsClient.CreateKey(input)
+	require.NoError(t, err)
+	k.arn = awsSDK.StringValue(key.KeyMetadata.Arn)
+
+	_, err = kmsClient.CreateAlias(&kms.CreateAliasInput{AliasName: awsSDK.String(alias), TargetKeyId: awsSDK.String(k.arn)})
+	require.NoError(t, err)
+	k.alias = alias
+}
+
+// delete deletes the alias of the key and schedules the key for deletion.
+func (k *dataKey) delete(t *testing.T, kmsClient *kms.KMS) {
+	if k.alias != "" {
+		if _, err := kmsClient.DeleteAlias(&kms.DeleteAliasInput{AliasName: awsSDK.String(k.alias)}); err != nil {
+			t.Logf("Failed to delete KMS alias %s: %v", k.alias, err)
+		}
+	}
+	if k.arn != "" {
+		if _, err := kmsClient.ScheduleKeyDeletion(&kms.ScheduleKeyDeletionInput{KeyId: awsSDK.String(k.arn), PendingWindowInDays: awsSDK.Int64(keyDeletionWindowDays)}); err != nil {
+			t.Logf("Failed to schedule deletion of KMS key %s: %v", k.arn, err)
+		}
+	}
+}
+
+// dataKeyPolicy returns the default key policy of the account with the
+// statements added to it.
+func dataKeyPolicy(accountId string, statements []KeyPolicyStatement) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": append([]KeyPolicyStatement{{
+			"Sid":       "Account",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"AWS": "arn:aws:iam::" + accountId + ":root"},
+			"Action":    "kms:*",
+			"Resource":  "*",
+		}}, statements...),
+	})
+	return string(policy), err
+}
@@ -0,0 +1,57 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEncryptedResource(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		resource     string
+		expected     encryptedResource
+		resourceType string
+	}{
+		{resource: "arn:aws:s3:::tt-abc-iot-network-tfstate-123456789012", expected: encryptedResource{kind: kindS3Bucket, name: "tt-abc-iot-network-tfstate-123456789012"}},
+		{resource: "arn:aws:dynamodb:us-west-2:123456789012:table/tt-abc-iot-network-telemetry", expected: encryptedResource{kind: kindDynamoDBTable, name: "tt-abc-iot-network-telemetry"}},
+		{resource: "arn:aws:kinesis:us-west-2:123456789012:stream/telemetry", expected: encryptedResource{kind: kindKinesisStream, name: "telemetry"}},
+		{resource: "arn:aws:ec2:us-west-2:123456789012:volume/vol-0123456789abcdef0", expected: encryptedResource{kind: kindEbsVolume, name: "vol-0123456789abcdef0"}},
+		{resource: "vol-0123456789abcdef0", expected: encryptedResource{kind: kindEbsVolume, name: "vol-0123456789abcdef0"}},
+		{resource: "arn:aws:s3:::bucket/state/terraform.tfstate", resourceType: "s3:object"},
+		{resource: "arn:aws:dynamodb:us-west-2:123456789012:table/telemetry/stream/2024-01-01T00:00:00.000", resourceType: "dynamodb:table"},
+		{resource: "arn:aws:sqs:us-west-2:123456789012:telemetry-dlq", resourceType: "sqs:telemetry-dlq"},
+		{resource: "arn:aws:rds:us-west-2:123456789012:db:ledger", resourceType: "rds:db"},
+		{resource: "i-0123456789abcdef0", resourceType: `"i" (ID prefix)`},
+		{resource: "telemetry", resourceType: "unknown (neither an ARN nor an EC2 ID)"},
+	}
+
+	for _, tc := range testCases {
+		parsed, resourceType, ok := parseEncryptedResource(tc.resource)
+		assert.Equal(t, tc.resourceType == "", ok, tc.resource)
+		assert.Equal(t, tc.expected, parsed, tc.resource)
+		assert.Equal(t, tc.resourceType, resourceType, tc.resource)
+	}
+}
+
+func TestDataKeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := dataKeyPolicy("123456789012", []KeyPolicyStatement{{
+		"Sid":       "CloudWatchLogs",
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": "logs.us-west-2.amazonaws.com"},
+		"Action":    "kms:Decrypt*",
+		"Resource":  "*",
+	}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "Account", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:*", "Resource": "*"},
+			{"Sid": "CloudWatchLogs", "Effect": "Allow", "Principal": {"Service": "logs.us-west-2.amazonaws.com"}, "Action": "kms:Decrypt*", "Resource": "*"}
+		]
+	}`, policy)
+}
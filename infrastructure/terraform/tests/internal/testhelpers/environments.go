@@ -0,0 +1,81 @@
+package testhelpers
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// environmentsDir holds a tfvars file per environment, relative to the
+	// tests package.
+	environmentsDir = "../environments"
+
+	// defaultApplyEnvironment is the only environment applied unless
+	// TEST_APPLY_ENVIRONMENTS says otherwise.
+	defaultApplyEnvironment = "dev"
+)
+
+// ApplyEnvironmentsEnvVar lists the environments, comma separated, whose
+// tfvars are applied and not only planned, e.g.
+// TEST_APPLY_ENVIRONMENTS=dev,staging. Only dev is applied when it is unset.
+const ApplyEnvironmentsEnvVar = "TEST_APPLY_ENVIRONMENTS"
+
+// Environment is a deployment environment and the tfvars it is deployed with.
+type Environment struct {
+	Name    string
+	VarFile string
+	Vars    map[string]interface{}
+}
+
+// Environments returns the environments in environments/*.tfvars, by name.
+// The test fails if there are none or a file cannot be parsed, since a
+// skipped environment is an untested one.
+func Environments(t *testing.T) []Environment {
+	t.Helper()
+
+	environments, err := loadEnvironments(t, environmentsDir)
+	require.NoError(t, err)
+	return environments
+}
+
+// ShouldApplyEnvironment reports whether the environment is applied, see
+// ApplyEnvironmentsEnvVar.
+func ShouldApplyEnvironment(name string) bool {
+	environments := envList(ApplyEnvironmentsEnvVar)
+	if len(environments) == 0 {
+		return name == defaultApplyEnvironment
+	}
+	return slices.Contains(environments, name)
+}
+
+func loadEnvironments(t *testing.T, dir string) ([]Environment, error) {
+	varFiles, err := filepath.Glob(filepath.Join(dir, "*.tfvars"))
+	if err != nil {
+		return nil, err
+	}
+	if len(varFiles) == 0 {
+		return nil, fmt.Errorf("no tfvars files in %s", dir)
+	}
+	sort.Strings(varFiles)
+
+	environments := make([]Environment, 0, len(varFiles))
+	for _, varFile := range varFiles {
+		vars := map[string]interface{}{}
+		if err := terraform.GetAllVariablesFromVarFileE(t, varFile, &vars); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", varFile, err)
+		}
+		environments = append(environments, Environment{
+			Name:    strings.TrimSuffix(filepath.Base(varFile), ".tfvars"),
+			VarFile: varFile,
+			Vars:    vars,
+		})
+	}
+	return environments, nil
+}
@@ -0,0 +1,57 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prod.tfvars"), []byte("environment = \"prod\"\naz_count = 3\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dev.tfvars"), []byte("environment = \"dev\"\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a tfvars file"), 0o644))
+
+	environments, err := loadEnvironments(t, dir)
+	require.NoError(t, err)
+	require.Len(t, environments, 2)
+	assert.Equal(t, "dev", environments[0].Name)
+	assert.Equal(t, filepath.Join(dir, "dev.tfvars"), environments[0].VarFile)
+	assert.Equal(t, "prod", environments[1].Name)
+	assert.Equal(t, "prod", environments[1].Vars["environment"])
+	assert.EqualValues(t, 3, environments[1].Vars["az_count"])
+}
+
+func TestLoadEnvironmentsFailsOnBadFiles(t *testing.T) {
+	_, err := loadEnvironments(t, t.TempDir())
+	assert.ErrorContains(t, err, "no tfvars files")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dev.tfvars"), []byte("environment = \"dev\"\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "staging.tfvars"), []byte("environment = \n"), 0o644))
+	_, err = loadEnvironments(t, dir)
+	assert.ErrorContains(t, err, "staging.tfvars")
+}
+
+func TestRepoEnvironmentsParse(t *testing.T) {
+	// environmentsDir is relative to the tests package, two levels up
+	environments, err := loadEnvironments(t, filepath.Join("..", "..", environmentsDir))
+	require.NoError(t, err)
+	for _, environment := range environments {
+		assert.Equal(t, environment.Name, environment.Vars["environment"], "%s sets a different environment", environment.VarFile)
+	}
+}
+
+func TestShouldApplyEnvironment(t *testing.T) {
+	t.Setenv(ApplyEnvironmentsEnvVar, "")
+	assert.True(t, ShouldApplyEnvironment("dev"))
+	assert.False(t, ShouldApplyEnvironment("prod"))
+
+	t.Setenv(ApplyEnvironmentsEnvVar, "staging, prod")
+	assert.False(t, ShouldApplyEnvironment("dev"))
+	assert.True(t, ShouldApplyEnvironment("staging"))
+	assert.True(t, ShouldApplyEnvironment("prod"))
+}
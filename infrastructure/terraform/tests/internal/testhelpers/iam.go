@@ -0,0 +1,187 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// iamPolicyResourceTypes are the resource types whose policy documents are
+// linted, mapped to the attribute holding the document.
+var iamPolicyResourceTypes = map[string]string{
+	"aws_iam_policy":      "policy",
+	"aws_iam_role_policy": "policy",
+}
+
+// iamAllowList exempts policy statements from the least-privilege lint. Keys
+// are <module>/<resource address>, e.g. vpc/aws_iam_role_policy.flow_logs,
+// mapped to the Sids of the exempt statements and why each one needs its
+// wildcard. A statement has to have a Sid to be allow-listed.
+var iamAllowList = map[string]map[string]string{}
+
+// readOnlyActionPrefixes start the names of the actions that do not change
+// anything. Any other action, including a wildcard such as s3:* or s3:Put*,
+// counts as a write.
+var readOnlyActionPrefixes = []string{"Get", "List", "Describe", "Read", "View", "BatchGet", "Search", "Query", "Scan", "Lookup"}
+
+// StringOrList normalizes an IAM policy element, which may be encoded as a
+// single string or a list of strings.
+func StringOrList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// PlannedPolicyDocuments returns the IAM policy documents in the plan, keyed by
+// resource address. A document built from attributes of resources that do not
+// exist yet is only known after apply and is left out; PolicyDocumentsInState
+// picks it up once the module is applied.
+func PlannedPolicyDocuments(plan *terraform.PlanStruct) map[string]string {
+	documents := map[string]string{}
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		attribute, ok := iamPolicyResourceTypes[resource.Type]
+		if !ok {
+			continue
+		}
+		if document, ok := resource.AttributeValues[attribute].(string); ok {
+			documents[address] = document
+		}
+	}
+	return documents
+}
+
+// PolicyDocumentsInState returns the IAM policy documents in the state of the
+// module, keyed by resource address.
+func PolicyDocumentsInState(t *testing.T, terraformOptions *terraform.Options) map[string]string {
+	t.Helper()
+
+	documents := map[string]string{}
+	for address, resource := range StateResources(t, terraformOptions) {
+		attribute, ok := iamPolicyResourceTypes[resource.Type]
+		if !ok {
+			continue
+		}
+		if document, ok := resource.AttributeValues[attribute].(string); ok {
+			documents[address] = document
+		}
+	}
+	return documents
+}
+
+// AssertLeastPrivilegePolicies fails with every violation at once when an
+// Allow statement of the module's policy documents grants all actions, grants
+// write actions on all resources or uses NotAction, unless the statement is on
+// the allow-list. RunModuleChecks runs it against the plan and again against
+// the state after apply.
+func AssertLeastPrivilegePolicies(t *testing.T, terraformOptions *terraform.Options, documents map[string]string) {
+	t.Helper()
+
+	module := ModuleName(terraformOptions)
+	var violations []string
+	for address, document := range documents {
+		violations = append(violations, lintPolicyDocument(address, document, iamAllowList[module+"/"+address])...)
+	}
+	sort.Strings(violations)
+	recordViolations(t, module, "iam", violations)
+	if len(violations) == 0 {
+		return
+	}
+	t.Errorf("IAM policies of module %s are not least-privilege (allow-list statements in iamAllowList if they must be):\n  %s",
+		module, strings.Join(violations, "\n  "))
+}
+
+// lintPolicyDocument returns a line per violation in the policy document.
+// Statements whose Sid is a key of allowed are skipped.
+func lintPolicyDocument(address string, document string, allowed map[string]string) []string {
+	var policy struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return []string{fmt.Sprintf("%s: policy is not valid JSON: %v", address, err)}
+	}
+
+	// A policy with a single statement may have it as an object rather than
+	// a list
+	type statement struct {
+		Sid       string
+		Effect    string
+		Action    interface{}
+		NotAction interface{}
+		Resource  interface{}
+	}
+	var statements []statement
+	if err := json.Unmarshal(policy.Statement, &statements); err != nil {
+		var single statement
+		if err := json.Unmarshal(policy.Statement, &single); err != nil {
+			return []string{fmt.Sprintf("%s: Statement is neither a statement nor a list of them", address)}
+		}
+		statements = []statement{single}
+	}
+
+	var violations []string
+	for i, statement := range statements {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		if _, ok := allowed[statement.Sid]; ok && statement.Sid != "" {
+			continue
+		}
+		name := fmt.Sprintf("%s statement %d", address, i)
+		if statement.Sid != "" {
+			name = fmt.Sprintf("%s statement %s", address, statement.Sid)
+		}
+
+		if statement.NotAction != nil {
+			violations = append(violations, name+": uses NotAction")
+		}
+		actions := StringOrList(statement.Action)
+		for _, action := range actions {
+			if action == "*" || action == "*:*" {
+				violations = append(violations, name+`: allows all actions with "Action": "*"`)
+			}
+		}
+		if !slices.Contains(StringOrList(statement.Resource), "*") {
+			continue
+		}
+		var writes []string
+		for _, action := range actions {
+			if action != "*" && action != "*:*" && !isReadOnlyAction(action) {
+				writes = append(writes, action)
+			}
+		}
+		if len(writes) > 0 {
+			violations = append(violations, fmt.Sprintf(`%s: allows write actions %s on "Resource": "*"`, name, strings.Join(writes, ", ")))
+		}
+	}
+	return violations
+}
+
+// isReadOnlyAction reports whether the action, e.g. logs:DescribeLogStreams,
+// only reads. Action names are case-insensitive.
+func isReadOnlyAction(action string) bool {
+	_, name, found := strings.Cut(action, ":")
+	if !found {
+		return false
+	}
+	for _, prefix := range readOnlyActionPrefixes {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
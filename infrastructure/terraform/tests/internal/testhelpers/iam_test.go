@@ -0,0 +1,137 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlannedPolicyDocuments(t *testing.T) {
+	planJSON, err := os.ReadFile(filepath.Join("testdata", "iam_plan.json"))
+	require.NoError(t, err)
+	plan, err := terraform.ParsePlanJSON(string(planJSON))
+	require.NoError(t, err)
+
+	documents := PlannedPolicyDocuments(plan)
+
+	// aws_iam_role_policy.rule is only known after apply and the role's
+	// trust policy is not a permissions policy
+	assert.ElementsMatch(t, []string{"aws_iam_role_policy.logs", "module.core.aws_iam_policy.admin"}, policyAddresses(documents))
+}
+
+func TestLintPolicyDocument(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		document string
+		allowed  map[string]string
+		expected []string
+	}{
+		{
+			name:     "ScopedLists",
+			document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["dynamodb:PutItem","dynamodb:GetItem"],"Resource":["arn:aws:dynamodb:us-west-2:123456789012:table/telemetry"]}]}`,
+		},
+		{
+			name:     "ScopedStrings",
+			document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"dynamodb:PutItem","Resource":"arn:aws:dynamodb:us-west-2:123456789012:table/telemetry"}]}`,
+		},
+		{
+			name:     "ReadOnlyOnAllResources",
+			document: `{"Statement":[{"Effect":"Allow","Action":["ec2:DescribeInstances","logs:get*","s3:ListAllMyBuckets"],"Resource":"*"}]}`,
+		},
+		{
+			name:     "AllActionsString",
+			document: `{"Statement":[{"Effect":"Allow","Action":"*","Resource":"arn:aws:s3:::bucket"}]}`,
+			expected: []string{`policy statement 0: allows all actions with "Action": "*"`},
+		},
+		{
+			name:     "AllActionsInList",
+			document: `{"Statement":[{"Sid":"Everything","Effect":"Allow","Action":["s3:GetObject","*"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+			expected: []string{`policy statement Everything: allows all actions with "Action": "*"`},
+		},
+		{
+			name:     "AllActionsOnAllResources",
+			document: `{"Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`,
+			expected: []string{`policy statement 0: allows all actions with "Action": "*"`},
+		},
+		{
+			name:     "WriteOnAllResourcesString",
+			document: `{"Statement":{"Effect":"Allow","Action":"logs:PutLogEvents","Resource":"*"}}`,
+			expected: []string{`policy statement 0: allows write actions logs:PutLogEvents on "Resource": "*"`},
+		},
+		{
+			name:     "WriteOnAllResourcesList",
+			document: `{"Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:Put*","dynamodb:*"],"Resource":["arn:aws:s3:::bucket","*"]}]}`,
+			expected: []string{`policy statement 0: allows write actions s3:Put*, dynamodb:* on "Resource": "*"`},
+		},
+		{
+			name:     "NotAction",
+			document: `{"Statement":[{"Effect":"Allow","NotAction":["iam:*"],"Resource":"arn:aws:s3:::bucket"}]}`,
+			expected: []string{"policy statement 0: uses NotAction"},
+		},
+		{
+			name:     "DenyIsNotLinted",
+			document: `{"Statement":[{"Effect":"Deny","NotAction":"iot:Connect","Resource":"*"},{"Effect":"Deny","Action":"*","Resource":"*"}]}`,
+		},
+		{
+			name:     "EveryViolationReported",
+			document: `{"Statement":[{"Effect":"Allow","Action":"*","Resource":"*"},{"Effect":"Allow","Action":"ec2:ListInstances","Resource":"*"},{"Sid":"Tags","Effect":"Allow","Action":["ec2:CreateTags"],"Resource":"*"}]}`,
+			expected: []string{
+				`policy statement 0: allows all actions with "Action": "*"`,
+				`policy statement Tags: allows write actions ec2:CreateTags on "Resource": "*"`,
+			},
+		},
+		{
+			name:     "AllowListed",
+			document: `{"Statement":[{"Sid":"Tags","Effect":"Allow","Action":"ec2:CreateTags","Resource":"*"},{"Sid":"Logs","Effect":"Allow","Action":"logs:PutLogEvents","Resource":"*"}]}`,
+			allowed:  map[string]string{"Tags": "tags are written before the resources get their ARNs"},
+			expected: []string{`policy statement Logs: allows write actions logs:PutLogEvents on "Resource": "*"`},
+		},
+		{
+			name:     "AllowListNeedsSid",
+			document: `{"Statement":[{"Effect":"Allow","Action":"ec2:CreateTags","Resource":"*"}]}`,
+			allowed:  map[string]string{"": "no Sid"},
+			expected: []string{`policy statement 0: allows write actions ec2:CreateTags on "Resource": "*"`},
+		},
+		{
+			name:     "NotJSON",
+			document: `{"Statement":`,
+			expected: []string{"policy: policy is not valid JSON: unexpected end of JSON input"},
+		},
+		{
+			name:     "BadStatement",
+			document: `{"Statement":"*"}`,
+			expected: []string{"policy: Statement is neither a statement nor a list of them"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, lintPolicyDocument("policy", tc.document, tc.allowed))
+		})
+	}
+}
+
+func TestStringOrList(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"iot:Connect"}, StringOrList("iot:Connect"))
+	assert.Equal(t, []string{"iot:Publish", "iot:Receive"}, StringOrList([]interface{}{"iot:Publish", "iot:Receive"}))
+	assert.Nil(t, StringOrList(nil))
+	assert.Nil(t, StringOrList(map[string]interface{}{"AWS": "*"}))
+}
+
+func policyAddresses(documents map[string]string) []string {
+	var addresses []string
+	for address := range documents {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,207 @@
+// Package testhelpers holds the plumbing shared by the terratest module suites.
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/files"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/nameprefix"
+	"terraform-tests/internal/report"
+)
+
+const (
+	// modulesDir is the location of the terraform modules relative to the
+	// tests package, which is the working directory during go test.
+	modulesDir = "../modules"
+
+	// namePrefixVar is the module variable every resource name is prefixed
+	// with so that concurrent and repeated runs do not collide.
+	namePrefixVar = "name_prefix"
+
+	// stagedRunsDir is the dir in the temp dir holding the working dirs of
+	// runs with a SKIP_ variable set, where a later run of the same test finds
+	// them again.
+	stagedRunsDir = "terratest-stages"
+
+	// NAT and EIP capacity errors can take a few minutes to clear, so retries
+	// are spread over roughly two and a half minutes.
+	defaultMaxRetries         = 5
+	defaultTimeBetweenRetries = 30 * time.Second
+)
+
+// uniqueNamePrefix returns a fresh name prefix for a single test run.
+func uniqueNamePrefix() string {
+	return nameprefix.New(time.Now())
+}
+
+// NewModuleOptionsInRegion builds terraform options for the named module with
+// the given vars. A unique name_prefix is injected unless vars already sets
+// one, the AWS provider is pinned to the region, which tests pick with
+// SelectRegion, and applies under the role in TEST_ASSUME_ROLE_ARN when it is
+// set, transient errors are retried, secrets are redacted from the terraform
+// output, and the module is destroyed when the test and all of its subtests
+// finish, or earlier when the test deadline or a signal would otherwise cut
+// that short. Terraform is the newest installed version in TF_VERSIONS,
+// falling back to terraform on the PATH.
+//
+// Each call works on its own copy of the modules dir from ModuleWorkingDir, so
+// parallel tests never share a .terraform dir or state file. The options are
+// built in the setup stage and persisted in the copy's .test-data dir, which
+// is what lets a run with SKIP_setup reuse the name prefix, region and state
+// of the run that applied the module. Only modules applied through ApplyModule
+// or registered with RegisterTeardown are destroyed, in the teardown stage.
+func NewModuleOptionsInRegion(t *testing.T, moduleName string, region string, vars map[string]interface{}) *terraform.Options {
+	t.Helper()
+	return newModuleOptions(t, moduleName, region, nil, vars)
+}
+
+// NewModuleOptionsWithVarFiles is NewModuleOptionsInRegion with the vars of the
+// given tfvars files passed to the module as well. The files are given relative to
+// the tests package and must exist. vars, including the injected name_prefix,
+// take precedence over the values in the files.
+func NewModuleOptionsWithVarFiles(t *testing.T, moduleName string, region string, varFiles []string, vars map[string]interface{}) *terraform.Options {
+	t.Helper()
+	return newModuleOptions(t, moduleName, region, varFiles, vars)
+}
+
+func newModuleOptions(t *testing.T, moduleName string, region string, varFiles []string, vars map[string]interface{}) *terraform.Options {
+	t.Helper()
+
+	// Terraform runs in the copy of the module, so relative paths would no
+	// longer point at the files
+	absVarFiles := make([]string, 0, len(varFiles))
+	for _, varFile := range varFiles {
+		absVarFile, err := filepath.Abs(varFile)
+		require.NoError(t, err)
+		require.FileExists(t, absVarFile, "var file of module %s", moduleName)
+		absVarFiles = append(absVarFiles, absVarFile)
+	}
+
+	workingDir := ModuleWorkingDir(t, moduleName)
+
+	test_structure.RunTestStage(t, StageSetup, func() {
+		moduleVars := map[string]interface{}{
+			namePrefixVar: uniqueNamePrefix(),
+		}
+		for key, value := range vars {
+			moduleVars[key] = value
+		}
+
+		test_structure.SaveTerraformOptions(t, workingDir, &terraform.Options{
+			TerraformDir:             workingDir,
+			TerraformBinary:          NewestTerraformBinary(),
+			Vars:                     moduleVars,
+			VarFiles:                 absVarFiles,
+			SetVarsAfterVarFiles:     true,
+			EnvVars:                  map[string]string{regionEnvVar: region},
+			RetryableTerraformErrors: RetryableTerraformErrors(),
+			MaxRetries:               defaultMaxRetries,
+			TimeBetweenRetries:       defaultTimeBetweenRetries,
+		})
+	})
+
+	terraformOptions := test_structure.LoadTerraformOptions(t, workingDir)
+	report.SetRegion(t, Region(terraformOptions))
+	// Loggers do not survive being persisted with the options
+	terraformOptions.Logger = redactingLogger(terraformOptions.Vars)
+	// Neither should credentials, so the role is only added once the options
+	// are persisted
+	if roleArn := os.Getenv(AssumeRoleArnEnvVar); roleArn != "" {
+		terraformOptions.EnvVars[AssumeRoleArnEnvVar] = roleArn
+		require.NoError(t, refreshCredentials(terraformOptions))
+	}
+
+	// Registered now, so that it keeps its place among the test's cleanups,
+	// but only destroys the module once it was applied
+	moduleOptions.Store(terraformOptions, t.Name())
+	t.Cleanup(func() {
+		moduleOptions.Delete(terraformOptions)
+		test_structure.RunTestStage(t, StageTeardown, func() {
+			if module := inFlight.lookup(terraformOptions); module != nil {
+				var err error
+				withTerraformSlot(func() {
+					_, err = module.destroy(t)
+				})
+				require.NoError(t, err, "destroying module %s", moduleName)
+			}
+			require.NoError(t, inFlight.failure(terraformOptions), "destroying module %s ahead of the test deadline", moduleName)
+			test_structure.CleanupTestDataFolder(t, workingDir)
+		})
+	})
+
+	return terraformOptions
+}
+
+// moduleOptions holds the options built by newModuleOptions whose test has not
+// finished yet, the only ones RegisterTeardown registers, with the name of
+// that test.
+var moduleOptions sync.Map
+
+// stagedCopies counts the working dirs ModuleWorkingDir handed out per test
+// and module, so every copy a test asks for gets its own dir and a rerun of
+// the test the same dirs in the same order.
+var (
+	stagedCopiesMu sync.Mutex
+	stagedCopies   = map[string]int{}
+)
+
+// ModuleWorkingDir returns the dir of the named module in a copy of the
+// modules dir only the test works in. Normally it is a fresh temp dir. With a
+// SKIP_ variable set it is one named after the test, which keeps the
+// .terraform dir, state and .test-data of the runs before it while the module
+// sources are copied over again, so stages can be run one at a time without
+// parallel tests sharing a working dir.
+func ModuleWorkingDir(t *testing.T, moduleName string) string {
+	t.Helper()
+
+	if !test_structure.SkipStageEnvVarSet() {
+		return test_structure.CopyTerraformFolderToTemp(t, modulesDir, moduleName)
+	}
+
+	stagedCopiesMu.Lock()
+	key := t.Name() + "/" + moduleName
+	stagedCopies[key]++
+	copyName := fmt.Sprintf("%s-%d", moduleName, stagedCopies[key])
+	stagedCopiesMu.Unlock()
+
+	root := filepath.Join(stagedTestDir(t), copyName)
+	require.NoError(t, os.MkdirAll(root, 0o755))
+	require.NoError(t, files.CopyFolderContentsWithFilter(modulesDir, root, func(path string) bool {
+		return !files.PathContainsHiddenFileOrFolder(path) && !files.PathContainsTerraformStateOrVars(path)
+	}))
+	t.Logf("Using working dir %s for module %s", filepath.Join(root, moduleName), moduleName)
+	return filepath.Join(root, moduleName)
+}
+
+// stagedTestDir returns the dir in the temp dir the working dirs and saved
+// state of a staged run of the test are kept in.
+func stagedTestDir(t *testing.T) string {
+	return filepath.Join(os.TempDir(), stagedRunsDir, filepath.FromSlash(t.Name()))
+}
+
+// NamePrefix returns the name_prefix injected by NewModuleOptionsInRegion, for tests
+// that create resources of their own alongside the module's.
+func NamePrefix(terraformOptions *terraform.Options) string {
+	namePrefix, _ := terraformOptions.Vars[namePrefixVar].(string)
+	return namePrefix
+}
+
+// ModuleName returns the name of the module the options are for, which is the
+// name of its directory in the modules dir.
+func ModuleName(terraformOptions *terraform.Options) string {
+	return filepath.Base(terraformOptions.TerraformDir)
+}
+
+// Region returns the region the module of the options is deployed to.
+func Region(terraformOptions *terraform.Options) string {
+	return terraformOptions.EnvVars[regionEnvVar]
+}
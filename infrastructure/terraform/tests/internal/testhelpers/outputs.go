@@ -0,0 +1,114 @@
+package testhelpers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+// OutputType is the kind of value a terraform output holds, as decoded from
+// `terraform output -json`.
+type OutputType string
+
+const (
+	OutputString OutputType = "string"
+	OutputList   OutputType = "list"
+	OutputMap    OutputType = "map"
+)
+
+// OutputSpec describes an expected output. Pattern, when set, has to match a
+// string output, every element of a list output or every value of a map
+// output.
+type OutputSpec struct {
+	Type    OutputType
+	Pattern string
+}
+
+// OutputSchema maps output names to what they are expected to hold.
+type OutputSchema map[string]OutputSpec
+
+// ValidateOutputs fails for every output of the schema that is missing, has
+// the wrong type or does not match its pattern, and returns all the outputs
+// for further assertions.
+func ValidateOutputs(t *testing.T, terraformOptions *terraform.Options, schema OutputSchema) map[string]interface{} {
+	t.Helper()
+
+	outputs, err := terraform.OutputAllE(t, terraformOptions)
+	require.NoError(t, err)
+
+	for _, violation := range checkOutputs(outputs, schema) {
+		t.Error(violation)
+	}
+	return outputs
+}
+
+// checkOutputs returns a message per schema violation, sorted by output name.
+func checkOutputs(outputs map[string]interface{}, schema OutputSchema) []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []string
+	for _, name := range names {
+		spec := schema[name]
+		value, ok := outputs[name]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("output %q is missing", name))
+			continue
+		}
+		if actual := outputType(value); actual != spec.Type {
+			violations = append(violations, fmt.Sprintf("output %q is a %s, expected a %s", name, actual, spec.Type))
+			continue
+		}
+		if spec.Pattern == "" {
+			continue
+		}
+
+		pattern := regexp.MustCompile(spec.Pattern)
+		switch v := value.(type) {
+		case string:
+			if !pattern.MatchString(v) {
+				violations = append(violations, fmt.Sprintf("output %q value %q does not match %s", name, v, spec.Pattern))
+			}
+		case []interface{}:
+			for i, element := range v {
+				if s, ok := element.(string); !ok || !pattern.MatchString(s) {
+					violations = append(violations, fmt.Sprintf("output %q element %d %v does not match %s", name, i, element, spec.Pattern))
+				}
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if s, ok := v[key].(string); !ok || !pattern.MatchString(s) {
+					violations = append(violations, fmt.Sprintf("output %q key %q value %v does not match %s", name, key, v[key], spec.Pattern))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// outputType names the type of a decoded output value.
+func outputType(value interface{}) OutputType {
+	switch value.(type) {
+	case string:
+		return OutputString
+	case []interface{}:
+		return OutputList
+	case map[string]interface{}:
+		return OutputMap
+	case nil:
+		return "null"
+	}
+	return OutputType(fmt.Sprintf("%T", value))
+}
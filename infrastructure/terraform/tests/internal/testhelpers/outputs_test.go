@@ -0,0 +1,40 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOutputs(t *testing.T) {
+	schema := OutputSchema{
+		"vpc_id":          {Type: OutputString, Pattern: `^vpc-[0-9a-f]+$`},
+		"subnet_ids":      {Type: OutputList, Pattern: `^subnet-[0-9a-f]+$`},
+		"tags":            {Type: OutputMap},
+		"route_table_ids": {Type: OutputMap, Pattern: `^rtb-`},
+	}
+
+	valid := map[string]interface{}{
+		"vpc_id":          "vpc-0a1b2c3d4e5f67890",
+		"subnet_ids":      []interface{}{"subnet-0a1b2c", "subnet-3d4e5f"},
+		"tags":            map[string]interface{}{"Environment": "test"},
+		"route_table_ids": map[string]interface{}{"public": "rtb-0a1b2c"},
+		"unlisted":        42.0,
+	}
+	assert.Empty(t, checkOutputs(valid, schema))
+
+	invalid := map[string]interface{}{
+		"vpc_id":          []interface{}{"vpc-0a1b2c3d4e5f67890"},
+		"subnet_ids":      []interface{}{"subnet-0a1b2c", "sg-3d4e5f"},
+		"route_table_ids": map[string]interface{}{"private": "igw-0a1b2c"},
+	}
+	assert.Equal(t, []string{
+		`output "route_table_ids" key "private" value igw-0a1b2c does not match ^rtb-`,
+		`output "subnet_ids" element 1 sg-3d4e5f does not match ^subnet-[0-9a-f]+$`,
+		`output "tags" is missing`,
+		`output "vpc_id" is a list, expected a string`,
+	}, checkOutputs(invalid, schema))
+
+	assert.Equal(t, []string{`output "vpc_id" value "VPC-1" does not match ^vpc-[0-9a-f]+$`},
+		checkOutputs(map[string]interface{}{"vpc_id": "VPC-1"}, OutputSchema{"vpc_id": schema["vpc_id"]}))
+}
@@ -0,0 +1,245 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// PlannedResourcesOfType returns the planned values of every resource of the
+// given type, keyed by address.
+func PlannedResourcesOfType(plan *terraform.PlanStruct, resourceType string) map[string]map[string]interface{} {
+	resources := map[string]map[string]interface{}{}
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		if resource.Type == resourceType {
+			resources[address] = resource.AttributeValues
+		}
+	}
+	return resources
+}
+
+// CIDRContains reports whether the inner CIDR block lies entirely within the
+// outer one.
+func CIDRContains(outer, inner string) bool {
+	_, outerNet, err := net.ParseCIDR(outer)
+	if err != nil {
+		return false
+	}
+	_, innerNet, err := net.ParseCIDR(inner)
+	if err != nil {
+		return false
+	}
+	outerOnes, _ := outerNet.Mask.Size()
+	innerOnes, _ := innerNet.Mask.Size()
+	return outerNet.Contains(innerNet.IP) && innerOnes >= outerOnes
+}
+
+// AssertPlannedResources checks how many resources of each type the plan leaves
+// in place after apply. A key of the expectations is either a resource type,
+// counting the type in every module, or a module address and a type such as
+// module.core.aws_iam_policy, counting it in that module only. Types missing
+// from the expectations are not checked. On a mismatch the test fails with the
+// planned count of every type and the addresses behind each mismatched one.
+func AssertPlannedResources(t *testing.T, plan *terraform.PlanStruct, expectations map[string]int) bool {
+	t.Helper()
+
+	mismatches, breakdown := checkPlannedResources(plan, expectations)
+	if len(mismatches) == 0 {
+		return true
+	}
+	t.Errorf("planned resource counts do not match:\n%s\n\nplanned resources:\n%s",
+		strings.Join(mismatches, "\n"), strings.Join(breakdown, "\n"))
+	return false
+}
+
+// checkPlannedResources returns a line per expectation the plan does not meet,
+// sorted by key, along with a line per planned resource type and module.
+func checkPlannedResources(plan *terraform.PlanStruct, expectations map[string]int) ([]string, []string) {
+	addresses := plannedAddressesByKey(plan)
+
+	keys := make([]string, 0, len(expectations))
+	for key := range expectations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var mismatches []string
+	for _, key := range keys {
+		planned := addresses[key]
+		if len(planned) == expectations[key] {
+			continue
+		}
+		mismatch := fmt.Sprintf("%s: expected %d, planned %d", key, expectations[key], len(planned))
+		if len(planned) > 0 {
+			mismatch += " (" + strings.Join(planned, ", ") + ")"
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	if len(mismatches) == 0 {
+		return nil, nil
+	}
+
+	planned := make([]string, 0, len(addresses))
+	for key := range addresses {
+		planned = append(planned, key)
+	}
+	sort.Strings(planned)
+	breakdown := make([]string, 0, len(planned))
+	for _, key := range planned {
+		breakdown = append(breakdown, fmt.Sprintf("%s: %d", key, len(addresses[key])))
+	}
+	return mismatches, breakdown
+}
+
+// plannedAddressesByKey returns the sorted addresses of the managed resources
+// in the planned values, by type and, for resources in a child module, also
+// by module address and type.
+func plannedAddressesByKey(plan *terraform.PlanStruct) map[string][]string {
+	addresses := map[string][]string{}
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		if resource.Mode != "managed" {
+			continue
+		}
+		addresses[string(resource.Type)] = append(addresses[string(resource.Type)], address)
+		if module := moduleAddress(address, string(resource.Type), resource.Name, resource.Index); module != "" {
+			key := module + "." + string(resource.Type)
+			addresses[key] = append(addresses[key], address)
+		}
+	}
+	for _, keyed := range addresses {
+		sort.Strings(keyed)
+	}
+	return addresses
+}
+
+// moduleAddress returns the address of the module a resource is in, empty for
+// the root module.
+func moduleAddress(address string, resourceType string, name string, index interface{}) string {
+	switch key := index.(type) {
+	case string:
+		address = strings.TrimSuffix(address, "["+strconv.Quote(key)+"]")
+	case float64:
+		address = strings.TrimSuffix(address, "["+strconv.FormatFloat(key, 'f', -1, 64)+"]")
+	}
+	address = strings.TrimSuffix(address, resourceType+"."+name)
+	return strings.TrimSuffix(address, ".")
+}
+
+// AssertResourceAttr checks a planned value of the resource at the address.
+// The attribute path separates nested attributes with dots and indexes lists
+// in brackets, e.g. tags.Name or ingress[0].from_port, and map keys with
+// dots are quoted, e.g. components["aws.greengrass.Cli"]. Expected values are
+// compared the way they would appear in the plan JSON, so an int matches a
+// planned number and a []string a planned list.
+func AssertResourceAttr(t *testing.T, plan *terraform.PlanStruct, address string, attrPath string, expected interface{}) bool {
+	t.Helper()
+
+	if err := checkResourceAttr(plan, address, attrPath, expected); err != nil {
+		t.Error(err)
+		return false
+	}
+	return true
+}
+
+func checkResourceAttr(plan *terraform.PlanStruct, address string, attrPath string, expected interface{}) error {
+	resource, ok := plan.ResourcePlannedValuesMap[address]
+	if !ok {
+		return fmt.Errorf("%s is not in the plan", address)
+	}
+
+	actual, err := plannedAttr(resource.AttributeValues, attrPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", address, err)
+	}
+
+	encoded, err := json.Marshal(expected)
+	if err != nil {
+		return fmt.Errorf("%s.%s: expected value %v cannot be encoded: %w", address, attrPath, expected, err)
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return fmt.Errorf("%s.%s: expected value %v cannot be decoded: %w", address, attrPath, expected, err)
+	}
+	if !reflect.DeepEqual(normalized, actual) {
+		return fmt.Errorf("%s.%s is planned as %v, expected %v", address, attrPath, actual, expected)
+	}
+	return nil
+}
+
+// plannedAttr walks the attribute path through the planned values. Values only
+// known after apply are absent from the planned values and reported as such.
+func plannedAttr(values map[string]interface{}, attrPath string) (interface{}, error) {
+	var current interface{} = values
+	walked := ""
+	for _, part := range attrPathParts(attrPath) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok || value == nil {
+				return nil, fmt.Errorf("%s has no planned value, it is unset or only known after apply", joinAttrPath(walked, part))
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("%s is a list, %q is not an index", walked, part)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("%s has %d elements, no index %d", walked, len(node), index)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%s is %v, which has no attribute %q", walked, node, part)
+		}
+		walked = joinAttrPath(walked, part)
+	}
+	return current, nil
+}
+
+// attrPathParts splits an attribute path such as ingress[0].from_port into
+// ingress, 0 and from_port. Quoted keys are kept whole, so map keys may contain
+// dots, e.g. components["aws.greengrass.Cli"].
+func attrPathParts(attrPath string) []string {
+	var parts []string
+	var part strings.Builder
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+	}
+
+	quoted := false
+	for _, r := range attrPath {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == '.' || r == '[' || r == ']'):
+			flush()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	flush()
+	return parts
+}
+
+func joinAttrPath(walked string, part string) string {
+	if walked == "" {
+		return part
+	}
+	if _, err := strconv.Atoi(part); err == nil {
+		return walked + "[" + part + "]"
+	}
+	if strings.Contains(part, ".") {
+		return walked + "[" + strconv.Quote(part) + "]"
+	}
+	return walked + "." + part
+}
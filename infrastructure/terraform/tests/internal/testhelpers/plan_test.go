@@ -0,0 +1,156 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestPlan(t *testing.T, name string) *terraform.PlanStruct {
+	planJSON, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	plan, err := terraform.ParsePlanJSON(string(planJSON))
+	require.NoError(t, err)
+	return plan
+}
+
+func TestCheckPlannedResources(t *testing.T) {
+	plan := readTestPlan(t, "vpc_plan.json")
+
+	t.Run("Matching", func(t *testing.T) {
+		mismatches, breakdown := checkPlannedResources(plan, map[string]int{
+			"aws_subnet":                        4,
+			"aws_route_table":                   3,
+			"aws_nat_gateway":                   1,
+			"aws_vpc_endpoint":                  2,
+			"module.endpoints.aws_vpc_endpoint": 2,
+			"aws_eip":                           0,
+		})
+		assert.Empty(t, mismatches)
+		assert.Empty(t, breakdown)
+	})
+
+	t.Run("Mismatched", func(t *testing.T) {
+		mismatches, breakdown := checkPlannedResources(plan, map[string]int{
+			"aws_subnet":               4,
+			"aws_route_table":          2,
+			"aws_eip":                  1,
+			"aws_region":               1,
+			"module.endpoints.aws_vpc": 1,
+		})
+		// Data sources are read, not planned
+		assert.Equal(t, []string{
+			"aws_eip: expected 1, planned 0",
+			"aws_region: expected 1, planned 0",
+			"aws_route_table: expected 2, planned 3 (aws_route_table.private[0], aws_route_table.private[1], aws_route_table.public)",
+			"module.endpoints.aws_vpc: expected 1, planned 0",
+		}, mismatches)
+		assert.Equal(t, []string{
+			"aws_nat_gateway: 1",
+			"aws_route_table: 3",
+			"aws_security_group: 1",
+			"aws_subnet: 4",
+			"aws_vpc: 1",
+			"aws_vpc_endpoint: 2",
+			"module.endpoints.aws_security_group: 1",
+			"module.endpoints.aws_vpc_endpoint: 2",
+		}, breakdown)
+	})
+}
+
+func TestModuleAddress(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		address  string
+		name     string
+		index    interface{}
+		expected string
+	}{
+		{address: "aws_vpc.main", name: "main", expected: ""},
+		{address: "aws_vpc.main[1]", name: "main", index: 1.0, expected: ""},
+		{address: `module.core.aws_vpc.main["a.b"]`, name: "main", index: "a.b", expected: "module.core"},
+		{address: `module.spoke["eu"].module.net.aws_vpc.main`, name: "main", expected: `module.spoke["eu"].module.net`},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, moduleAddress(tc.address, "aws_vpc", tc.name, tc.index), tc.address)
+	}
+}
+
+func TestCheckResourceAttr(t *testing.T) {
+	t.Parallel()
+
+	plan := readTestPlan(t, "vpc_plan.json")
+
+	testCases := []struct {
+		name     string
+		address  string
+		attrPath string
+		expected interface{}
+		err      string
+	}{
+		{name: "String", address: "aws_subnet.private[0]", attrPath: "cidr_block", expected: "10.0.128.0/20"},
+		{name: "Bool", address: "aws_subnet.public[1]", attrPath: "map_public_ip_on_launch", expected: true},
+		{name: "NestedMap", address: "aws_vpc.main", attrPath: "tags.Environment", expected: "test"},
+		{name: "ListElement", address: "aws_route_table.public", attrPath: "route[0].cidr_block", expected: "0.0.0.0/0"},
+		{name: "IntAgainstNumber", address: "module.endpoints.aws_security_group.endpoints", attrPath: "ingress[0].from_port", expected: 443},
+		{name: "StringSlice", address: "module.endpoints.aws_security_group.endpoints", attrPath: "ingress[0].cidr_blocks", expected: []string{"10.0.0.0/16"}},
+		{name: "ForEachKey", address: `module.endpoints.aws_vpc_endpoint.interface["iot-data"]`, attrPath: "private_dns_enabled", expected: true},
+		{
+			name:     "WrongValue",
+			address:  "aws_subnet.private[1]",
+			attrPath: "cidr_block",
+			expected: "10.0.16.0/20",
+			err:      "aws_subnet.private[1].cidr_block is planned as 10.0.144.0/20, expected 10.0.16.0/20",
+		},
+		{
+			name:     "MissingResource",
+			address:  "aws_subnet.private[2]",
+			attrPath: "cidr_block",
+			err:      "aws_subnet.private[2] is not in the plan",
+		},
+		{
+			name:     "UnknownAttribute",
+			address:  "aws_vpc.main",
+			attrPath: "id",
+			err:      "aws_vpc.main: id has no planned value, it is unset or only known after apply",
+		},
+		{
+			name:     "IndexOutOfRange",
+			address:  "aws_route_table.public",
+			attrPath: "route[1].cidr_block",
+			err:      "aws_route_table.public: route has 1 elements, no index 1",
+		},
+		{
+			name:     "ThroughScalar",
+			address:  "aws_vpc.main",
+			attrPath: "cidr_block.prefix",
+			err:      `aws_vpc.main: cidr_block is 10.0.0.0/16, which has no attribute "prefix"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkResourceAttr(plan, tc.address, tc.attrPath, tc.expected)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAttrPathParts(t *testing.T) {
+	assert.Equal(t, []string{"ingress", "0", "cidr_blocks", "1"}, attrPathParts("ingress[0].cidr_blocks[1]"))
+	assert.Equal(t, []string{"tags", "Name"}, attrPathParts(`tags["Name"]`))
+	assert.Equal(t, []string{"cidr_block"}, attrPathParts("cidr_block"))
+	assert.Equal(t, []string{"components", "aws.greengrass.Cli", "component_version"}, attrPathParts(`components["aws.greengrass.Cli"].component_version`))
+}
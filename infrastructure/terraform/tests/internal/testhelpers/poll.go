@@ -0,0 +1,65 @@
+package testhelpers
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// PollIntervalEnvVar and PollDeadlineEnvVar tune how often and for how long
+// tests poll for asynchronous results such as messages routed by an IoT rule.
+// Both take Go durations, e.g. TEST_POLL_INTERVAL=2s TEST_POLL_DEADLINE=5m.
+const (
+	PollIntervalEnvVar = "TEST_POLL_INTERVAL"
+	PollDeadlineEnvVar = "TEST_POLL_DEADLINE"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultPollDeadline = 2 * time.Minute
+)
+
+// PollInterval returns the interval set in TEST_POLL_INTERVAL, or
+// defaultPollInterval when it is unset or invalid.
+func PollInterval() time.Duration {
+	return durationFromEnv(PollIntervalEnvVar, defaultPollInterval)
+}
+
+// PollDeadline returns the deadline set in TEST_POLL_DEADLINE, or
+// defaultPollDeadline when it is unset or invalid.
+func PollDeadline() time.Duration {
+	return durationFromEnv(PollDeadlineEnvVar, defaultPollDeadline)
+}
+
+func durationFromEnv(envVar string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(envVar)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// PollUntil calls check every PollInterval until it reports done or
+// PollDeadline has passed, and returns whether it finished in time. Errors from
+// check are logged and polling carries on, since they are usually the result
+// not being there yet.
+func PollUntil(t *testing.T, description string, check func() (bool, error)) bool {
+	return pollUntil(t, description, PollInterval(), PollDeadline(), check)
+}
+
+func pollUntil(t *testing.T, description string, interval time.Duration, deadline time.Duration, check func() (bool, error)) bool {
+	expires := time.Now().Add(deadline)
+	for {
+		done, err := check()
+		if err != nil {
+			t.Logf("%s: %v", description, err)
+		}
+		if done {
+			return true
+		}
+		if time.Now().Add(interval).After(expires) {
+			t.Logf("%s: gave up after %s", description, deadline)
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
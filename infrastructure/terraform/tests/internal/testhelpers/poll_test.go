@@ -0,0 +1,58 @@
+package testhelpers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurationFromEnv(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "Unset", value: "", expected: time.Minute},
+		{name: "Valid", value: "90s", expected: 90 * time.Second},
+		{name: "NotADuration", value: "soon", expected: time.Minute},
+		{name: "Negative", value: "-5s", expected: time.Minute},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(PollDeadlineEnvVar, tc.value)
+			assert.Equal(t, tc.expected, durationFromEnv(PollDeadlineEnvVar, time.Minute))
+		})
+	}
+}
+
+func TestPollUntil(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Done", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		done := pollUntil(t, "third call succeeds", time.Millisecond, time.Second, func() (bool, error) {
+			calls++
+			if calls < 3 {
+				return false, errors.New("not yet")
+			}
+			return true, nil
+		})
+		assert.True(t, done)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("DeadlinePassed", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		done := pollUntil(t, "never succeeds", 10*time.Millisecond, 35*time.Millisecond, func() (bool, error) {
+			calls++
+			return false, nil
+		})
+		assert.False(t, done)
+		assert.GreaterOrEqual(t, calls, 2, "gave up without polling again")
+	})
+}
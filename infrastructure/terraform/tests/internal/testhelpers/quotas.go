@@ -0,0 +1,218 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+
+	"terraform-tests/internal/report"
+)
+
+// QuotaRequirements is how much of the account's quotas in the region one
+// apply of a module takes. Tests declare it next to the module vars it follows
+// from.
+type QuotaRequirements struct {
+	VPCs       int
+	ElasticIPs int
+	// NATGateways is the most the module creates in any one AZ, which is
+	// what the quota limits.
+	NATGateways int
+	IoTThings   int
+}
+
+// quota is a Service Quotas quota, looked up by name so that services without
+// a fixed quota code for it are handled the same way.
+type quota struct {
+	serviceCode string
+	name        string
+	required    func(QuotaRequirements) int
+}
+
+// Quota names, as they appear in Service Quotas.
+const (
+	quotaVPCs        = "VPCs per Region"
+	quotaElasticIPs  = "EC2-VPC Elastic IPs"
+	quotaNATGateways = "NAT gateways per Availability Zone"
+	quotaIoTThings   = "Things"
+)
+
+var quotas = []quota{
+	{serviceCode: "vpc", name: quotaVPCs, required: func(r QuotaRequirements) int { return r.VPCs }},
+	{serviceCode: "ec2", name: quotaElasticIPs, required: func(r QuotaRequirements) int { return r.ElasticIPs }},
+	{serviceCode: "vpc", name: quotaNATGateways, required: func(r QuotaRequirements) int { return r.NATGateways }},
+	// IoT Core does not limit the number of things in most accounts, in
+	// which case no quota is found and it counts as unlimited
+	{serviceCode: "iotcore", name: quotaIoTThings, required: func(r QuotaRequirements) int { return r.IoTThings }},
+}
+
+// quotaSource looks up the value of a quota and how much of it is in use.
+// limit reports false when the quota is not found, i.e. there is no limit.
+type quotaSource interface {
+	limit(q quota) (float64, bool, error)
+	usage(q quota) (int, error)
+}
+
+// SkipWithoutQuotaHeadroom skips the test, naming the exhausted quotas, when
+// the region has too little headroom left for the requirements, so that a
+// full account fails fast instead of partway through an apply that leaves
+// partial state behind. Call it right before the apply. Nothing is checked in
+// plan-only mode or when the setup stage is skipped, and a quota that cannot
+// be looked up is logged and let through.
+func SkipWithoutQuotaHeadroom(t *testing.T, region string, requirements QuotaRequirements) {
+	t.Helper()
+
+	if requirements == (QuotaRequirements{}) || IsPlanOnly() || os.Getenv(test_structure.SKIP_STAGE_ENV_VAR_PREFIX+StageSetup) != "" {
+		return
+	}
+
+	source := awsQuotaSource{
+		serviceQuotas: NewServiceQuotasClient(t, region),
+		ec2:           ec2.New(NewSession(t, region)),
+		iot:           NewIotClient(t, region),
+	}
+	shortfalls, errs := quotaShortfalls(requirements, source)
+	for _, err := range errs {
+		t.Logf("Failed to check quota headroom in %s: %v", region, err)
+	}
+	if len(shortfalls) > 0 {
+		details := strings.Join(shortfalls, "; ")
+		report.Record(t, "", "quotas", report.Skip, details)
+		t.Skipf("not enough quota left in %s: %s", region, details)
+	}
+}
+
+// quotaShortfalls returns a line per quota that has less headroom left than
+// the requirements need, and the errors of the quotas it could not check.
+func quotaShortfalls(requirements QuotaRequirements, source quotaSource) ([]string, []error) {
+	var shortfalls []string
+	var errs []error
+	for _, q := range quotas {
+		required := q.required(requirements)
+		if required == 0 {
+			continue
+		}
+
+		limit, limited, err := source.limit(q)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("looking up quota %s: %w", q.name, err))
+			continue
+		}
+		if !limited {
+			continue
+		}
+		used, err := source.usage(q)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("counting usage of quota %s: %w", q.name, err))
+			continue
+		}
+		if float64(used+required) > limit {
+			shortfalls = append(shortfalls, fmt.Sprintf("%s: %d of %d in use, %d more needed", q.name, used, int(limit), required))
+		}
+	}
+	return shortfalls, errs
+}
+
+type awsQuotaSource struct {
+	serviceQuotas *servicequotas.ServiceQuotas
+	ec2           *ec2.EC2
+	iot           *iot.IoT
+}
+
+// limit returns the applied value of the quota, falling back to its default
+// for quotas the account never had applied.
+func (s awsQuotaSource) limit(q quota) (float64, bool, error) {
+	var value float64
+	found := false
+	match := func(quotas []*servicequotas.ServiceQuota) bool {
+		for _, candidate := range quotas {
+			if strings.EqualFold(awsSDK.StringValue(candidate.QuotaName), q.name) {
+				value, found = awsSDK.Float64Value(candidate.Value), true
+				return false
+			}
+		}
+		return true
+	}
+
+	err := s.serviceQuotas.ListServiceQuotasPages(&servicequotas.ListServiceQuotasInput{ServiceCode: awsSDK.String(q.serviceCode)},
+		func(page *servicequotas.ListServiceQuotasOutput, _ bool) bool { return match(page.Quotas) })
+	if err != nil || found {
+		return value, found, err
+	}
+	err = s.serviceQuotas.ListAWSDefaultServiceQuotasPages(&servicequotas.ListAWSDefaultServiceQuotasInput{ServiceCode: awsSDK.String(q.serviceCode)},
+		func(page *servicequotas.ListAWSDefaultServiceQuotasOutput, _ bool) bool { return match(page.Quotas) })
+	return value, found, err
+}
+
+func (s awsQuotaSource) usage(q quota) (int, error) {
+	switch q.name {
+	case quotaVPCs:
+		count := 0
+		err := s.ec2.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, _ bool) bool {
+			count += len(page.Vpcs)
+			return true
+		})
+		return count, err
+	case quotaElasticIPs:
+		output, err := s.ec2.DescribeAddresses(&ec2.DescribeAddressesInput{
+			Filters: []*ec2.Filter{{Name: awsSDK.String("domain"), Values: awsSDK.StringSlice([]string{"vpc"})}},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return len(output.Addresses), nil
+	case quotaNATGateways:
+		return s.natGatewaysInBusiestZone()
+	case quotaIoTThings:
+		count := 0
+		err := s.iot.ListThingsPages(&iot.ListThingsInput{}, func(page *iot.ListThingsOutput, _ bool) bool {
+			count += len(page.Things)
+			return true
+		})
+		return count, err
+	}
+	return 0, fmt.Errorf("no usage counter for quota %s", q.name)
+}
+
+// natGatewaysInBusiestZone returns the number of pending and available NAT
+// gateways in the AZ that has the most of them.
+func (s awsQuotaSource) natGatewaysInBusiestZone() (int, error) {
+	var subnetIds []string
+	err := s.ec2.DescribeNatGatewaysPages(&ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{{Name: awsSDK.String("state"), Values: awsSDK.StringSlice([]string{ec2.NatGatewayStatePending, ec2.NatGatewayStateAvailable})}},
+	}, func(page *ec2.DescribeNatGatewaysOutput, _ bool) bool {
+		for _, natGateway := range page.NatGateways {
+			subnetIds = append(subnetIds, awsSDK.StringValue(natGateway.SubnetId))
+		}
+		return true
+	})
+	if err != nil || len(subnetIds) == 0 {
+		return 0, err
+	}
+
+	zones := map[string]string{}
+	err = s.ec2.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{SubnetIds: awsSDK.StringSlice(subnetIds)}, func(page *ec2.DescribeSubnetsOutput, _ bool) bool {
+		for _, subnet := range page.Subnets {
+			zones[awsSDK.StringValue(subnet.SubnetId)] = awsSDK.StringValue(subnet.AvailabilityZone)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	perZone := map[string]int{}
+	busiest := 0
+	for _, subnetId := range subnetIds {
+		zone := zones[subnetId]
+		perZone[zone]++
+		busiest = max(busiest, perZone[zone])
+	}
+	return busiest, nil
+}
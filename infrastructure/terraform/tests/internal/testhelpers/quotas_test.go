@@ -0,0 +1,107 @@
+package testhelpers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubQuotaSource serves quota values and usage keyed by quota name. Quotas
+// missing from limits are unlimited.
+type stubQuotaSource struct {
+	limits    map[string]float64
+	usages    map[string]int
+	limitErrs map[string]error
+}
+
+func (s stubQuotaSource) limit(q quota) (float64, bool, error) {
+	if err := s.limitErrs[q.name]; err != nil {
+		return 0, false, err
+	}
+	limit, ok := s.limits[q.name]
+	return limit, ok, nil
+}
+
+func (s stubQuotaSource) usage(q quota) (int, error) {
+	return s.usages[q.name], nil
+}
+
+func TestQuotaShortfalls(t *testing.T) {
+	t.Parallel()
+
+	defaults := map[string]float64{quotaVPCs: 5, quotaElasticIPs: 5, quotaNATGateways: 5}
+	vpcWithNat := QuotaRequirements{VPCs: 1, ElasticIPs: 1, NATGateways: 1}
+
+	testCases := []struct {
+		name         string
+		requirements QuotaRequirements
+		source       stubQuotaSource
+		expected     []string
+		expectedErrs int
+	}{
+		{
+			name:         "Headroom",
+			requirements: vpcWithNat,
+			source:       stubQuotaSource{limits: defaults, usages: map[string]int{quotaVPCs: 4, quotaElasticIPs: 2}},
+		},
+		{
+			name:         "VpcsExhausted",
+			requirements: vpcWithNat,
+			source:       stubQuotaSource{limits: defaults, usages: map[string]int{quotaVPCs: 5, quotaElasticIPs: 2}},
+			expected:     []string{"VPCs per Region: 5 of 5 in use, 1 more needed"},
+		},
+		{
+			name:         "EveryShortfallReported",
+			requirements: QuotaRequirements{VPCs: 2, ElasticIPs: 3, NATGateways: 1},
+			source:       stubQuotaSource{limits: defaults, usages: map[string]int{quotaVPCs: 4, quotaElasticIPs: 3, quotaNATGateways: 4}},
+			expected: []string{
+				"VPCs per Region: 4 of 5 in use, 2 more needed",
+				"EC2-VPC Elastic IPs: 3 of 5 in use, 3 more needed",
+			},
+		},
+		{
+			name:         "RaisedQuota",
+			requirements: vpcWithNat,
+			source:       stubQuotaSource{limits: map[string]float64{quotaVPCs: 20, quotaElasticIPs: 5}, usages: map[string]int{quotaVPCs: 12}},
+		},
+		{
+			name:         "NotRequiredIsNotChecked",
+			requirements: QuotaRequirements{VPCs: 1},
+			source:       stubQuotaSource{limits: defaults, usages: map[string]int{quotaElasticIPs: 9, quotaNATGateways: 9}},
+		},
+		{
+			name:         "UnlimitedThings",
+			requirements: QuotaRequirements{IoTThings: 1},
+			source:       stubQuotaSource{limits: defaults, usages: map[string]int{quotaIoTThings: 100000}},
+		},
+		{
+			name:         "LimitedThings",
+			requirements: QuotaRequirements{IoTThings: 1},
+			source:       stubQuotaSource{limits: map[string]float64{quotaIoTThings: 10}, usages: map[string]int{quotaIoTThings: 10}},
+			expected:     []string{"Things: 10 of 10 in use, 1 more needed"},
+		},
+		{
+			name:         "LookupErrorLetThrough",
+			requirements: vpcWithNat,
+			source: stubQuotaSource{
+				limits:    defaults,
+				usages:    map[string]int{quotaElasticIPs: 5},
+				limitErrs: map[string]error{quotaVPCs: errors.New("AccessDeniedException")},
+			},
+			expected:     []string{"EC2-VPC Elastic IPs: 5 of 5 in use, 1 more needed"},
+			expectedErrs: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			shortfalls, errs := quotaShortfalls(tc.requirements, tc.source)
+			assert.Equal(t, tc.expected, shortfalls)
+			assert.Len(t, errs, tc.expectedErrs)
+		})
+	}
+}
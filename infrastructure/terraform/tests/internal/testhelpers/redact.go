@@ -0,0 +1,176 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/testing"
+)
+
+const (
+	// LogSecretsEnvVar turns redaction off for local debugging, e.g.
+	// TEST_LOG_SECRETS=true. Never set it in CI.
+	LogSecretsEnvVar = "TEST_LOG_SECRETS"
+
+	// SensitiveVarPatternsEnvVar adds patterns, comma separated, to
+	// SensitiveVarPatterns, e.g. TEST_SENSITIVE_VAR_PATTERNS=credential,pin.
+	SensitiveVarPatternsEnvVar = "TEST_SENSITIVE_VAR_PATTERNS"
+
+	// redacted replaces every secret in the log.
+	redacted = "[REDACTED]"
+
+	// minSecretLength keeps short values, which are far more likely to be
+	// flags or counts than secrets, from being redacted wherever they appear.
+	minSecretLength = 4
+)
+
+// SensitiveVarPatterns are matched, case-insensitively, against var names: the
+// values of the vars whose name contains one of them are redacted from the
+// terraform output, as are values assigned to such names in it.
+var SensitiveVarPatterns = []string{"password", "secret", "token", "key"}
+
+// secretFormats match secrets by their shape, wherever they come from.
+var secretFormats = []*regexp.Regexp{
+	// AWS access key IDs
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// PEM private keys on a single line, e.g. JSON encoded in a plan
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+	// JWTs, such as the custom authorizer's tokens
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// Redactor masks secrets in the lines terratest logs. It is safe for
+// concurrent use, as terratest logs stdout and stderr of terraform from two
+// goroutines.
+type Redactor struct {
+	secrets     []string
+	assignments *regexp.Regexp
+
+	mu sync.Mutex
+	// inPrivateKey is set between the BEGIN and END lines of a PEM private
+	// key logged line by line
+	inPrivateKey bool
+}
+
+// NewRedactor returns a Redactor for the values of the sensitive vars.
+func NewRedactor(vars map[string]interface{}) *Redactor {
+	patterns := append(append([]string{}, SensitiveVarPatterns...), envList(SensitiveVarPatternsEnvVar)...)
+
+	var secrets []string
+	for name, value := range vars {
+		if matchesAny(name, patterns) {
+			secrets = append(secrets, stringLeaves(value)...)
+		}
+	}
+	// Longest first, so a secret containing another is masked whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	quoted := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		quoted = append(quoted, regexp.QuoteMeta(pattern))
+	}
+	return &Redactor{
+		secrets: secrets,
+		// name = "value", name: value and -var name=value, as found in
+		// plan diffs, outputs and command lines
+		assignments: regexp.MustCompile(`(?i)(\b[\w-]*(?:` + strings.Join(quoted, "|") + `)[\w-]*"?\s*[=:]\s*"?)(\[REDACTED\]|[^"\s,\]]+)`),
+	}
+}
+
+// Redact returns the line with every secret masked.
+func (r *Redactor) Redact(line string) string {
+	r.mu.Lock()
+	if r.inPrivateKey {
+		if privateKeyEnd.MatchString(line) {
+			r.inPrivateKey = false
+		} else {
+			r.mu.Unlock()
+			return redacted
+		}
+	} else if privateKeyBegin.MatchString(line) && !privateKeyEnd.MatchString(line) {
+		r.inPrivateKey = true
+	}
+	r.mu.Unlock()
+
+	for _, secret := range r.secrets {
+		line = strings.ReplaceAll(line, secret, redacted)
+	}
+	for _, format := range secretFormats {
+		line = format.ReplaceAllString(line, redacted)
+	}
+	return r.assignments.ReplaceAllStringFunc(line, func(assignment string) string {
+		match := r.assignments.FindStringSubmatch(assignment)
+		// Values terraform already hides or does not know yet
+		if match[2] == redacted || strings.HasPrefix(match[2], "(") {
+			return assignment
+		}
+		return match[1] + redacted
+	})
+}
+
+// Logf implements logger.TestLogger, logging like terratest's default logger
+// with the secrets masked.
+func (r *Redactor) Logf(t testing.TestingT, format string, args ...interface{}) {
+	logger.DoLog(t, 3, os.Stdout, r.Redact(fmt.Sprintf(format, args...)))
+}
+
+// redactingLogger returns the logger NewModuleOptionsInRegion gives every
+// module: one that redacts the sensitive vars, or terratest's default one when
+// TEST_LOG_SECRETS is set.
+func redactingLogger(vars map[string]interface{}) *logger.Logger {
+	if logSecrets, _ := strconv.ParseBool(os.Getenv(LogSecretsEnvVar)); logSecrets {
+		return logger.Default
+	}
+	return logger.New(NewRedactor(vars))
+}
+
+func matchesAny(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if strings.Contains(name, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringLeaves returns the strings in a var value, which may be a list or map
+// of them, that are long enough to be redacted.
+func stringLeaves(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if len(v) >= minSecretLength {
+			return []string{v}
+		}
+	case []string:
+		var leaves []string
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+		return leaves
+	case []interface{}:
+		var leaves []string
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+		return leaves
+	case map[string]interface{}:
+		var leaves []string
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+		return leaves
+	}
+	return nil
+}
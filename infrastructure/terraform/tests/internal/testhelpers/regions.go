@@ -0,0 +1,185 @@
+package testhelpers
+
+import (
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/require"
+)
+
+// ForbiddenRegionsEnvVar lists the regions, comma separated, that SelectRegion
+// never picks, e.g. FORBIDDEN_REGIONS=us-east-1,ap-northeast-3.
+const ForbiddenRegionsEnvVar = "FORBIDDEN_REGIONS"
+
+// requiredServices are the endpoint IDs of the services the modules deploy,
+// IoT Core, Amazon MQ for the broker and Greengrass for the edge gateways. A
+// region is only picked when the SDK's endpoint resolver knows all of them
+// there.
+var requiredServices = []string{"iot", "mq", "greengrass"}
+
+// ModuleInstanceTypes are the instance types the modules launch by default:
+// the bastion, the gateway targets and workers, and the EKS nodes.
+var ModuleInstanceTypes = []string{"t3.micro", "t3.medium"}
+
+// selectedRegionKey is the name the region SelectRegion picked in the setup
+// stage is saved under for the later stages of a staged run.
+const selectedRegionKey = "region"
+
+// ForbiddenRegions returns the regions listed in FORBIDDEN_REGIONS.
+func ForbiddenRegions() []string {
+	return envList(ForbiddenRegionsEnvVar)
+}
+
+// SelectRegion returns the region a test deploys to. AWS_DEFAULT_REGION is
+// used when it is set, and the test fails if it is forbidden or lacks one of
+// the required services. Otherwise a random stable region is picked among the
+// ones that have all of them and are not forbidden. The choice is logged.
+// With a SKIP_ variable set the region is picked in the setup stage and saved
+// with the test's working dirs, so the later stages run against the region the
+// modules were applied to.
+func SelectRegion(t *testing.T) string {
+	t.Helper()
+
+	if !test_structure.SkipStageEnvVarSet() {
+		return selectRegion(t)
+	}
+	dir := stagedTestDir(t)
+	test_structure.RunTestStage(t, StageSetup, func() {
+		test_structure.SaveString(t, dir, selectedRegionKey, selectRegion(t))
+	})
+	region := test_structure.LoadString(t, dir, selectedRegionKey)
+	t.Logf("Using region %s of the setup stage", region)
+	return region
+}
+
+func selectRegion(t *testing.T) string {
+	t.Helper()
+
+	forbidden := ForbiddenRegions()
+	eligible := eligibleRegions(partitionServiceRegions(endpoints.DefaultPartitions()), requiredServices, forbidden)
+
+	if region := os.Getenv(regionEnvVar); region != "" {
+		require.NotContains(t, forbidden, region, "%s=%s is listed in %s", regionEnvVar, region, ForbiddenRegionsEnvVar)
+		require.Contains(t, eligible, region, "%s=%s does not offer all of %v", regionEnvVar, region, requiredServices)
+		t.Logf("Using region %s from %s", region, regionEnvVar)
+		return region
+	}
+
+	// GetRandomStableRegion picks from every stable region when it is given
+	// no approved ones, which must not happen here
+	require.NotEmpty(t, eligible, "no region offers all of %v outside %s=%v", requiredServices, ForbiddenRegionsEnvVar, forbidden)
+	region := aws.GetRandomStableRegion(t, eligible, forbidden)
+	t.Logf("Selected region %s", region)
+	return region
+}
+
+// SelectAvailabilityZones returns count available zones of the region that
+// offer every one of the instance types, in name order, and fails when the
+// region has fewer. The choice is logged.
+func SelectAvailabilityZones(t *testing.T, region string, instanceTypes []string, count int) []string {
+	t.Helper()
+
+	ec2Client := ec2.New(NewSession(t, region))
+	offerings := map[string][]string{}
+	err := ec2Client.DescribeInstanceTypeOfferingsPages(&ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: awsSDK.String(ec2.LocationTypeAvailabilityZone),
+		Filters:      []*ec2.Filter{{Name: awsSDK.String("instance-type"), Values: awsSDK.StringSlice(instanceTypes)}},
+	}, func(page *ec2.DescribeInstanceTypeOfferingsOutput, _ bool) bool {
+		for _, offering := range page.InstanceTypeOfferings {
+			instanceType := awsSDK.StringValue(offering.InstanceType)
+			offerings[instanceType] = append(offerings[instanceType], awsSDK.StringValue(offering.Location))
+		}
+		return true
+	})
+	require.NoError(t, err)
+
+	// Zone names map to different zones in every account, so they are looked
+	// up in the account the tests run in
+	available, err := ec2Client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+	require.NoError(t, err)
+	names := make([]string, 0, len(available.AvailabilityZones))
+	for _, zone := range available.AvailabilityZones {
+		names = append(names, awsSDK.StringValue(zone.ZoneName))
+	}
+
+	zones := zonesOfferingAll(names, offerings, instanceTypes)
+	require.GreaterOrEqual(t, len(zones), count, "only %v in %s offer all of %v", zones, region, instanceTypes)
+	zones = zones[:count]
+	t.Logf("Selected availability zones %v in %s", zones, region)
+	return zones
+}
+
+// partitionServiceRegions maps the endpoint IDs of the services in the
+// partitions to the regions they are available in.
+func partitionServiceRegions(partitions []endpoints.Partition) map[string][]string {
+	serviceRegions := map[string][]string{}
+	for _, partition := range partitions {
+		for id, service := range partition.Services() {
+			for region := range service.Regions() {
+				serviceRegions[id] = append(serviceRegions[id], region)
+			}
+		}
+	}
+	return serviceRegions
+}
+
+// eligibleRegions returns the regions, sorted, that offer every service and
+// are not forbidden.
+func eligibleRegions(serviceRegions map[string][]string, services []string, forbidden []string) []string {
+	if len(services) == 0 {
+		return nil
+	}
+
+	var eligible []string
+	for _, region := range serviceRegions[services[0]] {
+		if slices.Contains(forbidden, region) {
+			continue
+		}
+		offersAll := true
+		for _, service := range services[1:] {
+			offersAll = offersAll && slices.Contains(serviceRegions[service], region)
+		}
+		if offersAll {
+			eligible = append(eligible, region)
+		}
+	}
+	sort.Strings(eligible)
+	return eligible
+}
+
+// zonesOfferingAll returns the zones, sorted, that offer every instance type
+// according to offerings, which maps instance types to their zones.
+func zonesOfferingAll(zones []string, offerings map[string][]string, instanceTypes []string) []string {
+	var offering []string
+	for _, zone := range zones {
+		offersAll := true
+		for _, instanceType := range instanceTypes {
+			offersAll = offersAll && slices.Contains(offerings[instanceType], zone)
+		}
+		if offersAll {
+			offering = append(offering, zone)
+		}
+	}
+	sort.Strings(offering)
+	return offering
+}
+
+// envList returns the comma separated values of the environment variable,
+// with blanks dropped.
+func envList(envVar string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(envVar), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEligibleRegions(t *testing.T) {
+	t.Parallel()
+
+	serviceRegions := map[string][]string{
+		"iot": {"us-west-2", "eu-west-1", "us-east-1", "ap-south-1"},
+		"mq":  {"us-east-1", "eu-west-1", "us-west-2", "sa-east-1"},
+		"ec2": {"us-west-2", "eu-west-1", "us-east-1", "ap-south-1", "sa-east-1"},
+	}
+
+	testCases := []struct {
+		name      string
+		services  []string
+		forbidden []string
+		expected  []string
+	}{
+		{name: "OfferingAll", services: []string{"iot", "mq"}, expected: []string{"eu-west-1", "us-east-1", "us-west-2"}},
+		{name: "Forbidden", services: []string{"iot", "mq"}, forbidden: []string{"us-east-1", "eu-west-1"}, expected: []string{"us-west-2"}},
+		{name: "AllForbidden", services: []string{"iot", "mq"}, forbidden: []string{"us-east-1", "eu-west-1", "us-west-2"}},
+		{name: "SingleService", services: []string{"iot"}, expected: []string{"ap-south-1", "eu-west-1", "us-east-1", "us-west-2"}},
+		{name: "UnknownService", services: []string{"iot", "greengrass"}},
+		{name: "NoServices"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, eligibleRegions(serviceRegions, tc.services, tc.forbidden))
+		})
+	}
+}
+
+func TestPartitionServiceRegions(t *testing.T) {
+	t.Parallel()
+
+	serviceRegions := partitionServiceRegions(endpoints.DefaultPartitions())
+	for _, service := range requiredServices {
+		assert.Contains(t, serviceRegions[service], defaultRegion, "the SDK does not know %s in %s", service, defaultRegion)
+	}
+	assert.Contains(t, eligibleRegions(serviceRegions, requiredServices, nil), defaultRegion)
+}
+
+func TestZonesOfferingAll(t *testing.T) {
+	t.Parallel()
+
+	zones := []string{"us-west-2d", "us-west-2a", "us-west-2b", "us-west-2c"}
+	offerings := map[string][]string{
+		"t3.micro":  {"us-west-2a", "us-west-2b", "us-west-2c"},
+		"t3.medium": {"us-west-2c", "us-west-2a", "us-west-2d"},
+	}
+
+	assert.Equal(t, []string{"us-west-2a", "us-west-2c"}, zonesOfferingAll(zones, offerings, []string{"t3.micro", "t3.medium"}))
+	assert.Equal(t, []string{"us-west-2a", "us-west-2b", "us-west-2c"}, zonesOfferingAll(zones, offerings, []string{"t3.micro"}))
+	assert.Nil(t, zonesOfferingAll(zones, offerings, []string{"t3.micro", "m7g.large"}))
+	assert.Equal(t, []string{"us-west-2a", "us-west-2b", "us-west-2c", "us-west-2d"}, zonesOfferingAll(zones, offerings, nil))
+}
+
+func TestForbiddenRegions(t *testing.T) {
+	t.Setenv(ForbiddenRegionsEnvVar, " us-east-1, ,ap-northeast-3,")
+	assert.Equal(t, []string{"us-east-1", "ap-northeast-3"}, ForbiddenRegions())
+
+	t.Setenv(ForbiddenRegionsEnvVar, "")
+	assert.Nil(t, ForbiddenRegions())
+}
+
+func TestSelectRegionStaged(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv(ForbiddenRegionsEnvVar, "")
+
+	t.Setenv("SKIP_teardown", "true")
+	t.Setenv(regionEnvVar, "us-east-1")
+	assert.Equal(t, "us-east-1", SelectRegion(t))
+
+	// A later stage keeps the region of the setup stage
+	t.Setenv("SKIP_setup", "true")
+	t.Setenv(regionEnvVar, "eu-west-1")
+	assert.Equal(t, "us-east-1", SelectRegion(t))
+}
@@ -0,0 +1,55 @@
+package testhelpers
+
+import (
+	"regexp"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// retryableErrors are transient AWS and provider failures seen in CI that go
+// away on their own. Keys are regexps matched against the terraform output and
+// values are the explanation logged when a retry is triggered. Quota errors
+// such as AddressLimitExceeded are deliberately not listed: retrying them only
+// delays the failure.
+var retryableErrors = map[string]string{
+	// API throttling, reported differently by each service
+	`RequestLimitExceeded`:     "EC2 API request limit exceeded.",
+	`Throttling(Exception)?: `: "AWS API throttling.",
+	`Rate exceeded`:            "AWS API rate limit exceeded.",
+	`TooManyRequestsException`: "AWS API throttling.",
+
+	// Capacity
+	`InsufficientAddressCapacity`: "No Elastic IP capacity for the NAT gateway in this AZ.",
+
+	// Eventual consistency right after a resource was created
+	`Invalid(VpcID|SubnetID|RouteTableID|NatGatewayID|InternetGatewayID|AllocationID|Group)\.NotFound`: "EC2 resource not yet visible after create.",
+	`The provisioning role cannot be assumed`:                                                          "IAM role not yet propagated to AWS IoT.",
+	`cannot be assumed by Lambda`:                                                                      "IAM role not yet propagated to Lambda.",
+
+	// NAT gateways release their addresses asynchronously during destroy
+	`DependencyViolation: .* has some mapped public address\(es\)`: "NAT gateway still releasing its Elastic IP.",
+}
+
+// RetryableTerraformErrors returns the retryable errors every test should use:
+// terratest's defaults merged with retryableErrors.
+func RetryableTerraformErrors() map[string]string {
+	retryable := map[string]string{}
+	for pattern, message := range terraform.DefaultRetryableTerraformErrors {
+		retryable[pattern] = message
+	}
+	for pattern, message := range retryableErrors {
+		retryable[pattern] = message
+	}
+	return retryable
+}
+
+// matchRetryableError returns the explanation of the first retryable error
+// matching the output, mirroring how terratest decides whether to retry.
+func matchRetryableError(output string) (string, bool) {
+	for pattern, message := range RetryableTerraformErrors() {
+		if regexp.MustCompile(pattern).MatchString(output) {
+			return message, true
+		}
+	}
+	return "", false
+}
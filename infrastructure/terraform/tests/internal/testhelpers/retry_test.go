@@ -0,0 +1,39 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryableTerraformErrors(t *testing.T) {
+	// Error output collected from failed CI runs
+	retryable := []string{
+		"Error: creating EC2 NAT Gateway: InsufficientAddressCapacity: Insufficient capacity.\n\tstatus code: 400, request id: 5e1c3f0a",
+		"Error: creating EC2 VPC: operation error EC2: CreateVpc, exceeded maximum number of attempts, 25, https response error StatusCode: 503, RequestID: 1b2c, api error RequestLimitExceeded: Request limit exceeded.",
+		"Error: reading IoT Thing Group (tt-abc123-iot-network-devices): ThrottlingException: Rate exceeded",
+		"Error: creating EC2 Route in Route Table (rtb-0a1b2c3d4e5f67890) with destination (0.0.0.0/0): InvalidRouteTableID.NotFound: The routeTable ID 'rtb-0a1b2c3d4e5f67890' does not exist",
+		"Error: creating EC2 Subnet: InvalidVpcID.NotFound: The vpc ID 'vpc-0a1b2c3d4e5f67890' does not exist",
+		"Error: creating Route in Route Table (rtb-0a1b2c3d4e5f67890) with destination (0.0.0.0/0): InvalidNatGatewayID.NotFound: The natGateway ID 'nat-0a1b2c3d4e5f67890' does not exist",
+		"Error: authorizing Security Group (sg-0a1b2c3d4e5f67890) Rule: InvalidGroup.NotFound: The security group 'sg-0a1b2c3d4e5f67890' does not exist",
+		"Error: deleting EC2 Internet Gateway (igw-0a1b2c3d4e5f67890): detaching EC2 Internet Gateway (igw-0a1b2c3d4e5f67890) from VPC (vpc-0a1b2c3d4e5f67890): DependencyViolation: Network vpc-0a1b2c3d4e5f67890 has some mapped public address(es). Please unmap those public address(es) before detaching the gateway.",
+		"Error: creating IoT Provisioning Template (tt-abc123-iot-network-fleet): InvalidRequestException: The provisioning role cannot be assumed by AWS IoT.",
+		"Error: Failed to query available provider packages",
+	}
+	for _, output := range retryable {
+		_, matched := matchRetryableError(output)
+		assert.True(t, matched, "expected output to be retried:\n%s", output)
+	}
+
+	// Failures that will not fix themselves and must not burn retries
+	fatal := []string{
+		"Error: creating EC2 EIP: AddressLimitExceeded: The maximum number of addresses has been reached.",
+		"Error: creating EC2 VPC: VpcLimitExceeded: The maximum number of VPCs has been reached.",
+		"Error: Invalid value for variable\n\ncidr_block must be a valid IPv4 CIDR between /16 and /24.",
+		"Error: creating EC2 Subnet: InvalidSubnet.Conflict: The CIDR '10.0.0.0/20' conflicts with another subnet",
+	}
+	for _, output := range fatal {
+		message, matched := matchRetryableError(output)
+		assert.False(t, matched, "expected output not to be retried, but it matched %q:\n%s", message, output)
+	}
+}
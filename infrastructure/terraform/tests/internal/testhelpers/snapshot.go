@@ -0,0 +1,170 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+// UpdateSnapshotsEnvVar regenerates golden plan snapshots instead of comparing
+// against them, as does passing -update to go test.
+const UpdateSnapshotsEnvVar = "UPDATE_SNAPSHOTS"
+
+var updateSnapshots = flag.Bool("update", false, "regenerate golden plan snapshots")
+
+// PlanSnapshot maps planned resource addresses to the attributes recorded for
+// them.
+type PlanSnapshot map[string]map[string]interface{}
+
+var timestampPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// NewPlanSnapshot records the managed resources of the plan. Only the tags and
+// the attributes listed for the resource type are kept, so computed IDs never
+// end up in the snapshot. Every occurrence of a key of replacements in a string
+// value is replaced by its value, and timestamps are masked, so that the
+// snapshot is the same from one run to the next.
+func NewPlanSnapshot(plan *terraform.PlanStruct, attributes map[string][]string, replacements map[string]string) PlanSnapshot {
+	snapshot := PlanSnapshot{}
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		if resource.Mode != "managed" {
+			continue
+		}
+
+		recorded := map[string]interface{}{}
+		for _, name := range append([]string{"tags"}, attributes[resource.Type]...) {
+			if value, ok := resource.AttributeValues[name]; ok && value != nil {
+				recorded[name] = normalizeSnapshotValue(value, replacements)
+			}
+		}
+		snapshot[address] = recorded
+	}
+	return snapshot
+}
+
+func normalizeSnapshotValue(value interface{}, replacements map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		for old, replacement := range replacements {
+			if old != "" {
+				v = strings.ReplaceAll(v, old, replacement)
+			}
+		}
+		return timestampPattern.ReplaceAllString(v, "<timestamp>")
+	case map[string]interface{}:
+		normalized := map[string]interface{}{}
+		for key, item := range v {
+			normalized[key] = normalizeSnapshotValue(item, replacements)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeSnapshotValue(item, replacements)
+		}
+		return normalized
+	}
+	return value
+}
+
+// AssertPlanSnapshot compares the snapshot against the golden file and fails
+// with a line per added, removed or changed resource attribute. With -update or
+// UPDATE_SNAPSHOTS set the golden file is rewritten instead.
+func AssertPlanSnapshot(t *testing.T, snapshot PlanSnapshot, goldenPath string) {
+	t.Helper()
+
+	if update, _ := strconv.ParseBool(os.Getenv(UpdateSnapshotsEnvVar)); update || *updateSnapshots {
+		encoded, err := json.MarshalIndent(snapshot, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(goldenPath), 0o755))
+		require.NoError(t, os.WriteFile(goldenPath, append(encoded, '\n'), 0o644))
+		t.Logf("Updated plan snapshot %s", goldenPath)
+		return
+	}
+
+	encoded, err := os.ReadFile(goldenPath)
+	require.NoError(t, err, "missing plan snapshot, run with %s=1 to create it", UpdateSnapshotsEnvVar)
+	var golden PlanSnapshot
+	require.NoError(t, json.Unmarshal(encoded, &golden), "plan snapshot %s is not valid JSON", goldenPath)
+
+	if diff := diffPlanSnapshots(golden, snapshot); len(diff) > 0 {
+		t.Errorf("plan differs from snapshot %s (run with %s=1 if the change is intended):\n%s",
+			goldenPath, UpdateSnapshotsEnvVar, strings.Join(diff, "\n"))
+	}
+}
+
+// diffPlanSnapshots lists the differences between two snapshots, sorted by
+// resource address.
+func diffPlanSnapshots(expected, actual PlanSnapshot) []string {
+	addresses := map[string]bool{}
+	for address := range expected {
+		addresses[address] = true
+	}
+	for address := range actual {
+		addresses[address] = true
+	}
+	sorted := make([]string, 0, len(addresses))
+	for address := range addresses {
+		sorted = append(sorted, address)
+	}
+	sort.Strings(sorted)
+
+	var diff []string
+	for _, address := range sorted {
+		expectedAttributes, inExpected := expected[address]
+		actualAttributes, inActual := actual[address]
+		switch {
+		case !inExpected:
+			diff = append(diff, fmt.Sprintf("+ %s (new resource)", address))
+		case !inActual:
+			diff = append(diff, fmt.Sprintf("- %s (removed resource)", address))
+		default:
+			diff = append(diff, diffSnapshotAttributes(address, expectedAttributes, actualAttributes)...)
+		}
+	}
+	return diff
+}
+
+func diffSnapshotAttributes(address string, expected, actual map[string]interface{}) []string {
+	names := map[string]bool{}
+	for name := range expected {
+		names[name] = true
+	}
+	for name := range actual {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var diff []string
+	for _, name := range sorted {
+		if !reflect.DeepEqual(expected[name], actual[name]) {
+			diff = append(diff, fmt.Sprintf("~ %s.%s: %s => %s", address, name, snapshotJSON(expected[name]), snapshotJSON(actual[name])))
+		}
+	}
+	return diff
+}
+
+func snapshotJSON(value interface{}) string {
+	if value == nil {
+		return "(unset)"
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
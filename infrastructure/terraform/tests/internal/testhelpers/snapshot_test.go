@@ -0,0 +1,66 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPlanSnapshot(t *testing.T) {
+	plan := &terraform.PlanStruct{
+		ResourcePlannedValuesMap: map[string]*tfjson.StateResource{
+			"aws_vpc.main": {
+				Mode: tfjson.ManagedResourceMode,
+				Type: "aws_vpc",
+				AttributeValues: map[string]interface{}{
+					"cidr_block": "10.0.0.0/16",
+					"id":         nil,
+					"arn":        "arn:aws:ec2:us-west-2:123456789012:vpc/vpc-0a1b2c3d4e5f67890",
+					"tags": map[string]interface{}{
+						"Name":      "tt-abc123-iot-network-vpc",
+						"CreatedAt": "2024-03-01T12:34:56Z",
+					},
+				},
+			},
+			"data.aws_availability_zones.available": {
+				Mode: tfjson.DataResourceMode,
+				Type: "aws_availability_zones",
+			},
+		},
+	}
+
+	snapshot := NewPlanSnapshot(plan, map[string][]string{"aws_vpc": {"cidr_block", "id"}}, map[string]string{"tt-abc123": "${name_prefix}"})
+
+	assert.Equal(t, PlanSnapshot{
+		"aws_vpc.main": {
+			"cidr_block": "10.0.0.0/16",
+			"tags": map[string]interface{}{
+				"Name":      "${name_prefix}-iot-network-vpc",
+				"CreatedAt": "<timestamp>",
+			},
+		},
+	}, snapshot)
+}
+
+func TestDiffPlanSnapshots(t *testing.T) {
+	golden := PlanSnapshot{
+		"aws_subnet.public[0]":    {"cidr_block": "10.0.0.0/20"},
+		"aws_subnet.public[1]":    {"cidr_block": "10.0.16.0/20"},
+		"aws_nat_gateway.main[0]": {},
+	}
+	planned := PlanSnapshot{
+		"aws_subnet.public[0]": {"cidr_block": "10.0.0.0/20"},
+		"aws_subnet.public[1]": {"cidr_block": "10.0.32.0/20"},
+		"aws_eip.nat[0]":       {"domain": "vpc"},
+	}
+
+	assert.Equal(t, []string{
+		"+ aws_eip.nat[0] (new resource)",
+		"- aws_nat_gateway.main[0] (removed resource)",
+		`~ aws_subnet.public[1].cidr_block: "10.0.16.0/20" => "10.0.32.0/20"`,
+	}, diffPlanSnapshots(golden, planned))
+
+	assert.Empty(t, diffPlanSnapshots(golden, golden))
+}
@@ -0,0 +1,39 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/require"
+)
+
+// StateResources returns the managed resources in the state of the module,
+// including those of child modules, keyed by address.
+func StateResources(t *testing.T, terraformOptions *terraform.Options) map[string]*tfjson.StateResource {
+	t.Helper()
+
+	var state tfjson.State
+	require.NoError(t, json.Unmarshal([]byte(terraform.Show(t, terraformOptions)), &state))
+
+	resources := map[string]*tfjson.StateResource{}
+	if state.Values != nil {
+		collectStateResources(state.Values.RootModule, resources)
+	}
+	return resources
+}
+
+func collectStateResources(module *tfjson.StateModule, resources map[string]*tfjson.StateResource) {
+	if module == nil {
+		return
+	}
+	for _, resource := range module.Resources {
+		if resource.Mode == tfjson.ManagedResourceMode {
+			resources[resource.Address] = resource
+		}
+	}
+	for _, child := range module.ChildModules {
+		collectStateResources(child, resources)
+	}
+}
@@ -0,0 +1,213 @@
+package testhelpers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RequiredTagKeys are the tags finance and ops require on every resource.
+var RequiredTagKeys = []string{"Project", "Environment", "Owner", "CostCenter"}
+
+// NewTaggingClient creates a Resource Groups Tagging API client for the given
+// region.
+func NewTaggingClient(t *testing.T, region string) *resourcegroupstaggingapi.ResourceGroupsTaggingAPI {
+	return resourcegroupstaggingapi.New(NewSession(t, region))
+}
+
+// ResourcesWithNamePrefix returns the ARNs of every resource in the region
+// whose Name tag starts with the prefix, e.g. the one
+// NewModuleOptionsInRegion injected into a test.
+func ResourcesWithNamePrefix(t *testing.T, region string, prefix string) []string {
+	var arns []string
+	for arn, tags := range taggedResources(t, region, []*resourcegroupstaggingapi.TagFilter{{Key: awsSDK.String("Name")}}) {
+		if strings.HasPrefix(tags["Name"], prefix) {
+			arns = append(arns, arn)
+		}
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// ec2ResourceTypes maps the prefix of an EC2 resource ID to the resource type
+// in its ARN, for the resources whose state or outputs only have the ID.
+var ec2ResourceTypes = map[string]string{
+	"vpc-":      "vpc",
+	"subnet-":   "subnet",
+	"rtb-":      "route-table",
+	"igw-":      "internet-gateway",
+	"nat-":      "natgateway",
+	"eipalloc-": "elastic-ip",
+	"sg-":       "security-group",
+	"fl-":       "vpc-flow-log",
+	"acl-":      "network-acl",
+	"vpce-":     "vpc-endpoint",
+	"i-":        "instance",
+	"vol-":      "volume",
+	"lt-":       "launch-template",
+}
+
+// taggingBatchSize is the most ARNs one GetResources call looks up.
+const taggingBatchSize = 100
+
+// TaggableResources returns the resources in the state of the module that
+// Terraform tags, i.e. those with a tags_all attribute, as their ARN or, when
+// the state has none, their ID. It is what AssertRequiredTags is usually
+// given.
+func TaggableResources(t *testing.T, terraformOptions *terraform.Options) []string {
+	t.Helper()
+
+	var resources []string
+	for _, resource := range StateResources(t, terraformOptions) {
+		if _, ok := resource.AttributeValues["tags_all"]; !ok {
+			continue
+		}
+		if arn, _ := resource.AttributeValues["arn"].(string); arn != "" {
+			resources = append(resources, arn)
+		} else if id, _ := resource.AttributeValues["id"].(string); id != "" {
+			resources = append(resources, id)
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// AssertRequiredTags fails listing every resource that is missing one of the
+// required tag keys or has it set to an empty value. Resources are given as
+// ARNs or as the bare IDs of EC2 resources, e.g. vpc-0a1b2c, and only those
+// are looked up in the Tagging API. The Tagging API takes a while to learn
+// about new resources and tags, so it is polled until every resource shows up
+// with every key; resources that never show up are reported as missing every
+// key, the same as resources that have never been tagged.
+func AssertRequiredTags(t *testing.T, region string, resourceARNsOrIDs []string, requiredKeys []string) {
+	t.Helper()
+
+	var (
+		arns       []string
+		violations []string
+	)
+	checked := map[string]bool{}
+	accountId := ""
+	for _, resource := range resourceARNsOrIDs {
+		arn := resource
+		if !strings.HasPrefix(resource, "arn:") {
+			if accountId == "" {
+				accountId = AccountId(t, NewSession(t, region))
+			}
+			var ok bool
+			if arn, ok = ec2ResourceArn(region, accountId, resource); !ok {
+				violations = append(violations, resource+": not an ARN or the ID of an EC2 resource")
+				continue
+			}
+		}
+		if !checked[arn] {
+			checked[arn] = true
+			arns = append(arns, arn)
+		}
+	}
+
+	missingKeys := func(tagged map[string]map[string]string) map[string][]string {
+		missing := map[string][]string{}
+		for _, arn := range arns {
+			tags, found := tagged[arn]
+			for _, key := range requiredKeys {
+				if !found || tags[key] == "" {
+					missing[arn] = append(missing[arn], key)
+				}
+			}
+		}
+		return missing
+	}
+
+	var missing map[string][]string
+	PollUntil(t, fmt.Sprintf("waiting for the tags of %d resources", len(arns)), func() (bool, error) {
+		tagged, err := tagsOfResources(t, region, arns)
+		if err != nil {
+			return false, err
+		}
+		missing = missingKeys(tagged)
+		return len(missing) == 0, nil
+	})
+
+	for _, arn := range arns {
+		if keys, ok := missing[arn]; ok {
+			violations = append(violations, arn+": missing "+strings.Join(keys, ", "))
+		}
+	}
+
+	recordViolations(t, "", "tags", violations)
+	assert.Empty(t, violations, "resources missing required tags %v:\n%s", requiredKeys, strings.Join(violations, "\n"))
+}
+
+// ec2ResourceArn returns the ARN of the EC2 resource with the ID, and false
+// when the ID is not one of an EC2 resource type in ec2ResourceTypes.
+func ec2ResourceArn(region string, accountId string, id string) (string, bool) {
+	for prefix, resourceType := range ec2ResourceTypes {
+		if strings.HasPrefix(id, prefix) {
+			return fmt.Sprintf("arn:aws:ec2:%s:%s:%s/%s", region, accountId, resourceType, id), true
+		}
+	}
+	return "", false
+}
+
+// tagsOfResources returns the tags of the resources with the ARNs, keyed by
+// ARN. Resources the Tagging API does not know about are left out.
+func tagsOfResources(t *testing.T, region string, arns []string) (map[string]map[string]string, error) {
+	client := NewTaggingClient(t, region)
+
+	resources := map[string]map[string]string{}
+	for start := 0; start < len(arns); start += taggingBatchSize {
+		end := start + taggingBatchSize
+		if end > len(arns) {
+			end = len(arns)
+		}
+		// Looking resources up by ARN cannot be paginated, and does not
+		// need to be
+		output, err := client.GetResources(&resourcegroupstaggingapi.GetResourcesInput{ResourceARNList: awsSDK.StringSlice(arns[start:end])})
+		if err != nil {
+			return nil, err
+		}
+		for arn, tags := range tagMappings(output.ResourceTagMappingList) {
+			resources[arn] = tags
+		}
+	}
+	return resources, nil
+}
+
+// taggedResources returns the tags of every resource in the region matching
+// the filters, keyed by ARN.
+func taggedResources(t *testing.T, region string, filters []*resourcegroupstaggingapi.TagFilter) map[string]map[string]string {
+	client := NewTaggingClient(t, region)
+
+	resources := map[string]map[string]string{}
+	err := client.GetResourcesPages(&resourcegroupstaggingapi.GetResourcesInput{TagFilters: filters},
+		func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+			for arn, tags := range tagMappings(page.ResourceTagMappingList) {
+				resources[arn] = tags
+			}
+			return true
+		})
+	require.NoError(t, err)
+	return resources
+}
+
+// tagMappings returns the tags of the resources in a GetResources response,
+// keyed by ARN.
+func tagMappings(mappings []*resourcegroupstaggingapi.ResourceTagMapping) map[string]map[string]string {
+	resources := map[string]map[string]string{}
+	for _, mapping := range mappings {
+		tags := map[string]string{}
+		for _, tag := range mapping.Tags {
+			tags[awsSDK.StringValue(tag.Key)] = awsSDK.StringValue(tag.Value)
+		}
+		resources[awsSDK.StringValue(mapping.ResourceARN)] = tags
+	}
+	return resources
+}
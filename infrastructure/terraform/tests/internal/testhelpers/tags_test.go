@@ -0,0 +1,27 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEc2ResourceArn(t *testing.T) {
+	testCases := []struct {
+		id       string
+		expected string
+		ok       bool
+	}{
+		{id: "vpc-0a1b2c3d", expected: "arn:aws:ec2:us-west-2:123456789012:vpc/vpc-0a1b2c3d", ok: true},
+		{id: "rtb-0a1b2c3d", expected: "arn:aws:ec2:us-west-2:123456789012:route-table/rtb-0a1b2c3d", ok: true},
+		{id: "nat-0a1b2c3d", expected: "arn:aws:ec2:us-west-2:123456789012:natgateway/nat-0a1b2c3d", ok: true},
+		{id: "eipalloc-0a1b2c3d", expected: "arn:aws:ec2:us-west-2:123456789012:elastic-ip/eipalloc-0a1b2c3d", ok: true},
+		{id: "iot-network-telemetry", ok: false},
+	}
+
+	for _, tc := range testCases {
+		arn, ok := ec2ResourceArn("us-west-2", "123456789012", tc.id)
+		assert.Equal(t, tc.ok, ok, tc.id)
+		assert.Equal(t, tc.expected, arn, tc.id)
+	}
+}
@@ -0,0 +1,300 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	terratesting "github.com/gruntwork-io/terratest/modules/testing"
+)
+
+// DestroyBudgetEnvVar is how long destroying a module is given before the go
+// test -timeout deadline, as a Go duration, e.g. TERRATEST_DESTROY_BUDGET=20m.
+// A module whose test is still running that close to the deadline is destroyed
+// there and then, and a test about to apply a module with less time left fails
+// without applying it.
+const DestroyBudgetEnvVar = "TERRATEST_DESTROY_BUDGET"
+
+const (
+	// A VPC with NAT gateways per AZ takes around ten minutes to destroy
+	defaultDestroyBudget = 15 * time.Minute
+
+	// emergencyLockTimeout lets a destroy started ahead of the deadline or on
+	// a signal wait for an apply of the same module to let go of the state
+	emergencyLockTimeout = "10m"
+)
+
+// DestroyBudget returns the budget set in TERRATEST_DESTROY_BUDGET, or
+// defaultDestroyBudget when it is unset or invalid.
+func DestroyBudget() time.Duration {
+	return durationFromEnv(DestroyBudgetEnvVar, defaultDestroyBudget)
+}
+
+// destroyer destroys a module and returns the terraform output.
+type destroyer func(t terratesting.TestingT, terraformOptions *terraform.Options) (string, error)
+
+// teardownRegistry holds the modules that may have been applied and are not
+// destroyed yet, so that they can be destroyed when the test binary is about to
+// be killed. It is safe for concurrent use.
+type teardownRegistry struct {
+	destroy destroyer
+
+	mu      sync.Mutex
+	modules map[*terraform.Options]*registeredModule
+	// failed holds the errors of destroys ahead of the deadline until the
+	// test that registered the module reports them
+	failed map[*terraform.Options]error
+}
+
+// registeredModule is a module in the teardownRegistry. It is destroyed at
+// most once, by whichever of the test's cleanup, the deadline or a signal gets
+// to it first; the others wait for that destroy and get its result.
+type registeredModule struct {
+	registry         *teardownRegistry
+	terraformOptions *terraform.Options
+	// owner is the name of the test that built the options
+	owner string
+
+	once   sync.Once
+	output string
+	err    error
+
+	mu        sync.Mutex
+	stopTimer func() bool
+}
+
+func newTeardownRegistry(destroy destroyer) *teardownRegistry {
+	return &teardownRegistry{
+		destroy: destroy,
+		modules: map[*terraform.Options]*registeredModule{},
+		failed:  map[*terraform.Options]error{},
+	}
+}
+
+// inFlight is the registry of the modules applied through ApplyModule or
+// registered with RegisterTeardown.
+var inFlight = newTeardownRegistry(func(t terratesting.TestingT, terraformOptions *terraform.Options) (string, error) {
+	// Not waiting for a terraform slot: the tests holding them are the ones
+	// running out of time
+	envVarsMu.Lock()
+	destroyOptions, err := terraformOptions.Clone()
+	envVarsMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if err := refreshCredentials(destroyOptions); err != nil {
+		return "", err
+	}
+	destroyOptions.LockTimeout = emergencyLockTimeout
+	return terraform.DestroyE(t, destroyOptions)
+})
+
+// register adds the module of the options, built by the owner test, to the
+// registry, unless it is registered already.
+func (r *teardownRegistry) register(terraformOptions *terraform.Options, owner string) *registeredModule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if module, ok := r.modules[terraformOptions]; ok {
+		return module
+	}
+	module := &registeredModule{registry: r, terraformOptions: terraformOptions, owner: owner}
+	r.modules[terraformOptions] = module
+	return module
+}
+
+// lookup returns the registered module of the options, or nil when the module
+// was never applied or is destroyed already.
+func (r *teardownRegistry) lookup(terraformOptions *terraform.Options) *registeredModule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.modules[terraformOptions]
+}
+
+// failure returns the error destroying the module of the options ahead of the
+// deadline failed with, if it did, and forgets it.
+func (r *teardownRegistry) failure(terraformOptions *terraform.Options) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.failed[terraformOptions]
+	delete(r.failed, terraformOptions)
+	return err
+}
+
+// destroyAll destroys every registered module concurrently and returns the
+// errors of those that failed.
+func (r *teardownRegistry) destroyAll(t terratesting.TestingT) []error {
+	r.mu.Lock()
+	modules := make([]*registeredModule, 0, len(r.modules))
+	for _, module := range r.modules {
+		modules = append(modules, module)
+	}
+	r.mu.Unlock()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errors []error
+	)
+	for _, module := range modules {
+		module := module
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := module.destroy(t); err != nil {
+				mu.Lock()
+				errors = append(errors, fmt.Errorf("%s: %w", module.terraformOptions.TerraformDir, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors
+}
+
+// destroy destroys the module unless that already happened, and removes it
+// from the registry.
+func (m *registeredModule) destroy(t terratesting.TestingT) (string, error) {
+	m.once.Do(func() {
+		m.mu.Lock()
+		if m.stopTimer != nil {
+			m.stopTimer()
+		}
+		m.mu.Unlock()
+
+		m.output, m.err = m.registry.destroy(t, m.terraformOptions)
+
+		m.registry.mu.Lock()
+		delete(m.registry.modules, m.terraformOptions)
+		m.registry.mu.Unlock()
+	})
+	return m.output, m.err
+}
+
+// destroyBefore arranges for the module to be destroyed budget before the
+// deadline, and reports false, arranging nothing, when that is already past.
+// The test's cleanup cancels it by destroying the module itself. The test that
+// applied the module, often a subtest, has usually finished by the deadline,
+// so the destroy logs on behalf of the owner without going through any test,
+// and a failure is left in the registry for the owner's cleanup to report.
+func (m *registeredModule) destroyBefore(deadline time.Time, budget time.Duration) bool {
+	start := time.Until(deadline.Add(-budget))
+	if start <= 0 {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopTimer != nil {
+		return true
+	}
+	m.stopTimer = time.AfterFunc(start, func() {
+		owner := detachedT{name: m.owner}
+		logger.Default.Logf(owner, "Test deadline %s is %s away, destroying %s ahead of it", deadline.Format(time.RFC3339), budget, m.terraformOptions.TerraformDir)
+		if _, err := m.destroy(owner); err != nil {
+			logger.Default.Logf(owner, "Failed to destroy %s ahead of the test deadline: %v", m.terraformOptions.TerraformDir, err)
+			m.registry.mu.Lock()
+			m.registry.failed[m.terraformOptions] = err
+			m.registry.mu.Unlock()
+		}
+	}).Stop
+	return true
+}
+
+// RegisterTeardown makes the test destroy the module of the options when it
+// finishes, ahead of the test deadline or on a signal. ApplyModule calls it,
+// tests that apply a module with terraform directly call it before the apply.
+// Only options built by NewModuleOptionsInRegion are registered, and none in
+// plan-only mode or with the teardown stage skipped.
+func RegisterTeardown(terraformOptions *terraform.Options) {
+	if owner, ok := moduleOptions.Load(terraformOptions); ok && teardownEnabled() {
+		inFlight.register(terraformOptions, owner.(string))
+	}
+}
+
+// teardownEnabled reports whether modules are destroyed at all, which they are
+// not in plan-only mode or with the teardown stage skipped.
+func teardownEnabled() bool {
+	return !IsPlanOnly() && os.Getenv(test_structure.SKIP_STAGE_ENV_VAR_PREFIX+StageTeardown) == ""
+}
+
+// armDeadlineTeardown is called before applying a module: it fails the test
+// when there is no longer enough time left to destroy the module before the go
+// test -timeout deadline, and otherwise destroys the module ahead of that
+// deadline if the test is still running by then. Failing rather than skipping
+// keeps a -timeout too short for the suite, such as go test's default of ten
+// minutes, from passing a run in which nothing was applied.
+func armDeadlineTeardown(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if err := armDeadlineTeardownE(t, terraformOptions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// armDeadlineTeardownE is armDeadlineTeardown returning the error instead of
+// failing the test.
+func armDeadlineTeardownE(t *testing.T, terraformOptions *terraform.Options) error {
+	module := inFlight.lookup(terraformOptions)
+	deadline, ok := t.Deadline()
+	if module == nil || !ok || !teardownEnabled() {
+		return nil
+	}
+	if budget := DestroyBudget(); !module.destroyBefore(deadline, budget) {
+		return fmt.Errorf("only %s left before the test deadline, less than the %s it takes to destroy the module, set %s or raise -timeout",
+			time.Until(deadline).Round(time.Second), budget, DestroyBudgetEnvVar)
+	}
+	return nil
+}
+
+// DestroyOnSignal destroys every module applied and not destroyed yet when the
+// test binary receives SIGTERM or SIGINT, as CI runners send on cancelling a
+// job, and then exits with status 1. TestMain calls it before running the
+// tests, and the returned func once they are done.
+func DestroyOnSignal() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Received %s, destroying the modules under test before exiting\n", sig)
+			for _, err := range inFlight.destroyAll(detachedT{name: "teardown"}) {
+				fmt.Fprintf(os.Stderr, "Failed to destroy %v\n", err)
+			}
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// detachedT stands in for a test that may have finished already, such as the
+// tests a signal interrupted, logging to stderr.
+type detachedT struct {
+	name string
+}
+
+func (detachedT) Fail()                     {}
+func (detachedT) FailNow()                  {}
+func (detachedT) Fatal(args ...interface{}) { fmt.Fprintln(os.Stderr, args...) }
+func (detachedT) Fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+func (detachedT) Error(args ...interface{}) { fmt.Fprintln(os.Stderr, args...) }
+func (detachedT) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+func (d detachedT) Name() string { return d.name }
@@ -0,0 +1,140 @@
+package testhelpers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	terratesting "github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDestroyer records the modules it destroys instead of running terraform.
+type fakeDestroyer struct {
+	mu        sync.Mutex
+	destroyed []string
+	err       error
+}
+
+func (f *fakeDestroyer) destroy(t terratesting.TestingT, terraformOptions *terraform.Options) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.destroyed = append(f.destroyed, terraformOptions.TerraformDir)
+	return "Destroy complete!", f.err
+}
+
+func (f *fakeDestroyer) destroyedModules() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.destroyed...)
+}
+
+func TestTeardownBeforeDeadline(t *testing.T) {
+	fake := &fakeDestroyer{}
+	registry := newTeardownRegistry(fake.destroy)
+	module := registry.register(&terraform.Options{TerraformDir: "vpc"}, t.Name())
+
+	// The deadline is near: only 50ms are left beyond the destroy budget
+	deadline := time.Now().Add(time.Minute + 50*time.Millisecond)
+	require.True(t, module.destroyBefore(deadline, time.Minute))
+
+	assert.Eventually(t, func() bool { return len(fake.destroyedModules()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"module not destroyed ahead of the deadline")
+	assert.Nil(t, registry.lookup(module.terraformOptions), "destroyed module still registered")
+
+	// The test's own cleanup does not destroy it again
+	output, err := module.destroy(t)
+	require.NoError(t, err)
+	assert.Equal(t, "Destroy complete!", output)
+	assert.Equal(t, []string{"vpc"}, fake.destroyedModules())
+}
+
+func TestTeardownBeforeDeadlineFailure(t *testing.T) {
+	fake := &fakeDestroyer{err: errors.New("DependencyViolation")}
+	registry := newTeardownRegistry(fake.destroy)
+	module := registry.register(&terraform.Options{TerraformDir: "vpc"}, t.Name())
+
+	require.True(t, module.destroyBefore(time.Now().Add(time.Minute+50*time.Millisecond), time.Minute))
+
+	// The failure waits in the registry for the owner's cleanup, once only
+	var err error
+	assert.Eventually(t, func() bool {
+		err = registry.failure(module.terraformOptions)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond, "failure destroying ahead of the deadline not recorded")
+	assert.ErrorContains(t, err, "DependencyViolation")
+	assert.NoError(t, registry.failure(module.terraformOptions))
+}
+
+func TestTeardownDeadlineTooClose(t *testing.T) {
+	fake := &fakeDestroyer{}
+	module := newTeardownRegistry(fake.destroy).register(&terraform.Options{TerraformDir: "eks"}, t.Name())
+
+	assert.False(t, module.destroyBefore(time.Now().Add(time.Minute), 2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, fake.destroyedModules())
+}
+
+func TestTeardownCleanupStopsDeadline(t *testing.T) {
+	fake := &fakeDestroyer{}
+	module := newTeardownRegistry(fake.destroy).register(&terraform.Options{TerraformDir: "gateway"}, t.Name())
+
+	require.True(t, module.destroyBefore(time.Now().Add(time.Minute+100*time.Millisecond), time.Minute))
+	_, err := module.destroy(t)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"gateway"}, fake.destroyedModules())
+}
+
+func TestTeardownDestroyAll(t *testing.T) {
+	fake := &fakeDestroyer{err: errors.New("DependencyViolation")}
+	registry := newTeardownRegistry(fake.destroy)
+	for _, dir := range []string{"vpc", "iot-core", "mqtt"} {
+		registry.register(&terraform.Options{TerraformDir: dir}, t.Name())
+	}
+
+	errs := registry.destroyAll(detachedT{name: "teardown"})
+	assert.Len(t, errs, 3)
+	assert.ElementsMatch(t, []string{"vpc", "iot-core", "mqtt"}, fake.destroyedModules())
+
+	// Nothing is left to destroy on a second signal
+	assert.Empty(t, registry.destroyAll(detachedT{name: "teardown"}))
+	assert.Len(t, fake.destroyedModules(), 3)
+}
+
+func TestTeardownRegisterTwice(t *testing.T) {
+	fake := &fakeDestroyer{}
+	registry := newTeardownRegistry(fake.destroy)
+	options := &terraform.Options{TerraformDir: "storage"}
+
+	module := registry.register(options, t.Name())
+	require.True(t, module.destroyBefore(time.Now().Add(time.Hour), time.Minute))
+	assert.Same(t, module, registry.register(options, t.Name()), "registering an applied module again replaced it")
+	_, err := module.destroy(t)
+	require.NoError(t, err)
+}
+
+func TestRegisterTeardownOnlyModuleOptions(t *testing.T) {
+	t.Setenv(PlanOnlyEnvVar, "false")
+	t.Setenv("SKIP_"+StageTeardown, "")
+
+	planned := &terraform.Options{TerraformDir: "planned"}
+	RegisterTeardown(planned)
+	assert.Nil(t, inFlight.lookup(planned), "options not built by NewModuleOptionsInRegion were registered")
+
+	applied := &terraform.Options{TerraformDir: "applied"}
+	moduleOptions.Store(applied, t.Name())
+	t.Cleanup(func() {
+		moduleOptions.Delete(applied)
+		inFlight.mu.Lock()
+		delete(inFlight.modules, applied)
+		inFlight.mu.Unlock()
+	})
+	assert.Nil(t, inFlight.lookup(applied), "module registered before it was applied")
+	RegisterTeardown(applied)
+	assert.NotNil(t, inFlight.lookup(applied), "module not registered for teardown")
+}
@@ -0,0 +1,131 @@
+package testhelpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+)
+
+// TLSCABundleEnvVar points at a PEM bundle of the CA certificates that
+// endpoint chains are verified against instead of the system roots, for
+// deployments behind a private CA.
+const TLSCABundleEnvVar = "TLS_CA_BUNDLE"
+
+const (
+	// A freshly created endpoint can take a few minutes to resolve.
+	tlsDialRetries            = 20
+	tlsDialTimeBetweenRetries = 15 * time.Second
+	tlsDialTimeout            = 10 * time.Second
+)
+
+// AssertTLSEndpoint connects to hostPort with the host as SNI and fails unless
+// the endpoint presents a chain that verifies against the trusted roots, a
+// leaf valid for the host whose common name or one of its DNS names is
+// expectedCN, and a leaf that does not expire within minDaysValid days. The
+// dial is retried while the endpoint cannot be reached yet, which is reported
+// separately from an invalid chain.
+func AssertTLSEndpoint(t *testing.T, hostPort string, expectedCN string, minDaysValid int) {
+	t.Helper()
+	AssertTLSEndpointWithCABundle(t, hostPort, os.Getenv(TLSCABundleEnvVar), expectedCN, minDaysValid)
+}
+
+// AssertTLSEndpointWithCABundle is AssertTLSEndpoint with the chain verified
+// against the CA certificates in the PEM bundle at caBundlePath, in the format
+// of TLS_CA_BUNDLE, for endpoints serving a certificate the test issued
+// itself. An empty caBundlePath means the system roots.
+func AssertTLSEndpointWithCABundle(t *testing.T, hostPort string, caBundlePath string, expectedCN string, minDaysValid int) {
+	t.Helper()
+
+	host, _, err := net.SplitHostPort(hostPort)
+	require.NoError(t, err)
+
+	var chain []*x509.Certificate
+	_, err = retry.DoWithRetryE(t, "TLS handshake with "+hostPort, tlsDialRetries, tlsDialTimeBetweenRetries, func() (string, error) {
+		var err error
+		chain, err = presentedChain(hostPort, host)
+		return "", err
+	})
+	if err != nil {
+		report.Record(t, "", "tls", report.Fail, fmt.Sprintf("endpoint unreachable: %s: %v", hostPort, err))
+		t.Fatalf("endpoint unreachable: %s: %v", hostPort, err)
+	}
+
+	if err := checkCertificateChain(chain, host, trustedRoots(t, caBundlePath), expectedCN, time.Duration(minDaysValid)*24*time.Hour, time.Now()); err != nil {
+		report.Record(t, "", "tls", report.Fail, fmt.Sprintf("chain invalid: %s: %v", hostPort, err))
+		t.Fatalf("chain invalid: %s: %v", hostPort, err)
+	}
+	report.Record(t, "", "tls", report.Pass, hostPort)
+}
+
+// presentedChain completes a TLS handshake and returns the certificates the
+// server presented. Verification is left to checkCertificateChain so that a
+// bad chain is not mistaken for an unreachable endpoint.
+func presentedChain(hostPort string, serverName string) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostPort, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// trustedRoots returns the certificates in the CA bundle at path, or nil for
+// the system roots when path is empty.
+func trustedRoots(t *testing.T, path string) *x509.CertPool {
+	if path == "" {
+		return nil
+	}
+	bundle, err := os.ReadFile(path)
+	require.NoError(t, err, "could not read CA bundle %s", path)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(bundle), "CA bundle %s holds no PEM certificates", path)
+	return roots
+}
+
+// checkCertificateChain verifies a chain as presented by a server, leaf first.
+// A nil roots pool means the system roots.
+func checkCertificateChain(chain []*x509.Certificate, host string, roots *x509.CertPool, expectedCN string, minValid time.Duration, now time.Time) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	leaf := chain[0]
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range chain[1:] {
+		intermediates.AddCert(certificate)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	}); err != nil {
+		return err
+	}
+
+	if leaf.Subject.CommonName != expectedCN && !slices.Contains(leaf.DNSNames, expectedCN) {
+		return fmt.Errorf("leaf is for %q (DNS names %s), expected %q", leaf.Subject.CommonName, strings.Join(leaf.DNSNames, ", "), expectedCN)
+	}
+
+	if remaining := leaf.NotAfter.Sub(now); remaining < minValid {
+		return fmt.Errorf("leaf %q expires on %s, in less than %d days", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339), int(minValid.Hours()/24))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package testhelpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var tlsTestNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// testCertificate signs a certificate with the parent's key, or self-signs it
+// when parent is nil.
+func testCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate, key
+}
+
+func TestCheckCertificateChain(t *testing.T) {
+	t.Parallel()
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Terratest Root CA"},
+		NotBefore:             tlsTestNow.AddDate(-1, 0, 0),
+		NotAfter:              tlsTestNow.AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca, caKey := testCertificate(t, caTemplate, nil, nil)
+	otherCa, _ := testCertificate(t, caTemplate, nil, nil)
+
+	leaf := func(commonName string, dnsNames []string, notAfter time.Time) *x509.Certificate {
+		certificate, _ := testCertificate(t, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: commonName},
+			DNSNames:     dnsNames,
+			NotBefore:    tlsTestNow.AddDate(0, -1, 0),
+			NotAfter:     notAfter,
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca, caKey)
+		return certificate
+	}
+	wildcard := leaf("*.iot.us-west-2.amazonaws.com", []string{"*.iot.us-west-2.amazonaws.com"}, tlsTestNow.AddDate(0, 6, 0))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCa)
+
+	testCases := []struct {
+		name       string
+		chain      []*x509.Certificate
+		host       string
+		roots      *x509.CertPool
+		expectedCN string
+		errorText  string
+	}{
+		{
+			name:       "Valid",
+			chain:      []*x509.Certificate{wildcard},
+			host:       "a1b2c3-ats.iot.us-west-2.amazonaws.com",
+			roots:      roots,
+			expectedCN: "*.iot.us-west-2.amazonaws.com",
+		},
+		{
+			name:       "ExpectedCNInDNSNames",
+			chain:      []*x509.Certificate{leaf("", []string{"broker.example.com"}, tlsTestNow.AddDate(1, 0, 0))},
+			host:       "broker.example.com",
+			roots:      roots,
+			expectedCN: "broker.example.com",
+		},
+		{
+			name:      "NoCertificate",
+			host:      "broker.example.com",
+			roots:     roots,
+			errorText: "no certificate presented",
+		},
+		{
+			name:       "UntrustedChain",
+			chain:      []*x509.Certificate{wildcard},
+			host:       "a1b2c3-ats.iot.us-west-2.amazonaws.com",
+			roots:      otherRoots,
+			expectedCN: "*.iot.us-west-2.amazonaws.com",
+			errorText:  "unknown authority",
+		},
+		{
+			name:       "WrongHost",
+			chain:      []*x509.Certificate{wildcard},
+			host:       "a1b2c3-ats.iot.eu-west-1.amazonaws.com",
+			roots:      roots,
+			expectedCN: "*.iot.us-west-2.amazonaws.com",
+			errorText:  "not a1b2c3-ats.iot.eu-west-1.amazonaws.com",
+		},
+		{
+			name:       "WrongCN",
+			chain:      []*x509.Certificate{wildcard},
+			host:       "a1b2c3-ats.iot.us-west-2.amazonaws.com",
+			roots:      roots,
+			expectedCN: "broker.example.com",
+			errorText:  `expected "broker.example.com"`,
+		},
+		{
+			name:       "ExpiresSoon",
+			chain:      []*x509.Certificate{leaf("broker.example.com", []string{"broker.example.com"}, tlsTestNow.AddDate(0, 0, 10))},
+			host:       "broker.example.com",
+			roots:      roots,
+			expectedCN: "broker.example.com",
+			errorText:  "in less than 30 days",
+		},
+		{
+			name:       "Expired",
+			chain:      []*x509.Certificate{leaf("broker.example.com", []string{"broker.example.com"}, tlsTestNow.AddDate(0, 0, -1))},
+			host:       "broker.example.com",
+			roots:      roots,
+			expectedCN: "broker.example.com",
+			errorText:  "expired",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkCertificateChain(tc.chain, tc.host, tc.roots, tc.expectedCN, 30*24*time.Hour, tlsTestNow)
+			if tc.errorText == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errorText)
+		})
+	}
+}
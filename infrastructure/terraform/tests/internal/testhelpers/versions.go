@@ -0,0 +1,141 @@
+package testhelpers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TerraformVersionsEnvVar lists the Terraform versions, comma separated, that
+// the version matrix runs init, validate and plan with, e.g.
+// TF_VERSIONS=1.8,1.9, versions the root module's required_version of >= 1.8.0
+// allows. Each version is looked up on the PATH as terraform<version> or
+// terraform-<version>, then among the versions installed by tfenv; a minor
+// version like 1.9 matches the newest installed 1.9.x.
+const TerraformVersionsEnvVar = "TF_VERSIONS"
+
+// TerraformBinary is one version of the matrix and the binary it resolved to.
+// Path is empty when the version is not installed.
+type TerraformBinary struct {
+	Version string
+	Path    string
+}
+
+// TerraformVersions returns the versions listed in TF_VERSIONS with their
+// binaries.
+func TerraformVersions() []TerraformBinary {
+	tfenvRoot := os.Getenv("TFENV_ROOT")
+	if tfenvRoot == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			tfenvRoot = filepath.Join(home, ".tfenv")
+		}
+	}
+
+	var binaries []TerraformBinary
+	for _, version := range strings.Split(os.Getenv(TerraformVersionsEnvVar), ",") {
+		if version = strings.TrimPrefix(strings.TrimSpace(version), "v"); version != "" {
+			binaries = append(binaries, TerraformBinary{Version: version, Path: findTerraformBinary(version, exec.LookPath, tfenvRoot)})
+		}
+	}
+	return binaries
+}
+
+// NewestTerraformBinary returns the binary of the newest installed version in
+// TF_VERSIONS, or an empty string, which terratest takes as terraform on the
+// PATH, when none is. Apply-based tests only run with this one.
+func NewestTerraformBinary() string {
+	return newestTerraformBinary(TerraformVersions())
+}
+
+func newestTerraformBinary(binaries []TerraformBinary) string {
+	newest := TerraformBinary{}
+	for _, binary := range binaries {
+		if binary.Path != "" && (newest.Path == "" || compareVersions(binary.Version, newest.Version) > 0) {
+			newest = binary
+		}
+	}
+	return newest.Path
+}
+
+// ForEachTerraformVersion runs fn as a parallel subtest per version in
+// TF_VERSIONS, named after the version. Versions that are not installed are
+// skipped, and so is the whole matrix when TF_VERSIONS is not set.
+func ForEachTerraformVersion(t *testing.T, fn func(t *testing.T, terraformBinary string)) {
+	t.Helper()
+
+	binaries := TerraformVersions()
+	if len(binaries) == 0 {
+		t.Skipf("set %s to run the Terraform version matrix", TerraformVersionsEnvVar)
+	}
+
+	for _, binary := range binaries {
+		binary := binary
+		t.Run(binary.Version, func(t *testing.T) {
+			t.Parallel()
+			if binary.Path == "" {
+				t.Skipf("Terraform %s is not installed: no terraform%[1]s or terraform-%[1]s on the PATH and no tfenv version matching it", binary.Version)
+			}
+			fn(t, binary.Path)
+		})
+	}
+}
+
+// findTerraformBinary returns the binary for the version, or an empty string
+// when it is not installed.
+func findTerraformBinary(version string, lookPath func(string) (string, error), tfenvRoot string) string {
+	for _, name := range []string{"terraform" + version, "terraform-" + version} {
+		if path, err := lookPath(name); err == nil {
+			return path
+		}
+	}
+	if tfenvRoot == "" {
+		return ""
+	}
+
+	entries, err := os.ReadDir(filepath.Join(tfenvRoot, "versions"))
+	if err != nil {
+		return ""
+	}
+	best := ""
+	for _, entry := range entries {
+		installed := entry.Name()
+		if installed != version && !strings.HasPrefix(installed, version+".") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(tfenvRoot, "versions", installed, "terraform")); err != nil {
+			continue
+		}
+		if best == "" || compareVersions(installed, best) > 0 {
+			best = installed
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return filepath.Join(tfenvRoot, "versions", best, "terraform")
+}
+
+// compareVersions compares dotted numeric versions, treating missing parts as
+// zero, and returns -1, 0 or 1.
+func compareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[i])
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
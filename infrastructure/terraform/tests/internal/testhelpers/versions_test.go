@@ -0,0 +1,73 @@
+package testhelpers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindTerraformBinary(t *testing.T) {
+	t.Parallel()
+
+	tfenvRoot := t.TempDir()
+	for _, version := range []string{"1.5.0", "1.5.7", "1.7.5", "1.10.2"} {
+		dir := filepath.Join(tfenvRoot, "versions", version)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "terraform"), nil, 0o755))
+	}
+	// A version dir left behind by an interrupted install
+	require.NoError(t, os.MkdirAll(filepath.Join(tfenvRoot, "versions", "1.6.0"), 0o755))
+
+	onPath := map[string]string{"terraform1.3": "/usr/local/bin/terraform1.3", "terraform-1.4": "/opt/bin/terraform-1.4"}
+	lookPath := func(name string) (string, error) {
+		if path, ok := onPath[name]; ok {
+			return path, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	testCases := []struct {
+		version  string
+		expected string
+	}{
+		{version: "1.3", expected: "/usr/local/bin/terraform1.3"},
+		{version: "1.4", expected: "/opt/bin/terraform-1.4"},
+		{version: "1.5", expected: filepath.Join(tfenvRoot, "versions", "1.5.7", "terraform")},
+		{version: "1.5.0", expected: filepath.Join(tfenvRoot, "versions", "1.5.0", "terraform")},
+		{version: "1.1", expected: ""},
+		{version: "1.6", expected: ""},
+		// 1.10.2 must not count as a 1.1 release
+		{version: "1.10", expected: filepath.Join(tfenvRoot, "versions", "1.10.2", "terraform")},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, findTerraformBinary(tc.version, lookPath, tfenvRoot), "version %s", tc.version)
+	}
+	assert.Empty(t, findTerraformBinary("1.5", lookPath, ""), "found a binary without tfenv")
+}
+
+func TestNewestTerraformBinary(t *testing.T) {
+	t.Parallel()
+
+	binaries := []TerraformBinary{
+		{Version: "1.3", Path: "/bin/terraform1.3"},
+		{Version: "1.10", Path: "/bin/terraform1.10"},
+		{Version: "1.9"},
+		{Version: "1.7", Path: "/bin/terraform1.7"},
+	}
+	assert.Equal(t, "/bin/terraform1.10", newestTerraformBinary(binaries))
+	assert.Empty(t, newestTerraformBinary([]TerraformBinary{{Version: "1.7"}}), "picked a version that is not installed")
+	assert.Empty(t, newestTerraformBinary(nil))
+}
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, compareVersions("1.5", "1.5.0"))
+	assert.Equal(t, -1, compareVersions("1.5.7", "1.7"))
+	assert.Equal(t, 1, compareVersions("1.10", "1.9.8"))
+}
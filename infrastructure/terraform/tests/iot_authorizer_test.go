@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// The token signing key pair in testdata/authorizer was generated for these
+// tests only and is not trusted anywhere else.
+var (
+	tokenSigningKeyPath       = filepath.Join("testdata", "authorizer", "token_signing_key.pem")
+	tokenSigningPublicKeyPath = filepath.Join("testdata", "authorizer", "token_signing_key.pub.pem")
+)
+
+// authorizerResponse is the part of the custom authorizer response the tests
+// look at.
+type authorizerResponse struct {
+	IsAuthenticated bool     `json:"isAuthenticated"`
+	PrincipalID     string   `json:"principalId"`
+	PolicyDocuments []string `json:"policyDocuments"`
+}
+
+// defaultAuthorizerTestEnvVar opts in to TestIotDefaultAuthorizer, which
+// changes the default authorizer of the whole account for its duration.
+const defaultAuthorizerTestEnvVar = "RUN_DEFAULT_AUTHORIZER_TEST"
+
+// TestIotAuthorizerModule applies the authorizer module and invokes its Lambda
+// directly with tokens signed by the test key: a valid token has to be allowed
+// to connect as its client ID, while an expired token and one with a tampered
+// signature have to be explicitly denied. The authorizer has to be registered
+// with IoT without becoming the account default.
+func TestIotAuthorizerModule(t *testing.T) {
+	t.Parallel()
+	checkAuthorizerModule(t, testhelpers.SelectRegion(t), false)
+}
+
+// TestIotDefaultAuthorizer runs the checks of TestIotAuthorizerModule with
+// make_default set, and checks that the authorizer is the account default
+// while applied and that destroying it restores the default it replaced. The
+// default is shared by everything in the account, so the test is opt-in and
+// not run in parallel with the others.
+func TestIotDefaultAuthorizer(t *testing.T) {
+	testhelpers.SkipUnlessEnabled(t, defaultAuthorizerTestEnvVar)
+
+	region := testhelpers.SelectRegion(t)
+	if !testhelpers.IsPlanOnly() {
+		iotClient := testhelpers.NewIotClient(t, region)
+		previous := defaultAuthorizerName(iotClient)
+		// Registered ahead of the module's destroy so it runs after it
+		t.Cleanup(func() {
+			assert.Equal(t, previous, defaultAuthorizerName(iotClient), "destroy did not restore the default authorizer")
+		})
+	}
+	checkAuthorizerModule(t, region, true)
+}
+
+// checkAuthorizerModule applies the authorizer module in the region, made the
+// default or not, and checks the tokens it accepts and how it is registered with IoT.
+func checkAuthorizerModule(t *testing.T, region string, makeDefault bool) {
+	publicKey, err := os.ReadFile(tokenSigningPublicKeyPath)
+	require.NoError(t, err)
+	signingKey := readTokenSigningKey(t)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-authorizer", region, map[string]interface{}{
+		"project_name":             "iot-network",
+		"environment":              "test",
+		"owner":                    "terratest",
+		"cost_center":              "ci",
+		"token_signing_public_key": string(publicKey),
+		"make_default":             makeDefault,
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			functionName := terraform.Output(t, terraformOptions, "function_name")
+			authorizerName := terraform.Output(t, terraformOptions, "authorizer_name")
+			clientID := testhelpers.NamePrefix(terraformOptions) + "-device"
+
+			valid := signDeviceToken(t, signingKey, clientID, time.Now().Add(time.Hour))
+			response := invokeAuthorizer(t, region, functionName, clientID, valid)
+			assert.True(t, response.IsAuthenticated, "valid token was not authenticated")
+			assert.True(t, policiesHaveStatement(t, response.PolicyDocuments, "Allow", "iot:Connect", ":client/"+clientID),
+				"valid token is not allowed to connect as %s:\n%s", clientID, strings.Join(response.PolicyDocuments, "\n"))
+
+			rejected := map[string]string{
+				"expired":  signDeviceToken(t, signingKey, clientID, time.Now().Add(-time.Minute)),
+				"tampered": tamperSignature(valid),
+			}
+			for kind, token := range rejected {
+				response := invokeAuthorizer(t, region, functionName, clientID, token)
+				assert.False(t, response.IsAuthenticated, "%s token was authenticated", kind)
+				assert.True(t, policiesHaveStatement(t, response.PolicyDocuments, "Deny", "iot:Connect", ":client/*"),
+					"%s token is not explicitly denied:\n%s", kind, strings.Join(response.PolicyDocuments, "\n"))
+			}
+
+			iotClient := testhelpers.NewIotClient(t, region)
+			authorizer, err := iotClient.DescribeAuthorizer(&iot.DescribeAuthorizerInput{AuthorizerName: awsSDK.String(authorizerName)})
+			require.NoError(t, err, "authorizer %s is not registered", authorizerName)
+			assert.Equal(t, iot.AuthorizerStatusActive, awsSDK.StringValue(authorizer.AuthorizerDescription.Status))
+			assert.Contains(t, awsSDK.StringValue(authorizer.AuthorizerDescription.AuthorizerFunctionArn), ":function:"+functionName)
+
+			if makeDefault {
+				assert.Equal(t, authorizerName, defaultAuthorizerName(iotClient), "authorizer is not the default")
+			} else {
+				assert.NotEqual(t, authorizerName, defaultAuthorizerName(iotClient), "authorizer was made the default")
+			}
+		},
+	})
+}
+
+// defaultAuthorizerName returns the name of the account's default authorizer,
+// empty when there is none. DescribeDefaultAuthorizer fails with
+// ResourceNotFoundException in that case.
+func defaultAuthorizerName(iotClient *iot.IoT) string {
+	defaultAuthorizer, err := iotClient.DescribeDefaultAuthorizer(&iot.DescribeDefaultAuthorizerInput{})
+	if err != nil {
+		return ""
+	}
+	return awsSDK.StringValue(defaultAuthorizer.AuthorizerDescription.AuthorizerName)
+}
+
+func readTokenSigningKey(t *testing.T) ed25519.PrivateKey {
+	encoded, err := os.ReadFile(tokenSigningKeyPath)
+	require.NoError(t, err)
+	block, _ := pem.Decode(encoded)
+	require.NotNil(t, block, "%s is not PEM encoded", tokenSigningKeyPath)
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	signingKey, ok := key.(ed25519.PrivateKey)
+	require.True(t, ok, "%s is not an Ed25519 key", tokenSigningKeyPath)
+	return signingKey
+}
+
+// signDeviceToken builds a token in the format the authorizer Lambda expects:
+// base64url encoded claims and their base64url encoded signature, joined by a
+// dot.
+func signDeviceToken(t *testing.T, signingKey ed25519.PrivateKey, clientID string, expires time.Time) string {
+	claims, err := json.Marshal(map[string]interface{}{"sub": clientID, "exp": expires.Unix()})
+	require.NoError(t, err)
+
+	encodedClaims := base64.RawURLEncoding.EncodeToString(claims)
+	signature := ed25519.Sign(signingKey, []byte(encodedClaims))
+	return encodedClaims + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// tamperSignature flips a bit of the token's signature.
+func tamperSignature(token string) string {
+	encodedClaims, encodedSignature, _ := strings.Cut(token, ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(encodedSignature)
+	signature[0] ^= 0x01
+	return encodedClaims + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// invokeAuthorizer invokes the Lambda with the event IoT sends for an MQTT
+// connection passing the token as its password.
+func invokeAuthorizer(t *testing.T, region string, functionName string, clientID string, token string) authorizerResponse {
+	event := map[string]interface{}{
+		"signatureVerified": false,
+		"protocols":         []string{"mqtt"},
+		"protocolData": map[string]interface{}{
+			"mqtt": map[string]interface{}{
+				"clientId": clientID,
+				"username": clientID,
+				"password": base64.StdEncoding.EncodeToString([]byte(token)),
+			},
+		},
+		"connectionMetadata": map[string]interface{}{"id": clientID},
+	}
+
+	var response authorizerResponse
+	require.NoError(t, json.Unmarshal(testhelpers.InvokeFunction(t, region, functionName, event), &response))
+	return response
+}
+
+// policiesHaveStatement reports whether any of the policy documents has a
+// statement with the effect and action on a resource ending in resourceSuffix.
+func policiesHaveStatement(t *testing.T, policyDocuments []string, effect string, action string, resourceSuffix string) bool {
+	for _, document := range policyDocuments {
+		var policy struct {
+			Statement []struct {
+				Effect   string
+				Action   interface{}
+				Resource interface{}
+			}
+		}
+		require.NoError(t, json.Unmarshal([]byte(document), &policy))
+
+		for _, statement := range policy.Statement {
+			if statement.Effect != effect {
+				continue
+			}
+			for _, statementAction := range testhelpers.StringOrList(statement.Action) {
+				if statementAction != action {
+					continue
+				}
+				for _, resource := range testhelpers.StringOrList(statement.Resource) {
+					if strings.HasSuffix(resource, resourceSuffix) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
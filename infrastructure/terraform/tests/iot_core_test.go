@@ -0,0 +1,242 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// brokerCertificateMinDaysValid is how long the broker certificate has to stay
+// valid for. AWS rotates it well before that.
+const brokerCertificateMinDaysValid = 30
+
+// iotCoreQuotaRequirements is what the iot-core tests take out of the account's
+// quotas. The module creates no things itself; each test provisions a device.
+var iotCoreQuotaRequirements = testhelpers.QuotaRequirements{IoTThings: 1}
+
+func TestIotCoreModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-core", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Quotas: iotCoreQuotaRequirements,
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_iot_policy.device")
+			policyDocument, _ := plan.ResourcePlannedValuesMap["aws_iot_policy.device"].AttributeValues["policy"].(string)
+			assertNoIotWildcardStatements(t, "aws_iot_policy.device", policyDocument)
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			iotClient := testhelpers.NewIotClient(t, region)
+
+			// Port 443 serves the same certificate as MQTT on 8883 but does not
+			// insist on a client certificate during the handshake
+			brokerEndpoint := terraform.Output(t, terraformOptions, "broker_endpoint")
+			testhelpers.AssertTLSEndpoint(t, brokerEndpoint+":443", fmt.Sprintf("*.iot.%s.amazonaws.com", region), brokerCertificateMinDaysValid)
+
+			thingTypeName := terraform.Output(t, terraformOptions, "thing_type_name")
+			_, err := iotClient.DescribeThingType(&iot.DescribeThingTypeInput{ThingTypeName: awsSDK.String(thingTypeName)})
+			require.NoError(t, err, "thing type %s does not exist", thingTypeName)
+
+			thingGroupName := terraform.Output(t, terraformOptions, "thing_group_name")
+			_, err = iotClient.DescribeThingGroup(&iot.DescribeThingGroupInput{ThingGroupName: awsSDK.String(thingGroupName)})
+			require.NoError(t, err, "thing group %s does not exist", thingGroupName)
+
+			templateName := terraform.Output(t, terraformOptions, "provisioning_template_name")
+			template, err := iotClient.DescribeProvisioningTemplate(&iot.DescribeProvisioningTemplateInput{TemplateName: awsSDK.String(templateName)})
+			require.NoError(t, err, "provisioning template %s does not exist", templateName)
+
+			policyName := terraform.Output(t, terraformOptions, "device_policy_name")
+			templatePolicies := provisioningTemplatePolicyNames(t, awsSDK.StringValue(template.TemplateBody))
+			require.Contains(t, templatePolicies, policyName, "provisioning template %s does not attach the device policy", templateName)
+			for _, name := range templatePolicies {
+				policy, err := iotClient.GetPolicy(&iot.GetPolicyInput{PolicyName: awsSDK.String(name)})
+				require.NoError(t, err)
+				assertNoIotWildcardStatements(t, name, awsSDK.StringValue(policy.PolicyDocument))
+			}
+
+			thingName := testhelpers.NamePrefix(terraformOptions) + "-device"
+			certificateArn := provisionTestDevice(t, iotClient, awsSDK.StringValue(template.TemplateBody), thingName).certificateArn
+
+			_, err = iotClient.DescribeThing(&iot.DescribeThingInput{ThingName: awsSDK.String(thingName)})
+			require.NoError(t, err, "provisioning did not create thing %s", thingName)
+
+			_, err = iotClient.AttachPolicy(&iot.AttachPolicyInput{PolicyName: awsSDK.String(policyName), Target: awsSDK.String(certificateArn)})
+			require.NoError(t, err, "could not attach %s to the provisioned certificate", policyName)
+
+			attached, err := iotClient.ListAttachedPolicies(&iot.ListAttachedPoliciesInput{Target: awsSDK.String(certificateArn)})
+			require.NoError(t, err)
+			var attachedNames []string
+			for _, policy := range attached.Policies {
+				attachedNames = append(attachedNames, awsSDK.StringValue(policy.PolicyName))
+			}
+			assert.Contains(t, attachedNames, policyName, "device policy is not attached to the provisioned certificate")
+		},
+	})
+}
+
+// testDevice is a thing registered by provisionTestDevice, with the
+// certificate and private key it connects with.
+type testDevice struct {
+	thingName      string
+	certificateArn string
+	certificatePem string
+	privateKey     string
+}
+
+// provisionTestDevice creates a certificate and registers a thing for it
+// through the provisioning template. The thing and certificate are removed
+// when the test finishes, whether or not the remaining assertions pass.
+func provisionTestDevice(t *testing.T, iotClient *iot.IoT, templateBody string, thingName string) testDevice {
+	certificate, err := iotClient.CreateKeysAndCertificate(&iot.CreateKeysAndCertificateInput{SetAsActive: awsSDK.Bool(false)})
+	require.NoError(t, err)
+	certificateArn := awsSDK.StringValue(certificate.CertificateArn)
+	certificateId := awsSDK.StringValue(certificate.CertificateId)
+
+	t.Cleanup(func() {
+		deleteTestDevice(t, iotClient, thingName, certificateId, certificateArn)
+	})
+
+	_, err = iotClient.RegisterThing(&iot.RegisterThingInput{
+		TemplateBody: awsSDK.String(templateBody),
+		Parameters: map[string]*string{
+			"ThingName":                 awsSDK.String(thingName),
+			"AWS::IoT::Certificate::Id": awsSDK.String(certificateId),
+		},
+	})
+	require.NoError(t, err, "provisioning template could not register thing %s", thingName)
+
+	return testDevice{
+		thingName:      thingName,
+		certificateArn: certificateArn,
+		certificatePem: awsSDK.StringValue(certificate.CertificatePem),
+		privateKey:     awsSDK.StringValue(certificate.KeyPair.PrivateKey),
+	}
+}
+
+// deleteTestDevice tears down a device created by provisionTestDevice: its
+// certificate through deleteTestCertificate, then the thing once no principal
+// is attached to it any more. Every step is attempted even if an earlier one
+// fails, and errors are only logged so that cleanup never masks the original
+// test failure.
+func deleteTestDevice(t *testing.T, iotClient *iot.IoT, thingName string, certificateId string, certificateArn string) {
+	deleteTestCertificate(t, iotClient, thingName, certificateId, certificateArn)
+
+	testhelpers.PollUntil(t, "deleting thing "+thingName, func() (bool, error) {
+		_, err := iotClient.DeleteThing(&iot.DeleteThingInput{ThingName: awsSDK.String(thingName)})
+		return err == nil, err
+	})
+}
+
+// deleteTestCertificate detaches the policies and the thing of a certificate
+// a test issued, and deactivates and deletes it, leaving the thing itself in
+// place. DetachThingPrincipal only starts the detach, so the thing's
+// principals are polled until the certificate is gone from them before the
+// certificate is deleted, and the delete is retried until IoT lets go of it.
+// Errors are only logged.
+func deleteTestCertificate(t *testing.T, iotClient *iot.IoT, thingName string, certificateId string, certificateArn string) {
+	attached, err := iotClient.ListAttachedPolicies(&iot.ListAttachedPoliciesInput{Target: awsSDK.String(certificateArn)})
+	if err != nil {
+		t.Logf("Failed to list policies attached to %s: %v", certificateArn, err)
+	} else {
+		for _, policy := range attached.Policies {
+			if _, err := iotClient.DetachPolicy(&iot.DetachPolicyInput{PolicyName: policy.PolicyName, Target: awsSDK.String(certificateArn)}); err != nil {
+				t.Logf("Failed to detach policy %s from %s: %v", awsSDK.StringValue(policy.PolicyName), certificateArn, err)
+			}
+		}
+	}
+
+	if _, err := iotClient.DetachThingPrincipal(&iot.DetachThingPrincipalInput{ThingName: awsSDK.String(thingName), Principal: awsSDK.String(certificateArn)}); err != nil {
+		t.Logf("Failed to detach %s from thing %s: %v", certificateArn, thingName, err)
+	}
+	testhelpers.PollUntil(t, "waiting for "+certificateArn+" to be detached from thing "+thingName, func() (bool, error) {
+		principals, err := iotClient.ListThingPrincipals(&iot.ListThingPrincipalsInput{ThingName: awsSDK.String(thingName)})
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == iot.ErrCodeResourceNotFoundException {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return len(principals.Principals) == 0, nil
+	})
+
+	// A certificate has to be deactivated before it can be deleted
+	if _, err := iotClient.UpdateCertificate(&iot.UpdateCertificateInput{CertificateId: awsSDK.String(certificateId), NewStatus: awsSDK.String(iot.CertificateStatusInactive)}); err != nil {
+		t.Logf("Failed to deactivate certificate %s: %v", certificateId, err)
+	}
+	testhelpers.PollUntil(t, "deleting certificate "+certificateId, func() (bool, error) {
+		_, err := iotClient.DeleteCertificate(&iot.DeleteCertificateInput{CertificateId: awsSDK.String(certificateId)})
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == iot.ErrCodeResourceNotFoundException {
+			return true, nil
+		}
+		return err == nil, err
+	})
+}
+
+// provisioningTemplatePolicyNames returns the names of the IoT policies a
+// provisioning template attaches to the certificates it registers.
+func provisioningTemplatePolicyNames(t *testing.T, templateBody string) []string {
+	var template struct {
+		Resources map[string]struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(templateBody), &template), "provisioning template body is not valid JSON")
+
+	var names []string
+	for _, resource := range template.Resources {
+		if resource.Type != "AWS::IoT::Policy" {
+			continue
+		}
+		if name, ok := resource.Properties["PolicyName"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// assertNoIotWildcardStatements fails if any Allow statement of the policy
+// grants iot:* on every resource.
+func assertNoIotWildcardStatements(t *testing.T, policyName string, policyDocument string) {
+	var document struct {
+		Statement []struct {
+			Effect   string
+			Action   interface{}
+			Resource interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(policyDocument), &document), "policy %s is not valid JSON", policyName)
+
+	for i, statement := range document.Statement {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		actions := testhelpers.StringOrList(statement.Action)
+		resources := testhelpers.StringOrList(statement.Resource)
+		for _, action := range actions {
+			for _, resource := range resources {
+				assert.False(t, strings.EqualFold(action, "iot:*") && resource == "*",
+					"policy %s statement %d allows iot:* on all resources", policyName, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// TestIotRulesModule checks that the telemetry rule actually fires: a reading
+// published on a device's telemetry topic has to show up in the table under
+// that device's ID, while a reading the rule's WHERE clause rejects must not.
+// How long to wait for the rule is set with TEST_POLL_INTERVAL and
+// TEST_POLL_DEADLINE.
+func TestIotRulesModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	dataKeyArn, dataKeyAlias := testhelpers.CreateDataKey(t, region, nil)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-rules", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"kms_key_arn":  dataKeyArn,
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_iot_topic_rule.telemetry")
+			sql, _ := plan.ResourcePlannedValuesMap["aws_iot_topic_rule.telemetry"].AttributeValues["sql"].(string)
+			assert.Contains(t, sql, "FROM 'devices/test/+/telemetry'", "planned rule SQL")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			dataClient := testhelpers.NewIotDataClient(t, region)
+			dynamoClient := dynamodb.New(testhelpers.NewSession(t, region))
+			tableName := terraform.Output(t, terraformOptions, "telemetry_table_name")
+
+			testhelpers.AssertEncryptedAtRest(t, region, []string{terraform.Output(t, terraformOptions, "telemetry_table_arn")}, dataKeyAlias)
+
+			// Device IDs only need to be unique, nothing is provisioned for
+			// them since the data plane API publishes without a device
+			namePrefix := testhelpers.NamePrefix(terraformOptions)
+			validDevice := namePrefix + "-valid"
+			malformedDevice := namePrefix + "-malformed"
+			testRun := strings.ToLower(random.UniqueId())
+
+			publishTelemetry(t, dataClient, malformedDevice, map[string]interface{}{"temperature": "warm", "test_run": testRun})
+			publishTelemetry(t, dataClient, validDevice, map[string]interface{}{"temperature": 21.5, "test_run": testRun})
+
+			var item map[string]*dynamodb.AttributeValue
+			arrived := testhelpers.PollUntil(t, fmt.Sprintf("wait for %s in %s", validDevice, tableName), func() (bool, error) {
+				var err error
+				item, err = telemetryItem(dynamoClient, tableName, validDevice)
+				return item != nil, err
+			})
+			require.True(t, arrived, "telemetry of %s did not reach %s within %s", validDevice, tableName, testhelpers.PollDeadline())
+			require.Contains(t, item, "test_run", "stored reading of %s", validDevice)
+			require.Contains(t, item, "temperature", "stored reading of %s", validDevice)
+			assert.Equal(t, testRun, awsSDK.StringValue(item["test_run"].S), "test_run of the stored reading")
+			assert.Equal(t, "21.5", awsSDK.StringValue(item["temperature"].N), "temperature of the stored reading")
+			assert.Contains(t, item, "received_at", "rule did not add received_at")
+
+			// The malformed reading was published first, so by the time the
+			// valid one has arrived it would have too had the rule let it through
+			malformed, err := telemetryItem(dynamoClient, tableName, malformedDevice)
+			require.NoError(t, err)
+			assert.Nil(t, malformed, "reading without a numeric temperature was stored for %s", malformedDevice)
+		},
+	})
+}
+
+// publishTelemetry publishes a reading on the device's telemetry topic.
+func publishTelemetry(t *testing.T, dataClient *iotdataplane.IoTDataPlane, deviceID string, reading map[string]interface{}) {
+	payload, err := json.Marshal(reading)
+	require.NoError(t, err)
+
+	topic := fmt.Sprintf("devices/test/%s/telemetry", deviceID)
+	_, err = dataClient.Publish(&iotdataplane.PublishInput{Topic: awsSDK.String(topic), Qos: awsSDK.Int64(1), Payload: payload})
+	require.NoError(t, err, "could not publish to %s", topic)
+}
+
+// telemetryItem returns the stored reading of the device, or nil if there is
+// none yet.
+func telemetryItem(dynamoClient *dynamodb.DynamoDB, tableName string, deviceID string) (map[string]*dynamodb.AttributeValue, error) {
+	output, err := dynamoClient.GetItem(&dynamodb.GetItemInput{
+		TableName:      awsSDK.String(tableName),
+		Key:            map[string]*dynamodb.AttributeValue{"device_id": {S: awsSDK.String(deviceID)}},
+		ConsistentRead: awsSDK.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	return output.Item, nil
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// ledgerRetentionDays is how long the attestations the test writes are locked.
+// They are locked in GOVERNANCE mode, which the destroy bypasses, so the
+// bucket does not outlive the test.
+const ledgerRetentionDays = 1
+
+// TestLedgerModule applies the ledger module with and without deletion
+// protection of the attestation table. It writes a device attestation through
+// the access role, indexes it, and checks that the index cannot be overwritten,
+// that the checksum S3 keeps for the attestation matches both the attestation
+// and the index, and that the attestation is locked against deletion until
+// the end of its retention.
+func TestLedgerModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name               string
+		deletionProtection bool
+	}{
+		{name: "Protected", deletionProtection: true},
+		{name: "Unprotected", deletionProtection: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Any principal of the account that may assume roles, the test
+			// itself included, may assume the access role
+			accountId := placeholderAccountId
+			if !testhelpers.IsPlanOnly() {
+				accountId = testhelpers.AccountId(t, testhelpers.NewSession(t, region))
+			}
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "ledger", region, map[string]interface{}{
+				"project_name":          "iot-network",
+				"environment":           "test",
+				"owner":                 "terratest",
+				"cost_center":           "ci",
+				"deletion_protection":   tc.deletionProtection,
+				"retention_mode":        s3.ObjectLockRetentionModeGovernance,
+				"retention_days":        ledgerRetentionDays,
+				"force_destroy":         true,
+				"access_principal_arns": []string{fmt.Sprintf("arn:aws:iam::%s:root", accountId)},
+			})
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					testhelpers.AssertResourceAttr(t, plan, "aws_s3_bucket.attestations", "object_lock_enabled", true)
+					testhelpers.AssertResourceAttr(t, plan, "aws_s3_bucket_versioning.attestations", "versioning_configuration[0].status", "Enabled")
+					testhelpers.AssertResourceAttr(t, plan, "aws_s3_bucket_object_lock_configuration.attestations",
+						"rule[0].default_retention[0].mode", s3.ObjectLockRetentionModeGovernance)
+					testhelpers.AssertResourceAttr(t, plan, "aws_s3_bucket_object_lock_configuration.attestations",
+						"rule[0].default_retention[0].days", ledgerRetentionDays)
+					testhelpers.AssertResourceAttr(t, plan, "aws_dynamodb_table.attestations", "deletion_protection_enabled", tc.deletionProtection)
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					sess := testhelpers.SessionFor(t, terraformOptions)
+					bucket := terraform.Output(t, terraformOptions, "bucket_name")
+					tableName := terraform.Output(t, terraformOptions, "table_name")
+					adminDynamo := dynamodb.New(sess)
+
+					// Runs before the teardown: destroying a protected table
+					// fails, and the provider retries the failure until it
+					// times out
+					t.Cleanup(func() {
+						if _, err := adminDynamo.UpdateTable(&dynamodb.UpdateTableInput{
+							TableName:                 awsSDK.String(tableName),
+							DeletionProtectionEnabled: awsSDK.Bool(false),
+						}); err != nil {
+							t.Logf("Failed to disable deletion protection of table %s: %v", tableName, err)
+						}
+					})
+
+					table, err := adminDynamo.DescribeTable(&dynamodb.DescribeTableInput{TableName: awsSDK.String(tableName)})
+					require.NoError(t, err)
+					assert.Equal(t, tc.deletionProtection, awsSDK.BoolValue(table.Table.DeletionProtectionEnabled), "deletion protection of table %s", tableName)
+
+					access := assumeLedgerAccessRole(t, sess, terraform.Output(t, terraformOptions, "access_role_arn"), bucket)
+					assertAttestationLocked(t, access, sess, bucket, tableName, testhelpers.NamePrefix(terraformOptions)+"-device")
+				},
+			})
+		})
+	}
+}
+
+// assumeLedgerAccessRole returns a session with the credentials of the access
+// role. A role that was just created takes a while to be assumable, and its
+// policy a while longer to apply, so both are polled for.
+func assumeLedgerAccessRole(t *testing.T, sess *session.Session, roleArn string, bucket string) *session.Session {
+	var accessSession *session.Session
+	assumed := testhelpers.PollUntil(t, "assuming "+roleArn, func() (bool, error) {
+		candidate := sess.Copy(awsSDK.NewConfig().WithCredentials(stscreds.NewCredentials(sess, roleArn)))
+		if _, err := s3.New(candidate).ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: awsSDK.String(bucket)}); err != nil {
+			return false, err
+		}
+		accessSession = candidate
+		return true, nil
+	})
+	require.True(t, assumed, "could not list attestations in %s as access role %s", bucket, roleArn)
+	return accessSession
+}
+
+// assertAttestationLocked writes an attestation of the device and indexes it
+// the way the registry does, then checks it as a verifier would: the index
+// entry cannot be replaced, the checksum S3 computed on upload matches the
+// attestation and the index, and neither the access role nor the admin can
+// delete the attested version while it is retained.
+func assertAttestationLocked(t *testing.T, access *session.Session, admin *session.Session, bucket string, tableName string, deviceId string) {
+	attestation, err := json.Marshal(map[string]string{
+		"deviceId":    deviceId,
+		"firmware":    "1.4.2",
+		"attestation": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	})
+	require.NoError(t, err)
+	digest := sha256.Sum256(attestation)
+	checksum := base64.StdEncoding.EncodeToString(digest[:])
+
+	recordedAt := time.Now()
+	key := "attestations/" + deviceId + "/" + strconv.FormatInt(recordedAt.UnixNano(), 10) + ".json"
+	accessS3 := s3.New(access)
+	written, err := accessS3.PutObject(&s3.PutObjectInput{
+		Bucket:            awsSDK.String(bucket),
+		Key:               awsSDK.String(key),
+		Body:              strings.NewReader(string(attestation)),
+		ChecksumAlgorithm: awsSDK.String(s3.ChecksumAlgorithmSha256),
+	})
+	require.NoError(t, err, "access role could not write s3://%s/%s", bucket, key)
+	versionId := awsSDK.StringValue(written.VersionId)
+	require.NotEmpty(t, versionId, "s3://%s/%s has no version ID, versioning is off", bucket, key)
+
+	entry := map[string]*dynamodb.AttributeValue{
+		"device_id":       {S: awsSDK.String(deviceId)},
+		"recorded_at":     {N: awsSDK.String(strconv.FormatInt(recordedAt.UnixMilli(), 10))},
+		"object_key":      {S: awsSDK.String(key)},
+		"version_id":      {S: awsSDK.String(versionId)},
+		"checksum_sha256": {S: awsSDK.String(checksum)},
+	}
+	accessDynamo := dynamodb.New(access)
+	indexEntry := func() error {
+		_, err := accessDynamo.PutItem(&dynamodb.PutItemInput{
+			TableName:           awsSDK.String(tableName),
+			Item:                entry,
+			ConditionExpression: awsSDK.String("attribute_not_exists(device_id)"),
+		})
+		return err
+	}
+	require.NoError(t, indexEntry(), "access role could not index attestation %s", key)
+	err = indexEntry()
+	var awsErr awserr.Error
+	if assert.ErrorAs(t, err, &awsErr, "index entry of %s was overwritten", key) {
+		assert.Equal(t, dynamodb.ErrCodeConditionalCheckFailedException, awsErr.Code(), "overwriting the index entry of %s: %v", key, err)
+	}
+
+	indexed, err := accessDynamo.GetItem(&dynamodb.GetItemInput{
+		TableName:      awsSDK.String(tableName),
+		Key:            map[string]*dynamodb.AttributeValue{"device_id": entry["device_id"], "recorded_at": entry["recorded_at"]},
+		ConsistentRead: awsSDK.Bool(true),
+	})
+	require.NoError(t, err)
+	require.Contains(t, indexed.Item, "checksum_sha256", "index entry of %s has no checksum", key)
+	assert.Equal(t, versionId, awsSDK.StringValue(indexed.Item["version_id"].S), "version indexed for %s", key)
+
+	attributes, err := accessS3.GetObjectAttributes(&s3.GetObjectAttributesInput{
+		Bucket:           awsSDK.String(bucket),
+		Key:              awsSDK.String(key),
+		VersionId:        awsSDK.String(versionId),
+		ObjectAttributes: awsSDK.StringSlice([]string{s3.ObjectAttributesChecksum}),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, attributes.Checksum, "s3://%s/%s has no checksum", bucket, key)
+	assert.Equal(t, checksum, awsSDK.StringValue(attributes.Checksum.ChecksumSHA256), "checksum S3 keeps for s3://%s/%s", bucket, key)
+	assert.Equal(t, awsSDK.StringValue(attributes.Checksum.ChecksumSHA256), awsSDK.StringValue(indexed.Item["checksum_sha256"].S),
+		"checksum indexed for %s does not match the one S3 keeps", key)
+
+	object, err := accessS3.GetObject(&s3.GetObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(key), VersionId: awsSDK.String(versionId)})
+	require.NoError(t, err)
+	readBack, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(attestation), string(readBack), "attestation read back from s3://%s/%s", bucket, key)
+
+	retention, err := accessS3.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket:    awsSDK.String(bucket),
+		Key:       awsSDK.String(key),
+		VersionId: awsSDK.String(versionId),
+	})
+	require.NoError(t, err, "s3://%s/%s is not retained", bucket, key)
+	assert.Equal(t, s3.ObjectLockRetentionModeGovernance, awsSDK.StringValue(retention.Retention.Mode), "retention mode of s3://%s/%s", bucket, key)
+	assert.True(t, awsSDK.TimeValue(retention.Retention.RetainUntilDate).After(time.Now()), "s3://%s/%s is only retained until %s", bucket, key, retention.Retention.RetainUntilDate)
+
+	// The admin is allowed to delete, so only the lock can stop it
+	deleteVersion := &s3.DeleteObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(key), VersionId: awsSDK.String(versionId)}
+	for name, client := range map[string]*s3.S3{"access role": accessS3, "admin": s3.New(admin)} {
+		_, err := client.DeleteObject(deleteVersion)
+		var requestErr awserr.RequestFailure
+		if assert.ErrorAs(t, err, &requestErr, "%s deleted locked version %s of s3://%s/%s", name, versionId, bucket, key) {
+			assert.Equal(t, 403, requestErr.StatusCode(), "%s deleting locked version of s3://%s/%s: %v", name, bucket, key, err)
+		}
+	}
+}
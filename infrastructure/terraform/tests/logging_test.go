@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// logRetentionInDays differs from the module default so the test can tell
+	// the var is applied.
+	logRetentionInDays = 7
+
+	// The delivery stream buffers for a minute and takes another one or two
+	// to write the object, so events get five minutes to reach the sink.
+	logSinkBufferingInterval = 60
+	logSinkRetries           = 30
+	logSinkTimeBetween       = 10 * time.Second
+)
+
+// TestLoggingModule writes a synthetic device log event with PutLogEvents and
+// waits for the device log group's subscription filter to forward it through
+// the delivery stream into the sink bucket. A second event the filter pattern
+// does not match is written alongside it; FilterLogEvents with the pattern of
+// the subscription filter has to return the first and not the second, and the
+// second must not reach the sink. Every log group of the module has to keep
+// its events for retention_in_days, be encrypted with kms_key_arn and forward
+// to the delivery stream. The synthetic log stream is deleted afterwards.
+func TestLoggingModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	keyArn, _ := testhelpers.CreateDataKey(t, region, logsKeyGrants(region))
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "logging", region, map[string]interface{}{
+		"project_name":       "iot-network",
+		"environment":        "test",
+		"owner":              "terratest",
+		"cost_center":        "ci",
+		"retention_in_days":  logRetentionInDays,
+		"kms_key_arn":        keyArn,
+		"buffering_interval": logSinkBufferingInterval,
+		"force_destroy":      true,
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			testhelpers.AssertPlannedResources(t, plan, map[string]int{
+				"aws_cloudwatch_log_group":               2,
+				"aws_cloudwatch_log_subscription_filter": 2,
+				"aws_kinesis_firehose_delivery_stream":   1,
+				"aws_iot_topic_rule":                     1,
+			})
+			for address := range testhelpers.PlannedResourcesOfType(plan, "aws_cloudwatch_log_group") {
+				testhelpers.AssertResourceAttr(t, plan, address, "retention_in_days", logRetentionInDays)
+				testhelpers.AssertResourceAttr(t, plan, address, "kms_key_id", keyArn)
+			}
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			sess := testhelpers.SessionFor(t, terraformOptions)
+			logsClient := cloudwatchlogs.New(sess)
+			s3Client := s3.New(sess)
+			deliveryStreamArn := terraform.Output(t, terraformOptions, "delivery_stream_arn")
+
+			logGroupNames := terraform.OutputList(t, terraformOptions, "log_group_names")
+			require.Len(t, logGroupNames, 2)
+			for _, logGroupName := range logGroupNames {
+				assertLogGroup(t, logsClient, logGroupName, keyArn, deliveryStreamArn)
+			}
+
+			deviceLogGroup := terraform.Output(t, terraformOptions, "device_log_group_name")
+			filters, err := logsClient.DescribeSubscriptionFilters(&cloudwatchlogs.DescribeSubscriptionFiltersInput{LogGroupName: awsSDK.String(deviceLogGroup)})
+			require.NoError(t, err)
+			require.Len(t, filters.SubscriptionFilters, 1, "subscription filters of %s", deviceLogGroup)
+			filterPattern := awsSDK.StringValue(filters.SubscriptionFilters[0].FilterPattern)
+
+			prefix := testhelpers.NamePrefix(terraformOptions)
+			matching := fmt.Sprintf(`{"device_id":"%s-device","level":"INFO","message":"synthetic event of %s"}`, prefix, prefix)
+			unmatched := fmt.Sprintf("synthetic event of %s without a device", prefix)
+			logStream := writeSyntheticLogEvents(t, logsClient, deviceLogGroup, prefix+"-synthetic", matching, unmatched)
+
+			// The filter pattern as CloudWatch Logs evaluates it
+			var filtered []string
+			testhelpers.PollUntil(t, "filter pattern "+filterPattern+" matches the synthetic event", func() (bool, error) {
+				events, err := logsClient.FilterLogEvents(&cloudwatchlogs.FilterLogEventsInput{
+					LogGroupName:   awsSDK.String(deviceLogGroup),
+					LogStreamNames: []*string{awsSDK.String(logStream)},
+					FilterPattern:  awsSDK.String(filterPattern),
+				})
+				if err != nil {
+					return false, err
+				}
+				filtered = nil
+				for _, event := range events.Events {
+					filtered = append(filtered, awsSDK.StringValue(event.Message))
+				}
+				return len(filtered) > 0, nil
+			})
+			assert.Equal(t, []string{matching}, filtered, "log events matching %s", filterPattern)
+
+			sinkBucket := terraform.Output(t, terraformOptions, "sink_bucket_name")
+			sinkPrefix := terraform.Output(t, terraformOptions, "sink_prefix")
+			started := time.Now()
+			var delivered []string
+			_, err = retry.DoWithRetryE(t, "wait for the synthetic event in s3://"+sinkBucket+"/"+sinkPrefix, logSinkRetries, logSinkTimeBetween, func() (string, error) {
+				messages, err := sinkLogMessages(s3Client, sinkBucket, sinkPrefix)
+				if err != nil {
+					return "", err
+				}
+				delivered = messages
+				for _, message := range delivered {
+					if message == matching {
+						return "", nil
+					}
+				}
+				return "", fmt.Errorf("%d events in the sink, none of them the synthetic one", len(delivered))
+			})
+			require.NoError(t, err, "the synthetic event never reached the sink")
+			report.RecordDuration(t, "", "log-delivery", report.Pass, deviceLogGroup+" to s3://"+sinkBucket+"/"+sinkPrefix, time.Since(started))
+			assert.NotContains(t, delivered, unmatched, "an event the filter pattern does not match reached the sink")
+		},
+	})
+}
+
+// assertLogGroup checks that the log group keeps its events for
+// logRetentionInDays, is encrypted with the key and has a single subscription
+// filter, forwarding to the delivery stream.
+func assertLogGroup(t *testing.T, logsClient *cloudwatchlogs.CloudWatchLogs, logGroupName string, keyArn string, deliveryStreamArn string) {
+	groups, err := logsClient.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: awsSDK.String(logGroupName)})
+	require.NoError(t, err)
+
+	var group *cloudwatchlogs.LogGroup
+	for _, candidate := range groups.LogGroups {
+		if awsSDK.StringValue(candidate.LogGroupName) == logGroupName {
+			group = candidate
+		}
+	}
+	require.NotNil(t, group, "log group %s does not exist", logGroupName)
+	assert.Equal(t, int64(logRetentionInDays), awsSDK.Int64Value(group.RetentionInDays), "retention of %s", logGroupName)
+	assert.Equal(t, keyArn, awsSDK.StringValue(group.KmsKeyId), "KMS key of %s", logGroupName)
+
+	filters, err := logsClient.DescribeSubscriptionFilters(&cloudwatchlogs.DescribeSubscriptionFiltersInput{LogGroupName: awsSDK.String(logGroupName)})
+	require.NoError(t, err)
+	if assert.Len(t, filters.SubscriptionFilters, 1, "subscription filters of %s", logGroupName) {
+		assert.Equal(t, deliveryStreamArn, awsSDK.StringValue(filters.SubscriptionFilters[0].DestinationArn), "destination of the subscription filter of %s", logGroupName)
+	}
+}
+
+// writeSyntheticLogEvents writes the messages as log events to a new stream of
+// the log group, timestamped now, and returns the stream's name. The stream is
+// deleted when the test finishes.
+func writeSyntheticLogEvents(t *testing.T, logsClient *cloudwatchlogs.CloudWatchLogs, logGroupName string, logStream string, messages ...string) string {
+	_, err := logsClient.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  awsSDK.String(logGroupName),
+		LogStreamName: awsSDK.String(logStream),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if _, err := logsClient.DeleteLogStream(&cloudwatchlogs.DeleteLogStreamInput{
+			LogGroupName:  awsSDK.String(logGroupName),
+			LogStreamName: awsSDK.String(logStream),
+		}); err != nil {
+			t.Logf("Failed to delete log stream %s of %s: %v", logStream, logGroupName, err)
+		}
+	})
+
+	timestamp := time.Now().UnixMilli()
+	events := make([]*cloudwatchlogs.InputLogEvent, 0, len(messages))
+	for _, message := range messages {
+		events = append(events, &cloudwatchlogs.InputLogEvent{Message: awsSDK.String(message), Timestamp: awsSDK.Int64(timestamp)})
+	}
+	_, err = logsClient.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  awsSDK.String(logGroupName),
+		LogStreamName: awsSDK.String(logStream),
+		LogEvents:     events,
+	})
+	require.NoError(t, err)
+	return logStream
+}
+
+// cloudWatchLogsPayload is what a subscription filter sends to its
+// destination, gzipped.
+type cloudWatchLogsPayload struct {
+	MessageType string `json:"messageType"`
+	LogGroup    string `json:"logGroup"`
+	LogEvents   []struct {
+		Message string `json:"message"`
+	} `json:"logEvents"`
+}
+
+// sinkLogMessages returns the messages of every log event stored under the
+// prefix of the sink bucket. Each object holds the payloads of one buffer of
+// the delivery stream, concatenated.
+func sinkLogMessages(s3Client *s3.S3, bucket string, prefix string) ([]string, error) {
+	var keys []string
+	err := s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: awsSDK.String(bucket),
+		Prefix: awsSDK.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, awsSDK.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []string
+	for _, key := range keys {
+		object, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(key)})
+		if err != nil {
+			return nil, err
+		}
+		payloads, err := decodeCloudWatchLogsPayloads(object.Body)
+		object.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+		}
+		for _, payload := range payloads {
+			// Control messages only check the destination is reachable
+			if payload.MessageType != "DATA_MESSAGE" {
+				continue
+			}
+			for _, event := range payload.LogEvents {
+				messages = append(messages, event.Message)
+			}
+		}
+	}
+	return messages, nil
+}
+
+// decodeCloudWatchLogsPayloads decodes concatenated gzipped payloads.
+func decodeCloudWatchLogsPayloads(body io.Reader) ([]cloudWatchLogsPayload, error) {
+	reader, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var payloads []cloudWatchLogsPayload
+	decoder := json.NewDecoder(reader)
+	for {
+		var payload cloudWatchLogsPayload
+		if err := decoder.Decode(&payload); err == io.EOF {
+			return payloads, nil
+		} else if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+}
+
+// logsKeyGrants lets CloudWatch Logs encrypt the log groups of the modules
+// with the test's key, which it may only do when the key policy allows it.
+func logsKeyGrants(region string) func(accountId string) []testhelpers.KeyPolicyStatement {
+	return func(accountId string) []testhelpers.KeyPolicyStatement {
+		return []testhelpers.KeyPolicyStatement{{
+			"Sid":       "CloudWatchLogs",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": "logs." + region + ".amazonaws.com"},
+			"Action":    []string{"kms:Encrypt*", "kms:Decrypt*", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:Describe*"},
+			"Resource":  "*",
+			"Condition": map[string]interface{}{
+				"ArnLike": map[string]string{
+					"kms:EncryptionContext:aws:logs:arn": "arn:aws:logs:" + region + ":" + accountId + ":log-group:/iot/*",
+				},
+			},
+		}}
+	}
+}
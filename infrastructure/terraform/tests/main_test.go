@@ -0,0 +1,23 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+// TestMain writes the report of the checks that ran to TEST_REPORT_PATH, and
+// TEST_REPORT_JUNIT_PATH, once every test is done. Until then SIGTERM and
+// SIGINT destroy the modules under test before the binary exits.
+func TestMain(m *testing.M) {
+	stopDestroyOnSignal := testhelpers.DestroyOnSignal()
+	code := m.Run()
+	stopDestroyOnSignal()
+	if err := report.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write test report: %v\n", err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// monitoringBrokerNamespace keeps the synthetic broker datapoints of the test
+// apart from the metrics real brokers publish.
+const monitoringBrokerNamespace = "IoTNetwork/Terratest/Broker"
+
+// TestMonitoringModule applies the monitoring module against a VPC with a NAT
+// gateway and the iot-rules module, and checks that every alarm watches a
+// metric of a resource those modules created and notifies the alarms topic.
+// It then publishes a broker connection count below the alarm threshold and
+// waits, for up to TEST_POLL_DEADLINE, for the alarm to go into ALARM, which
+// proves the alarm is evaluated against the metric it is set up for.
+func TestMonitoringModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.21.0.0/16",
+		"az_count":     1,
+		"enable_nat":   true,
+	})
+	rulesOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-rules", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+	})
+
+	natGatewayIds := []string{"nat-00000000000000000"}
+	telemetryRuleName := "iot_network_telemetry"
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(true))
+		testhelpers.ApplyModule(t, vpcOptions)
+		testhelpers.ApplyModule(t, rulesOptions)
+		natGatewayIds = terraform.OutputList(t, vpcOptions, "nat_gateway_ids")
+		telemetryRuleName = terraform.Output(t, rulesOptions, "telemetry_rule_name")
+	}
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "monitoring", region, map[string]interface{}{
+		"project_name":            "iot-network",
+		"environment":             "test",
+		"owner":                   "terratest",
+		"cost_center":             "ci",
+		"telemetry_rule_name":     telemetryRuleName,
+		"nat_gateway_ids":         natGatewayIds,
+		"broker_metric_namespace": monitoringBrokerNamespace,
+		"min_broker_connections":  1,
+	})
+	// The module's local.name, which the broker dimension carries
+	deployment := testhelpers.NamePrefix(terraformOptions) + "-iot-network"
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			alarms := testhelpers.PlannedResourcesOfType(plan, "aws_cloudwatch_metric_alarm")
+			assert.Len(t, alarms, 2+len(natGatewayIds), "expected a broker and a rule alarm, and one per NAT gateway")
+			for address, alarm := range alarms {
+				namespace, _ := alarm["namespace"].(string)
+				assert.NotEmpty(t, namespace, "%s has no namespace", address)
+			}
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			cloudwatchClient := testhelpers.NewCloudWatchClient(t, region)
+			topicArn := terraform.Output(t, terraformOptions, "alarm_topic_arn")
+
+			alarms := alarmsWithPrefix(t, cloudwatchClient, testhelpers.NamePrefix(terraformOptions))
+			assert.ElementsMatch(t, terraform.OutputList(t, terraformOptions, "alarm_names"), alarmNames(alarms), "alarms with the name prefix")
+
+			natAlarms := map[string]bool{}
+			for _, alarm := range alarms {
+				name := awsSDK.StringValue(alarm.AlarmName)
+				assert.Contains(t, awsSDK.StringValueSlice(alarm.AlarmActions), topicArn, "alarm %s does not notify %s", name, topicArn)
+
+				dimensions := alarmDimensions(alarm)
+				switch namespace := awsSDK.StringValue(alarm.Namespace); namespace {
+				case monitoringBrokerNamespace:
+					assert.Equal(t, map[string]string{"Deployment": deployment}, dimensions, "dimensions of alarm %s", name)
+				case "AWS/IoT":
+					assert.Equal(t, telemetryRuleName, dimensions["RuleName"], "alarm %s does not watch the telemetry rule", name)
+				case "AWS/NATGateway":
+					natGatewayId := dimensions["NatGatewayId"]
+					assert.Contains(t, natGatewayIds, natGatewayId, "alarm %s watches a NAT gateway the vpc module did not create", name)
+					natAlarms[natGatewayId] = true
+				default:
+					t.Errorf("alarm %s watches namespace %s, which no module publishes to", name, namespace)
+				}
+			}
+			for _, natGatewayId := range natGatewayIds {
+				assert.True(t, natAlarms[natGatewayId], "no packet drop alarm for NAT gateway %s", natGatewayId)
+			}
+
+			dashboardName := terraform.Output(t, terraformOptions, "dashboard_name")
+			dashboard, err := cloudwatchClient.GetDashboard(&cloudwatch.GetDashboardInput{DashboardName: awsSDK.String(dashboardName)})
+			require.NoError(t, err, "dashboard %s does not exist", dashboardName)
+			body := awsSDK.StringValue(dashboard.DashboardBody)
+			require.True(t, json.Valid([]byte(body)), "dashboard %s body is not valid JSON", dashboardName)
+			for _, reference := range append([]string{telemetryRuleName, deployment}, natGatewayIds...) {
+				assert.Contains(t, body, reference, "dashboard %s does not chart %s", dashboardName, reference)
+			}
+
+			assertAlarmFires(t, cloudwatchClient, terraform.Output(t, terraformOptions, "broker_connections_alarm_name"), deployment)
+		},
+	})
+}
+
+// alarmsWithPrefix returns the metric alarms whose name starts with the name
+// prefix, leaving everything other runs created alone.
+func alarmsWithPrefix(t *testing.T, cloudwatchClient *cloudwatch.CloudWatch, namePrefix string) []*cloudwatch.MetricAlarm {
+	var alarms []*cloudwatch.MetricAlarm
+	err := cloudwatchClient.DescribeAlarmsPages(&cloudwatch.DescribeAlarmsInput{
+		AlarmNamePrefix: awsSDK.String(namePrefix + "-"),
+		AlarmTypes:      awsSDK.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm}),
+	}, func(page *cloudwatch.DescribeAlarmsOutput, _ bool) bool {
+		alarms = append(alarms, page.MetricAlarms...)
+		return true
+	})
+	require.NoError(t, err)
+	return alarms
+}
+
+func alarmNames(alarms []*cloudwatch.MetricAlarm) []string {
+	names := make([]string, 0, len(alarms))
+	for _, alarm := range alarms {
+		names = append(names, awsSDK.StringValue(alarm.AlarmName))
+	}
+	return names
+}
+
+func alarmDimensions(alarm *cloudwatch.MetricAlarm) map[string]string {
+	dimensions := map[string]string{}
+	for _, dimension := range alarm.Dimensions {
+		dimensions[awsSDK.StringValue(dimension.Name)] = awsSDK.StringValue(dimension.Value)
+	}
+	return dimensions
+}
+
+// assertAlarmFires publishes a connected client count of zero for the
+// deployment until the broker alarm goes into ALARM. The datapoint is published
+// on every poll so each evaluation period has one.
+func assertAlarmFires(t *testing.T, cloudwatchClient *cloudwatch.CloudWatch, alarmName string, deployment string) {
+	state := ""
+	fired := testhelpers.PollUntil(t, fmt.Sprintf("waiting for %s to go into ALARM", alarmName), func() (bool, error) {
+		_, err := cloudwatchClient.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace: awsSDK.String(monitoringBrokerNamespace),
+			MetricData: []*cloudwatch.MetricDatum{{
+				MetricName: awsSDK.String("ConnectedClients"),
+				Dimensions: []*cloudwatch.Dimension{{Name: awsSDK.String("Deployment"), Value: awsSDK.String(deployment)}},
+				Timestamp:  awsSDK.Time(time.Now()),
+				Unit:       awsSDK.String(cloudwatch.StandardUnitCount),
+				Value:      awsSDK.Float64(0),
+			}},
+		})
+		if err != nil {
+			return false, err
+		}
+
+		output, err := cloudwatchClient.DescribeAlarms(&cloudwatch.DescribeAlarmsInput{AlarmNames: awsSDK.StringSlice([]string{alarmName})})
+		if err != nil {
+			return false, err
+		}
+		if len(output.MetricAlarms) != 1 {
+			return false, fmt.Errorf("alarm %s not found", alarmName)
+		}
+		alarm := output.MetricAlarms[0]
+		state = awsSDK.StringValue(alarm.StateValue)
+		if state != cloudwatch.StateValueAlarm {
+			return false, fmt.Errorf("alarm %s is %s: %s", alarmName, state, strings.TrimSpace(awsSDK.StringValue(alarm.StateReason)))
+		}
+		return true, nil
+	})
+	assert.True(t, fired, "alarm %s never went into ALARM on a breaching datapoint, last state %s", alarmName, state)
+}
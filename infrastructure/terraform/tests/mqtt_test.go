@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iot"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	telemetryTopicFilter = "devices/test/+/telemetry"
+
+	// The broker may keep refusing a freshly provisioned certificate for a
+	// minute or so after apply, so connecting backs off from
+	// mqttInitialBackoff up to mqttMaxBackoff over mqttConnectAttempts tries.
+	mqttConnectAttempts = 8
+	mqttInitialBackoff  = 2 * time.Second
+	mqttMaxBackoff      = 30 * time.Second
+
+	mqttTimeout = 30 * time.Second
+)
+
+// TestMqttPublishSubscribe provisions a device against the IoT Core broker,
+// connects to it over MQTT/TLS with the device certificate and checks that a
+// message published on the device's telemetry topic comes back through a
+// subscription. It also checks that the broker does not accept plaintext MQTT.
+func TestMqttPublishSubscribe(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "iot-core", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Quotas: iotCoreQuotaRequirements,
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			t.Cleanup(func() { report.Record(t, "", "mqtt-e2e", report.StatusOf(t), "") })
+
+			iotClient := testhelpers.NewIotClient(t, testhelpers.Region(terraformOptions))
+			endpoint := terraform.Output(t, terraformOptions, "broker_endpoint")
+			namePrefix := testhelpers.NamePrefix(terraformOptions)
+
+			templateName := terraform.Output(t, terraformOptions, "provisioning_template_name")
+			template, err := iotClient.DescribeProvisioningTemplate(&iot.DescribeProvisioningTemplateInput{TemplateName: awsSDK.String(templateName)})
+			require.NoError(t, err)
+
+			device := provisionTestDevice(t, iotClient, awsSDK.StringValue(template.TemplateBody), namePrefix+"-mqtt-device")
+			policyName := terraform.Output(t, terraformOptions, "device_policy_name")
+			_, err = iotClient.AttachPolicy(&iot.AttachPolicyInput{PolicyName: awsSDK.String(policyName), Target: awsSDK.String(device.certificateArn)})
+			require.NoError(t, err)
+			// The device policy only lets a device subscribe to its own
+			// topics; listening to every device is for the test alone
+			attachTelemetryMonitorPolicy(t, iotClient, namePrefix+"-telemetry-monitor", device.certificateArn)
+
+			certificate, err := tls.X509KeyPair([]byte(device.certificatePem), []byte(device.privateKey))
+			require.NoError(t, err)
+			client := connectMqtt(t, mqtt.NewClientOptions().
+				AddBroker(fmt.Sprintf("ssl://%s:8883", endpoint)).
+				SetClientID(device.thingName).
+				SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}))
+
+			// Other tests publish telemetry of their own devices under the
+			// same filter, so only this device's topic may take the slot
+			topic := fmt.Sprintf("devices/test/%s/telemetry", device.thingName)
+			received := make(chan mqtt.Message, 1)
+			subscription := client.Subscribe(telemetryTopicFilter, 1, func(_ mqtt.Client, message mqtt.Message) {
+				if message.Topic() != topic {
+					return
+				}
+				select {
+				case received <- message:
+				default:
+				}
+			})
+			require.True(t, subscription.WaitTimeout(mqttTimeout), "timed out subscribing to %s", telemetryTopicFilter)
+			require.NoError(t, subscription.Error(), "could not subscribe to %s", telemetryTopicFilter)
+
+			payload := fmt.Sprintf(`{"device":%q,"sent_at":%q}`, device.thingName, time.Now().UTC().Format(time.RFC3339Nano))
+			publication := client.Publish(topic, 1, false, payload)
+			require.True(t, publication.WaitTimeout(mqttTimeout), "timed out publishing to %s", topic)
+			require.NoError(t, publication.Error(), "could not publish to %s", topic)
+
+			select {
+			case message := <-received:
+				assert.Equal(t, topic, message.Topic())
+				assert.Equal(t, payload, string(message.Payload()))
+			case <-time.After(mqttTimeout):
+				t.Errorf("message published on %s was not received within %s", topic, mqttTimeout)
+			}
+
+			// IoT Core only speaks MQTT over TLS, so nothing should answer on
+			// the plaintext port
+			plaintext := mqtt.NewClient(mqtt.NewClientOptions().
+				AddBroker(fmt.Sprintf("tcp://%s:1883", endpoint)).
+				SetClientID(device.thingName + "-plaintext").
+				SetConnectTimeout(10 * time.Second).
+				SetConnectRetry(false))
+			connection := plaintext.Connect()
+			if !connection.WaitTimeout(15 * time.Second) {
+				return
+			}
+			if connection.Error() == nil {
+				plaintext.Disconnect(0)
+			}
+			assert.Error(t, connection.Error(), "plaintext MQTT connection to %s:1883 was accepted", endpoint)
+		},
+	})
+}
+
+// connectMqtt connects the client, backing off exponentially between attempts,
+// and disconnects it when the test finishes.
+func connectMqtt(t *testing.T, options *mqtt.ClientOptions) mqtt.Client {
+	options.SetConnectTimeout(mqttTimeout).SetAutoReconnect(false).SetConnectRetry(false)
+	client := mqtt.NewClient(options)
+
+	backoff := mqttInitialBackoff
+	var err error
+	for attempt := 1; attempt <= mqttConnectAttempts; attempt++ {
+		token := client.Connect()
+		if !token.WaitTimeout(mqttTimeout + 5*time.Second) {
+			err = fmt.Errorf("timed out")
+		} else {
+			err = token.Error()
+		}
+		if err == nil {
+			t.Cleanup(func() { client.Disconnect(250) })
+			return client
+		}
+
+		t.Logf("MQTT connect attempt %d of %d failed, retrying in %s: %v", attempt, mqttConnectAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, mqttMaxBackoff)
+	}
+	require.NoError(t, err, "could not connect to the broker after %d attempts", mqttConnectAttempts)
+	return nil
+}
+
+// attachTelemetryMonitorPolicy creates an IoT policy that may subscribe to the
+// telemetry of every test device and attaches it to the certificate. The
+// policy is detached and deleted when the test finishes.
+func attachTelemetryMonitorPolicy(t *testing.T, iotClient *iot.IoT, policyName string, certificateArn string) {
+	arnPrefix := certificateArn[:strings.Index(certificateArn, ":cert/")]
+	document, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{"Effect": "Allow", "Action": "iot:Subscribe", "Resource": arnPrefix + ":topicfilter/" + telemetryTopicFilter},
+			{"Effect": "Allow", "Action": "iot:Receive", "Resource": arnPrefix + ":topic/devices/test/*/telemetry"},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = iotClient.CreatePolicy(&iot.CreatePolicyInput{PolicyName: awsSDK.String(policyName), PolicyDocument: awsSDK.String(string(document))})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if _, err := iotClient.DetachPolicy(&iot.DetachPolicyInput{PolicyName: awsSDK.String(policyName), Target: awsSDK.String(certificateArn)}); err != nil {
+			t.Logf("Failed to detach policy %s from %s: %v", policyName, certificateArn, err)
+		}
+		if _, err := iotClient.DeletePolicy(&iot.DeletePolicyInput{PolicyName: awsSDK.String(policyName)}); err != nil {
+			t.Logf("Failed to delete policy %s: %v", policyName, err)
+		}
+	})
+
+	_, err = iotClient.AttachPolicy(&iot.AttachPolicyInput{PolicyName: awsSDK.String(policyName), Target: awsSDK.String(certificateArn)})
+	require.NoError(t, err)
+}
@@ -0,0 +1,370 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	hubVpcCidr    = "10.30.0.0/16"
+	spokeAVpcCidr = "10.31.0.0/16"
+	spokeBVpcCidr = "10.32.0.0/16"
+
+	crossAccountHubVpcCidr   = "10.33.0.0/16"
+	crossAccountSpokeVpcCidr = "10.34.0.0/16"
+
+	// brokerPort is what the reachability checks probe, as devices connect to
+	// the broker in the hub over MQTT/TLS.
+	brokerPort = 8883
+
+	// peerAssumeRoleArnEnvVar is the role in a second account that
+	// TestVpcPeeringCrossAccount applies the spoke under. The test is skipped
+	// when it is unset.
+	peerAssumeRoleArnEnvVar = "TEST_PEER_ASSUME_ROLE_ARN"
+
+	// placeholderAccountId stands in for the spoke account in plan-only mode.
+	placeholderAccountId = "000000000000"
+)
+
+// peeredVpc is a VPC applied for the peering test.
+type peeredVpc struct {
+	id               string
+	cidrBlock        string
+	routeTableIds    []string
+	privateSubnetIds []string
+}
+
+// TestVpcPeering peers two spoke VPCs to a hub VPC and checks that the peering
+// is active, that routes exist in both directions, and that Reachability
+// Analyzer finds a path from a spoke to the hub's broker subnet but none from
+// one spoke to the other.
+func TestVpcPeering(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	hub := applyPeeredVpc(t, region, hubVpcCidr, "")
+	spokeA := applyPeeredVpc(t, region, spokeAVpcCidr, "")
+	spokeB := applyPeeredVpc(t, region, spokeBVpcCidr, "")
+
+	peeringOptions := newPeeringOptions(t, region, hub, spokeA, "")
+
+	// The second spoke is peered to the same hub so that the spoke to spoke
+	// check would catch the hub routing between them
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.ApplyModule(t, newPeeringOptions(t, region, hub, spokeB, ""))
+	}
+
+	testhelpers.RunModuleChecks(t, peeringOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_vpc_peering_connection.hub_spoke")
+			peering := plan.ResourcePlannedValuesMap["aws_vpc_peering_connection.hub_spoke"].AttributeValues
+			assert.Equal(t, true, peering["auto_accept"], "peering connection is not auto-accepted")
+
+			assert.Len(t, testhelpers.PlannedResourcesOfType(plan, "aws_route"), len(hub.routeTableIds)+len(spokeA.routeTableIds),
+				"expected a route in every hub and spoke route table")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			peeringId := terraform.Output(t, terraformOptions, "peering_connection_id")
+
+			ec2Client := ec2.New(testhelpers.NewSession(t, region))
+			peering := describePeering(t, ec2Client, peeringId)
+			assert.Equal(t, ec2.VpcPeeringConnectionStateReasonCodeActive, awsSDK.StringValue(peering.Status.Code), "peering connection %s is not active", peeringId)
+			assert.Equal(t, hub.id, awsSDK.StringValue(peering.RequesterVpcInfo.VpcId), "peering connection %s requester", peeringId)
+			assert.Equal(t, spokeA.id, awsSDK.StringValue(peering.AccepterVpcInfo.VpcId), "peering connection %s accepter", peeringId)
+
+			assertPeeringRoutes(t, ec2Client, hub.routeTableIds, spokeA.cidrBlock, peeringId)
+			assertPeeringRoutes(t, ec2Client, spokeA.routeTableIds, hub.cidrBlock, peeringId)
+
+			namePrefix := testhelpers.NamePrefix(terraformOptions)
+			spokeAInterface := createProbeInterface(t, ec2Client, namePrefix+"-spoke-a", spokeA)
+			hubInterface := createProbeInterface(t, ec2Client, namePrefix+"-hub", hub)
+			spokeBInterface := createProbeInterface(t, ec2Client, namePrefix+"-spoke-b", spokeB)
+
+			routed := assert.True(t, pathExists(t, ec2Client, spokeAInterface, hubInterface),
+				"no path from the spoke private subnet to the hub broker subnet on port %d", brokerPort)
+			isolated := assert.False(t, pathExists(t, ec2Client, spokeAInterface, spokeBInterface),
+				"found a path from one spoke to another on port %d, peering should not be transitive", brokerPort)
+			routing := report.Pass
+			if !routed || !isolated {
+				routing = report.Fail
+			}
+			report.Record(t, "", "routing", routing, fmt.Sprintf("spoke to hub: %t, spoke to spoke: %t", routed, !isolated))
+		},
+	})
+}
+
+// TestVpcPeeringCrossAccount peers a spoke VPC in the account of
+// TEST_PEER_ASSUME_ROLE_ARN to a hub VPC in the account the tests run in. The
+// hub requests the peering, the spoke accepts it with the vpc-peering-accepter
+// module applied under the peer role, and the test checks that the peering
+// turns active with routes on both sides.
+func TestVpcPeeringCrossAccount(t *testing.T) {
+	t.Parallel()
+
+	spokeRoleArn := os.Getenv(peerAssumeRoleArnEnvVar)
+	if spokeRoleArn == "" {
+		t.Skipf("%s is not set, no second account to peer with", peerAssumeRoleArnEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+	hub := applyPeeredVpc(t, region, crossAccountHubVpcCidr, "")
+	spoke := applyPeeredVpc(t, region, crossAccountSpokeVpcCidr, spokeRoleArn)
+
+	hubAccountId, spokeAccountId := placeholderAccountId, placeholderAccountId
+	if !testhelpers.IsPlanOnly() {
+		hubAccountId = testhelpers.AccountId(t, testhelpers.NewSession(t, region))
+		spokeAccountId = testhelpers.AccountId(t, testhelpers.NewSessionOfRole(t, region, spokeRoleArn))
+		require.NotEqual(t, hubAccountId, spokeAccountId, "%s is a role in the account the tests run in", peerAssumeRoleArnEnvVar)
+	}
+
+	peeringOptions := newPeeringOptions(t, region, hub, spoke, spokeAccountId)
+
+	testhelpers.RunModuleChecks(t, peeringOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_vpc_peering_connection.hub_spoke")
+			peering := plan.ResourcePlannedValuesMap["aws_vpc_peering_connection.hub_spoke"].AttributeValues
+			assert.Equal(t, false, peering["auto_accept"], "peering connection across accounts is auto-accepted")
+			assert.Equal(t, spokeAccountId, peering["peer_owner_id"], "planned peer_owner_id")
+
+			assert.Len(t, testhelpers.PlannedResourcesOfType(plan, "aws_route"), len(hub.routeTableIds),
+				"expected a route in every hub route table and none in the spoke's")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			peeringId := terraform.Output(t, terraformOptions, "peering_connection_id")
+			hubEc2Client := ec2.New(testhelpers.SessionFor(t, terraformOptions))
+
+			peering := describePeering(t, hubEc2Client, peeringId)
+			assert.Equal(t, ec2.VpcPeeringConnectionStateReasonCodePendingAcceptance, awsSDK.StringValue(peering.Status.Code),
+				"peering connection %s before the spoke accepted it", peeringId)
+
+			accepterOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc-peering-accepter", region, map[string]interface{}{
+				"project_name":          "iot-network",
+				"environment":           "test",
+				"owner":                 "terratest",
+				"cost_center":           "ci",
+				"peering_connection_id": peeringId,
+				"hub_cidr_block":        hub.cidrBlock,
+				"spoke_route_table_ids": spoke.routeTableIds,
+			})
+			testhelpers.UseRole(t, accepterOptions, spokeRoleArn)
+			testhelpers.ApplyModule(t, accepterOptions)
+			assert.Equal(t, ec2.VpcPeeringConnectionStateReasonCodeActive, terraform.Output(t, accepterOptions, "accept_status"), "accept_status")
+
+			active := testhelpers.PollUntil(t, "peering connection "+peeringId+" active", func() (bool, error) {
+				peering = describePeering(t, hubEc2Client, peeringId)
+				return awsSDK.StringValue(peering.Status.Code) == ec2.VpcPeeringConnectionStateReasonCodeActive, nil
+			})
+			require.True(t, active, "peering connection %s is %s once accepted", peeringId, awsSDK.StringValue(peering.Status.Code))
+			assert.Equal(t, hubAccountId, awsSDK.StringValue(peering.RequesterVpcInfo.OwnerId), "peering connection %s requester account", peeringId)
+			assert.Equal(t, spokeAccountId, awsSDK.StringValue(peering.AccepterVpcInfo.OwnerId), "peering connection %s accepter account", peeringId)
+			assert.Equal(t, spoke.id, awsSDK.StringValue(peering.AccepterVpcInfo.VpcId), "peering connection %s accepter", peeringId)
+
+			// Each side only sees the route tables of its own account
+			assertPeeringRoutes(t, hubEc2Client, hub.routeTableIds, spoke.cidrBlock, peeringId)
+			assertPeeringRoutes(t, ec2.New(testhelpers.SessionFor(t, accepterOptions)), spoke.routeTableIds, hub.cidrBlock, peeringId)
+		},
+	})
+}
+
+// applyPeeredVpc applies a single-AZ VPC without NAT in the region, under the
+// role when one is given. In plan-only mode nothing is applied and placeholder IDs are
+// returned for the peering module to be planned against.
+func applyPeeredVpc(t *testing.T, region string, cidrBlock string, roleArn string) peeredVpc {
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   cidrBlock,
+		"az_count":     1,
+		"enable_nat":   false,
+	})
+
+	if testhelpers.IsPlanOnly() {
+		return peeredVpc{
+			id:               "vpc-00000000000000000",
+			cidrBlock:        cidrBlock,
+			routeTableIds:    []string{"rtb-00000000000000000", "rtb-00000000000000001"},
+			privateSubnetIds: []string{"subnet-00000000000000000"},
+		}
+	}
+
+	if roleArn != "" {
+		testhelpers.UseRole(t, terraformOptions, roleArn)
+	}
+	testhelpers.SkipWithoutQuotaHeadroom(t, region, vpcQuotaRequirements(false))
+	testhelpers.ApplyModule(t, terraformOptions)
+	return peeredVpc{
+		id:        terraform.Output(t, terraformOptions, "vpc_id"),
+		cidrBlock: cidrBlock,
+		routeTableIds: append([]string{terraform.Output(t, terraformOptions, "public_route_table_id")},
+			terraform.OutputList(t, terraformOptions, "private_route_table_ids")...),
+		privateSubnetIds: terraform.OutputList(t, terraformOptions, "private_subnet_ids"),
+	}
+}
+
+// newPeeringOptions builds options for the vpc-peering module in the region. A
+// spoke account ID makes it request a peering the spoke has to accept.
+func newPeeringOptions(t *testing.T, region string, hub peeredVpc, spoke peeredVpc, spokeAccountId string) *terraform.Options {
+	return testhelpers.NewModuleOptionsInRegion(t, "vpc-peering", region, map[string]interface{}{
+		"project_name":          "iot-network",
+		"environment":           "test",
+		"owner":                 "terratest",
+		"cost_center":           "ci",
+		"hub_vpc_id":            hub.id,
+		"hub_cidr_block":        hub.cidrBlock,
+		"hub_route_table_ids":   hub.routeTableIds,
+		"spoke_vpc_id":          spoke.id,
+		"spoke_cidr_block":      spoke.cidrBlock,
+		"spoke_route_table_ids": spoke.routeTableIds,
+		"spoke_account_id":      spokeAccountId,
+	})
+}
+
+// describePeering returns the peering connection as the account of the client
+// sees it.
+func describePeering(t *testing.T, ec2Client *ec2.EC2, peeringId string) *ec2.VpcPeeringConnection {
+	peerings, err := ec2Client.DescribeVpcPeeringConnections(&ec2.DescribeVpcPeeringConnectionsInput{
+		VpcPeeringConnectionIds: []*string{awsSDK.String(peeringId)},
+	})
+	require.NoError(t, err)
+	require.Len(t, peerings.VpcPeeringConnections, 1, "DescribeVpcPeeringConnections did not return %s", peeringId)
+	return peerings.VpcPeeringConnections[0]
+}
+
+// assertPeeringRoutes checks that every route table has an active route to the
+// CIDR through the peering connection.
+func assertPeeringRoutes(t *testing.T, ec2Client *ec2.EC2, routeTableIds []string, destinationCidr string, peeringId string) {
+	routeTables, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{RouteTableIds: awsSDK.StringSlice(routeTableIds)})
+	require.NoError(t, err)
+	require.Len(t, routeTables.RouteTables, len(routeTableIds))
+
+	for _, routeTable := range routeTables.RouteTables {
+		var found bool
+		for _, route := range routeTable.Routes {
+			if awsSDK.StringValue(route.DestinationCidrBlock) == destinationCidr &&
+				awsSDK.StringValue(route.VpcPeeringConnectionId) == peeringId &&
+				awsSDK.StringValue(route.State) == ec2.RouteStateActive {
+				found = true
+			}
+		}
+		assert.True(t, found, "route table %s has no active route to %s through %s",
+			awsSDK.StringValue(routeTable.RouteTableId), destinationCidr, peeringId)
+	}
+}
+
+// createProbeInterface creates a network interface in the first private subnet
+// of the VPC, in a security group that accepts the broker port from the
+// 10.0.0.0/8 range the test VPCs share. Reachability Analyzer needs such an
+// endpoint on each side of a path; no instance is required. Both are deleted
+// when the test finishes.
+func createProbeInterface(t *testing.T, ec2Client *ec2.EC2, name string, vpc peeredVpc) string {
+	group, err := ec2Client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   awsSDK.String(name + "-probe"),
+		Description: awsSDK.String("Reachability probe for the peering test"),
+		VpcId:       awsSDK.String(vpc.id),
+	})
+	require.NoError(t, err)
+	groupId := group.GroupId
+	t.Cleanup(func() {
+		if _, err := ec2Client.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: groupId}); err != nil {
+			t.Logf("Failed to delete security group %s: %v", awsSDK.StringValue(groupId), err)
+		}
+	})
+
+	_, err = ec2Client.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:    groupId,
+		IpProtocol: awsSDK.String("tcp"),
+		FromPort:   awsSDK.Int64(brokerPort),
+		ToPort:     awsSDK.Int64(brokerPort),
+		CidrIp:     awsSDK.String("10.0.0.0/8"),
+	})
+	require.NoError(t, err)
+
+	networkInterface, err := ec2Client.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{
+		SubnetId: awsSDK.String(vpc.privateSubnetIds[0]),
+		Groups:   []*string{groupId},
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: awsSDK.String(ec2.ResourceTypeNetworkInterface),
+			Tags:         []*ec2.Tag{{Key: awsSDK.String("Name"), Value: awsSDK.String(name + "-probe")}},
+		}},
+	})
+	require.NoError(t, err)
+	interfaceId := networkInterface.NetworkInterface.NetworkInterfaceId
+	t.Cleanup(func() {
+		if _, err := ec2Client.DeleteNetworkInterface(&ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: interfaceId}); err != nil {
+			t.Logf("Failed to delete network interface %s: %v", awsSDK.StringValue(interfaceId), err)
+		}
+	})
+
+	return awsSDK.StringValue(interfaceId)
+}
+
+// pathExists asks Reachability Analyzer whether TCP traffic to the broker port
+// can get from the source interface to the destination interface. The path
+// and its analysis are deleted when the test finishes.
+func pathExists(t *testing.T, ec2Client *ec2.EC2, sourceInterfaceId string, destinationInterfaceId string) bool {
+	path, err := ec2Client.CreateNetworkInsightsPath(&ec2.CreateNetworkInsightsPathInput{
+		Source:          awsSDK.String(sourceInterfaceId),
+		Destination:     awsSDK.String(destinationInterfaceId),
+		Protocol:        awsSDK.String(ec2.ProtocolTcp),
+		DestinationPort: awsSDK.Int64(brokerPort),
+	})
+	require.NoError(t, err)
+	pathId := path.NetworkInsightsPath.NetworkInsightsPathId
+	t.Cleanup(func() {
+		if _, err := ec2Client.DeleteNetworkInsightsPath(&ec2.DeleteNetworkInsightsPathInput{NetworkInsightsPathId: pathId}); err != nil {
+			t.Logf("Failed to delete network insights path %s: %v", awsSDK.StringValue(pathId), err)
+		}
+	})
+
+	started, err := ec2Client.StartNetworkInsightsAnalysis(&ec2.StartNetworkInsightsAnalysisInput{NetworkInsightsPathId: pathId})
+	require.NoError(t, err)
+	analysisId := started.NetworkInsightsAnalysis.NetworkInsightsAnalysisId
+	// Registered after the path, so it runs first: a path with analyses
+	// cannot be deleted
+	t.Cleanup(func() {
+		if _, err := ec2Client.DeleteNetworkInsightsAnalysis(&ec2.DeleteNetworkInsightsAnalysisInput{NetworkInsightsAnalysisId: analysisId}); err != nil {
+			t.Logf("Failed to delete network insights analysis %s: %v", awsSDK.StringValue(analysisId), err)
+		}
+	})
+
+	var pathFound bool
+	description := fmt.Sprintf("wait for analysis of %s to %s", sourceInterfaceId, destinationInterfaceId)
+	retry.DoWithRetry(t, description, 30, 10*time.Second, func() (string, error) {
+		analyses, err := ec2Client.DescribeNetworkInsightsAnalyses(&ec2.DescribeNetworkInsightsAnalysesInput{
+			NetworkInsightsAnalysisIds: []*string{analysisId},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(analyses.NetworkInsightsAnalyses) != 1 {
+			return "", fmt.Errorf("analysis %s not found", awsSDK.StringValue(analysisId))
+		}
+
+		analysis := analyses.NetworkInsightsAnalyses[0]
+		switch awsSDK.StringValue(analysis.Status) {
+		case ec2.AnalysisStatusSucceeded:
+			pathFound = awsSDK.BoolValue(analysis.NetworkPathFound)
+			return "", nil
+		case ec2.AnalysisStatusFailed:
+			return "", retry.FatalError{Underlying: fmt.Errorf("analysis failed: %s", awsSDK.StringValue(analysis.StatusMessage))}
+		}
+		return "", fmt.Errorf("analysis is %s", awsSDK.StringValue(analysis.Status))
+	})
+	return pathFound
+}
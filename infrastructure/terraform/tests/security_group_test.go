@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	mqttTestVpcCidr    = "10.20.0.0/16"
+	mqttTestDeviceCidr = "203.0.113.0/24"
+)
+
+func TestMqttSecurityGroup(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name               string
+		allowPlaintextMqtt bool
+		expectedIngress    []string
+	}{
+		{
+			name:               "PlaintextInsideVpc",
+			allowPlaintextMqtt: true,
+			expectedIngress: []string{
+				"tcp 8883-8883 from " + mqttTestDeviceCidr,
+				"tcp 1883-1883 from " + mqttTestVpcCidr,
+			},
+		},
+		{
+			name:               "TlsOnly",
+			allowPlaintextMqtt: false,
+			expectedIngress: []string{
+				"tcp 8883-8883 from " + mqttTestDeviceCidr,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   mqttTestVpcCidr,
+				"az_count":     1,
+				"enable_nat":   false,
+			})
+
+			// The security module needs a VPC to attach to. In plan-only mode
+			// nothing is applied, so it is planned against a placeholder ID.
+			vpcId := "vpc-00000000000000000"
+			if !testhelpers.IsPlanOnly() {
+				testhelpers.SkipWithoutQuotaHeadroom(t, region, vpcQuotaRequirements(false))
+				testhelpers.ApplyModule(t, vpcOptions)
+				vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+			}
+
+			securityOptions := testhelpers.NewModuleOptionsInRegion(t, "security", region, map[string]interface{}{
+				"project_name":         "iot-network",
+				"environment":          "test",
+				"vpc_id":               vpcId,
+				"vpc_cidr_block":       mqttTestVpcCidr,
+				"device_cidr_blocks":   []string{mqttTestDeviceCidr},
+				"allow_plaintext_mqtt": tc.allowPlaintextMqtt,
+			})
+
+			testhelpers.RunModuleChecks(t, securityOptions, testhelpers.ModuleChecks{
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_security_group.mqtt")
+					ingress, _ := plan.ResourcePlannedValuesMap["aws_security_group.mqtt"].AttributeValues["ingress"].([]interface{})
+					var ports []float64
+					for _, rule := range ingress {
+						ports = append(ports, rule.(map[string]interface{})["from_port"].(float64))
+					}
+					if tc.allowPlaintextMqtt {
+						assert.ElementsMatch(t, []float64{8883, 1883}, ports, "planned MQTT ingress ports")
+					} else {
+						assert.ElementsMatch(t, []float64{8883}, ports, "planned MQTT ingress ports")
+					}
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					groupId := terraform.Output(t, terraformOptions, "mqtt_sg_id")
+					ingress := describeIngressRules(t, region, groupId)
+
+					for _, rule := range ingress {
+						assert.NotContains(t, rule, "from 0.0.0.0/0", "security group %s is open to the world: %s", groupId, rule)
+						assert.NotContains(t, rule, "from ::/0", "security group %s is open to the world: %s", groupId, rule)
+					}
+					assert.ElementsMatch(t, tc.expectedIngress, ingress, "security group %s has unexpected ingress rules", groupId)
+				},
+			})
+		})
+	}
+}
+
+// describeIngressRules flattens the ingress rules of a security group into one
+// "<protocol> <from>-<to> from <source>" string per source so that rule sets
+// can be compared and printed directly.
+func describeIngressRules(t *testing.T, region string, groupId string) []string {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	out, err := ec2Client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{awsSDK.String(groupId)}})
+	require.NoError(t, err)
+	require.Len(t, out.SecurityGroups, 1, "DescribeSecurityGroups did not return %s", groupId)
+
+	var rules []string
+	for _, permission := range out.SecurityGroups[0].IpPermissions {
+		ports := fmt.Sprintf("%s %d-%d", awsSDK.StringValue(permission.IpProtocol), awsSDK.Int64Value(permission.FromPort), awsSDK.Int64Value(permission.ToPort))
+		for _, ipRange := range permission.IpRanges {
+			rules = append(rules, fmt.Sprintf("%s from %s", ports, awsSDK.StringValue(ipRange.CidrIp)))
+		}
+		for _, ipRange := range permission.Ipv6Ranges {
+			rules = append(rules, fmt.Sprintf("%s from %s", ports, awsSDK.StringValue(ipRange.CidrIpv6)))
+		}
+		for _, pair := range permission.UserIdGroupPairs {
+			rules = append(rules, fmt.Sprintf("%s from %s", ports, awsSDK.StringValue(pair.GroupId)))
+		}
+		for _, prefixList := range permission.PrefixListIds {
+			rules = append(rules, fmt.Sprintf("%s from %s", ports, awsSDK.StringValue(prefixList.PrefixListId)))
+		}
+	}
+	return rules
+}
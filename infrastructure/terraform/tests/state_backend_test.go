@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// backendStateKey is where the vpc module keeps its state in the bucket.
+	backendStateKey = "vpc/terraform.tfstate"
+
+	// A second apply that waits for the lock instead of failing would take
+	// about as long as the first one, so anything slower than this is not
+	// failing fast.
+	lockContentionMaxDuration = time.Minute
+)
+
+// TestStateBackend provisions the state backend and applies the vpc module
+// with its state in it. The state object has to be encrypted and versioned,
+// the lock table has to hold a lock while the apply runs, and a second apply
+// started meanwhile has to fail on the lock straight away.
+func TestStateBackend(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	dataKeyArn, dataKeyAlias := testhelpers.CreateDataKey(t, region, nil)
+
+	backendOptions := testhelpers.NewModuleOptionsInRegion(t, "state-backend", region, map[string]interface{}{
+		"project_name":  "iot-network",
+		"environment":   "test",
+		"owner":         "terratest",
+		"cost_center":   "ci",
+		"force_destroy": true,
+		"kms_key_arn":   dataKeyArn,
+	})
+
+	testhelpers.RunModuleChecks(t, backendOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			terraform.RequirePlannedValuesMapKeyExists(t, plan, "aws_dynamodb_table.lock")
+			hashKey := plan.ResourcePlannedValuesMap["aws_dynamodb_table.lock"].AttributeValues["hash_key"]
+			assert.Equal(t, "LockID", hashKey, "the s3 backend only works with a LockID hash key")
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			bucket := terraform.Output(t, terraformOptions, "bucket_name")
+			lockTable := terraform.Output(t, terraformOptions, "lock_table_name")
+
+			testhelpers.AssertEncryptedAtRest(t, region, []string{
+				terraform.Output(t, terraformOptions, "bucket_arn"),
+				terraform.Output(t, terraformOptions, "lock_table_arn"),
+			}, dataKeyAlias)
+
+			// The NAT gateway keeps the apply running for a couple of
+			// minutes, long enough to contend for the lock
+			vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   "10.6.0.0/16",
+				"az_count":     1,
+				"enable_nat":   true,
+			})
+			useS3Backend(t, vpcOptions, region, bucket, lockTable)
+			testhelpers.SkipWithoutQuotaHeadroom(t, region, vpcQuotaRequirements(true))
+			testhelpers.SkipWithoutTerraformSlots(t, 2)
+
+			// A second copy of the module sharing the same state, applied
+			// while the first apply holds the lock
+			contenderOptions, err := vpcOptions.Clone()
+			require.NoError(t, err)
+			contenderOptions.TerraformDir = testhelpers.ModuleWorkingDir(t, "vpc")
+			useS3Backend(t, contenderOptions, region, bucket, lockTable)
+			contenderOptions.MaxRetries = 0
+			testhelpers.InitModule(t, contenderOptions)
+
+			lockID := bucket + "/" + backendStateKey
+			dynamoClient := dynamodb.New(testhelpers.NewSession(t, region))
+
+			// Every way out of the test, the failed asserts included, stops
+			// the poller and waits for it and the apply to finish
+			applied := make(chan error, 1)
+			applyFinished := false
+			defer func() {
+				if !applyFinished {
+					<-applied
+				}
+			}()
+			go func() {
+				_, err := testhelpers.ApplyModuleE(t, vpcOptions)
+				applied <- err
+			}()
+
+			var lockSeen atomic.Bool
+			polled := make(chan struct{})
+			defer func() { <-polled }()
+			stopPolling := make(chan struct{})
+			defer close(stopPolling)
+			go func() {
+				defer close(polled)
+				for {
+					select {
+					case <-stopPolling:
+						return
+					case <-time.After(time.Second):
+					}
+					item, err := dynamoClient.GetItem(&dynamodb.GetItemInput{
+						TableName:      awsSDK.String(lockTable),
+						Key:            map[string]*dynamodb.AttributeValue{"LockID": {S: awsSDK.String(lockID)}},
+						ConsistentRead: awsSDK.Bool(true),
+					})
+					if err == nil && len(item.Item) > 0 {
+						lockSeen.Store(true)
+					}
+				}
+			}()
+
+			contended := false
+			for !contended {
+				select {
+				case err := <-applied:
+					applyFinished = true
+					require.NoError(t, err, "apply with the s3 backend failed")
+					t.Fatal("apply finished before the lock could be contended")
+				case <-time.After(time.Second):
+				}
+				if !lockSeen.Load() {
+					continue
+				}
+
+				started := time.Now()
+				output, err := testhelpers.ApplyModuleE(t, contenderOptions)
+				elapsed := time.Since(started)
+				require.Error(t, err, "second apply did not fail while the state was locked")
+				assert.Contains(t, output, "Error acquiring the state lock", "second apply failed for another reason")
+				assert.Less(t, elapsed, lockContentionMaxDuration, "second apply took %s to fail on the lock", elapsed)
+				contended = true
+			}
+
+			err = <-applied
+			applyFinished = true
+			require.NoError(t, err, "apply with the s3 backend failed")
+			assert.True(t, lockSeen.Load(), "no lock item %s appeared in %s during apply", lockID, lockTable)
+
+			s3Client := s3.New(testhelpers.NewSession(t, region))
+			object, err := s3Client.HeadObject(&s3.HeadObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(backendStateKey)})
+			require.NoError(t, err, "state object s3://%s/%s does not exist", bucket, backendStateKey)
+			assert.NotEmpty(t, awsSDK.StringValue(object.ServerSideEncryption), "state object is not encrypted")
+			assert.NotEmpty(t, awsSDK.StringValue(object.VersionId), "state object has no version ID")
+			versioning, err := s3Client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: awsSDK.String(bucket)})
+			require.NoError(t, err)
+			assert.Equal(t, s3.BucketVersioningStatusEnabled, awsSDK.StringValue(versioning.Status), "bucket versioning")
+		},
+	})
+}
+
+// useS3Backend points the module of the options at the state backend. The
+// modules leave the backend unconfigured, so an empty s3 backend block is
+// added to the test's copy. Locking is off by default in terratest and is
+// turned on, without waiting for a held lock.
+func useS3Backend(t *testing.T, terraformOptions *terraform.Options, region string, bucket string, lockTable string) {
+	backend := []byte("terraform {\n  backend \"s3\" {}\n}\n")
+	require.NoError(t, os.WriteFile(filepath.Join(terraformOptions.TerraformDir, "backend.tf"), backend, 0o644))
+
+	terraformOptions.BackendConfig = map[string]interface{}{
+		"bucket":         bucket,
+		"key":            backendStateKey,
+		"region":         region,
+		"dynamodb_table": lockTable,
+		"encrypt":        true,
+	}
+	terraformOptions.Reconfigure = true
+	terraformOptions.Lock = true
+	terraformOptions.LockTimeout = "0s"
+}
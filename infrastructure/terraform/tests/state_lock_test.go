@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/report"
+	"terraform-tests/internal/testhelpers"
+)
+
+// lockContenders is how many applies TestConcurrentApplyLocking starts at once
+const lockContenders = 2
+
+// contenderResult is how the apply of one contender ended.
+type contenderResult struct {
+	contender int
+	output    string
+	err       error
+	elapsed   time.Duration
+}
+
+// TestConcurrentApplyLocking reproduces two engineers applying the same
+// environment at the same time: two copies of the vpc module sharing one state
+// in the state backend are applied concurrently. Exactly one apply has to
+// succeed and the other has to fail on the state lock within
+// lockContentionMaxDuration instead of waiting for it. Afterwards both copies
+// have to read the same state, plan no changes, and the digest the backend
+// keeps in the lock table has to match the state object. The winner is
+// recorded in the test report. The lock is force-unlocked if it is still held
+// when the test ends and the state object is deleted with all its versions
+// once the vpc is destroyed.
+func TestConcurrentApplyLocking(t *testing.T) {
+	t.Parallel()
+	if testhelpers.IsPlanOnly() {
+		t.Skipf("%s is set, nothing to contend for", testhelpers.PlanOnlyEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+
+	backendOptions := testhelpers.NewModuleOptionsInRegion(t, "state-backend", region, map[string]interface{}{
+		"project_name":  "iot-network",
+		"environment":   "test",
+		"owner":         "terratest",
+		"cost_center":   "ci",
+		"force_destroy": true,
+	})
+	testhelpers.ApplyModule(t, backendOptions)
+	bucket := terraform.Output(t, backendOptions, "bucket_name")
+	lockTable := terraform.Output(t, backendOptions, "lock_table_name")
+
+	sess := testhelpers.NewSession(t, region)
+	s3Client := s3.New(sess)
+	dynamoClient := dynamodb.New(sess)
+	lockID := bucket + "/" + backendStateKey
+
+	// Registered ahead of the vpc's destroy so it runs after it
+	t.Cleanup(func() {
+		deleteStateObject(t, s3Client, dynamoClient, bucket, lockTable, lockID)
+	})
+
+	// Creating the VPC and its subnets keeps the winner holding the lock long
+	// after the other apply tried to take it
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.24.0.0/16",
+		"az_count":     1,
+		"enable_nat":   false,
+	})
+	testhelpers.SkipWithoutQuotaHeadroom(t, region, vpcQuotaRequirements(false))
+	testhelpers.SkipWithoutTerraformSlots(t, lockContenders)
+
+	contenders := []*terraform.Options{vpcOptions}
+	for len(contenders) < lockContenders {
+		contenderOptions, err := vpcOptions.Clone()
+		require.NoError(t, err)
+		contenderOptions.TerraformDir = testhelpers.ModuleWorkingDir(t, "vpc")
+		contenders = append(contenders, contenderOptions)
+	}
+	for _, contenderOptions := range contenders {
+		useS3Backend(t, contenderOptions, region, bucket, lockTable)
+		// A retried apply could take the lock once the winner released it,
+		// turning the loss into a second success
+		contenderOptions.MaxRetries = 0
+		testhelpers.InitModule(t, contenderOptions)
+	}
+
+	// Any contender that wins applies the state of vpcOptions
+	testhelpers.RegisterTeardown(vpcOptions)
+
+	// The destroy of the vpc needs the lock, so a lock left behind by a
+	// killed apply is released before it
+	t.Cleanup(func() {
+		forceUnlockState(t, vpcOptions, dynamoClient, lockTable, lockID)
+	})
+
+	results := make(chan contenderResult, len(contenders))
+	start := make(chan struct{})
+	for i, contenderOptions := range contenders {
+		i, contenderOptions := i, contenderOptions
+		go func() {
+			<-start
+			started := time.Now()
+			output, err := testhelpers.ApplyModuleE(t, contenderOptions)
+			results <- contenderResult{contender: i, output: output, err: err, elapsed: time.Since(started)}
+		}()
+	}
+	close(start)
+
+	var winners, losers []contenderResult
+	for range contenders {
+		result := <-results
+		if result.err == nil {
+			winners = append(winners, result)
+		} else {
+			losers = append(losers, result)
+		}
+	}
+	require.Len(t, winners, 1, "%d of %d concurrent applies succeeded", len(winners), len(contenders))
+	winner := winners[0]
+	for _, loser := range losers {
+		assert.Contains(t, loser.output, "Error acquiring the state lock", "contender %d failed for another reason", loser.contender)
+		assert.Less(t, loser.elapsed, lockContentionMaxDuration, "contender %d took %s to fail on the lock", loser.contender, loser.elapsed)
+	}
+	report.RecordDuration(t, "", "lock-contention", report.Pass,
+		fmt.Sprintf("contender %d won, %d failed on the lock", winner.contender, len(losers)), winner.elapsed)
+
+	// Every copy has to see the winner's state and have nothing left to do
+	applied := testhelpers.StateResources(t, contenders[winner.contender])
+	require.Contains(t, applied, "aws_vpc.main", "the winner's state has no VPC")
+	vpcId := applied["aws_vpc.main"].AttributeValues["id"]
+	for i, contenderOptions := range contenders {
+		resources := testhelpers.StateResources(t, contenderOptions)
+		assert.ElementsMatch(t, stateAddresses(applied), stateAddresses(resources), "contender %d reads a different state", i)
+		if vpc, ok := resources["aws_vpc.main"]; ok {
+			assert.Equal(t, vpcId, vpc.AttributeValues["id"], "contender %d reads another VPC", i)
+		}
+		assert.Equal(t, 0, terraform.PlanExitCode(t, contenderOptions), "contender %d plans changes after the concurrent applies", i)
+	}
+
+	lock, err := getLockItem(dynamoClient, lockTable, lockID)
+	require.NoError(t, err)
+	assert.Empty(t, lock, "the state is still locked after both applies finished")
+
+	object, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(backendStateKey)})
+	require.NoError(t, err, "state object s3://%s/%s is not readable", bucket, backendStateKey)
+	state, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	require.NoError(t, err)
+	require.True(t, json.Valid(state), "state object s3://%s/%s is not valid JSON", bucket, backendStateKey)
+	digest := md5.Sum(state)
+
+	digestItem, err := getLockItem(dynamoClient, lockTable, lockID+"-md5")
+	require.NoError(t, err)
+	require.Contains(t, digestItem, "Digest", "the lock table has no digest of the state")
+	assert.Equal(t, hex.EncodeToString(digest[:]), awsSDK.StringValue(digestItem["Digest"].S), "the digest in the lock table does not match the state object")
+}
+
+// stateAddresses returns the addresses of the resources in a state.
+func stateAddresses(resources map[string]*tfjson.StateResource) []string {
+	addresses := make([]string, 0, len(resources))
+	for address := range resources {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// getLockItem reads an item of the lock table, the lock itself or the digest
+// of the state, and returns nil when there is none.
+func getLockItem(dynamoClient *dynamodb.DynamoDB, lockTable string, lockID string) (map[string]*dynamodb.AttributeValue, error) {
+	item, err := dynamoClient.GetItem(&dynamodb.GetItemInput{
+		TableName:      awsSDK.String(lockTable),
+		Key:            map[string]*dynamodb.AttributeValue{"LockID": {S: awsSDK.String(lockID)}},
+		ConsistentRead: awsSDK.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(item.Item) == 0 {
+		return nil, nil
+	}
+	return item.Item, nil
+}
+
+// forceUnlockState releases the lock on the state if one is still held,
+// through terraform force-unlock with the ID in the lock's info, or by
+// deleting the lock item when terraform cannot.
+func forceUnlockState(t *testing.T, terraformOptions *terraform.Options, dynamoClient *dynamodb.DynamoDB, lockTable string, lockID string) {
+	lock, err := getLockItem(dynamoClient, lockTable, lockID)
+	if err != nil {
+		t.Logf("Failed to read lock %s: %v", lockID, err)
+		return
+	}
+	if lock == nil {
+		return
+	}
+
+	var info struct {
+		ID string
+	}
+	if lock["Info"] != nil && json.Unmarshal([]byte(awsSDK.StringValue(lock["Info"].S)), &info) == nil && info.ID != "" {
+		_, err := terraform.RunTerraformCommandE(t, terraformOptions, "force-unlock", "-force", info.ID)
+		if err == nil {
+			return
+		}
+		t.Logf("Failed to force-unlock %s: %v", lockID, err)
+	}
+	if _, err := dynamoClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: awsSDK.String(lockTable),
+		Key:       map[string]*dynamodb.AttributeValue{"LockID": {S: awsSDK.String(lockID)}},
+	}); err != nil {
+		t.Logf("Failed to delete lock %s: %v", lockID, err)
+	}
+}
+
+// deleteStateObject deletes every version of the state object and its digest
+// in the lock table, so a rerun against the same backend starts from an empty
+// state.
+func deleteStateObject(t *testing.T, s3Client *s3.S3, dynamoClient *dynamodb.DynamoDB, bucket string, lockTable string, lockID string) {
+	err := s3Client.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: awsSDK.String(bucket),
+		Prefix: awsSDK.String(backendStateKey),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		var versionIds []*string
+		for _, version := range page.Versions {
+			if awsSDK.StringValue(version.Key) == backendStateKey {
+				versionIds = append(versionIds, version.VersionId)
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if awsSDK.StringValue(marker.Key) == backendStateKey {
+				versionIds = append(versionIds, marker.VersionId)
+			}
+		}
+		for _, versionId := range versionIds {
+			if _, err := s3Client.DeleteObject(&s3.DeleteObjectInput{Bucket: awsSDK.String(bucket), Key: awsSDK.String(backendStateKey), VersionId: versionId}); err != nil {
+				t.Logf("Failed to delete version %s of s3://%s/%s: %v", awsSDK.StringValue(versionId), bucket, backendStateKey, err)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Logf("Failed to list versions of s3://%s/%s: %v", bucket, backendStateKey, err)
+	}
+
+	if _, err := dynamoClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: awsSDK.String(lockTable),
+		Key:       map[string]*dynamodb.AttributeValue{"LockID": {S: awsSDK.String(lockID + "-md5")}},
+	}); err != nil {
+		t.Logf("Failed to delete the state digest %s-md5: %v", lockID, err)
+	}
+}
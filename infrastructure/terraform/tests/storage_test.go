@@ -0,0 +1,337 @@
+package tests
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// The storage contract the ingestion and dashboard code rely on, as documented
+// in the storage module.
+const (
+	telemetryHashKey       = "message_id"
+	telemetryIndexName     = "device-time"
+	telemetryIndexHashKey  = "device_id"
+	telemetryIndexRangeKey = "recorded_at"
+	telemetryTtlAttribute  = "expires_at"
+	archiveTransitionDays  = 30
+	archiveStorageClass    = s3.TransitionStorageClassGlacierIr
+	archiveExpirationDays  = 365
+
+	// telemetryReadingsPerRun is how many readings of one device the test
+	// writes, a minute apart.
+	telemetryReadingsPerRun = 5
+)
+
+// TestStorageModule writes a batch of readings through the writer role and
+// reads a time range of one device back through the reader role and the
+// index the dashboards use. The table has to match the storage contract and
+// the archive bucket has to move objects to the archive storage class, expire
+// them and block public access, and both have to be encrypted with the test's
+// key. The readings are deleted afterwards, an
+// archived object is left in the bucket, and destroying the module has to
+// remove the bucket anyway.
+func TestStorageModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+	accountId := placeholderAccountId
+	if !testhelpers.IsPlanOnly() {
+		accountId = testhelpers.AccountId(t, testhelpers.NewSession(t, region))
+	}
+	principal := fmt.Sprintf("arn:aws:iam::%s:root", accountId)
+	keyArn, keyAlias := testhelpers.CreateDataKey(t, region, nil)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "storage", region, map[string]interface{}{
+		"project_name":            "iot-network",
+		"environment":             "test",
+		"owner":                   "terratest",
+		"cost_center":             "ci",
+		"deletion_protection":     false,
+		"force_destroy":           true,
+		"archive_transition_days": archiveTransitionDays,
+		"archive_storage_class":   archiveStorageClass,
+		"archive_expiration_days": archiveExpirationDays,
+		"writer_principal_arns":   []string{principal},
+		"reader_principal_arns":   []string{principal},
+		"kms_key_arn":             keyArn,
+	})
+
+	testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			table := "aws_dynamodb_table.telemetry"
+			testhelpers.AssertResourceAttr(t, plan, table, "hash_key", telemetryHashKey)
+			testhelpers.AssertResourceAttr(t, plan, table, "ttl[0].attribute_name", telemetryTtlAttribute)
+			testhelpers.AssertResourceAttr(t, plan, table, "ttl[0].enabled", true)
+			testhelpers.AssertResourceAttr(t, plan, table, "global_secondary_index[0].name", telemetryIndexName)
+			testhelpers.AssertResourceAttr(t, plan, table, "server_side_encryption[0].kms_key_arn", keyArn)
+
+			lifecycle := "aws_s3_bucket_lifecycle_configuration.archive"
+			testhelpers.AssertResourceAttr(t, plan, lifecycle, "rule[0].transition[0].days", archiveTransitionDays)
+			testhelpers.AssertResourceAttr(t, plan, lifecycle, "rule[0].transition[0].storage_class", archiveStorageClass)
+			testhelpers.AssertResourceAttr(t, plan, lifecycle, "rule[0].expiration[0].days", archiveExpirationDays)
+			testhelpers.AssertResourceAttr(t, plan, "aws_s3_bucket.archive", "force_destroy", true)
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			sess := testhelpers.SessionFor(t, terraformOptions)
+			tableName := terraform.Output(t, terraformOptions, "table_name")
+			bucket := terraform.Output(t, terraformOptions, "archive_bucket_name")
+			assert.Equal(t, telemetryIndexName, terraform.Output(t, terraformOptions, "device_time_index_name"))
+
+			testhelpers.AssertEncryptedAtRest(t, region, []string{
+				terraform.Output(t, terraformOptions, "table_arn"),
+				terraform.Output(t, terraformOptions, "archive_bucket_arn"),
+			}, keyAlias)
+
+			adminDynamo := dynamodb.New(sess)
+			assertTelemetryTableContract(t, adminDynamo, tableName)
+
+			writer := assumeStorageRole(t, sess, terraform.Output(t, terraformOptions, "writer_role_arn"))
+			reader := assumeStorageRole(t, sess, terraform.Output(t, terraformOptions, "reader_role_arn"))
+
+			deviceId := testhelpers.NamePrefix(terraformOptions) + "-sensor"
+			readings := writeTelemetryReadings(t, dynamodb.New(writer), adminDynamo, tableName, deviceId)
+			assertDeviceTimeRange(t, dynamodb.New(reader), tableName, deviceId, readings)
+
+			s3Client := s3.New(sess)
+			assertArchiveBucket(t, s3Client, bucket)
+
+			// Left in place for the destroy to get rid of
+			object := "telemetry/" + deviceId + "/" + time.Now().UTC().Format("2006-01-02") + ".json"
+			_, err := s3.New(writer).PutObject(&s3.PutObjectInput{
+				Bucket: awsSDK.String(bucket),
+				Key:    awsSDK.String(object),
+				Body:   strings.NewReader(`[]`),
+			})
+			require.NoError(t, err, "writer role could not archive s3://%s/%s", bucket, object)
+
+			test_structure.RunTestStage(t, testhelpers.StageTeardown, func() {
+				deleteTelemetryReadings(t, adminDynamo, tableName, readings)
+				testhelpers.DestroyModule(t, terraformOptions)
+				_, err := s3Client.HeadBucket(&s3.HeadBucketInput{Bucket: awsSDK.String(bucket)})
+				var awsErr awserr.RequestFailure
+				if assert.ErrorAs(t, err, &awsErr, "bucket %s still exists after destroy", bucket) {
+					assert.Equal(t, 404, awsErr.StatusCode(), "bucket %s after destroy: %v", bucket, err)
+				}
+			})
+		},
+	})
+}
+
+// telemetryReading is a reading written by the test, identified by its
+// message ID.
+type telemetryReading struct {
+	messageId  string
+	deviceId   string
+	recordedAt int64
+}
+
+// assertTelemetryTableContract checks the key schema, index and TTL of the
+// live table against the storage contract.
+func assertTelemetryTableContract(t *testing.T, dynamoClient *dynamodb.DynamoDB, tableName string) {
+	described, err := dynamoClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: awsSDK.String(tableName)})
+	require.NoError(t, err)
+	table := described.Table
+
+	assert.Equal(t, map[string]string{telemetryHashKey: dynamodb.KeyTypeHash}, keySchema(table.KeySchema), "table %s key schema", tableName)
+
+	attributeTypes := map[string]string{}
+	for _, attribute := range table.AttributeDefinitions {
+		attributeTypes[awsSDK.StringValue(attribute.AttributeName)] = awsSDK.StringValue(attribute.AttributeType)
+	}
+	assert.Equal(t, map[string]string{
+		telemetryHashKey:       dynamodb.ScalarAttributeTypeS,
+		telemetryIndexHashKey:  dynamodb.ScalarAttributeTypeS,
+		telemetryIndexRangeKey: dynamodb.ScalarAttributeTypeN,
+	}, attributeTypes, "table %s attribute types", tableName)
+
+	require.Len(t, table.GlobalSecondaryIndexes, 1, "table %s global secondary indexes", tableName)
+	index := table.GlobalSecondaryIndexes[0]
+	assert.Equal(t, telemetryIndexName, awsSDK.StringValue(index.IndexName))
+	assert.Equal(t, map[string]string{
+		telemetryIndexHashKey:  dynamodb.KeyTypeHash,
+		telemetryIndexRangeKey: dynamodb.KeyTypeRange,
+	}, keySchema(index.KeySchema), "index %s key schema", telemetryIndexName)
+	assert.Equal(t, dynamodb.ProjectionTypeAll, awsSDK.StringValue(index.Projection.ProjectionType), "index %s projection", telemetryIndexName)
+
+	ttl, err := dynamoClient.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{TableName: awsSDK.String(tableName)})
+	require.NoError(t, err)
+	assert.Equal(t, telemetryTtlAttribute, awsSDK.StringValue(ttl.TimeToLiveDescription.AttributeName), "table %s TTL attribute", tableName)
+	assert.Contains(t, []string{dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling},
+		awsSDK.StringValue(ttl.TimeToLiveDescription.TimeToLiveStatus), "table %s TTL status", tableName)
+}
+
+func keySchema(elements []*dynamodb.KeySchemaElement) map[string]string {
+	keys := map[string]string{}
+	for _, element := range elements {
+		keys[awsSDK.StringValue(element.AttributeName)] = awsSDK.StringValue(element.KeyType)
+	}
+	return keys
+}
+
+// assumeStorageRole returns a session of the role, waiting for its trust
+// policy to be usable.
+func assumeStorageRole(t *testing.T, sess *session.Session, roleArn string) *session.Session {
+	var roleSession *session.Session
+	assumed := testhelpers.PollUntil(t, "assuming "+roleArn, func() (bool, error) {
+		candidate := sess.Copy(awsSDK.NewConfig().WithCredentials(stscreds.NewCredentials(sess, roleArn)))
+		if _, err := candidate.Config.Credentials.Get(); err != nil {
+			return false, err
+		}
+		roleSession = candidate
+		return true, nil
+	})
+	require.True(t, assumed, "could not assume %s", roleArn)
+	return roleSession
+}
+
+// writeTelemetryReadings writes readings of the device a minute apart, the
+// way the ingestion service does, plus one of another device that no query
+// for the device may return. The readings expire in an hour, and are deleted
+// when the test finishes in case it fails before deleting them itself.
+func writeTelemetryReadings(t *testing.T, writerClient *dynamodb.DynamoDB, adminClient *dynamodb.DynamoDB, tableName string, deviceId string) []telemetryReading {
+	now := time.Now()
+	expiresAt := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+
+	var readings []telemetryReading
+	for i := 0; i < telemetryReadingsPerRun; i++ {
+		readings = append(readings, telemetryReading{
+			messageId:  fmt.Sprintf("%s-%d", deviceId, i),
+			deviceId:   deviceId,
+			recordedAt: now.Add(time.Duration(i-telemetryReadingsPerRun) * time.Minute).UnixMilli(),
+		})
+	}
+	other := telemetryReading{messageId: deviceId + "-other", deviceId: deviceId + "-other", recordedAt: readings[2].recordedAt}
+
+	t.Cleanup(func() { deleteTelemetryReadings(t, adminClient, tableName, append(readings, other)) })
+
+	var requests []*dynamodb.WriteRequest
+	for _, reading := range append(readings, other) {
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{
+			telemetryHashKey:       {S: awsSDK.String(reading.messageId)},
+			telemetryIndexHashKey:  {S: awsSDK.String(reading.deviceId)},
+			telemetryIndexRangeKey: {N: awsSDK.String(strconv.FormatInt(reading.recordedAt, 10))},
+			telemetryTtlAttribute:  {N: awsSDK.String(expiresAt)},
+			"temperature":          {N: awsSDK.String("21.5")},
+		}}})
+	}
+	batchWrite(t, writerClient, tableName, requests)
+	return readings
+}
+
+// assertDeviceTimeRange queries the middle three readings of the device
+// through the index and expects exactly those, oldest first, with every
+// attribute projected. The index is eventually consistent, so the query is
+// retried until they show up.
+func assertDeviceTimeRange(t *testing.T, readerClient *dynamodb.DynamoDB, tableName string, deviceId string, readings []telemetryReading) {
+	from, to := readings[1], readings[len(readings)-2]
+	expected := []string{}
+	for _, reading := range readings[1 : len(readings)-1] {
+		expected = append(expected, reading.messageId)
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	found := testhelpers.PollUntil(t, "readings of "+deviceId+" in the "+telemetryIndexName+" index", func() (bool, error) {
+		output, err := readerClient.Query(&dynamodb.QueryInput{
+			TableName:              awsSDK.String(tableName),
+			IndexName:              awsSDK.String(telemetryIndexName),
+			KeyConditionExpression: awsSDK.String("#device = :device AND #recorded BETWEEN :from AND :to"),
+			ExpressionAttributeNames: map[string]*string{
+				"#device":   awsSDK.String(telemetryIndexHashKey),
+				"#recorded": awsSDK.String(telemetryIndexRangeKey),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":device": {S: awsSDK.String(deviceId)},
+				":from":   {N: awsSDK.String(strconv.FormatInt(from.recordedAt, 10))},
+				":to":     {N: awsSDK.String(strconv.FormatInt(to.recordedAt, 10))},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		items = output.Items
+		return len(items) >= len(expected), nil
+	})
+	require.True(t, found, "query of %s returned %d of %d readings", deviceId, len(items), len(expected))
+
+	messageIds := []string{}
+	for _, item := range items {
+		messageIds = append(messageIds, awsSDK.StringValue(item[telemetryHashKey].S))
+		assert.NotNil(t, item[telemetryTtlAttribute], "reading %s has no %s through the index", awsSDK.StringValue(item[telemetryHashKey].S), telemetryTtlAttribute)
+		assert.NotNil(t, item["temperature"], "reading %s has no temperature through the index", awsSDK.StringValue(item[telemetryHashKey].S))
+	}
+	assert.Equal(t, expected, messageIds, "readings of %s between %d and %d", deviceId, from.recordedAt, to.recordedAt)
+}
+
+// deleteTelemetryReadings deletes the readings, logging rather than failing
+// when a delete does not go through.
+func deleteTelemetryReadings(t *testing.T, dynamoClient *dynamodb.DynamoDB, tableName string, readings []telemetryReading) {
+	for _, reading := range readings {
+		_, err := dynamoClient.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: awsSDK.String(tableName),
+			Key:       map[string]*dynamodb.AttributeValue{telemetryHashKey: {S: awsSDK.String(reading.messageId)}},
+		})
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+				return
+			}
+			t.Logf("Failed to delete reading %s: %v", reading.messageId, err)
+		}
+	}
+}
+
+// batchWrite writes the requests, resubmitting whatever DynamoDB reports back
+// as unprocessed.
+func batchWrite(t *testing.T, dynamoClient *dynamodb.DynamoDB, tableName string, requests []*dynamodb.WriteRequest) {
+	pending := map[string][]*dynamodb.WriteRequest{tableName: requests}
+	for attempt := 0; len(pending[tableName]) > 0; attempt++ {
+		require.Less(t, attempt, 5, "%d writes to %s still unprocessed", len(pending[tableName]), tableName)
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		output, err := dynamoClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+		require.NoError(t, err, "batch write to %s", tableName)
+		pending = output.UnprocessedItems
+	}
+}
+
+// assertArchiveBucket checks the lifecycle rule and public access block of the
+// archive bucket.
+func assertArchiveBucket(t *testing.T, s3Client *s3.S3, bucket string) {
+	lifecycle, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: awsSDK.String(bucket)})
+	require.NoError(t, err)
+	require.Len(t, lifecycle.Rules, 1, "lifecycle rules of %s", bucket)
+	rule := lifecycle.Rules[0]
+	assert.Equal(t, s3.ExpirationStatusEnabled, awsSDK.StringValue(rule.Status), "lifecycle rule %s", awsSDK.StringValue(rule.ID))
+	if assert.Len(t, rule.Transitions, 1, "transitions of lifecycle rule %s", awsSDK.StringValue(rule.ID)) {
+		assert.Equal(t, int64(archiveTransitionDays), awsSDK.Int64Value(rule.Transitions[0].Days), "transition days")
+		assert.Equal(t, archiveStorageClass, awsSDK.StringValue(rule.Transitions[0].StorageClass), "transition storage class")
+	}
+	if assert.NotNil(t, rule.Expiration, "lifecycle rule %s does not expire objects", awsSDK.StringValue(rule.ID)) {
+		assert.Equal(t, int64(archiveExpirationDays), awsSDK.Int64Value(rule.Expiration.Days), "expiration days")
+	}
+
+	block, err := s3Client.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: awsSDK.String(bucket)})
+	require.NoError(t, err)
+	configuration := block.PublicAccessBlockConfiguration
+	assert.True(t, awsSDK.BoolValue(configuration.BlockPublicAcls), "%s does not block public ACLs", bucket)
+	assert.True(t, awsSDK.BoolValue(configuration.IgnorePublicAcls), "%s does not ignore public ACLs", bucket)
+	assert.True(t, awsSDK.BoolValue(configuration.BlockPublicPolicy), "%s does not block public policies", bucket)
+	assert.True(t, awsSDK.BoolValue(configuration.RestrictPublicBuckets), "%s does not restrict public buckets", bucket)
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+func TestVpcSubnetRouting(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name        string
+		natStrategy string
+		natCount    int
+	}{
+		{name: "SingleNat", natStrategy: "single", natCount: 1},
+		{name: "PerAzNat", natStrategy: "per_az", natCount: 2},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   "10.10.0.0/16",
+				"az_count":     2,
+				"nat_strategy": tc.natStrategy,
+			})
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					ec2Client := ec2.New(testhelpers.NewSession(t, region))
+					natGatewayIds := terraform.OutputList(t, terraformOptions, "nat_gateway_ids")
+					require.Len(t, natGatewayIds, tc.natCount, "unexpected number of NAT gateways for nat_strategy %q", tc.natStrategy)
+
+					for _, subnetId := range terraform.OutputList(t, terraformOptions, "public_subnet_ids") {
+						subnet := describeSubnet(t, ec2Client, subnetId)
+						assert.Equal(t, "public", subnetTag(subnet, "Type"), "subnet %s: expected Type=public", subnetId)
+						assert.True(t, awsSDK.BoolValue(subnet.MapPublicIpOnLaunch), "subnet %s: public subnet must map public IPs on launch", subnetId)
+
+						route := defaultRoute(routeTableForSubnet(t, ec2Client, subnetId))
+						if assert.NotNil(t, route, "subnet %s: no active 0.0.0.0/0 route", subnetId) {
+							assert.True(t, strings.HasPrefix(awsSDK.StringValue(route.GatewayId), "igw-"),
+								"subnet %s: 0.0.0.0/0 routes to %q, expected an internet gateway", subnetId, awsSDK.StringValue(route.GatewayId))
+						}
+					}
+
+					for _, subnetId := range terraform.OutputList(t, terraformOptions, "private_subnet_ids") {
+						subnet := describeSubnet(t, ec2Client, subnetId)
+						assert.Equal(t, "private", subnetTag(subnet, "Type"), "subnet %s: expected Type=private", subnetId)
+						assert.False(t, awsSDK.BoolValue(subnet.MapPublicIpOnLaunch), "subnet %s: private subnet must not map public IPs on launch", subnetId)
+
+						route := defaultRoute(routeTableForSubnet(t, ec2Client, subnetId))
+						if assert.NotNil(t, route, "subnet %s: no active 0.0.0.0/0 route", subnetId) {
+							assert.Contains(t, natGatewayIds, awsSDK.StringValue(route.NatGatewayId),
+								"subnet %s: 0.0.0.0/0 does not route through one of the module's NAT gateways", subnetId)
+						}
+					}
+				},
+			})
+		})
+	}
+}
+
+func describeSubnet(t *testing.T, ec2Client *ec2.EC2, subnetId string) *ec2.Subnet {
+	out, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{awsSDK.String(subnetId)}})
+	require.NoError(t, err)
+	require.Len(t, out.Subnets, 1, "DescribeSubnets did not return subnet %s", subnetId)
+	return out.Subnets[0]
+}
+
+func subnetTag(subnet *ec2.Subnet, key string) string {
+	for _, tag := range subnet.Tags {
+		if awsSDK.StringValue(tag.Key) == key {
+			return awsSDK.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// routeTableForSubnet returns the route table explicitly associated with the
+// subnet. The module associates every subnet, so falling back to the VPC's main
+// route table would hide a missing association.
+func routeTableForSubnet(t *testing.T, ec2Client *ec2.EC2, subnetId string) *ec2.RouteTable {
+	out, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{Name: awsSDK.String("association.subnet-id"), Values: []*string{awsSDK.String(subnetId)}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.RouteTables, 1, "subnet %s: expected exactly one associated route table", subnetId)
+	return out.RouteTables[0]
+}
+
+// defaultRoute returns the active 0.0.0.0/0 route of the route table, or nil.
+// A blackholed route, e.g. to a deleted NAT gateway, does not count.
+func defaultRoute(routeTable *ec2.RouteTable) *ec2.Route {
+	for _, route := range routeTable.Routes {
+		if awsSDK.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" && awsSDK.StringValue(route.State) == ec2.RouteStateActive {
+			return route
+		}
+	}
+	return nil
+}
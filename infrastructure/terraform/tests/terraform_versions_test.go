@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// versionMatrixModules are planned with every Terraform version in TF_VERSIONS.
+// Modules that take IDs of other modules get placeholders, nothing is applied.
+var versionMatrixModules = []struct {
+	module string
+	vars   map[string]interface{}
+}{
+	{module: "vpc", vars: map[string]interface{}{
+		"cidr_block": "10.0.0.0/16",
+		"az_count":   2,
+		"enable_nat": true,
+	}},
+	{module: "security", vars: map[string]interface{}{
+		"vpc_id":         "vpc-00000000000000000",
+		"vpc_cidr_block": "10.0.0.0/16",
+	}},
+	{module: "iot-core", vars: map[string]interface{}{}},
+	{module: "iot-rules", vars: map[string]interface{}{}},
+	{module: "iot-authorizer", vars: map[string]interface{}{
+		"token_signing_public_key": "-----BEGIN PUBLIC KEY-----\nplaceholder\n-----END PUBLIC KEY-----\n",
+	}},
+	{module: "gateway", vars: map[string]interface{}{
+		"vpc_id":            "vpc-00000000000000000",
+		"public_subnet_ids": []string{"subnet-00000000000000000", "subnet-00000000000000001"},
+	}},
+	{module: "bastion", vars: map[string]interface{}{
+		"vpc_id":      "vpc-00000000000000000",
+		"subnet_id":   "subnet-00000000000000000",
+		"access_mode": "ssm",
+	}},
+}
+
+// TestTerraformVersionMatrix runs init, validate and plan of the modules with
+// every Terraform version in TF_VERSIONS. Apply-based tests only use the
+// newest of them.
+func TestTerraformVersionMatrix(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	for _, tc := range versionMatrixModules {
+		tc := tc
+		t.Run(tc.module, func(t *testing.T) {
+			t.Parallel()
+
+			testhelpers.ForEachTerraformVersion(t, func(t *testing.T, terraformBinary string) {
+				vars := map[string]interface{}{
+					"project_name": "iot-network",
+					"environment":  "test",
+				}
+				if tc.module != "iot-core" && tc.module != "security" {
+					vars["owner"] = "terratest"
+					vars["cost_center"] = "ci"
+				}
+				for key, value := range tc.vars {
+					vars[key] = value
+				}
+
+				terraformOptions := testhelpers.NewModuleOptionsInRegion(t, tc.module, region, vars)
+				terraformOptions.TerraformBinary = terraformBinary
+
+				terraform.InitAndValidate(t, terraformOptions)
+				testhelpers.PlanModule(t, terraformOptions)
+			})
+		})
+	}
+}
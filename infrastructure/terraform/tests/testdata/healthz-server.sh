@@ -0,0 +1,23 @@
+#!/bin/bash
+# User data of the stand-in gateway target: answers /healthz with
+# {"status":"ok"} and everything else with a 404 on port 8000.
+cat > /opt/healthz-server.py <<'EOF'
+import http.server
+
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path == "/healthz":
+            status, body = 200, b'{"status":"ok"}'
+        else:
+            status, body = 404, b'{"error":"not found"}'
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+
+http.server.ThreadingHTTPServer(("", 8000), Handler).serve_forever()
+EOF
+nohup python3 /opt/healthz-server.py > /var/log/healthz-server.log 2>&1 &
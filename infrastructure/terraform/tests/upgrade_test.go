@@ -0,0 +1,242 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/files"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+const (
+	// upgradeTestEnvVar enables TestModuleUpgrade, which applies every module
+	// it covers twice and is only run nightly.
+	upgradeTestEnvVar = "RUN_UPGRADE_TEST"
+
+	// upgradeFromRefEnvVar is the git ref TestModuleUpgrade upgrades from,
+	// by default the most recent tag reachable from HEAD.
+	upgradeFromRefEnvVar = "UPGRADE_FROM_REF"
+)
+
+// statefulResourceTypes hold data or are what everything else is built in, so
+// an upgrade must never delete or replace them.
+var statefulResourceTypes = map[string]bool{
+	"aws_vpc":            true,
+	"aws_dynamodb_table": true,
+	"aws_s3_bucket":      true,
+}
+
+// allowedUpgradeChurn lists the stateful resources, by "<module>/<address>",
+// that the upgrade from the last release may delete or replace, each with why
+// that is acceptable. Keep it empty unless the release notes tell operators
+// how to move their data.
+var allowedUpgradeChurn = map[string]string{}
+
+// TestModuleUpgrade applies each module as it was at the last release, points
+// the same state at the module in the working tree and checks that the plan
+// deletes or replaces none of its stateful resources, in-place updates being
+// fine. It then applies the upgrade and checks that the outputs identifying the
+// stateful resources did not change.
+//
+//	RUN_UPGRADE_TEST=true UPGRADE_FROM_REF=v1.4.0 go test -run TestModuleUpgrade ./...
+func TestModuleUpgrade(t *testing.T) {
+	t.Parallel()
+	testhelpers.SkipUnlessEnabled(t, upgradeTestEnvVar)
+	if testhelpers.IsPlanOnly() {
+		t.Skipf("%s is set, nothing to upgrade", testhelpers.PlanOnlyEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+
+	releaseRoot, ref := checkoutRelease(t)
+
+	testCases := []struct {
+		module string
+		vars   map[string]interface{}
+		// stableOutputs identify the stateful resources and must be the
+		// same before and after the upgrade
+		stableOutputs []string
+	}{
+		{
+			module: "vpc",
+			vars: map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   "10.12.0.0/16",
+				"az_count":     1,
+				"enable_nat":   false,
+			},
+			stableOutputs: []string{"vpc_id", "vpc_cidr_block"},
+		},
+		{
+			module: "iot-rules",
+			vars: map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+			},
+			stableOutputs: []string{"telemetry_table_name"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.module, func(t *testing.T) {
+			t.Parallel()
+
+			releaseModuleDir := filepath.Join(releaseRoot, "infrastructure", "terraform", "modules", tc.module)
+			if _, err := os.Stat(releaseModuleDir); os.IsNotExist(err) {
+				t.Skipf("module %s did not exist at %s", tc.module, ref)
+			}
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, tc.module, region, tc.vars)
+			releaseOptions := releaseModuleOptions(t, terraformOptions, releaseModuleDir)
+
+			// Until its state is handed over to the working tree module,
+			// the release module is destroyed on its own
+			handedOver := false
+			t.Cleanup(func() {
+				if !handedOver {
+					testhelpers.DestroyModule(t, releaseOptions)
+				}
+			})
+
+			if tc.module == "vpc" {
+				testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(terraformOptions), vpcQuotaRequirements(false))
+			}
+			testhelpers.ApplyModule(t, releaseOptions)
+			released := map[string]string{}
+			for _, name := range tc.stableOutputs {
+				released[name] = terraform.Output(t, releaseOptions, name)
+			}
+
+			state, err := os.ReadFile(filepath.Join(releaseOptions.TerraformDir, "terraform.tfstate"))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(terraformOptions.TerraformDir, "terraform.tfstate"), state, 0o644))
+			handedOver = true
+			testhelpers.RegisterTeardown(terraformOptions)
+
+			plan := testhelpers.PlanModule(t, terraformOptions)
+			violations, updated := upgradeChurn(tc.module, plan, allowedUpgradeChurn)
+			t.Logf("Upgrading %s from %s updates in place: %v", tc.module, ref, updated)
+			require.Empty(t, violations, "upgrading %s from %s destroys stateful resources:\n%s", tc.module, ref, strings.Join(violations, "\n"))
+
+			// Not ApplyModule: that belongs to the setup stage
+			terraform.Apply(t, terraformOptions)
+			for name, value := range released {
+				assert.Equal(t, value, terraform.Output(t, terraformOptions, name), "output %s changed on upgrading %s from %s", name, tc.module, ref)
+			}
+		})
+	}
+}
+
+// checkoutRelease checks the ref to upgrade from out into a worktree that is
+// removed once the test is done, and returns its root and the ref.
+func checkoutRelease(t *testing.T) (string, string) {
+	repoRoot := strings.TrimSpace(shell.RunCommandAndGetOutput(t, shell.Command{
+		Command: "git",
+		Args:    []string{"rev-parse", "--show-toplevel"},
+	}))
+
+	ref := os.Getenv(upgradeFromRefEnvVar)
+	if ref == "" {
+		output, err := shell.RunCommandAndGetStdOutE(t, shell.Command{
+			Command:    "git",
+			Args:       []string{"describe", "--tags", "--abbrev=0"},
+			WorkingDir: repoRoot,
+		})
+		if err != nil {
+			t.Skipf("no release tag to upgrade from, set %s: %v", upgradeFromRefEnvVar, err)
+		}
+		ref = strings.TrimSpace(output)
+	}
+
+	worktree := t.TempDir()
+	shell.RunCommand(t, shell.Command{
+		Command:    "git",
+		Args:       []string{"worktree", "add", "--detach", worktree, ref},
+		WorkingDir: repoRoot,
+	})
+	t.Cleanup(func() {
+		if err := shell.RunCommandE(t, shell.Command{
+			Command:    "git",
+			Args:       []string{"worktree", "remove", "--force", worktree},
+			WorkingDir: repoRoot,
+		}); err != nil {
+			t.Logf("Failed to remove worktree %s: %v", worktree, err)
+		}
+	})
+	return worktree, ref
+}
+
+// variableBlock matches the variable declarations of a module.
+var variableBlock = regexp.MustCompile(`(?m)^variable\s+"([^"]+)"`)
+
+// releaseModuleOptions returns the options of the working tree module pointed
+// at a copy of the release module instead. Vars the release did not declare
+// yet are dropped, as terraform rejects undeclared ones.
+func releaseModuleOptions(t *testing.T, terraformOptions *terraform.Options, releaseModuleDir string) *terraform.Options {
+	releaseOptions, err := terraformOptions.Clone()
+	require.NoError(t, err)
+	releaseOptions.TerraformDir, err = files.CopyTerraformFolderToTemp(releaseModuleDir, filepath.Base(releaseModuleDir)+"-release")
+	require.NoError(t, err)
+
+	sources, err := filepath.Glob(filepath.Join(releaseModuleDir, "*.tf"))
+	require.NoError(t, err)
+	declared := map[string]bool{}
+	for _, source := range sources {
+		contents, err := os.ReadFile(source)
+		require.NoError(t, err)
+		for _, match := range variableBlock.FindAllStringSubmatch(string(contents), -1) {
+			declared[match[1]] = true
+		}
+	}
+
+	releaseOptions.Vars = map[string]interface{}{}
+	for name, value := range terraformOptions.Vars {
+		if declared[name] {
+			releaseOptions.Vars[name] = value
+		} else {
+			t.Logf("Not passing %s to the release module, which does not declare it", name)
+		}
+	}
+	return releaseOptions
+}
+
+// upgradeChurn returns a line per stateful resource the plan deletes or
+// replaces that allowed does not list, and the addresses it updates in place.
+func upgradeChurn(module string, plan *terraform.PlanStruct, allowed map[string]string) ([]string, []string) {
+	var violations, updated []string
+	for address, change := range plan.ResourceChangesMap {
+		if change.Change == nil {
+			continue
+		}
+		actions := change.Change.Actions
+		if actions.Update() {
+			updated = append(updated, address)
+		}
+		if !statefulResourceTypes[change.Type] || !(actions.Delete() || actions.Replace()) {
+			continue
+		}
+		if _, ok := allowed[module+"/"+address]; ok {
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("%s: %v", address, actions))
+	}
+	sort.Strings(violations)
+	sort.Strings(updated)
+	return violations, updated
+}
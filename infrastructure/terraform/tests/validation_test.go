@@ -0,0 +1,312 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// validationModuleVars are valid vars for each module with validated inputs.
+// Every case overrides one of them with garbage. IDs are placeholders as
+// nothing is ever applied.
+var validationModuleVars = map[string]map[string]interface{}{
+	"vpc": {
+		"cidr_block": "10.0.0.0/16",
+		"az_count":   2,
+		"enable_nat": false,
+	},
+	"vpc-peering": {
+		"hub_vpc_id":            "vpc-00000000000000000",
+		"hub_cidr_block":        "10.30.0.0/16",
+		"hub_route_table_ids":   []string{"rtb-00000000000000000"},
+		"spoke_vpc_id":          "vpc-00000000000000001",
+		"spoke_cidr_block":      "10.31.0.0/16",
+		"spoke_route_table_ids": []string{"rtb-00000000000000001"},
+	},
+	"security": {
+		"vpc_id":         "vpc-00000000000000000",
+		"vpc_cidr_block": "10.0.0.0/16",
+	},
+	"gateway": {
+		"vpc_id":            "vpc-00000000000000000",
+		"public_subnet_ids": []string{"subnet-00000000000000000", "subnet-00000000000000001"},
+	},
+	"bastion": {
+		"vpc_id":      "vpc-00000000000000000",
+		"subnet_id":   "subnet-00000000000000000",
+		"access_mode": "ssm",
+	},
+	"vpc-endpoints": {
+		"vpc_id":         "vpc-00000000000000000",
+		"vpc_cidr_block": "10.0.0.0/16",
+		"subnet_ids":     []string{"subnet-00000000000000000"},
+	},
+	"monitoring": {
+		"telemetry_rule_name": "iot_network_telemetry",
+	},
+	"dns": {
+		"zone_id":         "Z0000000000000000000",
+		"broker_endpoint": "a0000000000000-ats.iot.us-west-2.amazonaws.com",
+		"alb_dns_name":    "iot-network-alb-0000000000.us-west-2.elb.amazonaws.com",
+		"alb_zone_id":     "Z1H1FL5HABSF5",
+	},
+	"ledger": {
+		"access_principal_arns": []string{"arn:aws:iam::123456789012:root"},
+	},
+	"asg": {
+		"subnet_ids": []string{"subnet-00000000000000002"},
+	},
+	"edge-gateway": {
+		"components": map[string]string{"aws.greengrass.Cli": "2.12.6"},
+	},
+	"storage": {
+		"writer_principal_arns": []string{"arn:aws:iam::123456789012:root"},
+		"reader_principal_arns": []string{"arn:aws:iam::123456789012:root"},
+	},
+	"vpc-peering-accepter": {
+		"peering_connection_id": "pcx-00000000000000000",
+		"hub_cidr_block":        "10.30.0.0/16",
+		"spoke_route_table_ids": []string{"rtb-00000000000000001"},
+	},
+}
+
+// TestInputValidation plans modules with invalid vars and checks that each plan
+// fails with the validation message of the offending variable. A case that
+// plans cleanly means a validation was dropped or loosened.
+func TestInputValidation(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	testCases := []struct {
+		name     string
+		module   string
+		vars     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "CidrNotACidr",
+			module:   "vpc",
+			vars:     map[string]interface{}{"cidr_block": "10.0.0.0"},
+			expected: "cidr_block must be a valid IPv4 CIDR between /16 and /24.",
+		},
+		{
+			name:     "CidrTooLarge",
+			module:   "vpc",
+			vars:     map[string]interface{}{"cidr_block": "10.0.0.0/8"},
+			expected: "cidr_block must be a valid IPv4 CIDR between /16 and /24.",
+		},
+		{
+			name:     "AzCountZero",
+			module:   "vpc",
+			vars:     map[string]interface{}{"az_count": 0},
+			expected: "az_count must be between 1 and 3.",
+		},
+		{
+			name:     "AzCountTooHigh",
+			module:   "vpc",
+			vars:     map[string]interface{}{"az_count": 4},
+			expected: "az_count must be between 1 and 3.",
+		},
+		{
+			name:     "UnknownNatStrategy",
+			module:   "vpc",
+			vars:     map[string]interface{}{"nat_strategy": "sometimes"},
+			expected: `nat_strategy must be either "single" or "per_az".`,
+		},
+		{
+			name:     "EmptyOwner",
+			module:   "vpc",
+			vars:     map[string]interface{}{"owner": ""},
+			expected: "owner must not be empty.",
+		},
+		{
+			name:     "OverlappingPeeringCidrs",
+			module:   "vpc-peering",
+			vars:     map[string]interface{}{"spoke_cidr_block": "10.30.128.0/17"},
+			expected: "hub_cidr_block and spoke_cidr_block must not overlap.",
+		},
+		{
+			name:     "BrokerOpenToTheWorld",
+			module:   "security",
+			vars:     map[string]interface{}{"device_cidr_blocks": []string{"0.0.0.0/0"}},
+			expected: "device_cidr_blocks must not open the broker to 0.0.0.0/0.",
+		},
+		{
+			name:     "NamePrefixTooLong",
+			module:   "gateway",
+			vars:     map[string]interface{}{"name_prefix": "tt-much-too-long-for-a-load-balancer"},
+			expected: "name_prefix must be at most 16 characters, load balancer and target group names are limited to 32.",
+		},
+		{
+			name:     "UnknownInstanceType",
+			module:   "bastion",
+			vars:     map[string]interface{}{"instance_type": "large"},
+			expected: "instance_type must be an EC2 instance type such as t3.micro.",
+		},
+		{
+			name:     "UnknownAccessMode",
+			module:   "bastion",
+			vars:     map[string]interface{}{"access_mode": "telnet"},
+			expected: "access_mode must be ssh or ssm.",
+		},
+		{
+			name:     "SshOpenToTheWorld",
+			module:   "bastion",
+			vars:     map[string]interface{}{"access_mode": "ssh", "public_key": "ssh-ed25519 AAAA", "allowed_ssh_cidr_blocks": []string{"0.0.0.0/0"}},
+			expected: "allowed_ssh_cidr_blocks must not open the bastion to 0.0.0.0/0.",
+		},
+		{
+			name:     "NoEndpointSubnets",
+			module:   "vpc-endpoints",
+			vars:     map[string]interface{}{"subnet_ids": []string{}},
+			expected: "subnet_ids must not be empty.",
+		},
+		{
+			name:     "BrokerMetricsInAwsNamespace",
+			module:   "monitoring",
+			vars:     map[string]interface{}{"broker_metric_namespace": "AWS/IoT"},
+			expected: "broker_metric_namespace must not start with AWS/, which is reserved for AWS services.",
+		},
+		{
+			name:     "NotAHostedZoneId",
+			module:   "dns",
+			vars:     map[string]interface{}{"zone_id": "example.com"},
+			expected: "zone_id must be a Route53 hosted zone ID such as Z0123456789ABCDEFGHIJ.",
+		},
+		{
+			name:     "MqttTtlTooShort",
+			module:   "dns",
+			vars:     map[string]interface{}{"mqtt_record_ttl": 0},
+			expected: "mqtt_record_ttl must be between 30 and 86400 seconds.",
+		},
+		{
+			name:     "NoAccessPrincipals",
+			module:   "ledger",
+			vars:     map[string]interface{}{"access_principal_arns": []string{}},
+			expected: "access_principal_arns must list at least one IAM principal ARN.",
+		},
+		{
+			name:     "UnknownRetentionMode",
+			module:   "ledger",
+			vars:     map[string]interface{}{"retention_mode": "LEGAL_HOLD"},
+			expected: "retention_mode must be COMPLIANCE or GOVERNANCE.",
+		},
+		{
+			name:     "NotAnAccountId",
+			module:   "vpc-peering",
+			vars:     map[string]interface{}{"spoke_account_id": "spoke"},
+			expected: "spoke_account_id must be a 12 digit AWS account ID.",
+		},
+		{
+			name:     "NotAPeeringConnectionId",
+			module:   "vpc-peering-accepter",
+			vars:     map[string]interface{}{"peering_connection_id": "vpc-00000000000000000"},
+			expected: "peering_connection_id must be a VPC peering connection ID such as pcx-0123456789abcdef0.",
+		},
+		{
+			name:     "UnknownTargetType",
+			module:   "gateway",
+			vars:     map[string]interface{}{"target_type": "lambda"},
+			expected: "target_type must be ip or instance.",
+		},
+		{
+			name:     "SpotPercentageAboveHundred",
+			module:   "asg",
+			vars:     map[string]interface{}{"spot_percentage": 150},
+			expected: "spot_percentage must be a whole number between 0 and 100.",
+		},
+		{
+			name:     "NoWorkerSubnets",
+			module:   "asg",
+			vars:     map[string]interface{}{"subnet_ids": []string{}},
+			expected: "subnet_ids must list at least one subnet.",
+		},
+		{
+			name:     "UnknownArchiveStorageClass",
+			module:   "storage",
+			vars:     map[string]interface{}{"archive_storage_class": "REDUCED_REDUNDANCY"},
+			expected: "archive_storage_class must be an S3 storage class objects can transition to, such as GLACIER_IR.",
+		},
+		{
+			name:     "ArchiveExpiresBeforeTransition",
+			module:   "storage",
+			vars:     map[string]interface{}{"archive_transition_days": 90, "archive_expiration_days": 30},
+			expected: "archive_expiration_days must be 0 or later than archive_transition_days.",
+		},
+		{
+			name:     "NoComponents",
+			module:   "edge-gateway",
+			vars:     map[string]interface{}{"components": map[string]string{}},
+			expected: "components must list at least one component.",
+		},
+		{
+			name:     "ComponentVersionNotSemver",
+			module:   "edge-gateway",
+			vars:     map[string]interface{}{"components": map[string]string{"aws.greengrass.Cli": "latest"}},
+			expected: "components must map component names to semantic versions such as 2.12.6.",
+		},
+		{
+			name:     "UnsupportedLogRetention",
+			module:   "logging",
+			vars:     map[string]interface{}{"retention_in_days": 10},
+			expected: "retention_in_days must be a retention CloudWatch Logs supports, such as 7, 30 or 365.",
+		},
+		{
+			name:     "BufferingIntervalTooShort",
+			module:   "logging",
+			vars:     map[string]interface{}{"buffering_interval": 30},
+			expected: "buffering_interval must be between 60 and 900 seconds.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"environment": "test",
+			}
+			// DNS records carry neither a project name nor tags
+			if tc.module != "dns" {
+				vars["project_name"] = "iot-network"
+			}
+			if tc.module != "security" && tc.module != "dns" {
+				vars["owner"] = "terratest"
+				vars["cost_center"] = "ci"
+			}
+			for key, value := range validationModuleVars[tc.module] {
+				vars[key] = value
+			}
+			for key, value := range tc.vars {
+				vars[key] = value
+			}
+
+			// Not NewModuleOptionsInRegion: there is nothing to destroy, and a
+			// destroy with these vars would fail on the same validation
+			terraformOptions := &terraform.Options{
+				TerraformDir:    testhelpers.ModuleWorkingDir(t, tc.module),
+				TerraformBinary: testhelpers.NewestTerraformBinary(),
+				Vars:            vars,
+				EnvVars:         map[string]string{"AWS_DEFAULT_REGION": region},
+			}
+
+			output, err := terraform.InitAndPlanE(t, terraformOptions)
+			require.Error(t, err, "plan succeeded with invalid vars %v, a validation has regressed", tc.vars)
+			assert.Contains(t, diagnosticText(output), tc.expected, "plan failed without the validation message")
+		})
+	}
+}
+
+// diagnosticText joins the lines of terraform output back together. Terraform
+// wraps diagnostics to the terminal width and draws a box in front of them,
+// which splits longer error messages across lines.
+func diagnosticText(output string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(output, "│", " ")), " ")
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// destroyTestEnvVar enables TestVpcDestroy, which applies and destroys a full
+// VPC with NAT and is only run nightly.
+const destroyTestEnvVar = "RUN_DESTROY_TEST"
+
+// TestVpcDestroy checks that destroying the vpc module really removes
+// everything it created, including the ENI and Elastic IP behind the NAT
+// gateway and the flow log with its log group, role and key, and that a
+// second destroy is a no-op.
+func TestVpcDestroy(t *testing.T) {
+	t.Parallel()
+	testhelpers.SkipUnlessEnabled(t, destroyTestEnvVar)
+	if testhelpers.IsPlanOnly() {
+		t.Skipf("%s is set, nothing to destroy", testhelpers.PlanOnlyEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.5.0.0/16",
+		"az_count":     2,
+		"enable_nat":   true,
+		// The flow log's resources live outside the VPC and are the easiest
+		// to leave behind
+		"enable_flow_logs":            true,
+		"enable_flow_logs_encryption": true,
+	})
+
+	testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(terraformOptions), vpcQuotaRequirements(true))
+	testhelpers.ApplyModule(t, terraformOptions)
+
+	recorded := recordVpcResources(t, region, terraformOptions)
+	require.NotEmpty(t, recorded, "no resources recorded after apply")
+
+	testhelpers.DestroyModule(t, terraformOptions)
+
+	ids := make([]string, 0, len(recorded))
+	for id := range recorded {
+		ids = append(ids, id)
+	}
+	stragglers := append(existingEc2Resources(t, region, ids), existingFlowLogResources(t, region, recorded)...)
+	assert.Empty(t, stragglers, "resources still exist after destroy:\n%s", strings.Join(stragglers, "\n"))
+
+	output := testhelpers.DestroyModule(t, terraformOptions)
+	assert.Contains(t, output, "Resources: 0 destroyed", "destroying an already destroyed module changed something")
+}
+
+// recordVpcResources returns the IDs of everything the applied module owns,
+// mapped to their resource type where that is known: the resources in its
+// state, the tagged resources carrying its name prefix and the network
+// interfaces AWS created inside the VPC on its behalf.
+func recordVpcResources(t *testing.T, region string, terraformOptions *terraform.Options) map[string]string {
+	ids := map[string]string{}
+	for _, resource := range testhelpers.StateResources(t, terraformOptions) {
+		if id, ok := resource.AttributeValues["id"].(string); ok && id != "" {
+			ids[id] = resource.Type
+		}
+	}
+	for _, arn := range testhelpers.ResourcesWithNamePrefix(t, region, testhelpers.NamePrefix(terraformOptions)) {
+		if id := arn[strings.LastIndex(arn, "/")+1:]; ids[id] == "" {
+			ids[id] = ""
+		}
+	}
+
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+	interfaces, err := ec2Client.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{Name: awsSDK.String("vpc-id"), Values: []*string{awsSDK.String(terraform.Output(t, terraformOptions, "vpc_id"))}}},
+	})
+	require.NoError(t, err)
+	for _, networkInterface := range interfaces.NetworkInterfaces {
+		ids[awsSDK.StringValue(networkInterface.NetworkInterfaceId)] = "aws_network_interface"
+	}
+	return ids
+}
+
+// existingEc2Resources returns the IDs that still exist in EC2. Filtering by
+// ID instead of asking for the IDs directly means deleted resources are left
+// out rather than failing the call. IDs of kinds that cannot outlive their VPC,
+// such as route table associations, are ignored.
+func existingEc2Resources(t *testing.T, region string, ids []string) []string {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	byKind := map[string][]*string{}
+	for _, id := range ids {
+		kind := id[:strings.Index(id+"-", "-")]
+		byKind[kind] = append(byKind[kind], awsSDK.String(id))
+	}
+	filter := func(name string, values []*string) []*ec2.Filter {
+		return []*ec2.Filter{{Name: awsSDK.String(name), Values: values}}
+	}
+
+	var existing []string
+	if ids := byKind["vpc"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeVpcs(&ec2.DescribeVpcsInput{Filters: filter("vpc-id", ids)})
+		require.NoError(t, err)
+		for _, vpc := range output.Vpcs {
+			existing = append(existing, awsSDK.StringValue(vpc.VpcId))
+		}
+	}
+	if ids := byKind["subnet"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: filter("subnet-id", ids)})
+		require.NoError(t, err)
+		for _, subnet := range output.Subnets {
+			existing = append(existing, awsSDK.StringValue(subnet.SubnetId))
+		}
+	}
+	if ids := byKind["rtb"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: filter("route-table-id", ids)})
+		require.NoError(t, err)
+		for _, routeTable := range output.RouteTables {
+			existing = append(existing, awsSDK.StringValue(routeTable.RouteTableId))
+		}
+	}
+	if ids := byKind["igw"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{Filters: filter("internet-gateway-id", ids)})
+		require.NoError(t, err)
+		for _, gateway := range output.InternetGateways {
+			existing = append(existing, awsSDK.StringValue(gateway.InternetGatewayId))
+		}
+	}
+	if ids := byKind["nat"]; len(ids) > 0 {
+		// Deleted NAT gateways stay visible for about an hour
+		output, err := ec2Client.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{Filter: append(filter("nat-gateway-id", ids),
+			&ec2.Filter{Name: awsSDK.String("state"), Values: awsSDK.StringSlice([]string{"pending", "available", "deleting"})})})
+		require.NoError(t, err)
+		for _, natGateway := range output.NatGateways {
+			existing = append(existing, awsSDK.StringValue(natGateway.NatGatewayId))
+		}
+	}
+	if ids := byKind["eipalloc"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeAddresses(&ec2.DescribeAddressesInput{Filters: filter("allocation-id", ids)})
+		require.NoError(t, err)
+		for _, address := range output.Addresses {
+			existing = append(existing, awsSDK.StringValue(address.AllocationId))
+		}
+	}
+	if ids := byKind["eni"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{Filters: filter("network-interface-id", ids)})
+		require.NoError(t, err)
+		for _, networkInterface := range output.NetworkInterfaces {
+			existing = append(existing, awsSDK.StringValue(networkInterface.NetworkInterfaceId))
+		}
+	}
+	if ids := byKind["fl"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeFlowLogs(&ec2.DescribeFlowLogsInput{Filter: filter("flow-log-id", ids)})
+		require.NoError(t, err)
+		for _, flowLog := range output.FlowLogs {
+			existing = append(existing, awsSDK.StringValue(flowLog.FlowLogId))
+		}
+	}
+	if ids := byKind["sg"]; len(ids) > 0 {
+		output, err := ec2Client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{Filters: filter("group-id", ids)})
+		require.NoError(t, err)
+		for _, group := range output.SecurityGroups {
+			existing = append(existing, awsSDK.StringValue(group.GroupId))
+		}
+	}
+
+	sort.Strings(existing)
+	return existing
+}
+
+// existingFlowLogResources returns the recorded log groups and IAM roles that
+// still exist, and the KMS keys that are not pending deletion. Destroying a
+// key only schedules its deletion.
+func existingFlowLogResources(t *testing.T, region string, recorded map[string]string) []string {
+	sess := testhelpers.NewSession(t, region)
+	logsClient := cloudwatchlogs.New(sess)
+	iamClient := iam.New(sess)
+	kmsClient := kms.New(sess)
+
+	var existing []string
+	for id, resourceType := range recorded {
+		switch resourceType {
+		case "aws_cloudwatch_log_group":
+			output, err := logsClient.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: awsSDK.String(id)})
+			require.NoError(t, err)
+			for _, group := range output.LogGroups {
+				if awsSDK.StringValue(group.LogGroupName) == id {
+					existing = append(existing, "log group "+id)
+				}
+			}
+		case "aws_iam_role":
+			_, err := iamClient.GetRole(&iam.GetRoleInput{RoleName: awsSDK.String(id)})
+			var awsErr awserr.Error
+			if !errors.As(err, &awsErr) || awsErr.Code() != iam.ErrCodeNoSuchEntityException {
+				require.NoError(t, err)
+				existing = append(existing, "IAM role "+id)
+			}
+		case "aws_kms_key":
+			output, err := kmsClient.DescribeKey(&kms.DescribeKeyInput{KeyId: awsSDK.String(id)})
+			require.NoError(t, err)
+			if state := awsSDK.StringValue(output.KeyMetadata.KeyState); state != kms.KeyStatePendingDeletion {
+				existing = append(existing, "KMS key "+id+" ("+state+")")
+			}
+		}
+	}
+
+	sort.Strings(existing)
+	return existing
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// Stages of TestVpcDrift on top of setup and teardown, each skipped when
+// SKIP_<stage> is set. Skipping drift_detect leaves the drift in place for a
+// later run to detect.
+const (
+	stageDriftMutate    = "drift_mutate"
+	stageDriftDetect    = "drift_detect"
+	stageDriftReconcile = "drift_reconcile"
+)
+
+// driftedSubnetAddress is the resource TestVpcDrift changes out of band.
+const driftedSubnetAddress = "aws_subnet.public[0]"
+
+// TestVpcDrift removes the Name tag of a subnet of the applied vpc module
+// through the EC2 API, the way a console edit would, and checks that plan
+// reports exactly that subnet as changed and that applying again puts the tag
+// back.
+func TestVpcDrift(t *testing.T) {
+	t.Parallel()
+	if testhelpers.IsPlanOnly() {
+		t.Skipf("%s is set, nothing to drift", testhelpers.PlanOnlyEnvVar)
+	}
+
+	region := testhelpers.SelectRegion(t)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.7.0.0/16",
+		"az_count":     1,
+		"enable_nat":   false,
+	})
+
+	testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(terraformOptions), vpcQuotaRequirements(false))
+	testhelpers.ApplyModule(t, terraformOptions)
+
+	subnetIds := terraform.OutputList(t, terraformOptions, "public_subnet_ids")
+	require.NotEmpty(t, subnetIds)
+	subnetId := subnetIds[0]
+
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	test_structure.RunTestStage(t, stageDriftMutate, func() {
+		// Only ever touch a subnet this run created
+		tags, err := subnetTags(ec2Client, subnetId)
+		require.NoError(t, err)
+		name := tags["Name"]
+		require.True(t, strings.HasPrefix(name, testhelpers.NamePrefix(terraformOptions)+"-"),
+			"subnet %s is named %q, which does not carry the test's name prefix", subnetId, name)
+
+		_, err = ec2Client.DeleteTags(&ec2.DeleteTagsInput{
+			Resources: []*string{awsSDK.String(subnetId)},
+			Tags:      []*ec2.Tag{{Key: awsSDK.String("Name")}},
+		})
+		require.NoError(t, err, "removing the Name tag of subnet %s", subnetId)
+
+		removed := testhelpers.PollUntil(t, "Name tag removed from subnet "+subnetId, func() (bool, error) {
+			tags, err := subnetTags(ec2Client, subnetId)
+			if err != nil {
+				return false, err
+			}
+			_, tagged := tags["Name"]
+			return !tagged, nil
+		})
+		require.True(t, removed, "Name tag of subnet %s is still visible", subnetId)
+	})
+
+	test_structure.RunTestStage(t, stageDriftDetect, func() {
+		exitCode, err := terraform.PlanExitCodeE(t, terraformOptions)
+		require.NoError(t, err)
+		assert.Equal(t, terraform.TerraformPlanChangesPresentExitCode, exitCode, "plan did not report the drift")
+
+		plan := testhelpers.PlanModule(t, terraformOptions)
+		var changed []string
+		for address, change := range plan.ResourceChangesMap {
+			if !change.Change.Actions.NoOp() && !change.Change.Actions.Read() {
+				changed = append(changed, address)
+			}
+		}
+		assert.Equal(t, []string{driftedSubnetAddress}, changed, "resources changed by the plan")
+		if change, ok := plan.ResourceChangesMap[driftedSubnetAddress]; ok {
+			assert.True(t, change.Change.Actions.Update(), "drifted subnet is not updated in place")
+		}
+	})
+
+	test_structure.RunTestStage(t, stageDriftReconcile, func() {
+		// Not ApplyModule: that belongs to the setup stage
+		terraform.Apply(t, terraformOptions)
+
+		tags, err := subnetTags(ec2Client, subnetId)
+		require.NoError(t, err)
+		name := tags["Name"]
+		assert.True(t, strings.HasPrefix(name, testhelpers.NamePrefix(terraformOptions)+"-"), "Name tag of subnet %s is %q after apply", subnetId, name)
+
+		exitCode, err := terraform.PlanExitCodeE(t, terraformOptions)
+		require.NoError(t, err)
+		assert.Equal(t, terraform.DefaultSuccessExitCode, exitCode, "plan still reports changes after reconciling")
+	})
+}
+
+// subnetTags returns the tags of the subnet by key.
+func subnetTags(ec2Client *ec2.EC2, subnetId string) (map[string]string, error) {
+	subnets, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{awsSDK.String(subnetId)}})
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, subnet := range subnets.Subnets {
+		for _, tag := range subnet.Tags {
+			tags[awsSDK.StringValue(tag.Key)] = awsSDK.StringValue(tag.Value)
+		}
+	}
+	return tags, nil
+}
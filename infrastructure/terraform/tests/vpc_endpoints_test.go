@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iot"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// endpointServices are the services the vpc-endpoints module creates interface
+// endpoints for by default.
+var endpointServices = []string{"dynamodb", "iot.data", "s3"}
+
+// dnsProbeSource is the Lambda that resolves a hostname from inside the VPC.
+const dnsProbeSource = `import socket
+
+
+def handler(event, context):
+    return {"addresses": socket.gethostbyname_ex(event["hostname"])[2]}
+`
+
+// TestVpcEndpointsModule creates the interface endpoints in a VPC without NAT
+// and checks that each one is available, has private DNS enabled and only
+// accepts traffic from the VPC CIDR. A probe Lambda in a private subnet then
+// resolves the account's IoT data endpoint, which has to come back as a
+// private address in the VPC: a public one would mean devices silently reach
+// IoT over the NAT.
+func TestVpcEndpointsModule(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	const cidrBlock = "10.13.0.0/16"
+
+	vpcOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   cidrBlock,
+		"az_count":     2,
+		"enable_nat":   false,
+	})
+
+	vpcId := "vpc-00000000000000000"
+	privateSubnetIds := []string{"subnet-00000000000000000", "subnet-00000000000000001"}
+	if !testhelpers.IsPlanOnly() {
+		testhelpers.SkipWithoutQuotaHeadroom(t, testhelpers.Region(vpcOptions), vpcQuotaRequirements(false))
+		testhelpers.ApplyModule(t, vpcOptions)
+		vpcId = terraform.Output(t, vpcOptions, "vpc_id")
+		privateSubnetIds = terraform.OutputList(t, vpcOptions, "private_subnet_ids")
+	}
+
+	endpointsOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc-endpoints", region, map[string]interface{}{
+		"project_name":   "iot-network",
+		"environment":    "test",
+		"owner":          "terratest",
+		"cost_center":    "ci",
+		"vpc_id":         vpcId,
+		"vpc_cidr_block": cidrBlock,
+		"subnet_ids":     privateSubnetIds,
+	})
+
+	testhelpers.RunModuleChecks(t, endpointsOptions, testhelpers.ModuleChecks{
+		Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+			endpoints := testhelpers.PlannedResourcesOfType(plan, "aws_vpc_endpoint")
+			assert.Len(t, endpoints, len(endpointServices), "planned endpoints")
+			for _, service := range endpointServices {
+				address := fmt.Sprintf("aws_vpc_endpoint.interface[%q]", service)
+				if assert.Contains(t, endpoints, address) {
+					assert.Equal(t, true, endpoints[address]["private_dns_enabled"], "%s private_dns_enabled", address)
+				}
+			}
+		},
+		Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+			region := testhelpers.Region(terraformOptions)
+			endpointIds := terraform.OutputMap(t, terraformOptions, "endpoint_ids")
+			groupId := terraform.Output(t, terraformOptions, "security_group_id")
+
+			services := make([]string, 0, len(endpointIds))
+			ids := make([]string, 0, len(endpointIds))
+			for service, id := range endpointIds {
+				services = append(services, service)
+				ids = append(ids, id)
+			}
+			sort.Strings(services)
+			require.Equal(t, endpointServices, services, "endpoint_ids output")
+
+			output, err := ec2.New(testhelpers.NewSession(t, region)).DescribeVpcEndpoints(&ec2.DescribeVpcEndpointsInput{VpcEndpointIds: awsSDK.StringSlice(ids)})
+			require.NoError(t, err)
+			byService := map[string]*ec2.VpcEndpoint{}
+			for _, endpoint := range output.VpcEndpoints {
+				byService[awsSDK.StringValue(endpoint.ServiceName)] = endpoint
+			}
+			for _, service := range endpointServices {
+				serviceName := fmt.Sprintf("com.amazonaws.%s.%s", region, service)
+				endpoint, ok := byService[serviceName]
+				if !assert.True(t, ok, "no endpoint for %s", serviceName) {
+					continue
+				}
+				assert.Equal(t, vpcId, awsSDK.StringValue(endpoint.VpcId), "VPC of the %s endpoint", service)
+				assert.Equal(t, "available", awsSDK.StringValue(endpoint.State), "state of the %s endpoint", service)
+				assert.True(t, awsSDK.BoolValue(endpoint.PrivateDnsEnabled), "private DNS is disabled on the %s endpoint", service)
+				var groupIds []string
+				for _, group := range endpoint.Groups {
+					groupIds = append(groupIds, awsSDK.StringValue(group.GroupId))
+				}
+				assert.Equal(t, []string{groupId}, groupIds, "security groups of the %s endpoint", service)
+			}
+
+			assert.Equal(t, []string{"tcp 443-443 from " + cidrBlock}, describeIngressRules(t, region, groupId), "security group %s has unexpected ingress rules", groupId)
+			assert.Empty(t, describeEgressRules(t, region, groupId), "security group %s should have no egress rules", groupId)
+
+			endpoint, err := testhelpers.NewIotClient(t, region).DescribeEndpoint(&iot.DescribeEndpointInput{EndpointType: awsSDK.String("iot:Data-ATS")})
+			require.NoError(t, err)
+			hostname := awsSDK.StringValue(endpoint.EndpointAddress)
+
+			addresses := resolveInVpc(t, region, testhelpers.NamePrefix(terraformOptions), vpcId, privateSubnetIds[0], hostname)
+			require.NotEmpty(t, addresses, "%s did not resolve in the VPC", hostname)
+			for _, address := range addresses {
+				assert.True(t, testhelpers.CIDRContains(cidrBlock, address+"/32"),
+					"%s resolves to %s in the VPC, outside %s: private DNS of the iot.data endpoint is not in effect", hostname, address, cidrBlock)
+			}
+		},
+	})
+}
+
+// describeEgressRules flattens the egress rules of a security group the way
+// describeIngressRules does for ingress.
+func describeEgressRules(t *testing.T, region string, groupId string) []string {
+	out, err := ec2.New(testhelpers.NewSession(t, region)).DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{awsSDK.String(groupId)}})
+	require.NoError(t, err)
+	require.Len(t, out.SecurityGroups, 1, "DescribeSecurityGroups did not return %s", groupId)
+
+	var rules []string
+	for _, permission := range out.SecurityGroups[0].IpPermissionsEgress {
+		ports := fmt.Sprintf("%s %d-%d", awsSDK.StringValue(permission.IpProtocol), awsSDK.Int64Value(permission.FromPort), awsSDK.Int64Value(permission.ToPort))
+		for _, ipRange := range permission.IpRanges {
+			rules = append(rules, fmt.Sprintf("%s to %s", ports, awsSDK.StringValue(ipRange.CidrIp)))
+		}
+		for _, ipRange := range permission.Ipv6Ranges {
+			rules = append(rules, fmt.Sprintf("%s to %s", ports, awsSDK.StringValue(ipRange.CidrIpv6)))
+		}
+		for _, pair := range permission.UserIdGroupPairs {
+			rules = append(rules, fmt.Sprintf("%s to %s", ports, awsSDK.StringValue(pair.GroupId)))
+		}
+	}
+	return rules
+}
+
+// resolveInVpc resolves the hostname with a probe Lambda attached to the
+// subnet and returns the IPv4 addresses it got. The probe, its role and its
+// security group are removed when the test is done, whatever its outcome.
+func resolveInVpc(t *testing.T, region string, namePrefix string, vpcId string, subnetId string, hostname string) []string {
+	name := namePrefix + "-dns-probe"
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+	iamClient := iam.New(testhelpers.NewSession(t, region))
+	lambdaClient := lambda.New(testhelpers.NewSession(t, region))
+
+	group, err := ec2Client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   awsSDK.String(name),
+		Description: awsSDK.String("DNS probe of the vpc-endpoints test"),
+		VpcId:       awsSDK.String(vpcId),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: awsSDK.String(ec2.ResourceTypeSecurityGroup),
+			Tags:         []*ec2.Tag{{Key: awsSDK.String("Name"), Value: awsSDK.String(name)}},
+		}},
+	})
+	require.NoError(t, err)
+	groupId := awsSDK.StringValue(group.GroupId)
+	t.Cleanup(func() {
+		// Lambda releases its network interfaces a while after the function
+		// is deleted, and the group cannot go before they have
+		_, err := retry.DoWithRetryE(t, "delete security group "+groupId, 40, 30*time.Second, func() (string, error) {
+			_, err := ec2Client.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: awsSDK.String(groupId)})
+			return "", err
+		})
+		if err != nil {
+			t.Logf("Failed to delete security group %s: %v", groupId, err)
+		}
+	})
+
+	role, err := iamClient.CreateRole(&iam.CreateRoleInput{
+		RoleName:                 awsSDK.String(name),
+		AssumeRolePolicyDocument: awsSDK.String(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`),
+	})
+	require.NoError(t, err)
+	const vpcAccessPolicyArn = "arn:aws:iam::aws:policy/service-role/AWSLambdaVPCAccessExecutionRole"
+	t.Cleanup(func() {
+		if _, err := iamClient.DetachRolePolicy(&iam.DetachRolePolicyInput{RoleName: awsSDK.String(name), PolicyArn: awsSDK.String(vpcAccessPolicyArn)}); err != nil {
+			t.Logf("Failed to detach %s from role %s: %v", vpcAccessPolicyArn, name, err)
+		}
+		if _, err := iamClient.DeleteRole(&iam.DeleteRoleInput{RoleName: awsSDK.String(name)}); err != nil {
+			t.Logf("Failed to delete role %s: %v", name, err)
+		}
+	})
+	_, err = iamClient.AttachRolePolicy(&iam.AttachRolePolicyInput{RoleName: awsSDK.String(name), PolicyArn: awsSDK.String(vpcAccessPolicyArn)})
+	require.NoError(t, err)
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	handler, err := writer.Create("probe.py")
+	require.NoError(t, err)
+	_, err = handler.Write([]byte(dnsProbeSource))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	// A new role takes a few seconds before Lambda can assume it
+	_, err = retry.DoWithRetryE(t, "create function "+name, 10, 10*time.Second, func() (string, error) {
+		_, err := lambdaClient.CreateFunction(&lambda.CreateFunctionInput{
+			FunctionName: awsSDK.String(name),
+			Role:         role.Role.Arn,
+			Runtime:      awsSDK.String(lambda.RuntimePython312),
+			Handler:      awsSDK.String("probe.handler"),
+			Code:         &lambda.FunctionCode{ZipFile: archive.Bytes()},
+			Timeout:      awsSDK.Int64(10),
+			VpcConfig: &lambda.VpcConfig{
+				SubnetIds:        awsSDK.StringSlice([]string{subnetId}),
+				SecurityGroupIds: awsSDK.StringSlice([]string{groupId}),
+			},
+		})
+		if err != nil && !strings.Contains(err.Error(), "cannot be assumed by Lambda") {
+			return "", retry.FatalError{Underlying: err}
+		}
+		return "", err
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if _, err := lambdaClient.DeleteFunction(&lambda.DeleteFunctionInput{FunctionName: awsSDK.String(name)}); err != nil {
+			t.Logf("Failed to delete function %s: %v", name, err)
+		}
+	})
+	require.NoError(t, lambdaClient.WaitUntilFunctionActiveV2(&lambda.GetFunctionInput{FunctionName: awsSDK.String(name)}))
+
+	var response struct {
+		Addresses []string `json:"addresses"`
+	}
+	require.NoError(t, json.Unmarshal(testhelpers.InvokeFunction(t, region, name, map[string]string{"hostname": hostname}), &response))
+	for _, address := range response.Addresses {
+		require.NotNil(t, net.ParseIP(address), "probe returned %q for %s", address, hostname)
+	}
+	t.Logf("%s resolves to %v in %s", hostname, response.Addresses, vpcId)
+	return response.Addresses
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// privateSubnetRoute is where the default route of a private subnet leads.
+type privateSubnetRoute struct {
+	subnetId     string
+	zone         string
+	routeTableId string
+	// natGatewayId is empty when the subnet has no default route through a
+	// NAT gateway
+	natGatewayId string
+}
+
+// TestVpcNatStrategy applies the vpc module with each nat_strategy and checks
+// the live NAT gateways and private routes against it: "single" must create
+// exactly one NAT gateway that every private route table points at, "per_az"
+// one per AZ with each private subnet routed to the NAT gateway in its own AZ.
+func TestVpcNatStrategy(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	const azCount = 2
+
+	testCases := []struct {
+		strategy  string
+		cidrBlock string
+	}{
+		{strategy: "single", cidrBlock: "10.17.0.0/16"},
+		{strategy: "per_az", cidrBlock: "10.18.0.0/16"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.strategy, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   tc.cidrBlock,
+				"az_count":     azCount,
+				"enable_nat":   true,
+				"nat_strategy": tc.strategy,
+			})
+
+			natCount := 1
+			quotas := vpcQuotaRequirements(true)
+			if tc.strategy == "per_az" {
+				natCount = azCount
+				quotas.ElasticIPs = azCount
+			}
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Quotas: quotas,
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					assert.Len(t, testhelpers.PlannedResourcesOfType(plan, "aws_nat_gateway"), natCount, "planned NAT gateways with nat_strategy %q", tc.strategy)
+					assert.Len(t, testhelpers.PlannedResourcesOfType(plan, "aws_eip"), natCount, "planned NAT EIPs with nat_strategy %q", tc.strategy)
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					region := testhelpers.Region(terraformOptions)
+					vpcId := terraform.Output(t, terraformOptions, "vpc_id")
+					privateSubnetIds := terraform.OutputList(t, terraformOptions, "private_subnet_ids")
+
+					natZones := natGatewayZones(t, region, vpcId)
+					require.Len(t, natZones, natCount, "NAT gateways in VPC %s with nat_strategy %q", vpcId, tc.strategy)
+
+					routes := privateSubnetRoutes(t, region, vpcId, privateSubnetIds)
+					violations := natRoutingViolations(tc.strategy, routes, natZones)
+					assert.Empty(t, violations, "private subnets routed against nat_strategy %q:\n%s", tc.strategy, strings.Join(violations, "\n"))
+				},
+			})
+		})
+	}
+}
+
+// natGatewayZones returns the AZ of every available NAT gateway in the VPC, by
+// NAT gateway ID. A NAT gateway is in the AZ of the subnet it was created in.
+func natGatewayZones(t *testing.T, region string, vpcId string) map[string]string {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	natGateways, err := ec2Client.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{
+			{Name: awsSDK.String("vpc-id"), Values: []*string{awsSDK.String(vpcId)}},
+			{Name: awsSDK.String("state"), Values: []*string{awsSDK.String(ec2.NatGatewayStateAvailable)}},
+		},
+	})
+	require.NoError(t, err)
+	if len(natGateways.NatGateways) == 0 {
+		return map[string]string{}
+	}
+
+	subnetIds := make([]*string, 0, len(natGateways.NatGateways))
+	for _, natGateway := range natGateways.NatGateways {
+		subnetIds = append(subnetIds, natGateway.SubnetId)
+	}
+	subnetZones := subnetZones(t, ec2Client, subnetIds)
+
+	zones := map[string]string{}
+	for _, natGateway := range natGateways.NatGateways {
+		zones[awsSDK.StringValue(natGateway.NatGatewayId)] = subnetZones[awsSDK.StringValue(natGateway.SubnetId)]
+	}
+	return zones
+}
+
+// privateSubnetRoutes looks up the route table associated with each private
+// subnet and the NAT gateway its default route leads to.
+func privateSubnetRoutes(t *testing.T, region string, vpcId string, privateSubnetIds []string) []privateSubnetRoute {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	zones := subnetZones(t, ec2Client, awsSDK.StringSlice(privateSubnetIds))
+
+	routeTables, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{Name: awsSDK.String("vpc-id"), Values: []*string{awsSDK.String(vpcId)}},
+			{Name: awsSDK.String("association.subnet-id"), Values: awsSDK.StringSlice(privateSubnetIds)},
+		},
+	})
+	require.NoError(t, err)
+
+	routesBySubnet := map[string]privateSubnetRoute{}
+	for _, routeTable := range routeTables.RouteTables {
+		natGatewayId := ""
+		for _, route := range routeTable.Routes {
+			if awsSDK.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" {
+				natGatewayId = awsSDK.StringValue(route.NatGatewayId)
+			}
+		}
+		for _, association := range routeTable.Associations {
+			subnetId := awsSDK.StringValue(association.SubnetId)
+			if subnetId == "" {
+				continue
+			}
+			routesBySubnet[subnetId] = privateSubnetRoute{
+				subnetId:     subnetId,
+				zone:         zones[subnetId],
+				routeTableId: awsSDK.StringValue(routeTable.RouteTableId),
+				natGatewayId: natGatewayId,
+			}
+		}
+	}
+
+	routes := make([]privateSubnetRoute, 0, len(privateSubnetIds))
+	for _, subnetId := range privateSubnetIds {
+		route, ok := routesBySubnet[subnetId]
+		if !ok {
+			// Falls back to the VPC's main route table, which has no NAT route
+			route = privateSubnetRoute{subnetId: subnetId, zone: zones[subnetId]}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// subnetZones returns the AZ of each of the subnets, by subnet ID.
+func subnetZones(t *testing.T, ec2Client *ec2.EC2, subnetIds []*string) map[string]string {
+	subnets, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: subnetIds})
+	require.NoError(t, err)
+
+	zones := map[string]string{}
+	for _, subnet := range subnets.Subnets {
+		zones[awsSDK.StringValue(subnet.SubnetId)] = awsSDK.StringValue(subnet.AvailabilityZone)
+	}
+	return zones
+}
+
+// natRoutingViolations returns a line per private subnet whose default route
+// does not lead where the NAT strategy says: to the one NAT gateway with
+// "single", to the NAT gateway in the subnet's own AZ with "per_az".
+func natRoutingViolations(strategy string, routes []privateSubnetRoute, natZones map[string]string) []string {
+	var violations []string
+	for _, route := range routes {
+		if route.natGatewayId == "" {
+			violations = append(violations, fmt.Sprintf("subnet %s in %s: route table %q has no default route through a NAT gateway", route.subnetId, route.zone, route.routeTableId))
+			continue
+		}
+		natZone, ok := natZones[route.natGatewayId]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("subnet %s in %s: route table %s routes to %s, which is not an available NAT gateway of the VPC", route.subnetId, route.zone, route.routeTableId, route.natGatewayId))
+			continue
+		}
+		if strategy == "per_az" && natZone != route.zone {
+			violations = append(violations, fmt.Sprintf("subnet %s in %s routes to NAT gateway %s in %s instead of the one in its own AZ", route.subnetId, route.zone, route.natGatewayId, natZone))
+		}
+	}
+	// With a single NAT gateway natZones holds only it, so a route to any
+	// other one was reported above
+	sort.Strings(violations)
+	return violations
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"terraform-tests/internal/testhelpers"
+)
+
+// vpcSnapshotAttributes are the attributes recorded in the vpc plan snapshot
+// besides tags. IDs and anything else only known after apply are left out.
+var vpcSnapshotAttributes = map[string][]string{
+	"aws_vpc":    {"cidr_block", "enable_dns_hostnames", "enable_dns_support"},
+	"aws_subnet": {"cidr_block", "map_public_ip_on_launch"},
+	"aws_eip":    {"domain"},
+}
+
+// TestVpcPlanSnapshot compares the planned resource graph of the vpc module
+// against testdata/vpc_plan.golden.json to catch unintended churn before
+// anything is applied. Regenerate the snapshot after an intended change with
+//
+//	UPDATE_SNAPSHOTS=1 go test -run TestVpcPlanSnapshot .
+func TestVpcPlanSnapshot(t *testing.T) {
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+		"project_name": "iot-network",
+		"environment":  "test",
+		"owner":        "terratest",
+		"cost_center":  "ci",
+		"cidr_block":   "10.0.0.0/16",
+		"az_count":     2,
+		"enable_nat":   true,
+	})
+
+	plan := testhelpers.PlanModule(t, terraformOptions)
+	snapshot := testhelpers.NewPlanSnapshot(plan, vpcSnapshotAttributes, map[string]string{
+		testhelpers.NamePrefix(terraformOptions): "${name_prefix}",
+	})
+	testhelpers.AssertPlanSnapshot(t, snapshot, filepath.Join("testdata", "vpc_plan.golden.json"))
+}
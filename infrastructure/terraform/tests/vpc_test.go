@@ -1,21 +1,295 @@
+// The vpc tests are split into terratest stages so that a slow apply does not
+// have to be repeated while iterating on assertions:
+//
+//	setup     build and persist the terraform options, then apply the module
+//	validate  run the plan and apply assertions
+//	teardown  destroy the module and remove the persisted options
+//
+// Setting SKIP_<stage>=true skips that stage. To apply once and then re-run
+// only the assertions against the live infrastructure, pick a single case:
+//
+//	SKIP_teardown=true go test -run 'TestVpcModule/ThreeAzWithNat' ./...
+//	SKIP_setup=true SKIP_teardown=true go test -run 'TestVpcModule/ThreeAzWithNat' ./...
+//	SKIP_setup=true go test -run 'TestVpcModule/ThreeAzWithNat' ./...
+//
+// With any of SKIP_setup, SKIP_validate or SKIP_teardown set, the module is
+// copied to a working dir named after the test, os.TempDir()/terratest-stages/
+// <test name>/vpc-N, the Nth copy of the vpc module the test makes. Its
+// .test-data keeps the options, including the generated name prefix and the
+// region, its state the applied module, between runs. With no SKIP_ variables
+// every stage runs in order in a fresh temp dir.
+//
+// TestVpcModule deploys to AWS_DEFAULT_REGION or, when it is unset, to a random
+// region offering every service the modules use, never one in
+// FORBIDDEN_REGIONS, and in AZs offering the instance types they launch:
+//
+//	FORBIDDEN_REGIONS=us-east-1,ap-northeast-3 go test -run TestVpcModule ./...
+//
+// TestVpcModuleRegions runs the module once per region in TEST_REGIONS:
+//
+//	TEST_REGIONS=us-east-1,eu-west-1,ap-south-1 go test -run TestVpcModuleRegions ./...
+
 package tests
 
 import (
+	"strings"
 	"testing"
 
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"terraform-tests/internal/testhelpers"
 )
 
+// vpcOutputSchema is what every output of the vpc module has to look like.
+var vpcOutputSchema = testhelpers.OutputSchema{
+	"vpc_id":                  {Type: testhelpers.OutputString, Pattern: `^vpc-[0-9a-f]+$`},
+	"vpc_cidr_block":          {Type: testhelpers.OutputString, Pattern: `^\d+\.\d+\.\d+\.\d+/\d+$`},
+	"public_subnet_ids":       {Type: testhelpers.OutputList, Pattern: `^subnet-[0-9a-f]+$`},
+	"private_subnet_ids":      {Type: testhelpers.OutputList, Pattern: `^subnet-[0-9a-f]+$`},
+	"nat_gateway_ids":         {Type: testhelpers.OutputList, Pattern: `^nat-[0-9a-f]+$`},
+	"public_route_table_id":   {Type: testhelpers.OutputString, Pattern: `^rtb-[0-9a-f]+$`},
+	"private_route_table_ids": {Type: testhelpers.OutputList, Pattern: `^rtb-[0-9a-f]+$`},
+}
+
 func TestVpcModule(t *testing.T) {
-	terraformOptions := &terraform.Options{
-		TerraformDir: "../modules/vpc",
+	t.Parallel()
+
+	region := testhelpers.SelectRegion(t)
+
+	// Private subnets start halfway through the VPC CIDR
+	testCases := []struct {
+		name               string
+		cidrBlock          string
+		azCount            int
+		enableNat          bool
+		firstPrivateSubnet string
+	}{
+		{name: "ThreeAzWithNat", cidrBlock: "10.0.0.0/16", azCount: 3, enableNat: true, firstPrivateSubnet: "10.0.128.0/20"},
+		{name: "TwoAzWithoutNat", cidrBlock: "10.1.0.0/16", azCount: 2, enableNat: false, firstPrivateSubnet: "10.1.128.0/20"},
+		{name: "SingleAz", cidrBlock: "10.2.0.0/20", azCount: 1, enableNat: true, firstPrivateSubnet: "10.2.8.0/24"},
+		// /24 is the smallest VPC the module accepts: each subnet ends up a /28.
+		{name: "SmallestCidr", cidrBlock: "10.3.0.0/24", azCount: 2, enableNat: true, firstPrivateSubnet: "10.3.0.128/28"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			zones := testhelpers.SelectAvailabilityZones(t, region, testhelpers.ModuleInstanceTypes, tc.azCount)
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name":       "iot-network",
+				"environment":        "test",
+				"owner":              "terratest",
+				"cost_center":        "ci",
+				"cidr_block":         tc.cidrBlock,
+				"az_count":           tc.azCount,
+				"enable_nat":         tc.enableNat,
+				"availability_zones": zones,
+			})
+
+			natCount := 0
+			if tc.enableNat {
+				natCount = 1
+			}
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Quotas: vpcQuotaRequirements(tc.enableNat),
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					vpcs := testhelpers.PlannedResourcesOfType(plan, "aws_vpc")
+					if assert.Len(t, vpcs, 1, "expected a single VPC in the plan") {
+						vpc := vpcs["aws_vpc.main"]
+						assert.Equal(t, tc.cidrBlock, vpc["cidr_block"], "planned VPC cidr_block")
+						assert.Equal(t, "test", vpc["tags"].(map[string]interface{})["Environment"], "planned VPC Environment tag")
+					}
+
+					// A public and a private subnet per AZ, a public route table
+					// and a private one per AZ, and no endpoints, which are the
+					// vpc-endpoints module's
+					testhelpers.AssertPlannedResources(t, plan, map[string]int{
+						"aws_subnet":       2 * tc.azCount,
+						"aws_route_table":  1 + tc.azCount,
+						"aws_nat_gateway":  natCount,
+						"aws_eip":          natCount,
+						"aws_vpc_endpoint": 0,
+					})
+					testhelpers.AssertResourceAttr(t, plan, "aws_subnet.private[0]", "cidr_block", tc.firstPrivateSubnet)
+					testhelpers.AssertResourceAttr(t, plan, "aws_subnet.public[0]", "map_public_ip_on_launch", true)
+
+					subnets := testhelpers.PlannedResourcesOfType(plan, "aws_subnet")
+					// The zones are read back from the options, which a run with
+					// SKIP_setup=true loads from an earlier run
+					selectedZones := terraformOptions.Vars["availability_zones"]
+					for address, subnet := range subnets {
+						cidr := subnet["cidr_block"].(string)
+						assert.True(t, testhelpers.CIDRContains(tc.cidrBlock, cidr),
+							"%s cidr_block %s is outside the VPC CIDR %s", address, cidr, tc.cidrBlock)
+						assert.Contains(t, selectedZones, subnet["availability_zone"], "%s is outside the selected availability zones", address)
+					}
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					outputs := testhelpers.ValidateOutputs(t, terraformOptions, vpcOutputSchema)
+
+					vpcId, _ := outputs["vpc_id"].(string)
+					require.NotEmpty(t, vpcId, "VPC ID should not be empty")
+					region := testhelpers.Region(terraformOptions)
+					assertVpcAttributes(t, region, vpcId, tc.cidrBlock)
+					assert.Equal(t, tc.cidrBlock, outputs["vpc_cidr_block"], "vpc_cidr_block output does not match the input var")
+
+					publicSubnetIds := outputStrings(outputs["public_subnet_ids"])
+					assert.Len(t, publicSubnetIds, tc.azCount, "expected one public subnet per AZ")
+
+					privateSubnetIds := outputStrings(outputs["private_subnet_ids"])
+					assert.Len(t, privateSubnetIds, tc.azCount, "expected one private subnet per AZ")
+
+					privateRouteTableIds := outputStrings(outputs["private_route_table_ids"])
+					assert.Len(t, privateRouteTableIds, tc.azCount, "expected one private route table per AZ")
+
+					natGatewayIds := outputStrings(outputs["nat_gateway_ids"])
+					assert.Len(t, natGatewayIds, natCount, "unexpected number of NAT gateways")
+
+					// Everything in the state Terraform tags; the outputs are
+					// added explicitly so that a resource missing from the
+					// state is still caught.
+					resources := testhelpers.TaggableResources(t, terraformOptions)
+					publicRouteTableId, _ := outputs["public_route_table_id"].(string)
+					resources = append(resources, vpcId, publicRouteTableId)
+					resources = append(resources, publicSubnetIds...)
+					resources = append(resources, privateSubnetIds...)
+					resources = append(resources, privateRouteTableIds...)
+					resources = append(resources, natGatewayIds...)
+					testhelpers.AssertRequiredTags(t, region, resources, testhelpers.RequiredTagKeys)
+				},
+			})
+		})
 	}
+}
+
+// TestVpcModuleRegions applies the vpc module in every region listed in
+// TEST_REGIONS, asking for three AZs everywhere. Regions with fewer AZs must
+// still apply and get a public and a private subnet in each AZ they have. Each
+// region is its own subtest with its own options, so it is destroyed even if
+// another region fails.
+func TestVpcModuleRegions(t *testing.T) {
+	t.Parallel()
+
+	const requestedAzCount = 3
+
+	for _, region := range testhelpers.TestRegions() {
+		region := region
+		t.Run(region, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := testhelpers.NewModuleOptionsInRegion(t, "vpc", region, map[string]interface{}{
+				"project_name": "iot-network",
+				"environment":  "test",
+				"owner":        "terratest",
+				"cost_center":  "ci",
+				"cidr_block":   "10.4.0.0/16",
+				"az_count":     requestedAzCount,
+				"enable_nat":   false,
+			})
+
+			azCount := min(requestedAzCount, len(availableZones(t, region)))
+
+			testhelpers.RunModuleChecks(t, terraformOptions, testhelpers.ModuleChecks{
+				Quotas: vpcQuotaRequirements(false),
+				Plan: func(t *testing.T, plan *terraform.PlanStruct) {
+					zones := map[string]bool{}
+					for address, subnet := range testhelpers.PlannedResourcesOfType(plan, "aws_subnet") {
+						zone, _ := subnet["availability_zone"].(string)
+						assert.True(t, strings.HasPrefix(zone, region), "%s is planned in %s, outside %s", address, zone, region)
+						zones[zone] = true
+					}
+					assert.Len(t, zones, azCount, "expected subnets in %d AZs of %s", azCount, region)
+					assert.Len(t, testhelpers.PlannedResourcesOfType(plan, "aws_subnet"), 2*azCount, "expected a public and a private subnet per AZ")
+				},
+				Apply: func(t *testing.T, terraformOptions *terraform.Options) {
+					assert.Len(t, terraform.OutputList(t, terraformOptions, "public_subnet_ids"), azCount, "expected one public subnet per AZ in %s", region)
+					assert.Len(t, terraform.OutputList(t, terraformOptions, "private_subnet_ids"), azCount, "expected one private subnet per AZ in %s", region)
+				},
+			})
+		})
+	}
+}
+
+// vpcQuotaRequirements is what one apply of the vpc module takes out of the
+// account's quotas. The single NAT strategy puts the one NAT gateway and its
+// EIP in the first AZ.
+func vpcQuotaRequirements(enableNat bool) testhelpers.QuotaRequirements {
+	if !enableNat {
+		return testhelpers.QuotaRequirements{VPCs: 1}
+	}
+	return testhelpers.QuotaRequirements{VPCs: 1, ElasticIPs: 1, NATGateways: 1}
+}
+
+// outputStrings converts a list output returned by ValidateOutputs, which has
+// already checked its type.
+func outputStrings(value interface{}) []string {
+	list, _ := value.([]interface{})
+	values := make([]string, 0, len(list))
+	for _, element := range list {
+		value, _ := element.(string)
+		values = append(values, value)
+	}
+	return values
+}
+
+// availableZones returns the AZs of the region that are currently available,
+// matching the aws_availability_zones data source the module reads.
+func availableZones(t *testing.T, region string) []string {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	output, err := ec2Client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{{Name: awsSDK.String("state"), Values: []*string{awsSDK.String("available")}}},
+	})
+	require.NoError(t, err)
+
+	var zones []string
+	for _, zone := range output.AvailabilityZones {
+		zones = append(zones, awsSDK.StringValue(zone.ZoneName))
+	}
+	return zones
+}
+
+// assertVpcAttributes checks the live VPC against the inputs it was created
+// with, rather than trusting the module outputs.
+func assertVpcAttributes(t *testing.T, region string, vpcId string, expectedCidr string) {
+	ec2Client := ec2.New(testhelpers.NewSession(t, region))
+
+	vpcs, err := ec2Client.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{awsSDK.String(vpcId)}})
+	require.NoError(t, err)
+	require.Len(t, vpcs.Vpcs, 1, "DescribeVpcs did not return VPC %s", vpcId)
+	assert.Equal(t, expectedCidr, awsSDK.StringValue(vpcs.Vpcs[0].CidrBlock), "VPC %s: cidr_block does not match the input var", vpcId)
+
+	dnsHostnames, err := ec2Client.DescribeVpcAttribute(&ec2.DescribeVpcAttributeInput{
+		VpcId:     awsSDK.String(vpcId),
+		Attribute: awsSDK.String(ec2.VpcAttributeNameEnableDnsHostnames),
+	})
+	require.NoError(t, err)
+	assert.True(t, awsSDK.BoolValue(dnsHostnames.EnableDnsHostnames.Value), "VPC %s: enable_dns_hostnames is disabled", vpcId)
 
-	defer terraform.Destroy(t, terraformOptions)
-	terraform.InitAndApply(t, terraformOptions)
+	dnsSupport, err := ec2Client.DescribeVpcAttribute(&ec2.DescribeVpcAttributeInput{
+		VpcId:     awsSDK.String(vpcId),
+		Attribute: awsSDK.String(ec2.VpcAttributeNameEnableDnsSupport),
+	})
+	require.NoError(t, err)
+	assert.True(t, awsSDK.BoolValue(dnsSupport.EnableDnsSupport.Value), "VPC %s: enable_dns_support is disabled", vpcId)
 
-	// Validate outputs
-	vpcId := terraform.Output(t, terraformOptions, "vpc_id")
-	assert.NotEmpty(t, vpcId, "VPC ID should not be empty")
+	groups, err := ec2Client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: awsSDK.String("vpc-id"), Values: []*string{awsSDK.String(vpcId)}},
+			{Name: awsSDK.String("group-name"), Values: []*string{awsSDK.String("default")}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, groups.SecurityGroups, 1, "VPC %s: default security group not found", vpcId)
+	defaultGroup := groups.SecurityGroups[0]
+	assert.Empty(t, defaultGroup.IpPermissions, "VPC %s: default security group %s has ingress rules: %v",
+		vpcId, awsSDK.StringValue(defaultGroup.GroupId), defaultGroup.IpPermissions)
 }